@@ -0,0 +1,172 @@
+package archive
+
+import (
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// columns are written as the CSV header, in the order they are selected
+var columns = []string{"id", "serverid", "hostname", "checkname", "state", "result", "duration", "checkedat", "maintenance"}
+
+// Store uploads history exports to an S3 bucket
+type Store struct {
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+// NewStore returns a store using the default AWS credential chain. A non-empty
+// endpoint points it at an S3-compatible service such as MinIO or R2, using
+// path-style addressing.
+func NewStore(bucket string, prefix string, endpoint string) (*Store, error) {
+	cfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+			o.UsePathStyle = true
+		}
+	})
+
+	return &Store{client: client, bucket: bucket, prefix: prefix}, nil
+}
+
+// Export uploads history older than before as gzipped CSV, then deletes what
+// was uploaded. Nothing is deleted unless the upload succeeds. It returns how
+// many rows were archived.
+func (s *Store) Export(ctx context.Context, db *sql.DB, before time.Time) (int, error) {
+	file, err := os.CreateTemp("", "vbms-history-*.csv.gz")
+	if err != nil {
+		return 0, err
+	}
+
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	count, lastID, err := writeHistory(db, file, before)
+	if err != nil || count == 0 {
+		return 0, err
+	}
+
+	if _, err := file.Seek(0, 0); err != nil {
+		return 0, err
+	}
+
+	key := fmt.Sprintf("%vhistory-%v-%d.csv.gz", s.prefix, before.UTC().Format("20060102T150405Z"), lastID)
+
+	_, err = s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(key),
+		Body:            file,
+		ContentType:     aws.String("text/csv"),
+		ContentEncoding: aws.String("gzip"),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// Rows added since the export are newer than before, so this removes
+	// exactly what was uploaded
+	_, err = db.Exec("DELETE FROM check_history WHERE id <= ? AND checkedat < ?", lastID, before.Unix())
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// Prune deletes history older than before without exporting it, for when
+// no bucket is configured, returning how many rows were deleted
+func Prune(db *sql.DB, before time.Time) (int64, error) {
+	result, err := db.Exec("DELETE FROM check_history WHERE checkedat < ?", before.Unix())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// PruneRuns deletes the records of runs started before before, which are
+// kept as long as history but not archived
+func PruneRuns(db *sql.DB, before time.Time) (int64, error) {
+	result, err := db.Exec("DELETE FROM runs WHERE startedat < ?", before.Unix())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// writeHistory writes history rows older than before to w as gzipped CSV,
+// returning how many were written and the highest ID
+func writeHistory(db *sql.DB, w *os.File, before time.Time) (int, int64, error) {
+	rows, err := db.Query(`
+		SELECT h.id, h.serverid, COALESCE(s.hostname, ''), h.checkname, h.state,
+			COALESCE(h.result, ''), h.duration, h.checkedat, h.maintenance
+		FROM check_history h LEFT JOIN servers s ON s.id = h.serverid
+		WHERE h.checkedat < ?
+		ORDER BY h.id
+	`, before.Unix())
+	if err != nil {
+		return 0, 0, err
+	}
+
+	defer rows.Close()
+
+	gz := gzip.NewWriter(w)
+	out := csv.NewWriter(gz)
+	out.Write(columns)
+
+	var count int
+	var lastID int64
+
+	for rows.Next() {
+		var id, serverID, duration, checkedAt int64
+		var hostname, check, state, result string
+		var maintenance bool
+
+		if err := rows.Scan(&id, &serverID, &hostname, &check, &state, &result, &duration, &checkedAt, &maintenance); err != nil {
+			return 0, 0, err
+		}
+
+		out.Write([]string{
+			strconv.FormatInt(id, 10),
+			strconv.FormatInt(serverID, 10),
+			hostname,
+			check,
+			state,
+			strings.TrimSpace(result),
+			strconv.FormatInt(duration, 10),
+			time.Unix(checkedAt, 0).UTC().Format(time.RFC3339),
+			strconv.FormatBool(maintenance),
+		})
+
+		count++
+		lastID = id
+	}
+
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	out.Flush()
+	if err := out.Error(); err != nil {
+		return 0, 0, err
+	}
+
+	return count, lastID, gz.Close()
+}
@@ -0,0 +1,575 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/blinktag/vbms/digest"
+	"github.com/blinktag/vbms/fleet"
+	"github.com/blinktag/vbms/server"
+)
+
+// runCommand runs a CLI subcommand instead of the monitoring loop
+func runCommand(args []string) {
+	switch args[0] {
+	case "apply":
+		applyCommand(args[1:])
+	case "import-ansible":
+		importAnsibleCommand(args[1:])
+	case "discover":
+		discoverCommand(args[1:])
+	case "encrypt-db":
+		encryptCommand()
+	case "digest":
+		digestCommand(args[1:])
+	case "incidents":
+		incidentsCommand(args[1:])
+	case "maintenance":
+		maintenanceCommand(args[1:])
+	case "pause":
+		pauseCommand(args[1:], true)
+	case "resume":
+		pauseCommand(args[1:], false)
+	case "check":
+		checkCommand(args[1:])
+	case "recheck":
+		recheckCommand(args[1:])
+	case "ack":
+		ackCommand(args[1:])
+	case "--once", "-once":
+		onceCommand(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %v\n", args[0])
+		os.Exit(2)
+	}
+}
+
+// applyCommand reconciles the stored servers with a desired-state document:
+//
+//	vbms apply -f fleet.yaml -prune
+func applyCommand(args []string) {
+	flags := flag.NewFlagSet("apply", flag.ExitOnError)
+	file := flags.String("f", "", "YAML document describing every server")
+	prune := flags.Bool("prune", false, "delete servers missing from the document")
+	flags.Parse(args)
+
+	if *file == "" {
+		log.Fatal("apply requires -f")
+	}
+
+	servers, err := fleet.LoadFile(*file)
+	if err != nil {
+		log.WithError(err).Fatal("Unable to load server definitions")
+	}
+
+	verifyDatabase()
+	db := loadDatabase()
+	defer db.Close()
+
+	changes, err := fleet.Apply(db, servers, *prune)
+	if err != nil {
+		log.WithError(err).Fatal("Unable to apply server definitions")
+	}
+
+	log.Infof("Applied %v: %d created, %d updated, %d deleted", *file, changes.Created, changes.Updated, changes.Deleted)
+}
+
+// checkFlags collects repeated -checks group=column,column flags
+type checkFlags map[string][]string
+
+func (c checkFlags) String() string {
+	return fmt.Sprint(map[string][]string(c))
+}
+
+func (c checkFlags) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("expected group=column,column, got %v", value)
+	}
+
+	c[parts[0]] = append(c[parts[0]], strings.Split(parts[1], ",")...)
+	return nil
+}
+
+// importAnsibleCommand creates or updates servers from an Ansible inventory:
+//
+//	vbms import-ansible -i hosts.ini -checks web=enablehttp,enablehttps
+func importAnsibleCommand(args []string) {
+	checks := checkFlags{}
+
+	flags := flag.NewFlagSet("import-ansible", flag.ExitOnError)
+	file := flags.String("i", "", "Ansible inventory in INI or YAML format")
+	flags.Var(checks, "checks", "columns to enable for members of a group, as group=column,column")
+	flags.Parse(args)
+
+	if *file == "" {
+		log.Fatal("import-ansible requires -i")
+	}
+
+	servers, err := fleet.LoadAnsible(*file, checks)
+	if err != nil {
+		log.WithError(err).Fatal("Unable to load Ansible inventory")
+	}
+
+	verifyDatabase()
+	db := loadDatabase()
+	defer db.Close()
+
+	created, updated, err := fleet.Sync(db, servers)
+	if err != nil {
+		log.WithError(err).Fatal("Unable to import Ansible inventory")
+	}
+
+	log.Infof("Imported %v: %d created, %d updated", *file, created, updated)
+}
+
+// discoverCommand browses mDNS on the local network and prints proposed server
+// definitions, which can be reviewed and saved into CONFIG_DIR:
+//
+//	vbms discover -wait 10s > conf.d/lan.yaml
+func discoverCommand(args []string) {
+	flags := flag.NewFlagSet("discover", flag.ExitOnError)
+	wait := flags.Duration("wait", 5*time.Second, "how long to listen for services")
+	flags.Parse(args)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *wait)
+	defer cancel()
+
+	servers, err := fleet.Discover(ctx)
+	if err != nil {
+		log.WithError(err).Fatal("Unable to browse mDNS services")
+	}
+
+	out, err := fleet.Marshal(servers)
+	if err != nil {
+		log.WithError(err).Fatal("Unable to render server definitions")
+	}
+
+	log.Infof("Discovered %d hosts", len(servers))
+	os.Stdout.Write(out)
+}
+
+// encryptCommand encrypts credentials already stored in plaintext, after
+// DB_KEY or DB_KEY_FILE has been configured:
+//
+//	DB_KEY_FILE=/etc/vbms/key vbms encrypt-db
+func encryptCommand() {
+	if cfg.DBKey == "" && cfg.DBKeyFile == "" {
+		log.Fatal("encrypt-db requires DB_KEY or DB_KEY_FILE")
+	}
+
+	verifyDatabase()
+	db := loadDatabase()
+	defer db.Close()
+
+	count, err := fleet.EncryptStored(db)
+	if err != nil {
+		log.WithError(err).Fatal("Unable to encrypt stored credentials")
+	}
+
+	log.Infof("Encrypted %d stored credentials", count)
+}
+
+// digestCommand prints the digest for the last complete period, or sends it
+// to the configured destinations:
+//
+//	vbms digest -period monthly -send
+func digestCommand(args []string) {
+	flags := flag.NewFlagSet("digest", flag.ExitOnError)
+	period := flags.String("period", digest.Weekly, "weekly or monthly")
+	send := flags.Bool("send", false, "email or post the digest instead of printing it")
+	flags.Parse(args)
+
+	from, to, err := digest.LastPeriod(*period, time.Now())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	verifyDatabase()
+	db := loadDatabase()
+	defer db.Close()
+
+	report, err := digest.Build(db, from, to)
+	if err != nil {
+		log.WithError(err).Fatal("Unable to build digest")
+	}
+
+	if *send {
+		sendDigest(report)
+		return
+	}
+
+	fmt.Print(report.Text())
+}
+
+// incidentsCommand lists the incidents overlapping a period, by default the
+// last 7 days:
+//
+//	vbms incidents -from 2026-10-06 -to 2026-10-07 -host www.example.com
+func incidentsCommand(args []string) {
+	flags := flag.NewFlagSet("incidents", flag.ExitOnError)
+	fromFlag := flags.String("from", "", "start date, YYYY-MM-DD in local time")
+	toFlag := flags.String("to", "", "end date, YYYY-MM-DD in local time, exclusive")
+	host := flags.String("host", "", "only list incidents on this server")
+	flags.Parse(args)
+
+	now := time.Now()
+	from, to := now.AddDate(0, 0, -7), now
+
+	var err error
+	if *fromFlag != "" {
+		if from, err = time.ParseInLocation("2006-01-02", *fromFlag, time.Local); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *toFlag != "" {
+		if to, err = time.ParseInLocation("2006-01-02", *toFlag, time.Local); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	verifyDatabase()
+	db := loadDatabase()
+	defer db.Close()
+
+	incidents, err := server.LoadIncidents(db, from, to, *host)
+	if err != nil {
+		log.WithError(err).Fatal("Unable to load incidents")
+	}
+
+	for _, i := range incidents {
+		resolved := "ongoing"
+		if i.ResolvedAt > 0 {
+			resolved = time.Unix(i.ResolvedAt, 0).Format("2006-01-02 15:04")
+		}
+		fmt.Printf("%v  %v to %v (%v)  %v: %v\n", i.Hostname, time.Unix(i.StartedAt, 0).Format("2006-01-02 15:04"),
+			resolved, i.Duration(now).Round(time.Second), i.Checks, i.Result)
+	}
+}
+
+// maintenanceCommand schedules planned work on a server, the servers with a
+// tag or the whole fleet, lists what is scheduled or removes a window:
+//
+//	vbms maintenance -host db1 -start "2026-10-20 02:00" -duration 2h -repeat weekly -reason patching
+//	vbms maintenance -list
+//	vbms maintenance -delete 3
+func maintenanceCommand(args []string) {
+	flags := flag.NewFlagSet("maintenance", flag.ExitOnError)
+	host := flags.String("host", "", "server the window applies to")
+	tag := flags.String("tag", "", "tag of the servers the window applies to")
+	start := flags.String("start", "", "start, YYYY-MM-DD HH:MM in local time")
+	duration := flags.Duration("duration", time.Hour, "how long each window lasts")
+	repeat := flags.String("repeat", "", "daily, weekly or monthly")
+	until := flags.String("until", "", "date repeats stop, YYYY-MM-DD in local time")
+	reason := flags.String("reason", "", "what the work is")
+	list := flags.Bool("list", false, "list scheduled windows")
+	remove := flags.Int("delete", 0, "delete the window with this ID")
+	flags.Parse(args)
+
+	verifyDatabase()
+	db := loadDatabase()
+	defer db.Close()
+
+	switch {
+	case *list:
+		rows, err := db.Query(`
+			SELECT m.id, COALESCE(s.hostname, ''), m.tag, m.startsat, m.endsat, m.repeat, m.until, m.reason
+			FROM maintenancewindows m LEFT JOIN servers s ON s.id = m.serverid
+			ORDER BY m.startsat
+		`)
+		if err != nil {
+			log.WithError(err).Fatal("Unable to load maintenance windows")
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var id int
+			var hostname, tag, repeat, reason string
+			var startsAt, endsAt, until int64
+			if err := rows.Scan(&id, &hostname, &tag, &startsAt, &endsAt, &repeat, &until, &reason); err != nil {
+				log.WithError(err).Fatal("Unable to load maintenance window")
+			}
+
+			target := "all servers"
+			if hostname != "" {
+				target = hostname
+			} else if tag != "" {
+				target = "tag " + tag
+			}
+			if repeat == "" {
+				repeat = "once"
+			}
+			if until > 0 {
+				repeat += " until " + time.Unix(until, 0).Format("2006-01-02")
+			}
+
+			fmt.Printf("%d  %v  %v for %v, %v  %v\n", id, target, time.Unix(startsAt, 0).Format("2006-01-02 15:04"),
+				time.Duration(endsAt-startsAt)*time.Second, repeat, reason)
+		}
+		return
+	case *remove > 0:
+		if _, err := db.Exec("DELETE FROM maintenancewindows WHERE id = ?", *remove); err != nil {
+			log.WithError(err).Fatal("Unable to delete maintenance window")
+		}
+		return
+	}
+
+	if *host != "" && *tag != "" {
+		log.Fatal("Give -host or -tag, not both")
+	}
+
+	begins, err := time.ParseInLocation("2006-01-02 15:04", *start, time.Local)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	window := server.MaintenanceWindow{
+		Tag:      *tag,
+		StartsAt: begins.Unix(),
+		EndsAt:   begins.Add(*duration).Unix(),
+		Repeat:   *repeat,
+		Reason:   *reason,
+	}
+
+	if *until != "" {
+		ends, err := time.ParseInLocation("2006-01-02", *until, time.Local)
+		if err != nil {
+			log.Fatal(err)
+		}
+		window.Until = ends.AddDate(0, 0, 1).Unix()
+	}
+
+	if *host != "" {
+		if err := db.QueryRow("SELECT id FROM servers WHERE hostname = ?", *host).Scan(&window.ServerID); err != nil {
+			log.WithError(err).Fatalf("Unknown server %v", *host)
+		}
+	}
+
+	if err := window.Validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	res, err := db.Exec(`
+		INSERT INTO maintenancewindows (serverid, tag, startsat, endsat, repeat, until, reason)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, window.ServerID, window.Tag, window.StartsAt, window.EndsAt, window.Repeat, window.Until, window.Reason)
+	if err != nil {
+		log.WithError(err).Fatal("Unable to schedule maintenance")
+	}
+
+	id, _ := res.LastInsertId()
+	fmt.Printf("Scheduled maintenance window %d\n", id)
+}
+
+// pauseCommand stops or restarts checks of servers without deleting them:
+//
+//	vbms pause -reason "being rebuilt" web3.example.com
+//	vbms resume web3.example.com
+func pauseCommand(args []string, paused bool) {
+	name, done := "resume", "resumed"
+	if paused {
+		name, done = "pause", "paused"
+	}
+
+	flags := flag.NewFlagSet(name, flag.ExitOnError)
+	reason := flags.String("reason", "", "why the server is paused")
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "Usage: vbms %v [-reason text] hostname...\n", name)
+		os.Exit(2)
+	}
+
+	verifyDatabase()
+	db := loadDatabase()
+	defer db.Close()
+
+	for _, hostname := range flags.Args() {
+		if err := server.SetPaused(db, hostname, paused, *reason); err != nil {
+			log.WithError(err).Fatalf("Unable to %v %v", name, hostname)
+		}
+		log.Infof("%v %v", hostname, done)
+	}
+}
+
+// recheckCommand queues servers to be checked in the running instance's
+// next batch, whether they're due or not:
+//
+//	vbms recheck www.example.com
+//	kill -USR1 $(pidof vbms)
+func recheckCommand(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "Usage: vbms recheck hostname...")
+		os.Exit(2)
+	}
+
+	verifyDatabase()
+	db := loadDatabase()
+	defer db.Close()
+
+	for _, hostname := range args {
+		if err := server.RequestRecheck(db, hostname); err != nil {
+			log.WithError(err).Fatalf("Unable to queue a recheck of %v", hostname)
+		}
+		log.Infof("%v queued for a recheck", hostname)
+	}
+}
+
+// ackCommand acknowledges a down check, telling the channels routed to take
+// it that someone is on it:
+//
+//	vbms ack www.example.com https
+func ackCommand(args []string) {
+	if len(args) != 2 {
+		fmt.Fprintln(os.Stderr, "Usage: vbms ack hostname check")
+		os.Exit(2)
+	}
+
+	verifyDatabase()
+	db := loadDatabase()
+	defer db.Close()
+
+	if err := server.Acknowledge(db, args[0], args[1]); err != nil {
+		log.WithError(err).Fatalf("Unable to acknowledge %v %v", args[0], args[1])
+	}
+	log.Infof("%v %v acknowledged", args[0], args[1])
+}
+
+// checkCommand runs a server's checks now, whether they're due or the server
+// is paused, prints each result and exits with the worst state's code:
+//
+//	vbms check www.example.com
+func checkCommand(args []string) {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: vbms check hostname")
+		os.Exit(2)
+	}
+
+	verifyDatabase()
+	loadPlugins()
+	db := loadDatabase()
+	defer db.Close()
+
+	servers := selectServers(db, "SELECT * FROM servers WHERE hostname = ?", flags.Arg(0))
+	if len(servers) == 0 {
+		log.Fatalf("No server %v", flags.Arg(0))
+	}
+
+	os.Exit(checkOnce(servers))
+}
+
+// onceCommand runs every unpaused server's checks a single time, or those
+// of the servers named or tagged, and exits with the worst state's code, 0
+// when everything is OK, 1 for WARN, 2 for CRIT and 3 for UNKNOWN or
+// UNREACHABLE:
+//
+//	vbms --once
+//	vbms --once -tag web
+//	vbms --once db1.example.com db2.example.com
+func onceCommand(args []string) {
+	flags := flag.NewFlagSet("once", flag.ExitOnError)
+	tag := flags.String("tag", "", "only check servers with this tag")
+	flags.Parse(args)
+
+	verifyDatabase()
+	loadPlugins()
+	db := loadDatabase()
+	defer db.Close()
+
+	var servers []*server.Server
+	if flags.NArg() > 0 {
+		query := "SELECT * FROM servers WHERE hostname IN (?" + strings.Repeat(", ?", flags.NArg()-1) + ")"
+		hostnames := make([]interface{}, flags.NArg())
+		for i, hostname := range flags.Args() {
+			hostnames[i] = hostname
+		}
+		servers = selectServers(db, query, hostnames...)
+	} else {
+		servers = selectServers(db, "SELECT * FROM servers WHERE paused = 0")
+	}
+
+	if *tag != "" {
+		tagged := servers[:0]
+		for _, srv := range servers {
+			if srv.HasTag(*tag) {
+				tagged = append(tagged, srv)
+			}
+		}
+		servers = tagged
+	}
+
+	if len(servers) == 0 {
+		log.Fatal("No servers to check")
+	}
+
+	os.Exit(checkOnce(servers))
+}
+
+// selectServers loads the servers a query returns
+func selectServers(db *sql.DB, query string, args ...interface{}) []*server.Server {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		log.WithError(err).Fatal("Unable to select servers")
+	}
+	defer rows.Close()
+
+	var servers []*server.Server
+	for rows.Next() {
+		srv := server.NewServer(db, rows)
+		servers = append(servers, &srv)
+	}
+
+	return servers
+}
+
+// checkOnce runs every check of the servers at once, recording the results
+// as a scheduled run would, prints them and returns the worst state's exit
+// code
+func checkOnce(servers []*server.Server) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	wg := new(sync.WaitGroup)
+	for _, srv := range servers {
+		srv.Force = true
+
+		// The servers weren't claimed for this run, so releasing them must
+		// leave alone a claim the daemon holds on them
+		srv.ClaimedBy, srv.ClaimedAt = "", 0
+
+		wg.Add(1)
+		go func(cur *server.Server) {
+			defer wg.Done()
+			cur.RunChecks(ctx)
+		}(srv)
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		log.Fatal("Interrupted")
+	}
+
+	var worst server.State
+	for _, srv := range servers {
+		for _, result := range srv.Results() {
+			fmt.Printf("%v  %v  %v  %.0fms  %v\n", srv.Hostname, result.Check, result.Status, result.Latency, result.Message)
+			if result.Status.Worse(worst) {
+				worst = result.Status
+			}
+		}
+	}
+
+	return worst.ExitCode()
+}
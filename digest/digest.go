@@ -0,0 +1,318 @@
+package digest
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Periods a digest can cover
+const (
+	Weekly  = "weekly"
+	Monthly = "monthly"
+)
+
+// expiryWindow is how far ahead certificate and domain expiries are listed
+const expiryWindow = 30 * 24 * time.Hour
+
+// slowestCount is how many of the slowest checks are listed per group
+const slowestCount = 5
+
+// ungrouped names the group of servers without a profile
+const ungrouped = "(no profile)"
+
+// Report summarises check history between From and To, grouped by the
+// profile servers belong to
+type Report struct {
+	From   time.Time
+	To     time.Time
+	Groups []*Group
+}
+
+// Group is the part of a report covering one profile
+type Group struct {
+	Name      string
+	Checks    int
+	Failures  int
+	Uptime    []Uptime
+	Incidents []Incident
+	Slowest   []Uptime
+	Expiring  []Expiry
+	Domains   []Expiry
+}
+
+// Uptime is how often one check on one server passed, and how long it took
+type Uptime struct {
+	Hostname string
+	Check    string
+	Runs     int
+	Passed   int
+	Duration time.Duration
+}
+
+// Percent is the share of runs that weren't CRIT
+func (u Uptime) Percent() float64 {
+	if u.Runs == 0 {
+		return 100
+	}
+
+	return 100 * float64(u.Passed) / float64(u.Runs)
+}
+
+// Incident is a run of consecutive CRIT results for one check
+type Incident struct {
+	Hostname string
+	Check    string
+	Start    time.Time
+	End      time.Time
+	Result   string
+}
+
+// Expiry is a certificate or domain registration due to expire soon
+type Expiry struct {
+	Hostname string
+	Expires  time.Time
+}
+
+// LastPeriod returns the most recent complete week (starting Monday) or
+// calendar month before now, in now's location
+func LastPeriod(period string, now time.Time) (time.Time, time.Time, error) {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	switch period {
+	case Weekly:
+		to := midnight.AddDate(0, 0, -((int(now.Weekday()) + 6) % 7))
+		return to.AddDate(0, 0, -7), to, nil
+	case Monthly:
+		to := midnight.AddDate(0, 0, 1-now.Day())
+		return to.AddDate(0, -1, 0), to, nil
+	}
+
+	return time.Time{}, time.Time{}, fmt.Errorf("Unknown digest period '%v'", period)
+}
+
+// Build summarises the history table between from and to, ignoring checks
+// run during maintenance
+func Build(db *sql.DB, from time.Time, to time.Time) (*Report, error) {
+	report := &Report{From: from, To: to}
+	groups := map[string]*Group{}
+
+	group := func(name string) *Group {
+		if name == "" {
+			name = ungrouped
+		}
+		if groups[name] == nil {
+			groups[name] = &Group{Name: name}
+			report.Groups = append(report.Groups, groups[name])
+		}
+		return groups[name]
+	}
+
+	rows, err := db.Query(`
+		SELECT s.profile, s.hostname, h.checkname, h.state, h.result, h.duration, h.checkedat
+		FROM check_history h JOIN servers s ON s.id = h.serverid
+		WHERE h.checkedat >= ? AND h.checkedat < ? AND h.maintenance = 0
+		ORDER BY s.profile, s.hostname, h.checkname, h.checkedat
+	`, from.Unix(), to.Unix())
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var cur *Uptime
+	var open *Incident
+	var g *Group
+
+	// Rows arrive ordered by check, so each check's runs are consecutive
+	for rows.Next() {
+		var profile, hostname, check, state, result string
+		var duration, checkedat int64
+
+		if err := rows.Scan(&profile, &hostname, &check, &state, &result, &duration, &checkedat); err != nil {
+			return nil, err
+		}
+
+		if cur == nil || cur.Hostname != hostname || cur.Check != check {
+			if open != nil {
+				g.Incidents = append(g.Incidents, *open)
+				open = nil
+			}
+			if cur != nil {
+				g.Uptime = append(g.Uptime, *cur)
+			}
+			g = group(profile)
+			cur = &Uptime{Hostname: hostname, Check: check}
+		}
+
+		at := time.Unix(checkedat, 0)
+		cur.Runs++
+		cur.Duration += time.Duration(duration) * time.Millisecond
+		g.Checks++
+
+		if state != "CRIT" {
+			cur.Passed++
+			if open != nil {
+				open.End = at
+				g.Incidents = append(g.Incidents, *open)
+				open = nil
+			}
+			continue
+		}
+
+		g.Failures++
+		if open == nil {
+			open = &Incident{Hostname: hostname, Check: check, Start: at, Result: result}
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if open != nil {
+		g.Incidents = append(g.Incidents, *open)
+	}
+	if cur != nil {
+		g.Uptime = append(g.Uptime, *cur)
+	}
+
+	for _, g := range report.Groups {
+		g.Slowest = append([]Uptime(nil), g.Uptime...)
+		sort.Slice(g.Slowest, func(i, j int) bool {
+			return average(g.Slowest[i]) > average(g.Slowest[j])
+		})
+		if len(g.Slowest) > slowestCount {
+			g.Slowest = g.Slowest[:slowestCount]
+		}
+	}
+
+	// Expiries are listed from now, not the end of the period
+	expiring, err := db.Query(`
+		SELECT profile, hostname, certexpiry FROM servers
+		WHERE certexpiry > 0 AND certexpiry < ?
+		ORDER BY certexpiry
+	`, time.Now().Add(expiryWindow).Unix())
+	if err != nil {
+		return nil, err
+	}
+
+	defer expiring.Close()
+
+	for expiring.Next() {
+		var profile, hostname string
+		var expires int64
+
+		if err := expiring.Scan(&profile, &hostname, &expires); err != nil {
+			return nil, err
+		}
+
+		g := group(profile)
+		g.Expiring = append(g.Expiring, Expiry{Hostname: hostname, Expires: time.Unix(expires, 0)})
+	}
+
+	if err := expiring.Err(); err != nil {
+		return nil, err
+	}
+
+	domains, err := db.Query(`
+		SELECT DISTINCT profile, CASE WHEN domain != '' THEN domain ELSE hostname END, domainexpiry FROM servers
+		WHERE enabledomain AND domainexpiry > 0 AND domainexpiry < ?
+		ORDER BY domainexpiry
+	`, time.Now().Add(expiryWindow).Unix())
+	if err != nil {
+		return nil, err
+	}
+
+	defer domains.Close()
+
+	for domains.Next() {
+		var profile, domain string
+		var expires int64
+
+		if err := domains.Scan(&profile, &domain, &expires); err != nil {
+			return nil, err
+		}
+
+		g := group(profile)
+		g.Domains = append(g.Domains, Expiry{Hostname: domain, Expires: time.Unix(expires, 0)})
+	}
+
+	return report, domains.Err()
+}
+
+// average is the mean time a check took
+func average(u Uptime) time.Duration {
+	if u.Runs == 0 {
+		return 0
+	}
+
+	return u.Duration / time.Duration(u.Runs)
+}
+
+// Subject is a one line title for the report
+func (r *Report) Subject() string {
+	return fmt.Sprintf("vbms uptime digest %v to %v", r.From.Format("2006-01-02"), r.To.AddDate(0, 0, -1).Format("2006-01-02"))
+}
+
+// Text renders the report as plain text, suitable for email or chat
+func (r *Report) Text() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%v\n", r.Subject())
+
+	if len(r.Groups) == 0 {
+		b.WriteString("\nNo checks were run in this period.\n")
+	}
+
+	for _, g := range r.Groups {
+		uptime := 100.0
+		if g.Checks > 0 {
+			uptime = 100 * float64(g.Checks-g.Failures) / float64(g.Checks)
+		}
+
+		fmt.Fprintf(&b, "\n== %v: %.2f%% uptime, %d incidents ==\n", g.Name, uptime, len(g.Incidents))
+
+		for _, u := range g.Uptime {
+			if u.Passed < u.Runs {
+				fmt.Fprintf(&b, "  %v %v: %.2f%%\n", u.Hostname, u.Check, u.Percent())
+			}
+		}
+
+		if len(g.Incidents) > 0 {
+			b.WriteString("\nIncidents:\n")
+			for _, inc := range g.Incidents {
+				end := "ongoing"
+				if !inc.End.IsZero() {
+					end = inc.End.Sub(inc.Start).Round(time.Second).String()
+				}
+				fmt.Fprintf(&b, "  %v %v %v (%v): %v\n", inc.Start.Format("2006-01-02 15:04"), inc.Hostname, inc.Check, end, inc.Result)
+			}
+		}
+
+		if len(g.Slowest) > 0 {
+			b.WriteString("\nSlowest checks:\n")
+			for _, u := range g.Slowest {
+				fmt.Fprintf(&b, "  %v %v: %v average\n", u.Hostname, u.Check, average(u).Round(time.Millisecond))
+			}
+		}
+
+		if len(g.Expiring) > 0 {
+			b.WriteString("\nCertificates expiring:\n")
+			for _, e := range g.Expiring {
+				fmt.Fprintf(&b, "  %v: %v\n", e.Hostname, e.Expires.Format("2006-01-02"))
+			}
+		}
+
+		if len(g.Domains) > 0 {
+			b.WriteString("\nDomains expiring:\n")
+			for _, e := range g.Domains {
+				fmt.Fprintf(&b, "  %v: %v\n", e.Hostname, e.Expires.Format("2006-01-02"))
+			}
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,102 @@
+package digest
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// mailTimeout bounds delivering the report to the relay, so one that accepts
+// the connection but never answers can't hang vbms
+const mailTimeout = time.Minute
+
+// Mail sends the report through an SMTP relay at addr (host:port), using
+// STARTTLS when offered. The relay is expected to accept mail from this host
+// without authentication.
+func (r *Report) Mail(addr string, from string, to []string) error {
+	var msg bytes.Buffer
+
+	fmt.Fprintf(&msg, "From: %v\r\n", from)
+	fmt.Fprintf(&msg, "To: %v\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %v\r\n", r.Subject())
+	fmt.Fprintf(&msg, "Date: %v\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(r.Text(), "\n", "\r\n"))
+
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "25")
+	}
+
+	host, _, _ := net.SplitHostPort(addr)
+
+	conn, err := net.DialTimeout("tcp", addr, mailTimeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(mailTimeout))
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := client.Rcpt(strings.TrimSpace(rcpt)); err != nil {
+			return err
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg.Bytes()); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+// PostSlack posts the report to a Slack incoming webhook
+func (r *Report) PostSlack(webhook string) error {
+	payload, err := json.Marshal(map[string]string{
+		"text": "```\n" + r.Text() + "```",
+	})
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(webhook, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Slack returned %v", resp.Status)
+	}
+
+	return nil
+}
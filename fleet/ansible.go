@@ -0,0 +1,303 @@
+package fleet
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// varPrefix marks Ansible host and group vars which set servers columns,
+// e.g. vbms_profile: web
+const varPrefix = "vbms_"
+
+// group is an Ansible inventory group
+type group struct {
+	hosts    map[string]bool
+	children []string
+	vars     map[string]interface{}
+}
+
+// inventory holds the groups and host vars of an Ansible inventory
+type inventory struct {
+	groups map[string]*group
+	hosts  map[string]map[string]interface{}
+	order  []string
+}
+
+func newInventory() *inventory {
+	return &inventory{
+		groups: map[string]*group{},
+		hosts:  map[string]map[string]interface{}{},
+	}
+}
+
+// group returns the named group, creating it on first use
+func (inv *inventory) group(name string) *group {
+	g, ok := inv.groups[name]
+	if !ok {
+		g = &group{hosts: map[string]bool{}, vars: map[string]interface{}{}}
+		inv.groups[name] = g
+	}
+	return g
+}
+
+// addHost records a host as a member of a group and merges its vars
+func (inv *inventory) addHost(groupName string, host string, vars map[string]interface{}) {
+	if _, ok := inv.hosts[host]; !ok {
+		inv.hosts[host] = map[string]interface{}{}
+		inv.order = append(inv.order, host)
+	}
+
+	for key, value := range vars {
+		inv.hosts[host][key] = value
+	}
+
+	inv.group(groupName).hosts[host] = true
+}
+
+// LoadAnsible builds server definitions from an Ansible inventory in INI or
+// YAML format. Each host becomes a server named after its inventory hostname,
+// with ansible_host as its IP. Hosts in a group listed in checks get those
+// columns enabled, and vbms_ prefixed host and group vars set columns directly.
+func LoadAnsible(path string, checks map[string][]string) ([]Server, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	inv := newInventory()
+	if strings.HasSuffix(path, ".yaml") || strings.HasSuffix(path, ".yml") {
+		err = inv.parseYAML(data)
+	} else {
+		err = inv.parseINI(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+
+	var servers []Server
+	for _, host := range inv.order {
+		def := Server{"hostname": host}
+
+		vars := inv.hostVars(host)
+		if ip, ok := vars["ansible_host"]; ok {
+			def["ip"] = fmt.Sprint(ip)
+		}
+
+		for key, value := range vars {
+			if strings.HasPrefix(key, varPrefix) {
+				def[strings.TrimPrefix(key, varPrefix)] = value
+			}
+		}
+
+		for _, name := range inv.memberOf(host) {
+			for _, col := range checks[name] {
+				def[col] = true
+			}
+		}
+
+		servers = append(servers, def)
+	}
+
+	if err := validate(servers); err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+
+	return servers, nil
+}
+
+// memberOf returns every group containing host, directly or through
+// children, ordered from the outermost group inwards
+func (inv *inventory) memberOf(host string) []string {
+	depth := map[string]int{}
+
+	var visit func(name string, d int) bool
+	visit = func(name string, d int) bool {
+		g := inv.groups[name]
+		found := g.hosts[host]
+		for _, child := range g.children {
+			if _, ok := inv.groups[child]; ok && visit(child, d+1) {
+				found = true
+			}
+		}
+		if found && d >= depth[name] {
+			depth[name] = d
+		}
+		return found
+	}
+
+	for name := range inv.groups {
+		visit(name, 0)
+	}
+
+	var names []string
+	for name := range depth {
+		names = append(names, name)
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		if depth[names[i]] != depth[names[j]] {
+			return depth[names[i]] < depth[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	return names
+}
+
+// hostVars merges group vars, inner groups overriding outer ones, with the
+// host's own vars taking precedence
+func (inv *inventory) hostVars(host string) map[string]interface{} {
+	vars := map[string]interface{}{}
+
+	for _, name := range inv.memberOf(host) {
+		for key, value := range inv.groups[name].vars {
+			vars[key] = value
+		}
+	}
+
+	for key, value := range inv.hosts[host] {
+		vars[key] = value
+	}
+
+	return vars
+}
+
+// hostRange matches numeric host patterns such as web[01:10].example.com
+var hostRange = regexp.MustCompile(`\[(\d+):(\d+)\]`)
+
+// expandHost expands a numeric range pattern into individual hostnames
+func expandHost(pattern string) []string {
+	m := hostRange.FindStringSubmatchIndex(pattern)
+	if m == nil {
+		return []string{pattern}
+	}
+
+	start, _ := strconv.Atoi(pattern[m[2]:m[3]])
+	end, _ := strconv.Atoi(pattern[m[4]:m[5]])
+	width := m[3] - m[2]
+
+	var hosts []string
+	for i := start; i <= end; i++ {
+		name := pattern[:m[0]] + fmt.Sprintf("%0*d", width, i) + pattern[m[1]:]
+		hosts = append(hosts, expandHost(name)...)
+	}
+
+	return hosts
+}
+
+// scalar converts an INI value into the type YAML would give it, so
+// enablehttp=true is stored as a boolean rather than a string
+func scalar(raw string) interface{} {
+	var value interface{}
+	if err := yaml.Unmarshal([]byte(raw), &value); err != nil || value == nil {
+		return strings.Trim(raw, `"'`)
+	}
+	return value
+}
+
+// parseINI reads an INI inventory with [group], [group:vars] and
+// [group:children] sections
+func (inv *inventory) parseINI(data []byte) error {
+	section, kind := "ungrouped", ""
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section, kind = strings.Trim(line, "[]"), ""
+			if i := strings.Index(section, ":"); i >= 0 {
+				section, kind = section[:i], section[i+1:]
+			}
+			inv.group(section)
+			continue
+		}
+
+		switch kind {
+		case "vars":
+			parts := strings.SplitN(line, "=", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("line %d: expected key=value", n)
+			}
+			inv.group(section).vars[strings.TrimSpace(parts[0])] = scalar(strings.TrimSpace(parts[1]))
+		case "children":
+			g := inv.group(section)
+			g.children = append(g.children, line)
+			inv.group(line)
+		case "":
+			fields := strings.Fields(line)
+			vars := map[string]interface{}{}
+			for _, field := range fields[1:] {
+				parts := strings.SplitN(field, "=", 2)
+				if len(parts) != 2 {
+					return fmt.Errorf("line %d: expected key=value, got %v", n, field)
+				}
+				vars[parts[0]] = scalar(parts[1])
+			}
+			for _, host := range expandHost(fields[0]) {
+				inv.addHost(section, host, vars)
+			}
+		default:
+			return fmt.Errorf("line %d: unknown section type %v", n, kind)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// yamlGroup is a group in a YAML inventory
+type yamlGroup struct {
+	Hosts    map[string]map[string]interface{} `yaml:"hosts"`
+	Vars     map[string]interface{}            `yaml:"vars"`
+	Children map[string]*yamlGroup             `yaml:"children"`
+}
+
+// parseYAML reads a YAML inventory rooted at one or more top level groups
+func (inv *inventory) parseYAML(data []byte) error {
+	var root map[string]*yamlGroup
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return err
+	}
+
+	for name, g := range root {
+		inv.addYAMLGroup(name, g)
+	}
+
+	// Keep host order stable between runs
+	sort.Strings(inv.order)
+
+	return nil
+}
+
+// addYAMLGroup records a YAML group and its children recursively
+func (inv *inventory) addYAMLGroup(name string, yg *yamlGroup) {
+	g := inv.group(name)
+	if yg == nil {
+		return
+	}
+
+	for key, value := range yg.Vars {
+		g.vars[key] = value
+	}
+
+	for pattern, vars := range yg.Hosts {
+		for _, host := range expandHost(pattern) {
+			inv.addHost(name, host, vars)
+		}
+	}
+
+	for child, cg := range yg.Children {
+		g.children = append(g.children, child)
+		inv.addYAMLGroup(child, cg)
+	}
+}
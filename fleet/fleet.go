@@ -0,0 +1,473 @@
+package fleet
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/blinktag/vbms/notify"
+	"github.com/blinktag/vbms/secrets"
+	"github.com/blinktag/vbms/server"
+	"gopkg.in/yaml.v3"
+)
+
+// Server is a server definition keyed by servers table column, e.g.
+//
+//	servers:
+//	  - hostname: www.example.com
+//	    ip: 203.0.113.10
+//	    profile: web
+//	    enableping: true
+//
+// ${VAR} and secret references are stored as written and resolved when the
+// server is checked, so secrets are never persisted in plaintext.
+type Server map[string]interface{}
+
+// Hostname returns the hostname the definition is keyed on
+func (s Server) Hostname() string {
+	name, _ := s["hostname"].(string)
+	return name
+}
+
+// document is the layout of a config file
+type document struct {
+	Servers []Server `yaml:"servers"`
+}
+
+// columns returns the servers table columns a definition may set. Results,
+// check state and the row ID are owned by vbms.
+func columns() map[string]bool {
+	allowed := map[string]bool{}
+	t := reflect.TypeOf(server.Server{})
+
+	for i := 0; i < t.NumField(); i++ {
+		col := t.Field(i).Tag.Get("sql")
+		if col == "" || col == "id" || strings.HasSuffix(col, "result") {
+			continue
+		}
+		allowed[col] = true
+	}
+
+	for _, col := range server.StateColumns {
+		delete(allowed, col)
+	}
+
+	return allowed
+}
+
+// LoadFile reads the server definitions in a YAML file
+func LoadFile(path string) ([]Server, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc document
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+
+	if err := validate(doc.Servers); err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+
+	return doc.Servers, nil
+}
+
+// LoadDir reads every *.yaml and *.yml fragment in dir in name order. A
+// hostname may only be defined once across all fragments.
+func LoadDir(dir string) ([]Server, error) {
+	var files []string
+	for _, pattern := range []string{"*.yaml", "*.yml"} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	sort.Strings(files)
+
+	var servers []Server
+	seen := map[string]string{}
+
+	for _, file := range files {
+		defs, err := LoadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, def := range defs {
+			if prev, ok := seen[def.Hostname()]; ok {
+				return nil, fmt.Errorf("%v: %v is already defined in %v", file, def.Hostname(), prev)
+			}
+			seen[def.Hostname()] = file
+		}
+
+		servers = append(servers, defs...)
+	}
+
+	return servers, nil
+}
+
+// validate makes sure every definition has a hostname and only known settings
+func validate(servers []Server) error {
+	allowed := columns()
+
+	for i, def := range servers {
+		if def.Hostname() == "" {
+			return fmt.Errorf("server %d has no hostname", i+1)
+		}
+
+		for key := range def {
+			if !allowed[key] {
+				return fmt.Errorf("unknown setting %v for %v", key, def.Hostname())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Sync creates or updates a row for each definition, matched on hostname.
+// Settings a definition leaves out keep their stored values.
+func Sync(db *sql.DB, servers []Server) (created int, updated int, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	for _, def := range servers {
+		isNew, err := upsert(tx, def)
+		if err != nil {
+			return 0, 0, fmt.Errorf("%v: %v", def.Hostname(), err)
+		}
+
+		if isNew {
+			created++
+		} else {
+			updated++
+		}
+	}
+
+	return created, updated, tx.Commit()
+}
+
+// serverTables hold rows belonging to a server, deleted along with it
+var serverTables = []string{
+	"checkstates",
+	"check_results",
+	"check_history",
+	"runs",
+	"uptime",
+	"incidents",
+	"transactionsteps",
+	"remediations",
+	"remediationlog",
+	"maintenancewindows",
+	"channelroutes",
+	"notifications",
+}
+
+// Changes counts what Apply did to the servers table
+type Changes struct {
+	Created int
+	Updated int
+	Deleted int
+}
+
+// Apply makes the servers table match servers exactly. Settings a definition
+// leaves out are reset to their column defaults, and when prune is set rows
+// for hostnames not in servers are deleted with everything recorded about
+// them. Pruning with no servers is refused. Applying the same definitions
+// twice leaves the table unchanged.
+func Apply(db *sql.DB, servers []Server, prune bool) (changes Changes, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return changes, err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	defaults, err := columnDefaults(tx)
+	if err != nil {
+		return changes, err
+	}
+
+	hostnames := make([]interface{}, len(servers))
+
+	for i, def := range servers {
+		hostnames[i] = def.Hostname()
+
+		full := Server{}
+		for col, value := range defaults {
+			full[col] = value
+		}
+		for col, value := range def {
+			full[col] = value
+		}
+
+		isNew, err := upsert(tx, full)
+		if err != nil {
+			return changes, fmt.Errorf("%v: %v", def.Hostname(), err)
+		}
+
+		if isNew {
+			changes.Created++
+		} else {
+			changes.Updated++
+		}
+	}
+
+	if prune {
+		// An empty or mistyped document would otherwise delete the whole fleet
+		if len(hostnames) == 0 {
+			return changes, errors.New("refusing to prune, the document defines no servers")
+		}
+
+		missing := "SELECT id FROM servers WHERE hostname NOT IN (" + strings.TrimSuffix(strings.Repeat("?, ", len(hostnames)), ", ") + ")"
+
+		for _, table := range serverTables {
+			if _, err := tx.Exec(fmt.Sprintf("DELETE FROM `%v` WHERE serverid IN (%v)", table, missing), hostnames...); err != nil {
+				return changes, err
+			}
+		}
+
+		res, err := tx.Exec("DELETE FROM servers WHERE id IN ("+missing+")", hostnames...)
+		if err != nil {
+			return changes, err
+		}
+
+		deleted, _ := res.RowsAffected()
+		changes.Deleted = int(deleted)
+	}
+
+	return changes, tx.Commit()
+}
+
+// columnDefaults returns the schema default of every column a definition may
+// set. Columns without a default are left out.
+func columnDefaults(tx *sql.Tx) (map[string]interface{}, error) {
+	rows, err := tx.Query("PRAGMA table_info(servers)")
+	if err != nil {
+		return nil, err
+	}
+
+	allowed := columns()
+	literals := map[string]string{}
+
+	for rows.Next() {
+		var (
+			cid     int
+			name    string
+			kind    string
+			notNull bool
+			dflt    sql.NullString
+			pk      int
+		)
+		if err := rows.Scan(&cid, &name, &kind, &notNull, &dflt, &pk); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		if allowed[name] && dflt.Valid {
+			literals[name] = dflt.String
+		}
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Let sqlite evaluate the default expressions from our own schema
+	defaults := map[string]interface{}{}
+	for col, literal := range literals {
+		var value interface{}
+		if err := tx.QueryRow("SELECT " + literal).Scan(&value); err != nil {
+			return nil, err
+		}
+		defaults[col] = value
+	}
+
+	return defaults, nil
+}
+
+// isSensitive reports whether a column holds credentials
+func isSensitive(col string) bool {
+	for _, sensitive := range server.SensitiveColumns {
+		if col == sensitive {
+			return true
+		}
+	}
+	return false
+}
+
+// EncryptStored encrypts any sensitive values still stored in plaintext,
+// in servers, transaction steps and channel configs, returning how many were
+// encrypted
+func EncryptStored(db *sql.DB) (count int, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	tables := map[string][]string{
+		"servers":          server.SensitiveColumns,
+		"transactionsteps": server.TransactionSecrets,
+	}
+
+	for table, cols := range tables {
+		for _, col := range cols {
+			encrypted, err := encryptColumn(tx, table, col)
+			if err != nil {
+				return 0, err
+			}
+			count += encrypted
+		}
+	}
+
+	encrypted, err := encryptChannels(tx)
+	if err != nil {
+		return 0, err
+	}
+	count += encrypted
+
+	return count, tx.Commit()
+}
+
+// encryptColumn encrypts the plaintext values in a column of table
+func encryptColumn(tx *sql.Tx, table string, col string) (int, error) {
+	rows, err := tx.Query(fmt.Sprintf("SELECT id, `%v` FROM `%v` WHERE `%v` != ''", col, table, col))
+	if err != nil {
+		return 0, err
+	}
+
+	plain := map[int]string{}
+	for rows.Next() {
+		var id int
+		var value string
+		if err := rows.Scan(&id, &value); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if !secrets.IsEncrypted(value) {
+			plain[id] = value
+		}
+	}
+	rows.Close()
+
+	for id, value := range plain {
+		encrypted, err := secrets.Encrypt(value)
+		if err != nil {
+			return 0, err
+		}
+
+		if _, err := tx.Exec(fmt.Sprintf("UPDATE `%v` SET `%v` = ? WHERE id = ?", table, col), encrypted, id); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(plain), nil
+}
+
+// encryptChannels encrypts the credentials in each channel's config
+func encryptChannels(tx *sql.Tx) (int, error) {
+	rows, err := tx.Query("SELECT id, kind, config FROM channels")
+	if err != nil {
+		return 0, err
+	}
+
+	configs := map[int]string{}
+	count := 0
+	for rows.Next() {
+		var id int
+		var kind, config string
+		if err := rows.Scan(&id, &kind, &config); err != nil {
+			rows.Close()
+			return 0, err
+		}
+
+		encrypted, n, err := notify.EncryptConfig(kind, config)
+		if err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("channel %d: %v", id, err)
+		}
+		if n > 0 {
+			configs[id] = encrypted
+			count += n
+		}
+	}
+	rows.Close()
+
+	for id, config := range configs {
+		if _, err := tx.Exec("UPDATE channels SET config = ? WHERE id = ?", config, id); err != nil {
+			return 0, err
+		}
+	}
+
+	return count, nil
+}
+
+// upsert writes one definition, reporting whether a new row was created
+func upsert(tx *sql.Tx, def Server) (bool, error) {
+	var keys []string
+	for key := range def {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	values := make([]interface{}, len(keys))
+	for i, key := range keys {
+		values[i] = def[key]
+
+		if str, ok := values[i].(string); ok && isSensitive(key) {
+			encrypted, err := secrets.Encrypt(str)
+			if err != nil {
+				return false, err
+			}
+			values[i] = encrypted
+		}
+	}
+
+	var id int
+	err := tx.QueryRow("SELECT id FROM servers WHERE hostname = ?", def.Hostname()).Scan(&id)
+
+	if err == sql.ErrNoRows {
+		query := fmt.Sprintf("INSERT INTO servers (`%v`) VALUES (%v)",
+			strings.Join(keys, "`, `"),
+			strings.TrimSuffix(strings.Repeat("?, ", len(keys)), ", "))
+
+		_, err = tx.Exec(query, values...)
+		return true, err
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	query := fmt.Sprintf("UPDATE servers SET `%v` = ? WHERE id = ?", strings.Join(keys, "` = ?, `"))
+
+	_, err = tx.Exec(query, append(values, id)...)
+	return false, err
+}
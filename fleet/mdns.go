@@ -0,0 +1,98 @@
+package fleet
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/grandcat/zeroconf"
+	"gopkg.in/yaml.v3"
+)
+
+// DiscoveryServices maps mDNS service types to the check enabled for hosts
+// advertising them. Every discovered host also gets a ping check.
+var DiscoveryServices = map[string]string{
+	"_http._tcp":        "enablehttp",
+	"_https._tcp":       "enablehttps",
+	"_smtp._tcp":        "enablestmp",
+	"_pop3._tcp":        "enablepop3",
+	"_pop3s._tcp":       "enablepop3s",
+	"_imap._tcp":        "enableimap",
+	"_ipp._tcp":         "enableping",
+	"_printer._tcp":     "enableping",
+	"_smb._tcp":         "enableping",
+	"_afpovertcp._tcp":  "enableping",
+	"_hap._tcp":         "enableping",
+	"_ssh._tcp":         "enablessh",
+	"_ftp._tcp":         "enableftp",
+	"_ldap._tcp":        "enableldap",
+	"_amqp._tcp":        "enableamqp",
+	"_device-info._tcp": "enableping",
+}
+
+// Discover browses the local network for mDNS/Bonjour services until ctx is
+// done, and proposes a server definition for each host found
+func Discover(ctx context.Context) ([]Server, error) {
+	resolver, err := zeroconf.NewResolver(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		mu    sync.Mutex
+		wg    sync.WaitGroup
+		found = map[string]Server{}
+	)
+
+	for service, col := range DiscoveryServices {
+		entries := make(chan *zeroconf.ServiceEntry)
+		if err := resolver.Browse(ctx, service, "local.", entries); err != nil {
+			return nil, err
+		}
+
+		wg.Add(1)
+		go func(col string) {
+			defer wg.Done()
+
+			for entry := range entries {
+				hostname := strings.TrimSuffix(entry.HostName, ".")
+				if hostname == "" {
+					continue
+				}
+
+				mu.Lock()
+				def, ok := found[hostname]
+				if !ok {
+					def = Server{"hostname": hostname, "enableping": true}
+					found[hostname] = def
+				}
+				if len(entry.AddrIPv4) > 0 {
+					def["ip"] = entry.AddrIPv4[0].String()
+				} else if len(entry.AddrIPv6) > 0 && def["ip"] == nil {
+					def["ip"] = entry.AddrIPv6[0].String()
+				}
+				def[col] = true
+				mu.Unlock()
+			}
+		}(col)
+	}
+
+	wg.Wait()
+
+	var servers []Server
+	for _, def := range found {
+		servers = append(servers, def)
+	}
+
+	sort.Slice(servers, func(i, j int) bool {
+		return servers[i].Hostname() < servers[j].Hostname()
+	})
+
+	return servers, nil
+}
+
+// Marshal renders definitions as a config document, suitable for conf.d or apply
+func Marshal(servers []Server) ([]byte, error) {
+	return yaml.Marshal(document{Servers: servers})
+}
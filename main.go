@@ -1,47 +1,261 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
+	"math/rand"
+	"net/http"
 	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/blinktag/vbms/archive"
+	"github.com/blinktag/vbms/digest"
+	"github.com/blinktag/vbms/fleet"
+	"github.com/blinktag/vbms/secrets"
 	"github.com/blinktag/vbms/server"
 	"github.com/caarlos0/env"
 	_ "github.com/mattn/go-sqlite3"
 )
 
 type config struct {
-	UpdateTick int `env:"UPDATE_TICK" envDefault:"5"`
-	BatchSize  int `env:"BATCH_SIZE" envDefault:"10"`
+	UpdateTick int    `env:"UPDATE_TICK" envDefault:"5"`
+	BatchSize  int    `env:"BATCH_SIZE" envDefault:"10"`
+	Jitter     int    `env:"BATCH_JITTER" envDefault:"5"`
+	MaxChecks  int    `env:"MAX_CONCURRENT_CHECKS" envDefault:"256"`
+	HostChecks int    `env:"MAX_CHECKS_PER_HOST"`
+	RunTimeout int    `env:"RUN_TIMEOUT" envDefault:"300"`
+	Flapping   int    `env:"FLAP_THRESHOLD" envDefault:"50"`
+	Interval   int    `env:"DEFAULT_INTERVAL" envDefault:"60"`
+	Failing    int    `env:"DEFAULT_FAILING_INTERVAL" envDefault:"30"`
+	Timeout    int    `env:"DEFAULT_TIMEOUT" envDefault:"10"`
+	Retries    int    `env:"DEFAULT_RETRIES"`
+	Backoff    int    `env:"DEFAULT_RETRY_BACKOFF" envDefault:"1000"`
+	ExpectHTTP string `env:"DEFAULT_HTTP_EXPECT" envDefault:"200"`
+	SecretsTTL int    `env:"SECRETS_TTL" envDefault:"300"`
+	VaultAddr  string `env:"VAULT_ADDR"`
+	VaultToken string `env:"VAULT_TOKEN"`
+	AWSSecrets bool   `env:"AWS_SECRETS_MANAGER"`
+	ConfigDir  string `env:"CONFIG_DIR" envDefault:"./conf.d"`
+	SSHJump    string `env:"SSH_JUMP"`
+	SSHJumpKey string `env:"SSH_JUMP_KEY"`
+	KnownHosts string `env:"SSH_KNOWN_HOSTS"`
+	Proxy      string `env:"PROXY"`
+	TLSCert    string `env:"TLS_CLIENT_CERT"`
+	TLSKey     string `env:"TLS_CLIENT_KEY"`
+	UserAgent  string `env:"HTTP_USER_AGENT" envDefault:"vbms"`
+	PingUDP    bool   `env:"PING_UNPRIVILEGED"`
+	RDAP       string `env:"RDAP_URL" envDefault:"https://rdap.org/"`
+	DBKey      string `env:"DB_KEY"`
+	DBKeyFile  string `env:"DB_KEY_FILE"`
+	PluginDir  string `env:"PLUGIN_DIR" envDefault:"./plugins"`
+	Heartbeat  string `env:"HEARTBEAT_LISTEN"`
+	Listen     string `env:"LISTEN"`
+	APIToken   string `env:"API_TOKEN"`
+
+	// Instances sharing the database claim batches under their own ID,
+	// taking over claims older than CLAIM_EXPIRY seconds
+	Instance    string `env:"INSTANCE_ID"`
+	ClaimExpiry int    `env:"CLAIM_EXPIRY" envDefault:"600"`
+
+	// Uptime digests, sent weekly or monthly by email and/or to Slack
+	DigestPeriod string `env:"DIGEST"`
+	DigestSMTP   string `env:"DIGEST_SMTP" envDefault:"localhost:25"`
+	DigestFrom   string `env:"DIGEST_FROM" envDefault:"vbms@localhost"`
+	DigestTo     string `env:"DIGEST_TO"`
+	DigestSlack  string `env:"DIGEST_SLACK_WEBHOOK"`
+
+	// History older than HistoryDays is pruned, after being archived to the
+	// bucket if there is one. 0 keeps it forever.
+	HistoryDays     int    `env:"HISTORY_RETENTION_DAYS" envDefault:"90"`
+	ArchiveBucket   string `env:"ARCHIVE_BUCKET"`
+	ArchivePrefix   string `env:"ARCHIVE_PREFIX" envDefault:"vbms/"`
+	ArchiveEndpoint string `env:"ARCHIVE_ENDPOINT"`
 }
 
+// archiveInterval is how often aged history is exported
+const archiveInterval = 24 * time.Hour
+
+// uptimeInterval is how often the uptime table is recomputed
+const uptimeInterval = time.Hour
+
+// minInterval is the shortest time in seconds allowed between checks of a server.
+// Don't want to DOS ourselves
+const minInterval = 60
+
+// minFailingInterval is the shortest while a server is failing, when
+// recovery should be noticed quickly
+const minFailingInterval = 10
+
 // cfg holds the application configuration
 var cfg config
 
 // Servers holds all servers we wish to monitor
 var Servers []*server.Server
 
+// running tracks the servers being checked, and housekeeping jobs, so
+// shutdown can wait for them
+var running sync.WaitGroup
+
+// digesting and archiving are held while those jobs run, see inBackground
+var (
+	digesting = make(chan struct{}, 1)
+	archiving = make(chan struct{}, 1)
+)
+
+// job is a claimed server waiting for a worker, and when to start on it
+type job struct {
+	server *server.Server
+	at     time.Time
+}
+
+// jobs feeds the servers each batch claims to the workers
+var jobs chan job
+
 func main() {
 
 	loadEnvironment()
+
+	loadSecrets()
+
+	if len(os.Args) > 1 {
+		runCommand(os.Args[1:])
+		return
+	}
+
 	verifyDatabase()
-	runBatch() // Fire off first batch
+	loadConfigDir()
+	loadPlugins()
+
+	// SIGINT or SIGTERM stops claiming batches and cancels running checks
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// SIGUSR1 claims a batch straight away, e.g. after requesting rechecks
+	usr1 := make(chan os.Signal, 1)
+	signal.Notify(usr1, syscall.SIGUSR1)
 
-	for range doTicker() {
-		runBatch()
+	listener := listen()
+	startWorkers(ctx)
+	runBatch(ctx) // Fire off first batch
+
+	ticker := doTicker()
+	for {
+		select {
+		case <-ticker.C:
+			runBatch(ctx)
+			inBackground(digesting, runDigest)
+			inBackground(archiving, func() { runArchive(ctx) })
+			runUptime()
+		case <-usr1:
+			runBatch(ctx)
+		case <-ctx.Done():
+			// A second signal kills vbms without waiting
+			stop()
+			ticker.Stop()
+			shutdown(listener)
+			return
+		}
 	}
 }
 
+// shutdown stops serving the API and waits for the servers being checked
+// to store their results, or give up their claims if their checks were
+// interrupted, so another instance can pick them up
+func shutdown(listener *http.Server) {
+	log.Info("Shutting down")
+
+	if listener != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := listener.Shutdown(ctx); err != nil {
+			log.WithError(err).Error("Unable to stop listening")
+		}
+	}
+
+	running.Wait()
+	log.Info("Shut down")
+}
+
 // Load environment variables
 func loadEnvironment() {
 	env.Parse(&cfg)
+
+	// Claims have to tell instances sharing the database apart
+	if cfg.Instance == "" {
+		hostname, _ := os.Hostname()
+		cfg.Instance = fmt.Sprintf("%v-%d", hostname, os.Getpid())
+	}
+
+	// Fleet-wide defaults, overridable per server
+	server.Default = server.Defaults{
+		Timeout:      time.Second * time.Duration(cfg.Timeout),
+		Retries:      cfg.Retries,
+		RetryBackoff: time.Millisecond * time.Duration(cfg.Backoff),
+		ExpectHTTP:   cfg.ExpectHTTP,
+		SSHJump:      cfg.SSHJump,
+		SSHJumpKey:   cfg.SSHJumpKey,
+		KnownHosts:   cfg.KnownHosts,
+		Proxy:        cfg.Proxy,
+		TLSCert:      cfg.TLSCert,
+		TLSKey:       cfg.TLSKey,
+		UserAgent:    cfg.UserAgent,
+		RDAP:         cfg.RDAP,
+
+		UnprivilegedPing: cfg.PingUDP,
+
+		MaxChecks:        cfg.MaxChecks,
+		MaxChecksPerHost: cfg.HostChecks,
+
+		RunTimeout:    time.Second * time.Duration(cfg.RunTimeout),
+		FlapThreshold: cfg.Flapping,
+	}
+}
+
+// loadSecrets sets up credential encryption and registers the secret stores
+// that settings may reference
+func loadSecrets() {
+	key := []byte(cfg.DBKey)
+	if cfg.DBKeyFile != "" {
+		var err error
+		if key, err = os.ReadFile(cfg.DBKeyFile); err != nil {
+			log.WithError(err).Fatal("Unable to read DB_KEY_FILE")
+		}
+	}
+
+	if len(key) > 0 {
+		if err := secrets.SetKey(key); err != nil {
+			log.WithError(err).Fatal("Unable to set database key")
+		}
+	}
+
+	secrets.TTL = time.Second * time.Duration(cfg.SecretsTTL)
+
+	if cfg.VaultAddr != "" {
+		vault := secrets.NewVault(cfg.VaultAddr, cfg.VaultToken)
+		secrets.Register("vault", vault)
+		go vault.KeepTokenAlive()
+	}
+
+	if cfg.AWSSecrets {
+		sm, err := secrets.NewAWSSecretsManager()
+		if err != nil {
+			log.WithError(err).Fatal("Unable to configure AWS Secrets Manager")
+		}
+		secrets.Register("awssm", sm)
+	}
 }
 
 // doTicker creates a ticker based on the UPDATE_TICK envar
-func doTicker() <-chan time.Time {
-	ticker := time.NewTicker(time.Second * time.Duration(cfg.UpdateTick))
-	return ticker.C
+func doTicker() *time.Ticker {
+	return time.NewTicker(time.Second * time.Duration(cfg.UpdateTick))
 }
 
 // verifyDatabase checks that our sqlite db exists
@@ -56,7 +270,9 @@ func verifyDatabase() {
 
 // loadDatabase opens sqlite3 database
 func loadDatabase() *sql.DB {
-	db, err := sql.Open("sqlite3", "./servers.db")
+	// Other instances may be writing, so wait for their locks, and take the
+	// write lock when a transaction begins so claims can't interleave
+	db, err := sql.Open("sqlite3", "./servers.db?_busy_timeout=5000&_txlock=immediate")
 
 	if err != nil {
 		log.Fatal("Unable to open servers.db sqlite database")
@@ -66,12 +282,107 @@ func loadDatabase() *sql.DB {
 	return db
 }
 
-// runBatch initiates checks on a batch of servers
-func runBatch() {
+// loadConfigDir syncs server definitions from the config fragments in CONFIG_DIR
+func loadConfigDir() {
+	if _, err := os.Stat(cfg.ConfigDir); err != nil {
+		return
+	}
+
+	servers, err := fleet.LoadDir(cfg.ConfigDir)
+	if err != nil {
+		log.WithError(err).Fatal("Unable to load server definitions")
+	}
+
+	db := loadDatabase()
+	defer db.Close()
+
+	created, updated, err := fleet.Sync(db, servers)
+	if err != nil {
+		log.WithError(err).Fatal("Unable to sync server definitions")
+	}
+
+	log.Infof("Loaded %d servers from %v: %d created, %d updated", len(servers), cfg.ConfigDir, created, updated)
+}
+
+// loadPlugins discovers check plugins in PLUGIN_DIR
+func loadPlugins() {
+	if _, err := os.Stat(cfg.PluginDir); err != nil {
+		return
+	}
+
+	count, err := server.LoadPlugins(cfg.PluginDir)
+	if err != nil {
+		log.WithError(err).Fatal("Unable to load plugins")
+	}
+
+	log.Infof("Loaded %d check plugins from %v", count, cfg.PluginDir)
+}
+
+// listen accepts heartbeats pushed by hosts, and API requests when
+// API_TOKEN is set, on LISTEN or the older HEARTBEAT_LISTEN
+func listen() *http.Server {
+	addr := cfg.Listen
+	if addr == "" {
+		addr = cfg.Heartbeat
+	}
+	if addr == "" {
+		return nil
+	}
+
+	db := loadDatabase()
+	listener := &http.Server{Addr: addr, Handler: server.Handler(db, cfg.APIToken)}
+
+	go func() {
+		log.Infof("Listening for heartbeats and API requests on %v", addr)
+		if err := listener.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.WithError(err).Fatal("Unable to listen")
+		}
+	}()
+
+	return listener
+}
+
+// startWorkers starts the fixed pool of workers that check the servers
+// claimed, one at a time each. There are MAX_CONCURRENT_CHECKS of them, or
+// BATCH_SIZE when that's unlimited, so a large batch queues rather than
+// starting every server at once.
+func startWorkers(ctx context.Context) {
+	workers := cfg.MaxChecks
+	if workers <= 0 {
+		workers = cfg.BatchSize
+	}
+
+	jobs = make(chan job, workers)
+	for i := 0; i < workers; i++ {
+		go work(ctx)
+	}
+}
+
+// work checks servers from jobs as they come, releasing those still
+// waiting to start once ctx is cancelled
+func work(ctx context.Context) {
+	for next := range jobs {
+		select {
+		case <-time.After(time.Until(next.at)):
+			next.server.RunChecks(ctx)
+		case <-ctx.Done():
+			next.server.Release()
+		}
+		running.Done()
+	}
+}
+
+// runBatch claims a batch of servers and queues them for the workers,
+// tracked in running
+func runBatch(ctx context.Context) {
 	db := loadDatabase()
-	batchID := updateBatch(db)
+	batchID, err := updateBatch(db)
+	if err != nil {
+		log.WithError(err).Error("Unable to claim a batch of servers")
+		return
+	}
 
-	rows, err := db.Query("SELECT * FROM servers WHERE lastupdate = ?", batchID)
+	rows, err := db.Query("SELECT * FROM servers WHERE claimedby = ? AND claimedat = ?", cfg.Instance, batchID)
 
 	if err != nil {
 		log.Fatal("Unable to select rows from database")
@@ -80,45 +391,245 @@ func runBatch() {
 	// Ensure cleanup
 	defer rows.Close()
 
+	// Spread the batch over BATCH_JITTER seconds, so servers behind the
+	// same firewall aren't all checked in the same instant. They're queued
+	// soonest first, so no worker waits on one while an earlier one queues.
+	var batch []job
+	now := time.Now()
 	for rows.Next() {
-
 		srv := server.NewServer(db, rows)
 
-		go func(cur *server.Server) {
-			cur.RunChecks()
-		}(&srv)
+		var delay time.Duration
+		if cfg.Jitter > 0 {
+			delay = time.Duration(rand.Int63n(int64(time.Duration(cfg.Jitter) * time.Second)))
+		}
+
+		batch = append(batch, job{server: &srv, at: now.Add(delay)})
+	}
+
+	sort.Slice(batch, func(i, j int) bool {
+		return batch[i].at.Before(batch[j].at)
+	})
+
+	// Queueing waits while every worker is busy, so no more is claimed
+	// until there's room
+	for _, next := range batch {
+		running.Add(1)
+		select {
+		case jobs <- next:
+		case <-ctx.Done():
+			next.server.Release()
+			running.Done()
+		}
 	}
 }
 
-// updateBatch updates a chunk of server rows with a lock value
-func updateBatch(db *sql.DB) int64 {
+// updateBatch claims a chunk of server rows for this instance, returning
+// the claim time that identifies the batch. Servers stay claimed until
+// their checks finish, or CLAIM_EXPIRY seconds have passed in case the
+// instance checking them died, so instances sharing the database never
+// check the same server at once.
+func updateBatch(db *sql.DB) (int64, error) {
 
 	// Current timestamp will be used as a batch lock
 	now := time.Now().Unix()
 
-	// No checks quicker than 60 seconds. Don't want to DOS ourselves
-	limit := now - 60
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
 
-	// Update batch of servers
+	// Update batch of unpaused, unclaimed servers whose interval has
+	// elapsed, or with a recheck requested, which go first. A zero interval
+	// inherits the profile's, then the default, and nothing is checked
+	// quicker than minInterval. Failing servers use their failing interval
+	// instead, if it's shorter, down to minFailingInterval.
 	// sqlite doesn't like LIMIT clauses in UPDATE statements, so do a hacky subquery
-	stmt, err := db.Prepare(`
-		UPDATE servers SET lastupdate = ?
-		WHERE id IN (SELECT id FROM servers WHERE lastupdate < ? LIMIT ?)
-	`)
+	res, err := tx.Exec(`
+		UPDATE servers SET lastupdate = ?, claimedby = ?, claimedat = ?
+		WHERE id IN (
+			SELECT id FROM (
+				SELECT id, recheckat, lastupdate,
+					MAX(CASE WHEN interval > 0 THEN interval ELSE COALESCE(
+						(SELECT p.interval FROM profiles p WHERE p.name = servers.profile AND p.interval > 0), ?
+					) END, ?) AS every,
+					CASE WHEN failing = 0 THEN 0 WHEN failinginterval > 0 THEN failinginterval ELSE COALESCE(
+						(SELECT p.failinginterval FROM profiles p WHERE p.name = servers.profile AND p.failinginterval > 0), ?
+					) END AS failingevery
+				FROM servers
+				WHERE paused = 0 AND (claimedby = '' OR claimedat < ?)
+			)
+			WHERE recheckat > 0 OR lastupdate < ? - CASE
+				WHEN failingevery > 0 AND failingevery < every THEN MAX(failingevery, ?)
+				ELSE every
+			END
+			ORDER BY recheckat > 0 DESC
+			LIMIT ?
+		)
+	`, now, cfg.Instance, now, cfg.Interval, minInterval, cfg.Failing, now-int64(cfg.ClaimExpiry), now, minFailingInterval, cfg.BatchSize)
 
 	if err != nil {
-		log.Fatal(err)
+		tx.Rollback()
+		return 0, err
 	}
 
-	res, err := stmt.Exec(now, limit, cfg.BatchSize)
-
-	if err != nil {
-		log.Fatal(err)
+	if err := tx.Commit(); err != nil {
+		return 0, err
 	}
 
 	rows, _ := res.RowsAffected()
 	log.Infof("Batch of %d servers queued for updates", rows)
 
 	// Return current batch ID
-	return now
+	return now, nil
+}
+
+// runDigest sends the digest for the last complete period once it is over
+func runDigest() {
+	if cfg.DigestPeriod == "" {
+		return
+	}
+
+	from, to, err := digest.LastPeriod(cfg.DigestPeriod, time.Now())
+	if err != nil {
+		log.WithError(err).Error("Unable to schedule digest")
+		return
+	}
+
+	db := loadDatabase()
+	defer db.Close()
+
+	var last int64
+	db.QueryRow("SELECT lastrun FROM jobs WHERE name = 'digest'").Scan(&last)
+	if last >= to.Unix() {
+		return
+	}
+
+	// Record the period first, so a broken relay doesn't resend every tick
+	if _, err := db.Exec("INSERT OR REPLACE INTO jobs (name, lastrun) VALUES ('digest', ?)", to.Unix()); err != nil {
+		log.WithError(err).Error("Unable to record digest")
+		return
+	}
+
+	report, err := digest.Build(db, from, to)
+	if err != nil {
+		log.WithError(err).Error("Unable to build digest")
+		return
+	}
+
+	sendDigest(report)
+}
+
+// sendDigest delivers a report to every configured destination
+func sendDigest(report *digest.Report) {
+	if cfg.DigestTo != "" {
+		if err := report.Mail(cfg.DigestSMTP, cfg.DigestFrom, strings.Split(cfg.DigestTo, ",")); err != nil {
+			log.WithError(err).Error("Unable to email digest")
+		} else {
+			log.Infof("Emailed %v to %v", report.Subject(), cfg.DigestTo)
+		}
+	}
+
+	if cfg.DigestSlack != "" {
+		if err := report.PostSlack(cfg.DigestSlack); err != nil {
+			log.WithError(err).Error("Unable to post digest to Slack")
+		} else {
+			log.Infof("Posted %v to Slack", report.Subject())
+		}
+	}
+}
+
+// runUptime recomputes rolling uptime and SLA breaches once an hour
+func runUptime() {
+	db := loadDatabase()
+	defer db.Close()
+
+	var last int64
+	db.QueryRow("SELECT lastrun FROM jobs WHERE name = 'uptime'").Scan(&last)
+	if time.Since(time.Unix(last, 0)) < uptimeInterval {
+		return
+	}
+
+	if _, err := db.Exec("INSERT OR REPLACE INTO jobs (name, lastrun) VALUES ('uptime', ?)", time.Now().Unix()); err != nil {
+		log.WithError(err).Error("Unable to record uptime")
+		return
+	}
+
+	if err := server.UpdateUptime(db, time.Now()); err != nil {
+		log.WithError(err).Error("Unable to update uptime")
+	}
+}
+
+// inBackground runs job off the scheduler loop, so a slow one doesn't hold up
+// batches or signals. It's skipped while the last run of it, holding busy,
+// is still going.
+func inBackground(busy chan struct{}, job func()) {
+	select {
+	case busy <- struct{}{}:
+	default:
+		return
+	}
+
+	running.Add(1)
+	go func() {
+		defer running.Done()
+		defer func() { <-busy }()
+		job()
+	}()
+}
+
+// runArchive prunes aged history once a day, exporting it first when a
+// bucket is configured. The export is abandoned when ctx is cancelled.
+func runArchive(ctx context.Context) {
+	if cfg.HistoryDays <= 0 {
+		return
+	}
+
+	db := loadDatabase()
+	defer db.Close()
+
+	var last int64
+	db.QueryRow("SELECT lastrun FROM jobs WHERE name = 'archive'").Scan(&last)
+	if time.Since(time.Unix(last, 0)) < archiveInterval {
+		return
+	}
+
+	if _, err := db.Exec("INSERT OR REPLACE INTO jobs (name, lastrun) VALUES ('archive', ?)", time.Now().Unix()); err != nil {
+		log.WithError(err).Error("Unable to record archive")
+		return
+	}
+
+	before := time.Now().AddDate(0, 0, -cfg.HistoryDays)
+
+	if _, err := archive.PruneRuns(db, before); err != nil {
+		log.WithError(err).Error("Unable to prune runs")
+	}
+
+	if cfg.ArchiveBucket == "" {
+		count, err := archive.Prune(db, before)
+		if err != nil {
+			log.WithError(err).Error("Unable to prune history")
+			return
+		}
+
+		log.Infof("Pruned %d history rows from before %v", count, before.Format("2006-01-02"))
+		return
+	}
+
+	store, err := archive.NewStore(cfg.ArchiveBucket, cfg.ArchivePrefix, cfg.ArchiveEndpoint)
+	if err != nil {
+		log.WithError(err).Error("Unable to configure archive storage")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+	defer cancel()
+
+	count, err := store.Export(ctx, db, before)
+	if err != nil {
+		log.WithError(err).Error("Unable to archive history")
+		return
+	}
+
+	log.Infof("Archived %d history rows from before %v to %v", count, before.Format("2006-01-02"), cfg.ArchiveBucket)
 }
@@ -0,0 +1,158 @@
+package notify
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// defaultAlertExpiry is how long a firing alert lasts without vbms resolving
+// it, by default
+const defaultAlertExpiry = 24 * time.Hour
+
+// invalidLabel matches characters Prometheus doesn't allow in label names
+var invalidLabel = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// alertmanagerConfig is an alertmanager channel's config. Alerts are posted
+// to each of URLs, every Alertmanager in a cluster, with a bearer Token or
+// a Username and Password when they're behind authentication. Labels are
+// added to every alert, e.g. to route them to a team.
+type alertmanagerConfig struct {
+	URLs        []string          `json:"urls"`
+	Token       string            `json:"token"`
+	Username    string            `json:"username"`
+	Password    string            `json:"password"`
+	Labels      map[string]string `json:"labels"`
+	ExpiryHours int               `json:"expiry_hours"`
+}
+
+// alertmanagerNotifier forwards events to Prometheus Alertmanager
+type alertmanagerNotifier struct {
+	config alertmanagerConfig
+}
+
+// alert is an alert in Alertmanager's v2 API
+type alert struct {
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      time.Time         `json:"endsAt"`
+}
+
+// newAlertmanager makes an Alertmanager notifier
+func newAlertmanager(raw json.RawMessage) (Notifier, error) {
+	var config alertmanagerConfig
+	if err := json.Unmarshal(raw, &config); err != nil {
+		return nil, fmt.Errorf("invalid alertmanager config: %v", err)
+	}
+	if len(config.URLs) == 0 {
+		return nil, errors.New("alertmanager channels need at least one url")
+	}
+
+	var err error
+	if config.Token, err = resolve(config.Token); err != nil {
+		return nil, fmt.Errorf("unable to resolve token: %v", err)
+	}
+	if config.Password, err = resolve(config.Password); err != nil {
+		return nil, fmt.Errorf("unable to resolve password: %v", err)
+	}
+
+	return &alertmanagerNotifier{config: config}, nil
+}
+
+// labels identifies the alert for e. The tags are listed in tags between
+// commas, e.g. ",web,db,", to match with tags=~".*,web,.*", and a key=value
+// tag is also a label of its own.
+func (n *alertmanagerNotifier) labels(e Event) map[string]string {
+	labels := map[string]string{}
+	for key, value := range n.config.Labels {
+		labels[key] = value
+	}
+
+	for _, tag := range e.Tags {
+		if key, value, ok := strings.Cut(tag, "="); ok && key != "" {
+			labels[invalidLabel.ReplaceAllString(key, "_")] = value
+		}
+	}
+	if len(e.Tags) > 0 {
+		labels["tags"] = "," + strings.Join(e.Tags, ",") + ","
+	}
+
+	labels["alertname"] = "VbmsCheckDown"
+	labels["instance"] = e.Hostname
+	labels["check"] = e.Check
+	labels["severity"] = "critical"
+
+	return labels
+}
+
+// refreshes has the alerts of checks that stay down sent again each run
+func (n *alertmanagerNotifier) refreshes() {}
+
+// Notify fires an alert when a check goes down, fires it again on each run
+// it stays down and resolves it on recovery. Alertmanager resolves alerts
+// that aren't sent again within its resolve timeout, so firing alerts say
+// when they end instead, ExpiryHours after they were last sent, in case
+// vbms stops checking. Acknowledgements are left to Alertmanager's silences,
+// and flapping, without an end, isn't sent.
+func (n *alertmanagerNotifier) Notify(ctx context.Context, e Event) error {
+	a := alert{
+		Labels: n.labels(e),
+		Annotations: map[string]string{
+			"summary":     e.Title(),
+			"description": e.Result,
+		},
+	}
+
+	expiry := defaultAlertExpiry
+	if n.config.ExpiryHours > 0 {
+		expiry = time.Duration(n.config.ExpiryHours) * time.Hour
+	}
+
+	switch e.Kind {
+	case Down, Firing:
+		a.StartsAt, a.EndsAt = e.Since, e.At.Add(expiry)
+		if e.Kind == Down || e.Since.IsZero() {
+			a.StartsAt = e.At
+		}
+	case Recovered:
+		a.StartsAt, a.EndsAt = e.Since, e.At
+		if e.Since.IsZero() {
+			a.StartsAt = e.At
+		}
+	default:
+		return nil
+	}
+
+	header := http.Header{}
+	if n.config.Token != "" {
+		header.Set("Authorization", "Bearer "+n.config.Token)
+	} else if n.config.Username != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte(n.config.Username + ":" + n.config.Password))
+		header.Set("Authorization", "Basic "+credentials)
+	}
+
+	var failed []string
+	for _, url := range n.config.URLs {
+		endpoint := strings.TrimSuffix(url, "/") + "/api/v2/alerts"
+		if err := postJSON(ctx, endpoint, []alert{a}, header); err != nil {
+			failed = append(failed, fmt.Sprintf("%v: %v", url, err))
+		}
+	}
+
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, "; "))
+	}
+
+	return nil
+}
+
+func init() {
+	Register("alertmanager", newAlertmanager, "token", "password")
+}
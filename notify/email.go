@@ -0,0 +1,183 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Default templates, given the Event
+const (
+	defaultSubject = `[vbms] {{.Hostname}} {{.Check}} {{.Kind}}`
+	defaultBody    = `{{.Summary}}
+
+Server:   {{.Hostname}}
+Check:    {{.Check}}
+State:    {{.State}} (was {{.Previous}})
+Result:   {{.Result}}
+{{if .Duration}}Duration: {{.Duration}}
+{{end}}At:       {{.At.Format "2006-01-02 15:04:05 MST"}}
+`
+)
+
+// emailConfig is an email channel's config. Relay is host:port, port 25
+// when left out. Without a username the relay has to accept mail from this
+// host.
+type emailConfig struct {
+	recipientLists
+	Relay    string `json:"relay"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+	From     string `json:"from"`
+	Subject  string `json:"subject"`
+	Body     string `json:"body"`
+}
+
+// emailNotifier mails events through an SMTP relay
+type emailNotifier struct {
+	config  emailConfig
+	subject *template.Template
+	body    *template.Template
+}
+
+// newEmail makes an email notifier, checking its templates parse
+func newEmail(raw json.RawMessage) (Notifier, error) {
+	var config emailConfig
+	if err := json.Unmarshal(raw, &config); err != nil {
+		return nil, fmt.Errorf("invalid email config: %v", err)
+	}
+	if config.Relay == "" || config.From == "" {
+		return nil, errors.New("email channels need a relay and from address")
+	}
+
+	if config.Subject == "" {
+		config.Subject = defaultSubject
+	}
+	if config.Body == "" {
+		config.Body = defaultBody
+	}
+
+	subject, err := template.New("subject").Parse(config.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("invalid subject template: %v", err)
+	}
+	body, err := template.New("body").Parse(config.Body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid body template: %v", err)
+	}
+
+	password, err := resolve(config.Password)
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve password: %v", err)
+	}
+	config.Password = password
+
+	return &emailNotifier{config: config, subject: subject, body: body}, nil
+}
+
+// message renders the mail for e
+func (n *emailNotifier) message(e Event, to []string) ([]byte, error) {
+	var subject, body bytes.Buffer
+	if err := n.subject.Execute(&subject, e); err != nil {
+		return nil, err
+	}
+	if err := n.body.Execute(&body, e); err != nil {
+		return nil, err
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %v\r\n", n.config.From)
+	fmt.Fprintf(&msg, "To: %v\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %v\r\n", strings.TrimSpace(strings.ReplaceAll(subject.String(), "\n", " ")))
+	fmt.Fprintf(&msg, "Date: %v\r\n", e.At.Format(time.RFC1123Z))
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body.String(), "\n", "\r\n"))
+
+	return msg.Bytes(), nil
+}
+
+// Notify sends e to its recipients, using STARTTLS when the relay offers it.
+// Only down and recovered events are mailed.
+func (n *emailNotifier) Notify(ctx context.Context, e Event) error {
+	if e.Kind != Down && e.Kind != Recovered {
+		return nil
+	}
+
+	to := n.config.recipients(e)
+	if len(to) == 0 {
+		return errors.New("no recipients")
+	}
+
+	msg, err := n.message(e, to)
+	if err != nil {
+		return err
+	}
+
+	addr := n.config.Relay
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "25")
+	}
+	host, _, _ := net.SplitHostPort(addr)
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+
+	if n.config.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", n.config.Username, n.config.Password, host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(n.config.From); err != nil {
+		return err
+	}
+	for _, rcpt := range to {
+		if err := client.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+func init() {
+	Register("email", newEmail, "password")
+}
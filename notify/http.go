@@ -0,0 +1,80 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// client sends the notifiers' requests
+var client = &http.Client{Timeout: 30 * time.Second}
+
+// statusError is a response other than 2xx
+type statusError struct {
+	code   int
+	status string
+	reply  string
+}
+
+func (e *statusError) Error() string {
+	if e.reply != "" {
+		return fmt.Sprintf("%v: %v", e.status, e.reply)
+	}
+
+	return e.status
+}
+
+// temporary reports whether the request might succeed if sent again, on a
+// server error or being rate limited
+func (e *statusError) temporary() bool {
+	return e.code >= 500 || e.code == http.StatusTooManyRequests
+}
+
+// post sends body to endpoint with its content type and any extra headers,
+// failing with a statusError unless the response is 2xx
+func post(ctx context.Context, endpoint string, contentType string, body []byte, header http.Header) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	// Webhook URLs can be credentials, so keep them out of the error
+	resp, err := client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		reply, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &statusError{code: resp.StatusCode, status: resp.Status, reply: strings.TrimSpace(string(reply))}
+	}
+
+	return nil
+}
+
+// postJSON posts v as JSON to endpoint
+func postJSON(ctx context.Context, endpoint string, v interface{}, header http.Header) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return post(ctx, endpoint, "application/json", body, header)
+}
@@ -0,0 +1,415 @@
+package notify
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/blinktag/vbms/secrets"
+	"github.com/kisielk/sqlstruct"
+)
+
+// Kind is the sort of transition an event reports
+type Kind string
+
+// Kinds of event. A check is down when its hard state goes CRIT, recovered
+// when it goes back to OK and flapping when it changes state too often for
+// either to mean much. A down check is acknowledged when someone says
+// they're on it, with vbms ack, and firing on each run it stays down, for
+// channels whose alerts expire unless sent again.
+const (
+	Down         Kind = "down"
+	Recovered    Kind = "recovered"
+	Flapping     Kind = "flapping"
+	Acknowledged Kind = "acknowledged"
+	Firing       Kind = "firing"
+)
+
+// Event is a check on a server changing state
+type Event struct {
+	Kind     Kind      `json:"kind"`
+	ServerID int       `json:"serverid"`
+	Hostname string    `json:"hostname"`
+	Tags     []string  `json:"tags,omitempty"`
+	Check    string    `json:"check"`
+	State    string    `json:"state"`
+	Previous string    `json:"previous"`
+	Result   string    `json:"result"`
+	Since    time.Time `json:"since"`
+	At       time.Time `json:"at"`
+}
+
+// Duration is how long the check was in its previous state, e.g. how long
+// it was down for on recovery, to the second
+func (e Event) Duration() time.Duration {
+	if e.Since.IsZero() {
+		return 0
+	}
+
+	return e.At.Sub(e.Since).Round(time.Second)
+}
+
+// Title is a headline for the event, e.g. "www.example.com https is down"
+func (e Event) Title() string {
+	switch e.Kind {
+	case Down, Firing:
+		return fmt.Sprintf("%v %v is down", e.Hostname, e.Check)
+	case Flapping:
+		return fmt.Sprintf("%v %v is flapping", e.Hostname, e.Check)
+	}
+
+	return fmt.Sprintf("%v %v %v", e.Hostname, e.Check, e.Kind)
+}
+
+// Summary describes the event in a line, e.g. "www.example.com https is
+// down: Unable to open port"
+func (e Event) Summary() string {
+	switch e.Kind {
+	case Down, Firing:
+		return fmt.Sprintf("%v %v is down: %v", e.Hostname, e.Check, e.Result)
+	case Recovered:
+		return fmt.Sprintf("%v %v recovered after %v: %v", e.Hostname, e.Check, e.Duration(), e.Result)
+	case Flapping:
+		return fmt.Sprintf("%v %v is flapping, now %v: %v", e.Hostname, e.Check, e.State, e.Result)
+	case Acknowledged:
+		return fmt.Sprintf("%v %v acknowledged, down for %v: %v", e.Hostname, e.Check, e.Duration(), e.Result)
+	}
+
+	return fmt.Sprintf("%v %v %v: %v", e.Hostname, e.Check, e.Kind, e.Result)
+}
+
+// Notifier delivers events to one channel, such as an email address or a
+// chat webhook
+type Notifier interface {
+	Notify(ctx context.Context, e Event) error
+}
+
+// Factory makes a notifier from a channel's JSON configuration
+type Factory func(config json.RawMessage) (Notifier, error)
+
+// stateful is implemented by notifiers keeping state between events in the
+// database, such as how many messages each recipient has been sent
+type stateful interface {
+	useDatabase(db *sql.DB, channel Channel)
+}
+
+// refreshing is implemented by notifiers whose alerts expire unless sent
+// again, such as Alertmanager's. Only they are sent Firing events.
+type refreshing interface {
+	refreshes()
+}
+
+var (
+	mu        sync.Mutex
+	factories = map[string]Factory{}
+	sensitive = map[string][]string{}
+)
+
+// Register makes a kind of channel available, e.g. "email" for channels
+// rows with that kind. keys are the config keys holding credentials, which
+// the factory resolves and EncryptConfig encrypts.
+func Register(kind string, f Factory, keys ...string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	factories[kind] = f
+	sensitive[kind] = keys
+}
+
+// EncryptConfig encrypts the credentials in a config for a kind of channel,
+// returning the new config and how many values were encrypted. Lists and
+// objects of credentials, such as webhook URLs or headers, have each value
+// encrypted.
+func EncryptConfig(kind string, config string) (string, int, error) {
+	mu.Lock()
+	keys := sensitive[kind]
+	mu.Unlock()
+
+	if len(keys) == 0 || strings.TrimSpace(config) == "" {
+		return config, 0, nil
+	}
+
+	var values map[string]interface{}
+	if err := json.Unmarshal([]byte(config), &values); err != nil {
+		return config, 0, fmt.Errorf("invalid %v config: %v", kind, err)
+	}
+
+	count := 0
+	encrypt := func(value interface{}) (interface{}, error) {
+		str, ok := value.(string)
+		if !ok || str == "" || secrets.IsEncrypted(str) {
+			return value, nil
+		}
+		count++
+		return secrets.Encrypt(str)
+	}
+
+	for _, key := range keys {
+		var err error
+		switch value := values[key].(type) {
+		case []interface{}:
+			for i := range value {
+				if value[i], err = encrypt(value[i]); err != nil {
+					return config, 0, err
+				}
+			}
+		case map[string]interface{}:
+			for k := range value {
+				if value[k], err = encrypt(value[k]); err != nil {
+					return config, 0, err
+				}
+			}
+		case string:
+			if values[key], err = encrypt(value); err != nil {
+				return config, 0, err
+			}
+		}
+	}
+
+	if count == 0 {
+		return config, 0, nil
+	}
+
+	encrypted, err := json.Marshal(values)
+	return string(encrypted), count, err
+}
+
+// Channel is a configured destination, stored in the channels table. Config
+// is a JSON object whose keys depend on the kind.
+type Channel struct {
+	ID     int    `sql:"id"`
+	Name   string `sql:"name"`
+	Kind   string `sql:"kind"`
+	Config string `sql:"config"`
+}
+
+// notifier makes the channel's notifier from its configuration
+func (c Channel) notifier() (Notifier, error) {
+	mu.Lock()
+	f, ok := factories[c.Kind]
+	mu.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown channel kind '%v'", c.Kind)
+	}
+
+	config := json.RawMessage(c.Config)
+	if strings.TrimSpace(c.Config) == "" {
+		config = json.RawMessage("{}")
+	}
+
+	return f(config)
+}
+
+// Route sends events to a channel for one server, the servers with a tag,
+// or with neither the whole fleet. Events lists the kinds routed, comma
+// separated, or all of them when empty.
+type Route struct {
+	ID        int    `sql:"id"`
+	ChannelID int    `sql:"channelid"`
+	ServerID  int    `sql:"serverid"`
+	Tag       string `sql:"tag"`
+	Events    string `sql:"events"`
+}
+
+// matches reports whether the route takes e. Routes taking down events take
+// firing ones too.
+func (r Route) matches(e Event) bool {
+	if r.ServerID != 0 && r.ServerID != e.ServerID {
+		return false
+	}
+
+	if r.ServerID == 0 && r.Tag != "" {
+		tagged := false
+		for _, tag := range e.Tags {
+			if strings.EqualFold(tag, r.Tag) {
+				tagged = true
+			}
+		}
+		if !tagged {
+			return false
+		}
+	}
+
+	if strings.TrimSpace(r.Events) == "" {
+		return true
+	}
+
+	for _, kind := range strings.Split(r.Events, ",") {
+		if k := Kind(strings.TrimSpace(kind)); k == e.Kind || (k == Down && e.Kind == Firing) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Channels returns the channels routed to take e, each once however many of
+// its routes match
+func Channels(db *sql.DB, e Event) ([]Channel, error) {
+	rows, err := db.Query("SELECT * FROM channelroutes WHERE serverid = ? OR serverid = 0", e.ServerID)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []int
+	seen := map[int]bool{}
+	for rows.Next() {
+		var r Route
+		if err := sqlstruct.Scan(&r, rows); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if r.matches(e) && !seen[r.ChannelID] {
+			seen[r.ChannelID] = true
+			ids = append(ids, r.ChannelID)
+		}
+	}
+	rows.Close()
+
+	var channels []Channel
+	for _, id := range ids {
+		rows, err := db.Query("SELECT * FROM channels WHERE id = ?", id)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var c Channel
+			if err := sqlstruct.Scan(&c, rows); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			channels = append(channels, c)
+		}
+		rows.Close()
+	}
+
+	return channels, nil
+}
+
+// Dispatch sends e to every channel routed to take it and records each
+// delivery, with its error if it failed, in the notifications table. A
+// failing channel is logged rather than stopping the others. Firing events
+// come every run, so only failures to send them are recorded.
+func Dispatch(ctx context.Context, db *sql.DB, e Event) {
+	logger := logrus.WithFields(logrus.Fields{"Server": e.Hostname, "Service": "NOTIFY"})
+
+	channels, err := Channels(db, e)
+	if err != nil {
+		logger.WithError(err).Error("Unable to load notification channels")
+		return
+	}
+
+	for _, c := range channels {
+		var sendErr string
+
+		n, err := c.notifier()
+		if _, ok := n.(refreshing); e.Kind == Firing && !ok {
+			continue
+		}
+		if err == nil {
+			if n, ok := n.(stateful); ok {
+				n.useDatabase(db, c)
+			}
+			err = n.Notify(ctx, e)
+		}
+		switch {
+		case err != nil:
+			sendErr = err.Error()
+			logger.WithError(err).Errorf("Unable to notify %v", c.Name)
+		case e.Kind == Firing:
+			logger.Debugf("Notified %v: %v", c.Name, e.Summary())
+			continue
+		default:
+			logger.Infof("Notified %v: %v", c.Name, e.Summary())
+		}
+
+		_, err = db.Exec(`
+			INSERT INTO notifications (channelid, serverid, checkname, kind, state, sentat, error)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+		`, c.ID, e.ServerID, e.Check, e.Kind, e.State, e.At.Unix(), sendErr)
+		if err != nil {
+			logger.WithError(err).Error("Unable to record notification")
+		}
+	}
+}
+
+// recipientLists is the part of a channel's config saying who its messages go
+// to: To gets every event, and ToServers and ToTags add recipients for a
+// hostname or the servers with a tag
+type recipientLists struct {
+	To        []string            `json:"to"`
+	ToServers map[string][]string `json:"to_servers"`
+	ToTags    map[string][]string `json:"to_tags"`
+}
+
+// recipients returns who gets e, each once
+func (r recipientLists) recipients(e Event) []string {
+	lists := [][]string{r.To}
+	for hostname, to := range r.ToServers {
+		if strings.EqualFold(hostname, e.Hostname) {
+			lists = append(lists, to)
+		}
+	}
+	for tag, to := range r.ToTags {
+		for _, t := range e.Tags {
+			if strings.EqualFold(tag, t) {
+				lists = append(lists, to)
+			}
+		}
+	}
+
+	var recipients []string
+	seen := map[string]bool{}
+	for _, list := range lists {
+		for _, to := range list {
+			key := strings.ToLower(strings.TrimSpace(to))
+			if key != "" && !seen[key] {
+				seen[key] = true
+				recipients = append(recipients, strings.TrimSpace(to))
+			}
+		}
+	}
+
+	return recipients
+}
+
+// envReference matches ${VAR} references, as in server settings
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// resolve decrypts a setting from a channel's config, expands environment
+// references and fetches it if it references a secret store, as server
+// settings are
+func resolve(value string) (string, error) {
+	value, err := secrets.Decrypt(value)
+	if err != nil {
+		return "", err
+	}
+
+	value = envReference.ReplaceAllStringFunc(value, func(ref string) string {
+		return os.Getenv(envReference.FindStringSubmatch(ref)[1])
+	})
+
+	return secrets.Resolve(value)
+}
+
+// logNotifier writes events to the log, for trying routes out
+type logNotifier struct{}
+
+func (logNotifier) Notify(ctx context.Context, e Event) error {
+	logrus.WithField("Server", e.Hostname).Warn(e.Summary())
+	return nil
+}
+
+func init() {
+	Register("log", func(config json.RawMessage) (Notifier, error) {
+		return logNotifier{}, nil
+	})
+}
@@ -0,0 +1,88 @@
+package notify
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ntfyServer is the public ntfy server, used unless one is configured
+const ntfyServer = "https://ntfy.sh"
+
+// ntfyConfig is an ntfy channel's config: the topic to publish to on Server,
+// ntfy.sh by default. A server with access control takes an access Token,
+// or a Username and Password.
+type ntfyConfig struct {
+	Server   string `json:"server"`
+	Topic    string `json:"topic"`
+	Token    string `json:"token"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// ntfyNotifier publishes events to an ntfy topic
+type ntfyNotifier struct {
+	config ntfyConfig
+}
+
+// Priorities and tags, shown as emoji, for each kind of event
+var (
+	ntfyPriorities = map[Kind]string{Down: "urgent", Flapping: "high"}
+	ntfyTags       = map[Kind]string{Down: "rotating_light", Recovered: "white_check_mark", Flapping: "warning", Acknowledged: "eyes"}
+)
+
+// newNtfy makes an ntfy notifier. Anyone who knows a topic on a public
+// server can subscribe to it, so it, the token and the password can be
+// encrypted or reference a secret store.
+func newNtfy(raw json.RawMessage) (Notifier, error) {
+	var config ntfyConfig
+	if err := json.Unmarshal(raw, &config); err != nil {
+		return nil, fmt.Errorf("invalid ntfy config: %v", err)
+	}
+
+	for _, setting := range []*string{&config.Topic, &config.Token, &config.Password} {
+		var err error
+		if *setting, err = resolve(*setting); err != nil {
+			return nil, fmt.Errorf("unable to resolve secret: %v", err)
+		}
+	}
+	if config.Topic == "" {
+		return nil, errors.New("ntfy channels need a topic")
+	}
+
+	if config.Server == "" {
+		config.Server = ntfyServer
+	}
+
+	return &ntfyNotifier{config: config}, nil
+}
+
+// Notify publishes e, urgently when a check goes down
+func (n *ntfyNotifier) Notify(ctx context.Context, e Event) error {
+	header := http.Header{}
+	header.Set("Title", e.Title())
+	header.Set("Tags", ntfyTags[e.Kind])
+	if priority := ntfyPriorities[e.Kind]; priority != "" {
+		header.Set("Priority", priority)
+	}
+
+	if n.config.Token != "" {
+		header.Set("Authorization", "Bearer "+n.config.Token)
+	} else if n.config.Username != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte(n.config.Username + ":" + n.config.Password))
+		header.Set("Authorization", "Basic "+credentials)
+	}
+
+	endpoint := strings.TrimSuffix(n.config.Server, "/") + "/" + url.PathEscape(n.config.Topic)
+
+	return post(ctx, endpoint, "text/plain; charset=utf-8", []byte(e.Summary()), header)
+}
+
+func init() {
+	Register("ntfy", newNtfy, "topic", "token", "password")
+}
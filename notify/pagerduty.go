@@ -0,0 +1,116 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// pagerDutyURL is the Events API v2 endpoint
+const pagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+
+// pagerDutyConfig is a pagerduty channel's config. RoutingKey is the
+// integration key of an Events API v2 integration on the service. URL only
+// needs setting for the EU service region.
+type pagerDutyConfig struct {
+	RoutingKey string `json:"routing_key"`
+	URL        string `json:"url"`
+}
+
+// pagerDutyNotifier pages through PagerDuty's Events API v2
+type pagerDutyNotifier struct {
+	config pagerDutyConfig
+}
+
+// pagerDutyEvent is an Events API v2 request. Payload is only sent when
+// triggering.
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string                 `json:"summary"`
+	Source        string                 `json:"source"`
+	Severity      string                 `json:"severity"`
+	Timestamp     string                 `json:"timestamp"`
+	Component     string                 `json:"component"`
+	Group         string                 `json:"group,omitempty"`
+	CustomDetails map[string]interface{} `json:"custom_details"`
+}
+
+// newPagerDuty makes a PagerDuty notifier
+func newPagerDuty(raw json.RawMessage) (Notifier, error) {
+	var config pagerDutyConfig
+	if err := json.Unmarshal(raw, &config); err != nil {
+		return nil, fmt.Errorf("invalid pagerduty config: %v", err)
+	}
+
+	key, err := resolve(config.RoutingKey)
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve routing key: %v", err)
+	}
+	if key == "" {
+		return nil, errors.New("pagerduty channels need a routing_key")
+	}
+	config.RoutingKey = key
+
+	if config.URL == "" {
+		config.URL = pagerDutyURL
+	}
+
+	return &pagerDutyNotifier{config: config}, nil
+}
+
+// Notify triggers an alert when a check goes down, or a warning when it
+// starts flapping, and acknowledges or resolves it. Every event for a check
+// on a server shares a dedup key, so they all act on the same alert and a
+// recovery resolves the page.
+func (n *pagerDutyNotifier) Notify(ctx context.Context, e Event) error {
+	req := pagerDutyEvent{
+		RoutingKey: n.config.RoutingKey,
+		DedupKey:   fmt.Sprintf("vbms/%v/%v", e.Hostname, e.Check),
+	}
+
+	switch e.Kind {
+	case Down, Flapping:
+		severity := "critical"
+		if e.Kind == Flapping {
+			severity = "warning"
+		}
+
+		req.EventAction = "trigger"
+		req.Payload = &pagerDutyPayload{
+			Summary:   e.Summary(),
+			Source:    e.Hostname,
+			Severity:  severity,
+			Timestamp: e.At.Format(time.RFC3339),
+			Component: e.Check,
+			CustomDetails: map[string]interface{}{
+				"state":    e.State,
+				"previous": e.Previous,
+				"result":   e.Result,
+				"tags":     e.Tags,
+			},
+		}
+		if len(e.Tags) > 0 {
+			req.Payload.Group = e.Tags[0]
+		}
+	case Acknowledged:
+		req.EventAction = "acknowledge"
+	case Recovered:
+		req.EventAction = "resolve"
+	default:
+		return nil
+	}
+
+	return postJSON(ctx, n.config.URL, req, nil)
+}
+
+func init() {
+	Register("pagerduty", newPagerDuty, "routing_key")
+}
@@ -0,0 +1,75 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// pushoverURL is where Pushover messages are sent
+var pushoverURL = "https://api.pushover.net/1/messages.json"
+
+// pushoverConfig is a pushover channel's config: the application's API
+// token and the user or group key to push to, optionally only to one of
+// their devices
+type pushoverConfig struct {
+	Token  string `json:"token"`
+	User   string `json:"user"`
+	Device string `json:"device"`
+}
+
+// pushoverNotifier pushes events to phones with Pushover
+type pushoverNotifier struct {
+	config pushoverConfig
+}
+
+// newPushover makes a Pushover notifier
+func newPushover(raw json.RawMessage) (Notifier, error) {
+	var config pushoverConfig
+	if err := json.Unmarshal(raw, &config); err != nil {
+		return nil, fmt.Errorf("invalid pushover config: %v", err)
+	}
+
+	var err error
+	if config.Token, err = resolve(config.Token); err != nil {
+		return nil, fmt.Errorf("unable to resolve token: %v", err)
+	}
+	if config.User, err = resolve(config.User); err != nil {
+		return nil, fmt.Errorf("unable to resolve user: %v", err)
+	}
+	if config.Token == "" || config.User == "" {
+		return nil, errors.New("pushover channels need a token and user")
+	}
+
+	return &pushoverNotifier{config: config}, nil
+}
+
+// Notify pushes e, at high priority when a check goes down so it bypasses
+// the user's quiet hours
+func (n *pushoverNotifier) Notify(ctx context.Context, e Event) error {
+	priority := "0"
+	if e.Kind == Down {
+		priority = "1"
+	}
+
+	form := url.Values{
+		"token":     {n.config.Token},
+		"user":      {n.config.User},
+		"title":     {e.Title()},
+		"message":   {e.Summary()},
+		"priority":  {priority},
+		"timestamp": {strconv.FormatInt(e.At.Unix(), 10)},
+	}
+	if n.config.Device != "" {
+		form.Set("device", n.config.Device)
+	}
+
+	return post(ctx, pushoverURL, "application/x-www-form-urlencoded", []byte(form.Encode()), nil)
+}
+
+func init() {
+	Register("pushover", newPushover, "token", "user")
+}
@@ -0,0 +1,112 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// teamsConfig is a teams channel's config, the incoming webhook or workflow
+// URLs of the channels to post to
+type teamsConfig struct {
+	URLs []string `json:"urls"`
+}
+
+// teamsNotifier posts Adaptive Cards to Microsoft Teams
+type teamsNotifier struct {
+	config teamsConfig
+}
+
+// newTeams makes a Teams notifier. Webhook URLs carry their own credentials,
+// so they can be encrypted or reference a secret store.
+func newTeams(raw json.RawMessage) (Notifier, error) {
+	var config teamsConfig
+	if err := json.Unmarshal(raw, &config); err != nil {
+		return nil, fmt.Errorf("invalid teams config: %v", err)
+	}
+	if len(config.URLs) == 0 {
+		return nil, errors.New("teams channels need at least one url")
+	}
+
+	for i, url := range config.URLs {
+		var err error
+		if config.URLs[i], err = resolve(url); err != nil {
+			return nil, fmt.Errorf("unable to resolve url: %v", err)
+		}
+	}
+
+	return &teamsNotifier{config: config}, nil
+}
+
+// card returns the message for e: a headline coloured by the new state, the
+// result and the details as facts
+func (n *teamsNotifier) card(e Event) map[string]interface{} {
+	color := "attention"
+	if e.Kind == Recovered {
+		color = "good"
+	}
+
+	facts := []map[string]string{
+		{"title": "Server", "value": e.Hostname},
+		{"title": "Check", "value": e.Check},
+		{"title": "State", "value": fmt.Sprintf("%v (was %v)", e.State, e.Previous)},
+	}
+	if d := e.Duration(); d > 0 {
+		label := "Since"
+		if e.Kind == Recovered {
+			label = "Down for"
+		}
+		facts = append(facts, map[string]string{"title": label, "value": d.String()})
+	}
+	if len(e.Tags) > 0 {
+		facts = append(facts, map[string]string{"title": "Tags", "value": strings.Join(e.Tags, ", ")})
+	}
+	facts = append(facts, map[string]string{"title": "At", "value": e.At.Format(time.RFC1123)})
+
+	return map[string]interface{}{
+		"type": "message",
+		"attachments": []map[string]interface{}{{
+			"contentType": "application/vnd.microsoft.card.adaptive",
+			"content": map[string]interface{}{
+				"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+				"type":    "AdaptiveCard",
+				"version": "1.4",
+				"body": []map[string]interface{}{
+					{"type": "TextBlock", "text": e.Title(), "weight": "bolder", "size": "medium", "color": color, "wrap": true},
+					{"type": "TextBlock", "text": e.Result, "wrap": true},
+					{"type": "FactSet", "facts": facts},
+				},
+			},
+		}},
+	}
+}
+
+// Notify posts a card for down and recovered events to every URL,
+// continuing past any that fail
+func (n *teamsNotifier) Notify(ctx context.Context, e Event) error {
+	if e.Kind != Down && e.Kind != Recovered {
+		return nil
+	}
+
+	card := n.card(e)
+
+	var failed []string
+	for _, url := range n.config.URLs {
+		if err := postJSON(ctx, url, card, nil); err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, "; "))
+	}
+
+	return nil
+}
+
+func init() {
+	Register("teams", newTeams, "urls")
+}
@@ -0,0 +1,186 @@
+package notify
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// twilioURL is where messages are created, given the account SID
+var twilioURL = "https://api.twilio.com/2010-04-01/Accounts/%v/Messages.json"
+
+// Defaults for how many texts a recipient is sent within a window
+const (
+	defaultThrottle        = 5
+	defaultThrottleMinutes = 60
+)
+
+// smsLength is the longest text sent, in characters, keeping a page to a
+// couple of SMS segments
+const smsLength = 300
+
+// twilioConfig is a twilio channel's config. Texts go from From, a Twilio
+// number, to numbers in E.164 form. Each recipient is sent at most Throttle
+// texts every ThrottleMinutes.
+type twilioConfig struct {
+	recipientLists
+	AccountSID      string `json:"account_sid"`
+	AuthToken       string `json:"auth_token"`
+	From            string `json:"from"`
+	Throttle        int    `json:"throttle"`
+	ThrottleMinutes int    `json:"throttle_minutes"`
+}
+
+// twilioNotifier texts critical alerts through Twilio
+type twilioNotifier struct {
+	config  twilioConfig
+	db      *sql.DB
+	channel Channel
+}
+
+// newTwilio makes a Twilio notifier
+func newTwilio(raw json.RawMessage) (Notifier, error) {
+	var config twilioConfig
+	if err := json.Unmarshal(raw, &config); err != nil {
+		return nil, fmt.Errorf("invalid twilio config: %v", err)
+	}
+
+	token, err := resolve(config.AuthToken)
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve auth token: %v", err)
+	}
+	config.AuthToken = token
+
+	if config.AccountSID == "" || config.AuthToken == "" || config.From == "" {
+		return nil, errors.New("twilio channels need an account_sid, auth_token and from number")
+	}
+
+	if config.Throttle <= 0 {
+		config.Throttle = defaultThrottle
+	}
+	if config.ThrottleMinutes <= 0 {
+		config.ThrottleMinutes = defaultThrottleMinutes
+	}
+
+	return &twilioNotifier{config: config}, nil
+}
+
+func (n *twilioNotifier) useDatabase(db *sql.DB, channel Channel) {
+	n.db = db
+	n.channel = channel
+}
+
+// allow counts a text to recipient against its throttle. It returns whether
+// the text may be sent and, if so, how many were held back since the last
+// one sent. The count is kept in the throttles table so it holds across
+// runs and instances.
+func (n *twilioNotifier) allow(recipient string, now time.Time) (bool, int, error) {
+	tx, err := n.db.Begin()
+	if err != nil {
+		return false, 0, err
+	}
+
+	var windowStart int64
+	var sent, heldBack int
+	err = tx.QueryRow("SELECT windowstart, sent, heldback FROM throttles WHERE channelid = ? AND recipient = ?", n.channel.ID, recipient).Scan(&windowStart, &sent, &heldBack)
+	if err != nil && err != sql.ErrNoRows {
+		tx.Rollback()
+		return false, 0, err
+	}
+
+	if now.Unix()-windowStart >= int64(n.config.ThrottleMinutes)*60 {
+		windowStart, sent = now.Unix(), 0
+	}
+
+	allowed, held := sent < n.config.Throttle, 0
+	if allowed {
+		sent++
+		held, heldBack = heldBack, 0
+	} else {
+		heldBack++
+	}
+
+	_, err = tx.Exec(`
+		INSERT OR REPLACE INTO throttles (channelid, recipient, windowstart, sent, heldback)
+		VALUES (?, ?, ?, ?, ?)
+	`, n.channel.ID, recipient, windowStart, sent, heldBack)
+	if err != nil {
+		tx.Rollback()
+		return false, 0, err
+	}
+
+	return allowed, held, tx.Commit()
+}
+
+// text returns the message for e, noting any held back before it
+func text(e Event, heldBack int) string {
+	msg := []rune("vbms: " + e.Summary())
+	if len(msg) > smsLength {
+		msg = append(msg[:smsLength-1], '…')
+	}
+
+	if heldBack > 0 {
+		return fmt.Sprintf("%v (%d more alerts held back)", string(msg), heldBack)
+	}
+
+	return string(msg)
+}
+
+// send creates a message to recipient
+func (n *twilioNotifier) send(ctx context.Context, to string, body string) error {
+	form := url.Values{"To": {to}, "From": {n.config.From}, "Body": {body}}
+
+	credentials := base64.StdEncoding.EncodeToString([]byte(n.config.AccountSID + ":" + n.config.AuthToken))
+	header := http.Header{"Authorization": {"Basic " + credentials}}
+
+	endpoint := fmt.Sprintf(twilioURL, url.PathEscape(n.config.AccountSID))
+
+	return post(ctx, endpoint, "application/x-www-form-urlencoded", []byte(form.Encode()), header)
+}
+
+// Notify texts down events, which are critical, to each recipient until
+// their throttle is reached, so a cascading outage doesn't page everyone
+// hundreds of times. Texts over the limit are held back and counted in the
+// next one sent.
+func (n *twilioNotifier) Notify(ctx context.Context, e Event) error {
+	if e.Kind != Down {
+		return nil
+	}
+
+	to := n.config.recipients(e)
+	if len(to) == 0 {
+		return errors.New("no recipients")
+	}
+
+	var failed []string
+	for _, recipient := range to {
+		allowed, heldBack, err := n.allow(recipient, e.At)
+		if err != nil {
+			return fmt.Errorf("unable to throttle texts: %v", err)
+		}
+		if !allowed {
+			continue
+		}
+
+		if err := n.send(ctx, recipient, text(e, heldBack)); err != nil {
+			failed = append(failed, fmt.Sprintf("%v: %v", recipient, err))
+		}
+	}
+
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, "; "))
+	}
+
+	return nil
+}
+
+func init() {
+	Register("twilio", newTwilio, "auth_token")
+}
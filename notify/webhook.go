@@ -0,0 +1,148 @@
+package notify
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// webhookRetries is how many times a failed delivery is retried by default
+const webhookRetries = 3
+
+// webhookConfig is a webhook channel's config. Every event is posted to each
+// of URLs, signed with Secret when set. Headers are added to each request,
+// e.g. an Authorization header the receiver expects.
+type webhookConfig struct {
+	URLs    []string          `json:"urls"`
+	Secret  string            `json:"secret"`
+	Headers map[string]string `json:"headers"`
+	Retries *int              `json:"retries"`
+}
+
+// webhookNotifier posts events as JSON
+type webhookNotifier struct {
+	config webhookConfig
+}
+
+// webhookPayload is the event as posted, with how long the check was in its
+// previous state in seconds
+type webhookPayload struct {
+	Event
+	Duration int64 `json:"duration"`
+}
+
+// newWebhook makes a webhook notifier
+func newWebhook(raw json.RawMessage) (Notifier, error) {
+	var config webhookConfig
+	if err := json.Unmarshal(raw, &config); err != nil {
+		return nil, fmt.Errorf("invalid webhook config: %v", err)
+	}
+	if len(config.URLs) == 0 {
+		return nil, errors.New("webhook channels need at least one url")
+	}
+
+	for i, url := range config.URLs {
+		var err error
+		if config.URLs[i], err = resolve(url); err != nil {
+			return nil, fmt.Errorf("unable to resolve url: %v", err)
+		}
+	}
+
+	secret, err := resolve(config.Secret)
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve secret: %v", err)
+	}
+	config.Secret = secret
+
+	for key, value := range config.Headers {
+		if config.Headers[key], err = resolve(value); err != nil {
+			return nil, fmt.Errorf("unable to resolve header %v: %v", key, err)
+		}
+	}
+
+	if config.Retries == nil {
+		retries := webhookRetries
+		config.Retries = &retries
+	}
+
+	return &webhookNotifier{config: config}, nil
+}
+
+// sign returns the hex HMAC-SHA256 of the timestamp and body, joined by a
+// dot, so a receiver sharing the secret can check a request came from vbms
+// and isn't a replay of an old one
+func (n *webhookNotifier) sign(timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(n.config.Secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Notify posts e to every URL, continuing past any that fail. Failures name
+// the URL by its position, as URLs can be credentials.
+func (n *webhookNotifier) Notify(ctx context.Context, e Event) error {
+	body, err := json.Marshal(webhookPayload{Event: e, Duration: int64(e.Duration().Seconds())})
+	if err != nil {
+		return err
+	}
+
+	var failed []string
+	for i, url := range n.config.URLs {
+		if err := n.deliver(ctx, url, body); err != nil {
+			failed = append(failed, fmt.Sprintf("url %d: %v", i+1, err))
+		}
+	}
+
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, "; "))
+	}
+
+	return nil
+}
+
+// deliver posts body to url, retrying with a doubling backoff from a second
+// when it can't connect or the receiver has a server error or is rate
+// limiting. Other errors, such as a 400, won't go away by retrying.
+func (n *webhookNotifier) deliver(ctx context.Context, url string, body []byte) error {
+	backoff := time.Second
+
+	for attempt := 0; ; attempt++ {
+		header := http.Header{}
+		for key, value := range n.config.Headers {
+			header.Set(key, value)
+		}
+		header.Set("User-Agent", "vbms")
+		if n.config.Secret != "" {
+			timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+			header.Set("X-Vbms-Timestamp", timestamp)
+			header.Set("X-Vbms-Signature", "sha256="+n.sign(timestamp, body))
+		}
+
+		err := post(ctx, url, "application/json", body, header)
+
+		var status *statusError
+		if err == nil || attempt >= *n.config.Retries || (errors.As(err, &status) && !status.temporary()) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func init() {
+	Register("webhook", newWebhook, "urls", "secret", "headers")
+}
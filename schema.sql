@@ -1,16 +1,492 @@
 CREATE TABLE `servers` (
 	`id`	INTEGER PRIMARY KEY AUTOINCREMENT,
 	`hostname`	TEXT,
-	`ip`	TEXT,
+	`ip`	TEXT DEFAULT '',
 	`enablehttp`	INTEGER DEFAULT 0,
-	`httpresult`	TEXT,
+	`httpresult`	TEXT DEFAULT '',
 	`enablestmp`	INTEGER DEFAULT 0,
-	`smtpresult`	TEXT,
+	`smtpresult`	TEXT DEFAULT '',
 	`smtpport`	INTEGER DEFAULT 25,
+	`smtpbanner`	TEXT DEFAULT '',
+	`smtptls`	INTEGER DEFAULT 0,
+	`smtpstarttls`	INTEGER DEFAULT 0,
+	`smtpfrom`	TEXT DEFAULT '',
+	`smtprcpt`	TEXT DEFAULT '',
 	`enablepop3`	INTEGER DEFAULT 0,
-	`pop3result`	TEXT,
+	`pop3result`	TEXT DEFAULT '',
+	`pop3banner`	TEXT DEFAULT '',
+	`pop3user`	TEXT DEFAULT '',
+	`pop3password`	TEXT DEFAULT '',
+	`enablepop3s`	INTEGER DEFAULT 0,
+	`pop3sresult`	TEXT DEFAULT '',
+	`pop3sstate`	TEXT DEFAULT '',
 	`enablehttps`	INTEGER DEFAULT 0,
-	`httpsresult`	TEXT,
+	`httpsresult`	TEXT DEFAULT '',
+	`tlscafile`	TEXT DEFAULT '',
+	`tlsinsecure`	INTEGER DEFAULT 0,
+	`tlsskiphostname`	INTEGER DEFAULT 0,
+	`tlsservername`	TEXT DEFAULT '',
+	`tlscert`	TEXT DEFAULT '',
+	`tlskey`	TEXT DEFAULT '',
+	`tlspolicy`	TEXT DEFAULT '',
+	`httpsocsp`	INTEGER DEFAULT 0,
 	`enableping`	INTEGER DEFAULT 0,
-	`pingresult`	TEXT
+	`pingresult`	TEXT DEFAULT '',
+	`pingcount`	INTEGER DEFAULT 0,
+	`pingloss`	REAL DEFAULT 0,
+	`pingmin`	REAL DEFAULT 0,
+	`pingavg`	REAL DEFAULT 0,
+	`pingmax`	REAL DEFAULT 0,
+	`pingjitter`	REAL DEFAULT 0,
+	`httplatency`	INTEGER DEFAULT 0,
+	`httpdns`	REAL DEFAULT 0,
+	`httpconnect`	REAL DEFAULT 0,
+	`httptls`	REAL DEFAULT 0,
+	`httpttfb`	REAL DEFAULT 0,
+	`httptotal`	REAL DEFAULT 0,
+	`httpsdns`	REAL DEFAULT 0,
+	`httpsconnect`	REAL DEFAULT 0,
+	`httpstls`	REAL DEFAULT 0,
+	`httpsttfb`	REAL DEFAULT 0,
+	`httpstotal`	REAL DEFAULT 0,
+	`httpdownload`	INTEGER DEFAULT 0,
+	`httpsizedrift`	INTEGER DEFAULT 0,
+	`httpbytes`	INTEGER DEFAULT 0,
+	`httpbaseline`	INTEGER DEFAULT 0,
+	`httpdownloadtime`	REAL DEFAULT 0,
+	`httpsbytes`	INTEGER DEFAULT 0,
+	`httpsbaseline`	INTEGER DEFAULT 0,
+	`httpsdownloadtime`	REAL DEFAULT 0,
+	`httpchecksum`	INTEGER DEFAULT 0,
+	`httpsha256`	TEXT DEFAULT '',
+	`httpssha256`	TEXT DEFAULT '',
+	`timeout`	INTEGER DEFAULT 0,
+	`interval`	INTEGER DEFAULT 0,
+	`failinginterval`	INTEGER DEFAULT 0,
+	`failing`	INTEGER DEFAULT 0,
+	`retries`	INTEGER DEFAULT 0,
+	`retrybackoff`	INTEGER DEFAULT 0,
+	`hardafter`	INTEGER DEFAULT 0,
+	`checkintervals`	TEXT DEFAULT '',
+	`checkdepends`	TEXT DEFAULT '',
+	`httpexpect`	TEXT DEFAULT '',
+	`httppath`	TEXT DEFAULT '',
+	`httphost`	TEXT DEFAULT '',
+	`httpredirects`	INTEGER DEFAULT 0,
+	`httpuseragent`	TEXT DEFAULT '',
+	`httpheaders`	TEXT DEFAULT '',
+	`httpassert`	TEXT DEFAULT '',
+	`httpmatch`	TEXT DEFAULT '',
+	`httpminbody`	INTEGER DEFAULT 0,
+	`httpmaxbody`	INTEGER DEFAULT 0,
+	`httplength`	INTEGER DEFAULT 0,
+	`httpauth`	TEXT DEFAULT '',
+	`httpuser`	TEXT DEFAULT '',
+	`httppassword`	TEXT DEFAULT '',
+	`httptoken`	TEXT DEFAULT '',
+	`oauthtokenurl`	TEXT DEFAULT '',
+	`oauthclientid`	TEXT DEFAULT '',
+	`oauthclientsecret`	TEXT DEFAULT '',
+	`oauthscopes`	TEXT DEFAULT '',
+	`sshjump`	TEXT DEFAULT '',
+	`sshjumpkey`	TEXT DEFAULT '',
+	`proxy`	TEXT DEFAULT '',
+	`checkalladdrs`	INTEGER DEFAULT 0,
+	`profile`	TEXT DEFAULT '',
+	`tags`	TEXT DEFAULT '',
+	`paused`	INTEGER DEFAULT 0,
+	`pausedat`	INTEGER DEFAULT 0,
+	`pausedreason`	TEXT DEFAULT '',
+	`claimedby`	TEXT DEFAULT '',
+	`claimedat`	INTEGER DEFAULT 0,
+	`recheckat`	INTEGER DEFAULT 0,
+	`parents`	TEXT DEFAULT '',
+	`plugins`	TEXT DEFAULT '',
+	`pluginconfig`	TEXT DEFAULT '',
+	`pluginresult`	TEXT DEFAULT '',
+	`httpstate`	TEXT DEFAULT '',
+	`smtpstate`	TEXT DEFAULT '',
+	`pop3state`	TEXT DEFAULT '',
+	`httpsstate`	TEXT DEFAULT '',
+	`pingstate`	TEXT DEFAULT '',
+	`pluginstate`	TEXT DEFAULT '',
+	`enableclock`	INTEGER DEFAULT 0,
+	`clocksource`	TEXT DEFAULT '',
+	`clockdrift`	INTEGER DEFAULT 0,
+	`clockresult`	TEXT DEFAULT '',
+	`clockstate`	TEXT DEFAULT '',
+	`comparewith`	TEXT DEFAULT '',
+	`comparepath`	TEXT DEFAULT '/',
+	`comparetls`	INTEGER DEFAULT 0,
+	`comparelatency`	INTEGER DEFAULT 0,
+	`compareresult`	TEXT DEFAULT '',
+	`comparestate`	TEXT DEFAULT '',
+	`slo`	REAL DEFAULT 0,
+	`sla`	REAL DEFAULT 0,
+	`sloresult`	TEXT DEFAULT '',
+	`slostate`	TEXT DEFAULT '',
+	`enableimap`	INTEGER DEFAULT 0,
+	`imaptls`	INTEGER DEFAULT 0,
+	`imapport`	INTEGER DEFAULT 0,
+	`imapuser`	TEXT DEFAULT '',
+	`imappassword`	TEXT DEFAULT '',
+	`imapresult`	TEXT DEFAULT '',
+	`imapstate`	TEXT DEFAULT '',
+	`enablessh`	INTEGER DEFAULT 0,
+	`sshport`	INTEGER DEFAULT 0,
+	`sshfingerprint`	TEXT DEFAULT '',
+	`sshresult`	TEXT DEFAULT '',
+	`sshstate`	TEXT DEFAULT '',
+	`enableftp`	INTEGER DEFAULT 0,
+	`ftptls`	INTEGER DEFAULT 0,
+	`ftpresult`	TEXT DEFAULT '',
+	`ftpstate`	TEXT DEFAULT '',
+	`enablemysql`	INTEGER DEFAULT 0,
+	`mysqlport`	INTEGER DEFAULT 0,
+	`mysqluser`	TEXT DEFAULT '',
+	`mysqlpassword`	TEXT DEFAULT '',
+	`mysqlresult`	TEXT DEFAULT '',
+	`mysqlstate`	TEXT DEFAULT '',
+	`enablepg`	INTEGER DEFAULT 0,
+	`pgport`	INTEGER DEFAULT 0,
+	`pgtls`	INTEGER DEFAULT 0,
+	`pguser`	TEXT DEFAULT '',
+	`pgpassword`	TEXT DEFAULT '',
+	`pgdatabase`	TEXT DEFAULT '',
+	`pgresult`	TEXT DEFAULT '',
+	`pgstate`	TEXT DEFAULT '',
+	`enableldap`	INTEGER DEFAULT 0,
+	`ldaptls`	INTEGER DEFAULT 0,
+	`ldapport`	INTEGER DEFAULT 0,
+	`ldapbinddn`	TEXT DEFAULT '',
+	`ldappassword`	TEXT DEFAULT '',
+	`ldapresult`	TEXT DEFAULT '',
+	`ldapstate`	TEXT DEFAULT '',
+	`enablesnmp`	INTEGER DEFAULT 0,
+	`snmpcommunity`	TEXT DEFAULT '',
+	`snmpoid`	TEXT DEFAULT '',
+	`snmpresult`	TEXT DEFAULT '',
+	`snmpstate`	TEXT DEFAULT '',
+	`enablegrpc`	INTEGER DEFAULT 0,
+	`grpcport`	INTEGER DEFAULT 0,
+	`grpctls`	INTEGER DEFAULT 0,
+	`grpcservice`	TEXT DEFAULT '',
+	`grpcresult`	TEXT DEFAULT '',
+	`grpcstate`	TEXT DEFAULT '',
+	`enabledomain`	INTEGER DEFAULT 0,
+	`domain`	TEXT DEFAULT '',
+	`domainwarndays`	INTEGER DEFAULT 0,
+	`domainexpiry`	INTEGER DEFAULT 0,
+	`domaincheckedat`	INTEGER DEFAULT 0,
+	`domainresult`	TEXT DEFAULT '',
+	`domainstate`	TEXT DEFAULT '',
+	`enablednsbl`	INTEGER DEFAULT 0,
+	`dnsbl`	TEXT DEFAULT '',
+	`dnsblresult`	TEXT DEFAULT '',
+	`dnsblstate`	TEXT DEFAULT '',
+	`enablehttp3`	INTEGER DEFAULT 0,
+	`http3result`	TEXT DEFAULT '',
+	`http3state`	TEXT DEFAULT '',
+	`enablejson`	INTEGER DEFAULT 0,
+	`jsonurl`	TEXT DEFAULT '',
+	`jsonassert`	TEXT DEFAULT '',
+	`jsonvalues`	TEXT DEFAULT '',
+	`jsonresult`	TEXT DEFAULT '',
+	`jsonstate`	TEXT DEFAULT '',
+	`enabletransaction`	INTEGER DEFAULT 0,
+	`transactionresult`	TEXT DEFAULT '',
+	`transactionstate`	TEXT DEFAULT '',
+	`enableexec`	INTEGER DEFAULT 0,
+	`execcommand`	TEXT DEFAULT '',
+	`execresult`	TEXT DEFAULT '',
+	`execstate`	TEXT DEFAULT '',
+	`enableheartbeat`	INTEGER DEFAULT 0,
+	`heartbeattoken`	TEXT DEFAULT '',
+	`heartbeatgrace`	INTEGER DEFAULT 0,
+	`heartbeatat`	INTEGER DEFAULT 0,
+	`heartbeatresult`	TEXT DEFAULT '',
+	`heartbeatstate`	TEXT DEFAULT '',
+	`enableprom`	INTEGER DEFAULT 0,
+	`promurl`	TEXT DEFAULT '',
+	`promassert`	TEXT DEFAULT '',
+	`promresult`	TEXT DEFAULT '',
+	`promstate`	TEXT DEFAULT '',
+	`enableamqp`	INTEGER DEFAULT 0,
+	`amqpport`	INTEGER DEFAULT 0,
+	`amqptls`	INTEGER DEFAULT 0,
+	`amqpuser`	TEXT DEFAULT '',
+	`amqppassword`	TEXT DEFAULT '',
+	`amqpvhost`	TEXT DEFAULT '',
+	`amqpapi`	TEXT DEFAULT '',
+	`amqpqueue`	TEXT DEFAULT '',
+	`amqpmaxqueue`	INTEGER DEFAULT 0,
+	`amqpresult`	TEXT DEFAULT '',
+	`amqpstate`	TEXT DEFAULT '',
+	`enablekafka`	INTEGER DEFAULT 0,
+	`kafkaport`	INTEGER DEFAULT 0,
+	`kafkatls`	INTEGER DEFAULT 0,
+	`kafkaminbrokers`	INTEGER DEFAULT 0,
+	`kafkabrokers`	INTEGER DEFAULT 0,
+	`kafkaresult`	TEXT DEFAULT '',
+	`kafkastate`	TEXT DEFAULT '',
+	`enablebanner`	INTEGER DEFAULT 0,
+	`bannerport`	INTEGER DEFAULT 0,
+	`bannertls`	INTEGER DEFAULT 0,
+	`bannersend`	TEXT DEFAULT '',
+	`bannerexpect`	TEXT DEFAULT '',
+	`bannerresult`	TEXT DEFAULT '',
+	`bannerstate`	TEXT DEFAULT '',
+	`enablesource`	INTEGER DEFAULT 0,
+	`sourceport`	INTEGER DEFAULT 0,
+	`sourcename`	TEXT DEFAULT '',
+	`sourceplayers`	INTEGER DEFAULT 0,
+	`sourcemaxplayers`	INTEGER DEFAULT 0,
+	`sourceresult`	TEXT DEFAULT '',
+	`sourcestate`	TEXT DEFAULT '',
+	`enableminecraft`	INTEGER DEFAULT 0,
+	`mcport`	INTEGER DEFAULT 0,
+	`mcmotd`	TEXT DEFAULT '',
+	`mcplayers`	INTEGER DEFAULT 0,
+	`mcmaxplayers`	INTEGER DEFAULT 0,
+	`mcresult`	TEXT DEFAULT '',
+	`mcstate`	TEXT DEFAULT '',
+	`enableradius`	INTEGER DEFAULT 0,
+	`radiusport`	INTEGER DEFAULT 0,
+	`radiussecret`	TEXT DEFAULT '',
+	`radiususer`	TEXT DEFAULT '',
+	`radiuspassword`	TEXT DEFAULT '',
+	`radiusresult`	TEXT DEFAULT '',
+	`radiusstate`	TEXT DEFAULT '',
+	`enabledns`	INTEGER DEFAULT 0,
+	`dnsresolver`	TEXT DEFAULT '',
+	`dnsexpect`	TEXT DEFAULT '',
+	`dnsresult`	TEXT DEFAULT '',
+	`dnsstate`	TEXT DEFAULT '',
+	`enabledocker`	INTEGER DEFAULT 0,
+	`dockerurl`	TEXT DEFAULT '',
+	`dockercontainers`	TEXT DEFAULT '',
+	`dockerresult`	TEXT DEFAULT '',
+	`dockerstate`	TEXT DEFAULT '',
+	`enablek8s`	INTEGER DEFAULT 0,
+	`k8surl`	TEXT DEFAULT '',
+	`k8stoken`	TEXT DEFAULT '',
+	`k8sconfig`	TEXT DEFAULT '',
+	`k8snodes`	INTEGER DEFAULT 0,
+	`k8sresult`	TEXT DEFAULT '',
+	`k8sstate`	TEXT DEFAULT '',
+	`timezone`	TEXT DEFAULT '',
+	`maintenance`	TEXT DEFAULT '',
+	`certexpiry`	INTEGER DEFAULT 0,
+	`tlsversion`	TEXT DEFAULT '',
+	`tlscipher`	TEXT DEFAULT '',
+	`traceroute`	TEXT DEFAULT '',
+	`traceresult`	TEXT DEFAULT '',
+	`traceat`	INTEGER DEFAULT 0,
+	`wolmac`	TEXT DEFAULT '',
+	`wolbroadcast`	TEXT DEFAULT '',
+	`wolthreshold`	INTEGER DEFAULT 0,
+	`wolsent`	INTEGER DEFAULT 0,
+	`wolresult`	TEXT DEFAULT '',
+	`pingfailures`	INTEGER DEFAULT 0,
+	`lastupdate`	INTEGER DEFAULT 0
+);
+
+CREATE TABLE `profiles` (
+	`id`	INTEGER PRIMARY KEY AUTOINCREMENT,
+	`name`	TEXT NOT NULL UNIQUE,
+	`enablehttp`	INTEGER DEFAULT 0,
+	`enablesmtp`	INTEGER DEFAULT 0,
+	`enablepop3`	INTEGER DEFAULT 0,
+	`enablehttps`	INTEGER DEFAULT 0,
+	`enableping`	INTEGER DEFAULT 0,
+	`timeout`	INTEGER DEFAULT 0,
+	`interval`	INTEGER DEFAULT 0,
+	`failinginterval`	INTEGER DEFAULT 0,
+	`retries`	INTEGER DEFAULT 0,
+	`retrybackoff`	INTEGER DEFAULT 0,
+	`hardafter`	INTEGER DEFAULT 0,
+	`checkintervals`	TEXT DEFAULT '',
+	`checkdepends`	TEXT DEFAULT '',
+	`httpexpect`	TEXT DEFAULT '',
+	`httplatency`	INTEGER DEFAULT 0,
+	`slo`	REAL DEFAULT 0,
+	`sla`	REAL DEFAULT 0,
+	`timezone`	TEXT DEFAULT '',
+	`maintenance`	TEXT DEFAULT ''
+);
+
+CREATE TABLE `remediations` (
+	`id`	INTEGER PRIMARY KEY AUTOINCREMENT,
+	`serverid`	INTEGER NOT NULL,
+	`checkname`	TEXT NOT NULL,
+	`command`	TEXT NOT NULL,
+	`sshuser`	TEXT DEFAULT 'root',
+	`sshkey`	TEXT DEFAULT '',
+	`sshport`	INTEGER DEFAULT 22,
+	`firedat`	INTEGER DEFAULT 0
+);
+
+CREATE TABLE `remediationlog` (
+	`id`	INTEGER PRIMARY KEY AUTOINCREMENT,
+	`remediationid`	INTEGER NOT NULL,
+	`serverid`	INTEGER NOT NULL,
+	`checkname`	TEXT,
+	`command`	TEXT,
+	`ranat`	INTEGER,
+	`exitstatus`	INTEGER,
+	`output`	TEXT
+);
+
+CREATE TABLE `transactionsteps` (
+	`id`	INTEGER PRIMARY KEY AUTOINCREMENT,
+	`serverid`	INTEGER NOT NULL,
+	`position`	INTEGER DEFAULT 0,
+	`method`	TEXT DEFAULT 'GET',
+	`url`	TEXT NOT NULL,
+	`headers`	TEXT DEFAULT '',
+	`body`	TEXT DEFAULT '',
+	`follow`	INTEGER DEFAULT 0,
+	`expect`	TEXT DEFAULT '',
+	`match`	TEXT DEFAULT '',
+	`capture`	TEXT DEFAULT '',
+	`result`	TEXT DEFAULT '',
+	`state`	TEXT DEFAULT '',
+	`duration`	INTEGER DEFAULT 0,
+	`checkedat`	INTEGER DEFAULT 0
+);
+
+CREATE INDEX `transactionsteps_server` ON `transactionsteps` (`serverid`, `position`);
+
+CREATE TABLE `check_history` (
+	`id`	INTEGER PRIMARY KEY AUTOINCREMENT,
+	`serverid`	INTEGER NOT NULL,
+	`checkname`	TEXT NOT NULL,
+	`state`	TEXT NOT NULL,
+	`result`	TEXT,
+	`duration`	INTEGER DEFAULT 0,
+	`checkedat`	INTEGER NOT NULL,
+	`maintenance`	INTEGER DEFAULT 0
+);
+
+CREATE INDEX `check_history_server` ON `check_history` (`serverid`, `checkedat`);
+CREATE INDEX `check_history_checkedat` ON `check_history` (`checkedat`);
+
+CREATE TABLE `runs` (
+	`id`	INTEGER PRIMARY KEY AUTOINCREMENT,
+	`serverid`	INTEGER NOT NULL,
+	`instance`	TEXT DEFAULT '',
+	`startedat`	INTEGER NOT NULL,
+	`finishedat`	INTEGER NOT NULL,
+	`duration`	INTEGER DEFAULT 0,
+	`checks`	INTEGER DEFAULT 0,
+	`retried`	INTEGER DEFAULT 0,
+	`skipped`	INTEGER DEFAULT 0,
+	`timedout`	INTEGER DEFAULT 0,
+	`state`	TEXT DEFAULT ''
+);
+
+CREATE INDEX `runs_server` ON `runs` (`serverid`, `startedat`);
+CREATE INDEX `runs_startedat` ON `runs` (`startedat`);
+
+CREATE TABLE `check_results` (
+	`serverid`	INTEGER NOT NULL,
+	`checkname`	TEXT NOT NULL,
+	`status`	TEXT NOT NULL,
+	`message`	TEXT DEFAULT '',
+	`latency`	REAL DEFAULT 0,
+	`checkedat`	INTEGER NOT NULL,
+	`errorclass`	TEXT DEFAULT '',
+	PRIMARY KEY (`serverid`, `checkname`)
+);
+
+CREATE TABLE `checkstates` (
+	`serverid`	INTEGER NOT NULL,
+	`checkname`	TEXT NOT NULL,
+	`state`	TEXT DEFAULT '',
+	`hardstate`	TEXT DEFAULT '',
+	`failures`	INTEGER DEFAULT 0,
+	`changedat`	INTEGER DEFAULT 0,
+	`lastrun`	INTEGER DEFAULT 0,
+	`flapping`	INTEGER DEFAULT 0,
+	`flapfrom`	TEXT DEFAULT '',
+	PRIMARY KEY (`serverid`, `checkname`)
+);
+
+CREATE TABLE `uptime` (
+	`serverid`	INTEGER NOT NULL,
+	`hostname`	TEXT NOT NULL,
+	`checkname`	TEXT NOT NULL,
+	`day`	REAL DEFAULT 100,
+	`week`	REAL DEFAULT 100,
+	`month`	REAL DEFAULT 100,
+	`sla`	REAL DEFAULT 0,
+	`breached`	INTEGER DEFAULT 0,
+	`updatedat`	INTEGER DEFAULT 0,
+	PRIMARY KEY (`serverid`, `checkname`)
+);
+
+CREATE TABLE `incidents` (
+	`id`	INTEGER PRIMARY KEY AUTOINCREMENT,
+	`serverid`	INTEGER NOT NULL,
+	`hostname`	TEXT NOT NULL,
+	`checks`	TEXT NOT NULL,
+	`result`	TEXT DEFAULT '',
+	`startedat`	INTEGER NOT NULL,
+	`resolvedat`	INTEGER DEFAULT 0,
+	`maintenance`	INTEGER DEFAULT 0
+);
+
+CREATE INDEX `incidents_server` ON `incidents` (`serverid`, `resolvedat`);
+
+CREATE TABLE `maintenancewindows` (
+	`id`	INTEGER PRIMARY KEY AUTOINCREMENT,
+	`serverid`	INTEGER DEFAULT 0,
+	`tag`	TEXT DEFAULT '',
+	`startsat`	INTEGER NOT NULL,
+	`endsat`	INTEGER NOT NULL,
+	`repeat`	TEXT DEFAULT '',
+	`until`	INTEGER DEFAULT 0,
+	`reason`	TEXT DEFAULT ''
+);
+
+CREATE TABLE `channels` (
+	`id`	INTEGER PRIMARY KEY AUTOINCREMENT,
+	`name`	TEXT NOT NULL UNIQUE,
+	`kind`	TEXT NOT NULL,
+	`config`	TEXT DEFAULT ''
+);
+
+CREATE TABLE `channelroutes` (
+	`id`	INTEGER PRIMARY KEY AUTOINCREMENT,
+	`channelid`	INTEGER NOT NULL,
+	`serverid`	INTEGER DEFAULT 0,
+	`tag`	TEXT DEFAULT '',
+	`events`	TEXT DEFAULT ''
+);
+
+CREATE TABLE `notifications` (
+	`id`	INTEGER PRIMARY KEY AUTOINCREMENT,
+	`channelid`	INTEGER NOT NULL,
+	`serverid`	INTEGER NOT NULL,
+	`checkname`	TEXT NOT NULL,
+	`kind`	TEXT NOT NULL,
+	`state`	TEXT DEFAULT '',
+	`sentat`	INTEGER NOT NULL,
+	`error`	TEXT DEFAULT ''
+);
+
+CREATE INDEX `notifications_channel` ON `notifications` (`channelid`, `sentat`);
+
+CREATE TABLE `throttles` (
+	`channelid`	INTEGER NOT NULL,
+	`recipient`	TEXT NOT NULL,
+	`windowstart`	INTEGER DEFAULT 0,
+	`sent`	INTEGER DEFAULT 0,
+	`heldback`	INTEGER DEFAULT 0,
+	PRIMARY KEY (`channelid`, `recipient`)
+);
+
+CREATE TABLE `jobs` (
+	`name`	TEXT PRIMARY KEY,
+	`lastrun`	INTEGER DEFAULT 0
 );
\ No newline at end of file
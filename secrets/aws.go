@@ -0,0 +1,45 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+// AWSSecretsManager reads secrets from AWS Secrets Manager
+type AWSSecretsManager struct {
+	client *secretsmanager.Client
+}
+
+// NewAWSSecretsManager returns a provider using the default AWS credential chain
+func NewAWSSecretsManager() (*AWSSecretsManager, error) {
+	cfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	return &AWSSecretsManager{client: secretsmanager.NewFromConfig(cfg)}, nil
+}
+
+// Fetch reads the current version of the named secret
+func (a *AWSSecretsManager) Fetch(name string) (string, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	out, err := a.client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(name),
+	})
+	if err != nil {
+		return "", 0, err
+	}
+
+	if out.SecretString == nil {
+		return "", 0, errors.New("Binary secrets are not supported")
+	}
+
+	return *out.SecretString, 0, nil
+}
@@ -0,0 +1,80 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// encryptedPrefix marks values encrypted by Encrypt
+const encryptedPrefix = "enc:v1:"
+
+// aead encrypts sensitive columns at rest once a key has been set
+var aead cipher.AEAD
+
+// SetKey enables column encryption. The key material is hashed into an
+// AES-256 key, so it should be long and random rather than a password.
+func SetKey(material []byte) error {
+	key := sha256.Sum256(material)
+
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+
+	aead = gcm
+	return nil
+}
+
+// IsEncrypted reports whether value was produced by Encrypt
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix)
+}
+
+// Encrypt seals value with AES-GCM for storage. Without a key, and for empty
+// or already encrypted values, it is returned unchanged.
+func Encrypt(value string) (string, error) {
+	if aead == nil || value == "" || IsEncrypted(value) {
+		return value, nil
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(value), nil)
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt opens a value sealed by Encrypt. Plaintext values pass through.
+func Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+
+	if aead == nil {
+		return "", errors.New("Value is encrypted but no database key is configured")
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encryptedPrefix))
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", errors.New("Encrypted value is corrupt")
+	}
+
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("Unable to decrypt value, is the database key correct?")
+	}
+
+	return string(plain), nil
+}
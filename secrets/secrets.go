@@ -0,0 +1,151 @@
+package secrets
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Provider fetches a secret by path from an external store
+type Provider interface {
+	// Fetch returns the raw secret and how long it may be cached.
+	// A zero duration falls back to the default TTL.
+	Fetch(path string) (string, time.Duration, error)
+}
+
+// TTL is how long fetched secrets are cached when the provider gives no lease
+var TTL = 5 * time.Minute
+
+// entry is a cached secret value
+type entry struct {
+	value   string
+	expires time.Time
+}
+
+var (
+	mu        sync.Mutex
+	providers = map[string]Provider{}
+	cache     = map[string]entry{}
+
+	// fetching serializes fetches of each reference, so servers sharing a
+	// secret fetch it once without a slow store holding up other secrets
+	fetching = map[string]*sync.Mutex{}
+)
+
+// Register makes a provider available for references using the given scheme,
+// e.g. "vault" for vault://secret/data/app#password
+func Register(scheme string, p Provider) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	providers[scheme] = p
+}
+
+// parseReference splits a scheme://path#field reference. ok is false when the
+// value isn't a reference to a registered provider.
+func parseReference(value string) (p Provider, path string, field string, ok bool) {
+	i := strings.Index(value, "://")
+	if i < 0 {
+		return nil, "", "", false
+	}
+
+	p, ok = providers[value[:i]]
+	if !ok {
+		return nil, "", "", false
+	}
+
+	path = value[i+3:]
+	if j := strings.LastIndex(path, "#"); j >= 0 {
+		path, field = path[:j], path[j+1:]
+	}
+
+	return p, path, field, true
+}
+
+// Resolve returns the secret a reference points to, or value unchanged when it
+// isn't a reference. Secrets are cached, and if a refresh fails once the cache
+// expires the previous value keeps being served.
+func Resolve(value string) (string, error) {
+	mu.Lock()
+	p, path, field, ok := parseReference(value)
+	if !ok {
+		mu.Unlock()
+		return value, nil
+	}
+	lock, ok := fetching[value]
+	if !ok {
+		lock = new(sync.Mutex)
+		fetching[value] = lock
+	}
+	mu.Unlock()
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	mu.Lock()
+	cached, found := cache[value]
+	mu.Unlock()
+
+	if found && time.Now().Before(cached.expires) {
+		return cached.value, nil
+	}
+
+	raw, ttl, err := p.Fetch(path)
+	if err == nil {
+		raw, err = selectField(raw, field)
+	}
+
+	if err != nil {
+		if found {
+			logrus.WithError(err).Warnf("Unable to renew secret %v, using cached value", value)
+			return cached.value, nil
+		}
+		return "", err
+	}
+
+	if ttl <= 0 {
+		ttl = TTL
+	}
+
+	mu.Lock()
+	cache[value] = entry{value: raw, expires: time.Now().Add(ttl)}
+	mu.Unlock()
+
+	return raw, nil
+}
+
+// selectField picks a key out of a JSON object secret. Without a field the raw
+// secret is returned, unless it's an object holding exactly one value.
+func selectField(raw string, field string) (string, error) {
+	var values map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &values); err != nil {
+		if field != "" {
+			return "", fmt.Errorf("Secret is not an object, can't select field %v", field)
+		}
+		return raw, nil
+	}
+
+	if field == "" {
+		if len(values) != 1 {
+			return raw, nil
+		}
+		for key := range values {
+			field = key
+		}
+	}
+
+	val, ok := values[field]
+	if !ok {
+		return "", fmt.Errorf("Secret has no field %v", field)
+	}
+
+	if str, ok := val.(string); ok {
+		return str, nil
+	}
+
+	return fmt.Sprint(val), nil
+}
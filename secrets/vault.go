@@ -0,0 +1,104 @@
+package secrets
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Vault reads secrets from a HashiCorp Vault server over its HTTP API
+type Vault struct {
+	Addr   string
+	Token  string
+	client *http.Client
+}
+
+// NewVault returns a Vault provider for the server at addr
+func NewVault(addr string, token string) *Vault {
+	return &Vault{
+		Addr:   strings.TrimRight(addr, "/"),
+		Token:  token,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// vaultResponse is the subset of Vault's response envelope we use
+type vaultResponse struct {
+	LeaseDuration int             `json:"lease_duration"`
+	Data          json.RawMessage `json:"data"`
+	Auth          struct {
+		LeaseDuration int  `json:"lease_duration"`
+		Renewable     bool `json:"renewable"`
+	} `json:"auth"`
+	Errors []string `json:"errors"`
+}
+
+// do sends an authenticated request to the Vault API
+func (v *Vault) do(method string, path string) (*vaultResponse, error) {
+	req, err := http.NewRequest(method, v.Addr+"/v1/"+strings.TrimLeft(path, "/"), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", v.Token)
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var body vaultResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("Invalid response from Vault: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Vault returned %v: %v", resp.Status, strings.Join(body.Errors, ", "))
+	}
+
+	return &body, nil
+}
+
+// Fetch reads the secret at path. KV version 2 payloads are unwrapped so
+// fields can be selected the same way as version 1.
+func (v *Vault) Fetch(path string) (string, time.Duration, error) {
+	body, err := v.do("GET", path)
+	if err != nil {
+		return "", 0, err
+	}
+
+	data := body.Data
+	var kv2 struct {
+		Data     json.RawMessage `json:"data"`
+		Metadata json.RawMessage `json:"metadata"`
+	}
+	if json.Unmarshal(data, &kv2) == nil && kv2.Data != nil && kv2.Metadata != nil {
+		data = kv2.Data
+	}
+
+	return string(data), time.Second * time.Duration(body.LeaseDuration), nil
+}
+
+// KeepTokenAlive renews the Vault token before its lease runs out. It never
+// returns, so run it in a goroutine.
+func (v *Vault) KeepTokenAlive() {
+	for {
+		wait := time.Minute
+
+		body, err := v.do("POST", "auth/token/renew-self")
+		if err != nil {
+			logrus.WithError(err).Warn("Unable to renew Vault token")
+		} else if !body.Auth.Renewable {
+			logrus.Info("Vault token is not renewable")
+			return
+		} else if body.Auth.LeaseDuration > 0 {
+			wait = time.Second * time.Duration(body.Auth.LeaseDuration) / 2
+		}
+
+		time.Sleep(wait)
+	}
+}
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// results returns the result fields in a fixed order
+func (s *Server) results() []*string {
+	return []*string{
+		&s.ResultHTTP,
+		&s.ResultSMTP,
+		&s.ResultPOP3,
+		&s.ResultHTTPS,
+		&s.ResultPing,
+		&s.ResultPlugins,
+		&s.ResultClock,
+		&s.ResultCompare,
+		&s.ResultSLO,
+		&s.ResultIMAP,
+		&s.ResultPOP3S,
+		&s.ResultSSH,
+		&s.ResultFTP,
+		&s.ResultMySQL,
+		&s.ResultPostgres,
+		&s.ResultLDAP,
+		&s.ResultSNMP,
+		&s.ResultGRPC,
+		&s.ResultDomain,
+		&s.ResultDNSBL,
+		&s.ResultHTTP3,
+		&s.ResultJSON,
+		&s.ResultTransaction,
+		&s.ResultExec,
+		&s.ResultHeartbeat,
+		&s.ResultPrometheus,
+		&s.ResultAMQP,
+		&s.ResultKafka,
+		&s.ResultBanner,
+		&s.ResultSource,
+		&s.ResultMinecraft,
+		&s.ResultRADIUS,
+		&s.ResultDNS,
+		&s.ResultDocker,
+		&s.ResultKubernetes,
+	}
+}
+
+// checkAllAddrs runs every check against each address the hostname resolves
+// to, so one dead backend behind round-robin DNS shows up on every run.
+// Results are recorded per address, e.g. "10.0.0.1: HTTP/1.1 200 OK; 10.0.0.2: ..."
+func (s *Server) checkAllAddrs() {
+	logger := s.GetLogger("DNS", 0)
+
+	addrs, err := net.LookupHost(s.Hostname)
+	if err != nil {
+		for i, result := range s.results() {
+			*result = "Unable to resolve hostname"
+			*s.states()[i] = StateCrit
+			s.classes[i] = ErrorDNS
+		}
+		logger.WithError(err).Error("Unable to resolve hostname")
+		return
+	}
+
+	// Buffered for every check, so ones that report after the run timed
+	// out don't block
+	done := make(chan checkRun, numChecks*len(addrs))
+	targets := make([]*Server, len(addrs))
+	var launched []checkRun
+
+	for i, addr := range addrs {
+		target := *s
+		target.IP = addr
+		target.pinned = true
+		targets[i] = &target
+
+		// Don't carry the combined results of the last run into each address
+		for j, result := range target.results() {
+			if !s.skipped[j] {
+				*result = ""
+			}
+		}
+
+		launched = append(launched, target.startChecks(done)...)
+	}
+
+	s.collect(done, launched)
+
+	for i, result := range s.results() {
+		if s.skipped[i] {
+			continue
+		}
+
+		var parts []string
+
+		for j, target := range targets {
+			if res := *target.results()[i]; res != "" {
+				parts = append(parts, fmt.Sprintf("%v: %v", addrs[j], res))
+			}
+		}
+
+		*result = strings.Join(parts, "; ")
+	}
+
+	// Each check reports its worst state and slowest time across the
+	// addresses, and the certificate expiring first
+	for i, st := range s.states() {
+		if s.skipped[i] {
+			continue
+		}
+
+		*st = ""
+		s.durations[i] = 0
+		s.expiries[i] = 0
+		for _, target := range targets {
+			s.fail(i, *target.states()[i], target.classes[i])
+			if target.durations[i] > s.durations[i] {
+				s.durations[i] = target.durations[i]
+			}
+			if exp := target.expiries[i]; exp > 0 && (s.expiries[i] == 0 || exp < s.expiries[i]) {
+				s.expiries[i] = exp
+			}
+		}
+	}
+
+	// Ping statistics are the worst address's. Wake-on-LAN goes by the
+	// combined state, see recordPing, so any address unanswered is a failure.
+	s.PingLoss, s.PingMin, s.PingAvg, s.PingMax, s.PingJitter = 0, 0, 0, 0, 0
+	for _, target := range targets {
+		if target.PingLoss > s.PingLoss || (target.PingLoss == s.PingLoss && target.PingAvg > s.PingAvg) {
+			s.PingLoss, s.PingMin, s.PingAvg, s.PingMax, s.PingJitter = target.PingLoss, target.PingMin, target.PingAvg, target.PingMax, target.PingJitter
+		}
+	}
+
+	// Request phases are the slowest address's
+	s.DNSTimeHTTP, s.ConnectTimeHTTP, s.TLSTimeHTTP, s.TTFBHTTP, s.TotalTimeHTTP = 0, 0, 0, 0, 0
+	s.DNSTimeHTTPS, s.ConnectTimeHTTPS, s.TLSTimeHTTPS, s.TTFBHTTPS, s.TotalTimeHTTPS = 0, 0, 0, 0, 0
+	for _, target := range targets {
+		if target.TotalTimeHTTP > s.TotalTimeHTTP {
+			s.DNSTimeHTTP, s.ConnectTimeHTTP, s.TLSTimeHTTP, s.TTFBHTTP, s.TotalTimeHTTP = target.DNSTimeHTTP, target.ConnectTimeHTTP, target.TLSTimeHTTP, target.TTFBHTTP, target.TotalTimeHTTP
+		}
+		if target.TotalTimeHTTPS > s.TotalTimeHTTPS {
+			s.DNSTimeHTTPS, s.ConnectTimeHTTPS, s.TLSTimeHTTPS, s.TTFBHTTPS, s.TotalTimeHTTPS = target.DNSTimeHTTPS, target.ConnectTimeHTTPS, target.TLSTimeHTTPS, target.TTFBHTTPS, target.TotalTimeHTTPS
+		}
+	}
+
+	// Downloads and checksums are the failing address's, else the first's
+	for i, target := range targets {
+		if i == 0 || target.StateHTTP == StateCrit {
+			s.BytesHTTP, s.BaselineHTTP, s.DownloadTimeHTTP = target.BytesHTTP, target.BaselineHTTP, target.DownloadTimeHTTP
+			s.SHA256HTTP = target.SHA256HTTP
+		}
+		if i == 0 || target.StateHTTPS == StateCrit {
+			s.BytesHTTPS, s.BaselineHTTPS, s.DownloadTimeHTTPS = target.BytesHTTPS, target.BaselineHTTPS, target.DownloadTimeHTTPS
+			s.SHA256HTTPS = target.SHA256HTTPS
+		}
+	}
+
+	// Report the oldest TLS version negotiated, names sort by version
+	s.TLSVersion, s.TLSCipher = "", ""
+	for _, target := range targets {
+		if target.TLSVersion != "" && (s.TLSVersion == "" || target.TLSVersion < s.TLSVersion) {
+			s.TLSVersion, s.TLSCipher = target.TLSVersion, target.TLSCipher
+		}
+	}
+
+	// Keep the freshest RDAP answer, so the domain isn't looked up again
+	for _, target := range targets {
+		if target.DomainAt > s.DomainAt {
+			s.DomainExpiry, s.DomainAt = target.DomainExpiry, target.DomainAt
+		}
+	}
+
+	// JSON values are the failing address's, else the first's
+	s.ValuesJSON = ""
+	for _, target := range targets {
+		if s.ValuesJSON == "" || target.StateJSON == StateCrit {
+			s.ValuesJSON = target.ValuesJSON
+		}
+	}
+
+	// Game server details are the failing address's, else the first's
+	for i, target := range targets {
+		if i == 0 || target.StateSource == StateCrit {
+			s.NameSource, s.PlayersSource, s.MaxPlayersSource = target.NameSource, target.PlayersSource, target.MaxPlayersSource
+		}
+		if i == 0 || target.StateMinecraft == StateCrit {
+			s.MOTDMinecraft, s.PlayersMinecraft, s.MaxPlayersMinecraft = target.MOTDMinecraft, target.PlayersMinecraft, target.MaxPlayersMinecraft
+		}
+	}
+
+	// Brokers seen from the address that saw fewest, as a partition shows
+	s.BrokersKafka = 0
+	for i, target := range targets {
+		if i == 0 || target.BrokersKafka < s.BrokersKafka {
+			s.BrokersKafka = target.BrokersKafka
+		}
+	}
+
+	logger.Infof("Checked %d addresses", len(addrs))
+}
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// defaultAMQPAPI is RabbitMQ's management API port, for queue depths
+const defaultAMQPAPI = "15672"
+
+// CheckAMQP opens an AMQP 0-9-1 connection on port 5672, or 5671 with TLS,
+// logging in to VhostAMQP as UserAMQP. With QueueAMQP set, the queue's depth
+// is read from the RabbitMQ management API and must not exceed MaxQueueAMQP.
+func (s *Server) CheckAMQP(wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	if !s.EnableAMQP {
+		return
+	}
+
+	scheme, port := "amqp", s.PortAMQP
+	if s.TLSAMQP {
+		scheme = "amqps"
+	}
+	if port == 0 {
+		port = 5672
+		if s.TLSAMQP {
+			port = 5671
+		}
+	}
+
+	logger := s.GetLogger("AMQP", port)
+	s.setState(checkAMQP, StateOK)
+
+	config := amqp.Config{
+		Vhost: s.amqpVhost(),
+		Dial: func(network, addr string) (net.Conn, error) {
+			conn, err := s.dial(addr)
+			if err != nil {
+				return nil, err
+			}
+			conn.SetDeadline(time.Now().Add(s.timeout()))
+			return conn, nil
+		},
+	}
+
+	if s.TLSAMQP {
+		var err error
+		if config.TLSClientConfig, err = s.tlsConfig(); err != nil {
+			s.ResultAMQP = "Invalid TLS configuration"
+			logger.WithError(err).Error(s.ResultAMQP)
+			s.fail(checkAMQP, StateCrit, ErrorConfig)
+			return
+		}
+	}
+
+	user, password := s.amqpCredentials()
+	config.SASL = []amqp.Authentication{&amqp.PlainAuth{Username: user, Password: password}}
+
+	addr := net.JoinHostPort(s.IP, strconv.Itoa(port))
+
+	start := time.Now()
+	conn, err := amqp.DialConfig(scheme+"://"+addr+"/", config)
+	if err != nil {
+		s.ResultAMQP = fmt.Sprintf("AMQP connection failed: %v", err)
+		logger.WithError(err).Error("AMQP connection failed")
+		s.fail(checkAMQP, StateCrit, ErrorConnect)
+		return
+	}
+	took := time.Since(start).Round(time.Millisecond)
+
+	if state := conn.ConnectionState(); len(state.PeerCertificates) > 0 {
+		s.expiries[checkAMQP] = state.PeerCertificates[0].NotAfter.Unix()
+		if certExpiresSoon(state) {
+			s.fail(checkAMQP, StateWarn, ErrorTLS)
+		}
+	}
+
+	product, _ := conn.Properties["product"].(string)
+	version, _ := conn.Properties["version"].(string)
+	conn.Close()
+
+	s.ResultAMQP = fmt.Sprintf("AMQP %v %v (handshake %v)", product, version, took)
+
+	if s.QueueAMQP != "" {
+		depth, err := s.amqpQueueDepth(user, password)
+		if err != nil {
+			s.ResultAMQP += fmt.Sprintf(", unable to read queue %v: %v", s.QueueAMQP, err)
+			logger.WithError(err).Error("Unable to read queue depth")
+			s.fail(checkAMQP, StateCrit, classOf(err))
+			return
+		}
+
+		s.ResultAMQP += fmt.Sprintf(", %v has %d messages", s.QueueAMQP, depth)
+
+		if s.MaxQueueAMQP > 0 && depth > s.MaxQueueAMQP {
+			s.ResultAMQP += fmt.Sprintf(", expected at most %d", s.MaxQueueAMQP)
+			logger.Error(s.ResultAMQP)
+			s.fail(checkAMQP, StateCrit, ErrorResponse)
+			return
+		}
+	}
+
+	logger.Infof("AMQP Check OK. Response: %v", s.ResultAMQP)
+}
+
+// amqpVhost returns the virtual host to log in to, "/" unless configured
+func (s *Server) amqpVhost() string {
+	if s.VhostAMQP == "" {
+		return "/"
+	}
+
+	return s.VhostAMQP
+}
+
+// amqpCredentials returns the login, RabbitMQ's guest account unless
+// configured
+func (s *Server) amqpCredentials() (string, string) {
+	if s.UserAMQP == "" {
+		return "guest", "guest"
+	}
+
+	return s.UserAMQP, s.PasswordAMQP
+}
+
+// amqpQueueDepth asks the management API at APIAMQP, by default port 15672
+// on the server, how many messages are in QueueAMQP
+func (s *Server) amqpQueueDepth(user string, password string) (int, error) {
+	api := s.APIAMQP
+	if api == "" {
+		api = "http://" + net.JoinHostPort(s.IP, defaultAMQPAPI)
+	}
+
+	// The default vhost "/" has to be sent escaped, as %2F
+	api = strings.TrimSuffix(api, "/") + "/api/queues/" + url.PathEscape(s.amqpVhost()) + "/" + url.PathEscape(s.QueueAMQP)
+
+	config, err := s.tlsConfig()
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequest("GET", api, nil)
+	if err != nil {
+		return 0, err
+	}
+	s.setHeaders(req)
+	req.SetBasicAuth(user, password)
+
+	resp, err := s.httpClient(config).Do(req)
+	if err != nil {
+		return 0, httpError(err)
+	}
+
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return 0, fmt.Errorf("no such queue")
+	case resp.StatusCode != http.StatusOK:
+		return 0, fmt.Errorf("management API returned %v", resp.Status)
+	}
+
+	var queue struct {
+		Messages int `json:"messages"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&queue); err != nil {
+		return 0, fmt.Errorf("invalid management API response: %v", err)
+	}
+
+	return queue.Messages, nil
+}
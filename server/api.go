@@ -0,0 +1,158 @@
+package server
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// apiPath prefixes the JSON API
+const apiPath = "/api/"
+
+// Handler serves heartbeats and, when token is set, the JSON API to clients
+// presenting it as a bearer token:
+//
+//	curl -H "Authorization: Bearer $API_TOKEN" https://vbms.example.com/api/uptime?hostname=www
+func Handler(db *sql.DB, token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle(heartbeatPath, HeartbeatHandler(db))
+
+	if token != "" {
+		api := http.NewServeMux()
+		api.Handle(apiPath+"uptime", uptimeHandler(db))
+		api.Handle(apiPath+"incidents", incidentsHandler(db))
+		api.Handle(apiPath+"servers/", serversHandler(db))
+		mux.Handle(apiPath, requireToken(token, api))
+	}
+
+	return mux
+}
+
+// requireToken only passes on requests with the bearer token
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// uptimeHandler returns the uptime table, for one server with ?hostname=
+func uptimeHandler(db *sql.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		uptimes, err := LoadUptime(db, r.URL.Query().Get("hostname"))
+		if err != nil {
+			logrus.WithError(err).Error("Unable to load uptime")
+			http.Error(w, "Unable to load uptime", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, uptimes)
+	})
+}
+
+// incidentsHandler returns the incidents overlapping ?from= to ?to= (RFC
+// 3339 times, defaulting to the last 7 days), for one server with ?hostname=
+func incidentsHandler(db *sql.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := r.URL.Query()
+		to := time.Now()
+		from := to.AddDate(0, 0, -7)
+
+		var err error
+		if v := query.Get("from"); v != "" {
+			if from, err = time.Parse(time.RFC3339, v); err != nil {
+				http.Error(w, "Invalid from", http.StatusBadRequest)
+				return
+			}
+		}
+		if v := query.Get("to"); v != "" {
+			if to, err = time.Parse(time.RFC3339, v); err != nil {
+				http.Error(w, "Invalid to", http.StatusBadRequest)
+				return
+			}
+		}
+
+		incidents, err := LoadIncidents(db, from, to, query.Get("hostname"))
+		if err != nil {
+			logrus.WithError(err).Error("Unable to load incidents")
+			http.Error(w, "Unable to load incidents", http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, incidents)
+	})
+}
+
+// serversHandler pauses and resumes servers with POST to
+// /api/servers/<hostname>/pause or /resume, taking an optional ?reason=,
+// queues a recheck with POST to /api/servers/<hostname>/check and
+// acknowledges a down check with POST to /api/servers/<hostname>/ack?check=
+func serversHandler(db *sql.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		hostname, action, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, apiPath+"servers/"), "/")
+		if hostname == "" || (action != "pause" && action != "resume" && action != "check" && action != "ack") {
+			http.NotFound(w, r)
+			return
+		}
+
+		var err error
+		switch action {
+		case "check":
+			err = RequestRecheck(db, hostname)
+		case "ack":
+			err = Acknowledge(db, hostname, r.URL.Query().Get("check"))
+		default:
+			err = SetPaused(db, hostname, action == "pause", r.URL.Query().Get("reason"))
+		}
+		if errors.Is(err, errUnknownServer) {
+			http.NotFound(w, r)
+			return
+		}
+		if errors.Is(err, errNotDown) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if err != nil {
+			logrus.WithError(err).Errorf("Unable to %v %v", action, hostname)
+			http.Error(w, "Unable to "+action+" server", http.StatusInternalServerError)
+			return
+		}
+
+		w.Write([]byte("OK\n"))
+	})
+}
+
+// writeJSON sends v as the response body
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/cel-go/cel"
+)
+
+// Assertion outcomes, returned by CEL expressions as strings. An expression
+// returning a bool maps true to AssertPass and false to AssertFail.
+const (
+	AssertPass = "pass"
+	AssertWarn = "warn"
+	AssertFail = "fail"
+)
+
+// maxAssertBody is how much of a response body assertions can see
+const maxAssertBody = 1 << 20
+
+// assertions caches compiled programs by expression
+var (
+	assertMu   sync.Mutex
+	assertEnv  *cel.Env
+	assertions = map[string]cel.Program{}
+)
+
+// assertProgram compiles an expression once. Expressions can use status
+// (int), headers (map of lower-cased names to values), body (string) and
+// latency (seconds, double), e.g.
+//
+//	status == 200 && body.contains("ok") ? (latency < 0.5 ? "pass" : "warn") : "fail"
+func assertProgram(expr string) (cel.Program, error) {
+	assertMu.Lock()
+	defer assertMu.Unlock()
+
+	if prg, ok := assertions[expr]; ok {
+		return prg, nil
+	}
+
+	if assertEnv == nil {
+		env, err := cel.NewEnv(
+			cel.Variable("status", cel.IntType),
+			cel.Variable("headers", cel.MapType(cel.StringType, cel.StringType)),
+			cel.Variable("body", cel.StringType),
+			cel.Variable("latency", cel.DoubleType),
+		)
+		if err != nil {
+			return nil, err
+		}
+		assertEnv = env
+	}
+
+	ast, issues := assertEnv.Compile(expr)
+	if issues != nil && issues.Err() != nil {
+		return nil, issues.Err()
+	}
+
+	prg, err := assertEnv.Program(ast)
+	if err != nil {
+		return nil, err
+	}
+
+	assertions[expr] = prg
+	return prg, nil
+}
+
+// assertResponse evaluates the server's assertion against a response,
+// returning AssertPass, AssertWarn or AssertFail. It reads the body, so call
+// it after anything else needing the response.
+func (s *Server) assertResponse(resp *http.Response, latency time.Duration) (string, error) {
+	if s.AssertHTTP == "" {
+		return AssertPass, nil
+	}
+
+	prg, err := assertProgram(s.AssertHTTP)
+	if err != nil {
+		return AssertFail, fmt.Errorf("Invalid assertion: %v", err)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxAssertBody))
+	if err != nil {
+		return AssertFail, fmt.Errorf("Unable to read response body: %v", err)
+	}
+
+	headers := map[string]string{}
+	for name, values := range resp.Header {
+		headers[strings.ToLower(name)] = strings.Join(values, ", ")
+	}
+
+	out, _, err := prg.Eval(map[string]interface{}{
+		"status":  resp.StatusCode,
+		"headers": headers,
+		"body":    string(body),
+		"latency": latency.Seconds(),
+	})
+	if err != nil {
+		return AssertFail, fmt.Errorf("Assertion error: %v", err)
+	}
+
+	switch val := out.Value().(type) {
+	case bool:
+		if val {
+			return AssertPass, nil
+		}
+		return AssertFail, nil
+	case string:
+		switch val {
+		case AssertPass, AssertWarn, AssertFail:
+			return val, nil
+		}
+	}
+
+	return AssertFail, fmt.Errorf("Assertion returned %v, expected a bool or pass/warn/fail", out.Value())
+}
+
+// applyAssertion runs the assertion and rewrites result to match its outcome,
+// returning the state the check should report and, if it failed, why
+func (s *Server) applyAssertion(resp *http.Response, latency time.Duration, result string) (string, State, ErrorClass) {
+	outcome, err := s.assertResponse(resp, latency)
+	if err != nil {
+		return err.Error(), StateCrit, ErrorConfig
+	}
+
+	switch outcome {
+	case AssertWarn:
+		return result + " (assertion warning)", StateWarn, ErrorResponse
+	case AssertFail:
+		return "Assertion failed: " + result, StateCrit, ErrorResponse
+	}
+
+	return result, StateOK, ""
+}
@@ -0,0 +1,199 @@
+package server
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"strings"
+	"sync"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// Authentication modes for HTTP checks
+const (
+	// AuthBasic sends User and Password with HTTP Basic authentication
+	AuthBasic = "basic"
+	// AuthBearer sends Token as a bearer token
+	AuthBearer = "bearer"
+	// AuthOAuth2 fetches bearer tokens with the OAuth2 client credentials grant
+	AuthOAuth2 = "oauth2"
+	// AuthDigest answers RFC 7616 digest challenges with User and Password
+	AuthDigest = "digest"
+)
+
+// tokenSources caches OAuth2 token sources per client and token endpoint, so
+// tokens are reused across runs and only refreshed once they expire
+var (
+	tokenMu      sync.Mutex
+	tokenSources = map[string]oauth2.TokenSource{}
+)
+
+// authorize adds credentials to req for modes that don't need a challenge.
+// With no mode set, a token is sent as a bearer token and a user and password
+// with Basic authentication.
+func (s *Server) authorize(req *http.Request) error {
+	mode := s.AuthHTTP
+	if mode == "" {
+		switch {
+		case s.Token != "":
+			mode = AuthBearer
+		case s.User != "":
+			mode = AuthBasic
+		}
+	}
+
+	switch mode {
+	case "", AuthDigest:
+		return nil
+	case AuthBasic:
+		req.SetBasicAuth(s.User, s.Password)
+		return nil
+	case AuthBearer:
+		req.Header.Set("Authorization", "Bearer "+s.Token)
+		return nil
+	case AuthOAuth2:
+		token, err := s.oauthToken()
+		if err != nil {
+			return err
+		}
+		token.SetAuthHeader(req)
+		return nil
+	default:
+		return fmt.Errorf("Unknown HTTP auth mode '%v'", s.AuthHTTP)
+	}
+}
+
+// oauthToken returns a current access token from the server's token endpoint
+func (s *Server) oauthToken() (*oauth2.Token, error) {
+	key := strings.Join([]string{s.OAuthURL, s.OAuthID, s.OAuthScopes}, " ")
+
+	tokenMu.Lock()
+	source, ok := tokenSources[key]
+	if !ok {
+		config := clientcredentials.Config{
+			ClientID:     s.OAuthID,
+			ClientSecret: s.OAuthSecret,
+			TokenURL:     s.OAuthURL,
+			Scopes:       strings.Fields(strings.Replace(s.OAuthScopes, ",", " ", -1)),
+		}
+		source = config.TokenSource(context.Background())
+		tokenSources[key] = source
+	}
+	tokenMu.Unlock()
+
+	return source.Token()
+}
+
+// digestAuthorization answers a Digest WWW-Authenticate challenge for a
+// request, supporting the MD5 and SHA-256 algorithms and their -sess variants
+func digestAuthorization(challenge string, method string, uri string, user string, password string) (string, error) {
+	params := parseChallenge(challenge)
+
+	algorithm := params["algorithm"]
+	if algorithm == "" {
+		algorithm = "MD5"
+	}
+
+	var newHash func() hash.Hash
+	switch strings.TrimSuffix(strings.ToUpper(algorithm), "-SESS") {
+	case "MD5":
+		newHash = md5.New
+	case "SHA-256":
+		newHash = sha256.New
+	default:
+		return "", fmt.Errorf("Unsupported digest algorithm %v", algorithm)
+	}
+
+	h := func(data string) string {
+		hasher := newHash()
+		hasher.Write([]byte(data))
+		return hex.EncodeToString(hasher.Sum(nil))
+	}
+
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	cnonce := hex.EncodeToString(nonce)
+	nc := "00000001"
+
+	ha1 := h(user + ":" + params["realm"] + ":" + password)
+	if strings.HasSuffix(strings.ToUpper(algorithm), "-SESS") {
+		ha1 = h(ha1 + ":" + params["nonce"] + ":" + cnonce)
+	}
+	ha2 := h(method + ":" + uri)
+
+	qop := ""
+	for _, offered := range strings.Split(params["qop"], ",") {
+		if strings.TrimSpace(offered) == "auth" {
+			qop = "auth"
+		}
+	}
+
+	var response string
+	if qop != "" {
+		response = h(strings.Join([]string{ha1, params["nonce"], nc, cnonce, qop, ha2}, ":"))
+	} else {
+		response = h(ha1 + ":" + params["nonce"] + ":" + ha2)
+	}
+
+	fields := []string{
+		fmt.Sprintf(`username="%v"`, user),
+		fmt.Sprintf(`realm="%v"`, params["realm"]),
+		fmt.Sprintf(`nonce="%v"`, params["nonce"]),
+		fmt.Sprintf(`uri="%v"`, uri),
+		fmt.Sprintf(`algorithm=%v`, algorithm),
+		fmt.Sprintf(`response="%v"`, response),
+	}
+
+	if qop != "" {
+		fields = append(fields, "qop="+qop, "nc="+nc, fmt.Sprintf(`cnonce="%v"`, cnonce))
+	}
+
+	if opaque, ok := params["opaque"]; ok {
+		fields = append(fields, fmt.Sprintf(`opaque="%v"`, opaque))
+	}
+
+	return "Digest " + strings.Join(fields, ", "), nil
+}
+
+// parseChallenge splits the key=value parameters of a Digest challenge
+func parseChallenge(challenge string) map[string]string {
+	params := map[string]string{}
+
+	rest := strings.TrimSpace(challenge[strings.Index(challenge, " ")+1:])
+	for rest != "" {
+		eq := strings.Index(rest, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(rest[:eq]))
+		rest = strings.TrimSpace(rest[eq+1:])
+
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+		} else if comma := strings.Index(rest, ","); comma >= 0 {
+			value, rest = rest[:comma], rest[comma:]
+		} else {
+			value, rest = rest, ""
+		}
+
+		params[key] = strings.TrimSpace(value)
+		rest = strings.TrimLeft(rest, ", ")
+	}
+
+	return params
+}
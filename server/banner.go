@@ -0,0 +1,173 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxBannerLine caps how much is read looking for the first line
+const maxBannerLine = 4096
+
+// Telnet commands, which negotiation sequences start with
+const (
+	telnetIAC  = 0xff
+	telnetSB   = 0xfa
+	telnetSE   = 0xf0
+	telnetWILL = 0xfb
+	telnetDONT = 0xfe
+)
+
+// CheckBanner connects to an arbitrary port, optionally over TLS, sends
+// SendBanner if set and matches the first line received against
+// ExpectBanner. It covers services vbms has no check for.
+func (s *Server) CheckBanner(wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	if !s.EnableBanner {
+		return
+	}
+
+	logger := s.GetLogger("BANNER", s.PortBanner)
+	s.setState(checkBanner, StateOK)
+
+	if s.PortBanner == 0 {
+		s.ResultBanner = "No port configured"
+		logger.Error(s.ResultBanner)
+		s.fail(checkBanner, StateCrit, ErrorConfig)
+		return
+	}
+
+	probe, err := unescapeProbe(s.SendBanner)
+	if err != nil {
+		s.ResultBanner = fmt.Sprintf("Invalid probe '%v'", s.SendBanner)
+		logger.WithError(err).Error(s.ResultBanner)
+		s.fail(checkBanner, StateCrit, ErrorConfig)
+		return
+	}
+
+	addr := net.JoinHostPort(s.IP, strconv.Itoa(s.PortBanner))
+
+	var conn net.Conn
+	if s.TLSBanner {
+		config, cfgErr := s.tlsConfig()
+		if cfgErr != nil {
+			s.ResultBanner = "Invalid TLS configuration"
+			logger.WithError(cfgErr).Error(s.ResultBanner)
+			s.fail(checkBanner, StateCrit, ErrorConfig)
+			return
+		}
+		conn, err = s.dialTLS(addr, config)
+	} else {
+		conn, err = s.dial(addr)
+	}
+	if err != nil {
+		s.ResultBanner = "Unable to open connection"
+		logger.WithError(err).Error(s.ResultBanner)
+		s.fail(checkBanner, StateCrit, ErrorConnect)
+		return
+	}
+
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(s.timeout()))
+
+	if probe != "" {
+		if _, err := conn.Write([]byte(probe)); err != nil {
+			s.ResultBanner = "Unable to send probe"
+			logger.WithError(err).Error(s.ResultBanner)
+			s.fail(checkBanner, StateCrit, ErrorResponse)
+			return
+		}
+	}
+
+	banner, err := readBanner(bufio.NewReaderSize(conn, maxBannerLine))
+	if err != nil {
+		s.ResultBanner = "No response received from server"
+		logger.WithError(err).Error(s.ResultBanner)
+		s.fail(checkBanner, StateCrit, ErrorTimeout)
+		return
+	}
+
+	s.ResultBanner = banner
+
+	if err := matchBanner(s.ExpectBanner, banner); err != nil {
+		s.ResultBanner = err.Error()
+		logger.Error(s.ResultBanner)
+		s.fail(checkBanner, StateCrit, ErrorResponse)
+		return
+	}
+
+	logger.Infof("Banner Check OK. Banner: %v", banner)
+}
+
+// unescapeProbe reads Go escapes such as \r\n and \x00 in a probe, so binary
+// and line-terminated requests can be stored in a text column
+func unescapeProbe(probe string) (string, error) {
+	if probe == "" {
+		return "", nil
+	}
+
+	return strconv.Unquote(`"` + strings.ReplaceAll(probe, `"`, `\"`) + `"`)
+}
+
+// readBanner returns the first non-empty line, skipping telnet option
+// negotiation. A line still unterminated after maxBannerLine bytes, or when
+// the server closes, counts as the banner.
+func readBanner(reader *bufio.Reader) (string, error) {
+	var line []byte
+
+	for len(line) < maxBannerLine {
+		b, err := reader.ReadByte()
+		if err != nil {
+			if len(strings.TrimSpace(string(line))) > 0 {
+				break
+			}
+			return "", err
+		}
+
+		switch {
+		case b == telnetIAC:
+			skipTelnetCommand(reader)
+		case b == '\n':
+			if text := strings.TrimSpace(string(line)); text != "" {
+				return text, nil
+			}
+			line = line[:0]
+		default:
+			line = append(line, b)
+		}
+	}
+
+	return strings.TrimSpace(string(line)), nil
+}
+
+// skipTelnetCommand consumes the rest of a command after IAC: an option for
+// WILL, WONT, DO and DONT, or everything up to IAC SE for subnegotiation
+func skipTelnetCommand(reader *bufio.Reader) {
+	cmd, err := reader.ReadByte()
+	if err != nil {
+		return
+	}
+
+	switch {
+	case cmd >= telnetWILL && cmd <= telnetDONT:
+		reader.ReadByte()
+	case cmd == telnetSB:
+		for {
+			b, err := reader.ReadByte()
+			if err != nil {
+				return
+			}
+			if b == telnetIAC {
+				if next, err := reader.ReadByte(); err != nil || next == telnetSE {
+					return
+				}
+			}
+		}
+	}
+}
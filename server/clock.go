@@ -0,0 +1,148 @@
+package server
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Where a server's clock is read from
+const (
+	ClockNTP  = "ntp"
+	ClockHTTP = "http"
+)
+
+// defaultClockDrift is how far, in seconds, a clock may drift before warning
+const defaultClockDrift = 5
+
+// ntpEpoch is the NTP era 0 epoch, 1900-01-01, as a Unix time
+const ntpEpoch = -2208988800
+
+// CheckClock compares the server's clock against ours and warns once it has
+// drifted further than ClockDrift seconds either way
+func (s *Server) CheckClock(wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	if !s.EnableClock {
+		return
+	}
+
+	logger := s.GetLogger("CLOCK", 0)
+	s.setState(checkClock, StateOK)
+
+	var offset time.Duration
+	var err error
+
+	switch s.ClockSource {
+	case "", ClockNTP:
+		logger = s.GetLogger("CLOCK", 123)
+		offset, err = s.ntpOffset()
+	case ClockHTTP:
+		logger = s.GetLogger("CLOCK", 80)
+		offset, err = s.httpOffset()
+	default:
+		err = fmt.Errorf("Unknown clock source '%v'", s.ClockSource)
+	}
+
+	if err != nil {
+		s.ResultClock = "Unable to read remote clock"
+		logger.WithError(err).Error(s.ResultClock)
+		s.fail(checkClock, StateCrit, ErrorResponse)
+		return
+	}
+
+	threshold := s.ClockDrift
+	if threshold <= 0 {
+		threshold = defaultClockDrift
+	}
+
+	s.ResultClock = fmt.Sprintf("Clock offset %v", offset.Round(time.Millisecond))
+
+	if offset.Abs() > time.Duration(threshold)*time.Second {
+		s.ResultClock = fmt.Sprintf("%v exceeds %ds", s.ResultClock, threshold)
+		logger.Warn(s.ResultClock)
+		s.fail(checkClock, StateWarn, ErrorResponse)
+		return
+	}
+
+	logger.Infof("Clock Check OK. %v", s.ResultClock)
+}
+
+// ntpOffset sends an SNTP client request and returns how far the server's
+// clock is ahead of ours, allowing for the round trip
+func (s *Server) ntpOffset() (time.Duration, error) {
+	// UDP can't be carried over an SSH tunnel
+	if spec, _ := s.sshJump(); spec != "" {
+		return 0, fmt.Errorf("NTP not available through SSH jump host")
+	}
+
+	conn, err := net.DialTimeout("udp", net.JoinHostPort(s.IP, "123"), s.timeout())
+	if err != nil {
+		return 0, err
+	}
+
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(s.timeout()))
+
+	// Leap indicator 0, version 4, mode 3 (client)
+	req := make([]byte, 48)
+	req[0] = 0x23
+
+	sent := time.Now()
+	if _, err := conn.Write(req); err != nil {
+		return 0, err
+	}
+
+	resp := make([]byte, 48)
+	n, err := conn.Read(resp)
+	received := time.Now()
+	if err != nil {
+		return 0, err
+	}
+
+	if n < 48 || resp[0]&0x07 != 4 {
+		return 0, fmt.Errorf("Invalid NTP response")
+	}
+
+	if resp[1] == 0 {
+		return 0, fmt.Errorf("NTP server is unsynchronised")
+	}
+
+	// Server receive and transmit timestamps
+	rx := ntpTime(resp[32:40])
+	tx := ntpTime(resp[40:48])
+
+	return (rx.Sub(sent) + tx.Sub(received)) / 2, nil
+}
+
+// ntpTime decodes a 64-bit NTP timestamp
+func ntpTime(b []byte) time.Time {
+	secs := int64(binary.BigEndian.Uint32(b[0:4]))
+	frac := int64(binary.BigEndian.Uint32(b[4:8]))
+
+	return time.Unix(secs+ntpEpoch, (frac*1e9)>>32)
+}
+
+// httpOffset reads the Date header of the root page. It only has a
+// resolution of a second, so it is compared with the middle of the request.
+func (s *Server) httpOffset() (time.Duration, error) {
+	start := time.Now()
+	resp, err := s.httpGet("http://"+net.JoinHostPort(s.IP, "80")+"/", nil)
+	if err != nil {
+		return 0, err
+	}
+
+	resp.Body.Close()
+	mid := start.Add(time.Since(start) / 2)
+
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, fmt.Errorf("No valid Date header")
+	}
+
+	return date.Sub(mid.Truncate(time.Second)), nil
+}
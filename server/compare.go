@@ -0,0 +1,134 @@
+package server
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxCompareBody is how much of each response body is hashed
+const maxCompareBody = 10 << 20
+
+// endpoint is what was seen when requesting one side of a comparison
+type endpoint struct {
+	status  int
+	hash    [sha256.Size]byte
+	latency time.Duration
+	err     error
+}
+
+// CheckCompare requests the same path from this server and CompareWith, and
+// fails when their status or body differ. A latency difference greater than
+// CompareLatency milliseconds is a warning.
+func (s *Server) CheckCompare(wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	if s.CompareWith == "" {
+		return
+	}
+
+	scheme, port := "http", 80
+	if s.CompareTLS {
+		scheme, port = "https", 443
+	}
+
+	logger := s.GetLogger("COMPARE", port)
+	s.setState(checkCompare, StateOK)
+
+	path := s.ComparePath
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	host := s.Hostname
+	if s.pinned {
+		host = s.IP
+	}
+
+	var ours, theirs endpoint
+	fetched := new(sync.WaitGroup)
+	fetched.Add(2)
+
+	go func() {
+		defer fetched.Done()
+		ours = s.fetchEndpoint(scheme, host, port, path, s.Hostname)
+	}()
+
+	go func() {
+		defer fetched.Done()
+		theirs = s.fetchEndpoint(scheme, s.CompareWith, port, path, s.CompareWith)
+	}()
+
+	fetched.Wait()
+
+	class := ErrorResponse
+	switch {
+	case ours.err != nil:
+		failed := httpError(ours.err)
+		s.ResultCompare = fmt.Sprintf("%v: %v", s.Hostname, failed)
+		class = classOf(failed)
+	case theirs.err != nil:
+		failed := httpError(theirs.err)
+		s.ResultCompare = fmt.Sprintf("%v: %v", s.CompareWith, failed)
+		class = classOf(failed)
+	case ours.status != theirs.status:
+		s.ResultCompare = fmt.Sprintf("Status differs: %d vs %d from %v", ours.status, theirs.status, s.CompareWith)
+	case ours.hash != theirs.hash:
+		s.ResultCompare = fmt.Sprintf("Body differs from %v", s.CompareWith)
+	}
+
+	if s.ResultCompare != "" {
+		logger.Error(s.ResultCompare)
+		s.fail(checkCompare, StateCrit, class)
+		return
+	}
+
+	diff := ours.latency - theirs.latency
+	s.ResultCompare = fmt.Sprintf("Matches %v, latency %v vs %v", s.CompareWith,
+		ours.latency.Round(time.Millisecond), theirs.latency.Round(time.Millisecond))
+
+	if s.CompareLatency > 0 && diff.Abs() > time.Duration(s.CompareLatency)*time.Millisecond {
+		s.ResultCompare = fmt.Sprintf("Latency differs from %v by %v", s.CompareWith, diff.Abs().Round(time.Millisecond))
+		logger.Warn(s.ResultCompare)
+		s.fail(checkCompare, StateWarn, ErrorResponse)
+		return
+	}
+
+	logger.Infof("Compare Check OK. %v", s.ResultCompare)
+}
+
+// fetchEndpoint requests path from host, verifying TLS against name
+func (s *Server) fetchEndpoint(scheme string, host string, port int, path string, name string) endpoint {
+	config, err := s.tlsConfig()
+	if err != nil {
+		return endpoint{err: err}
+	}
+	if s.TLSName == "" {
+		config.ServerName = name
+	}
+
+	start := time.Now()
+	resp, err := s.httpGet(fmt.Sprintf("%v://%v%v", scheme, net.JoinHostPort(host, fmt.Sprint(port)), path), config)
+	if err != nil {
+		return endpoint{err: err}
+	}
+
+	defer resp.Body.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, io.LimitReader(resp.Body, maxCompareBody)); err != nil {
+		return endpoint{err: err}
+	}
+
+	var e endpoint
+	e.status = resp.StatusCode
+	e.latency = time.Since(start)
+	copy(e.hash[:], hash.Sum(nil))
+
+	return e
+}
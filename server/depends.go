@@ -0,0 +1,122 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// checkDepends parses CheckDepends, e.g. "https=ping, smtp=dns+ping", a
+// comma separated list of checks and the checks, joined with +, that have to
+// pass first. It returns the indexes each check waits for.
+func (s *Server) checkDepends() (map[int][]int, error) {
+	depends := map[int][]int{}
+
+	index := map[string]int{}
+	for i, name := range checkNames {
+		index[name] = i
+	}
+
+	for _, part := range strings.Split(s.CheckDepends, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		name, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("Invalid check dependency '%v'", part)
+		}
+
+		check, ok := index[strings.TrimSpace(name)]
+		if !ok {
+			return nil, fmt.Errorf("Unknown check '%v'", strings.TrimSpace(name))
+		}
+
+		for _, on := range strings.Split(value, "+") {
+			dep, ok := index[strings.TrimSpace(on)]
+			if !ok {
+				return nil, fmt.Errorf("Unknown check '%v'", strings.TrimSpace(on))
+			}
+			depends[check] = append(depends[check], dep)
+		}
+	}
+
+	// A check waiting on itself, however indirectly, would never run
+	var visit func(check int, path []int) error
+	visit = func(check int, path []int) error {
+		for _, seen := range path {
+			if seen == check {
+				return fmt.Errorf("Check dependencies loop through %v", checkNames[check])
+			}
+		}
+		for _, dep := range depends[check] {
+			if err := visit(dep, append(path, check)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for check := range depends {
+		if err := visit(check, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return depends, nil
+}
+
+// dependencies hands each check's final state to the checks depending on
+// it. A check's state is set before its channel is closed.
+type dependencies struct {
+	finished [numChecks]chan struct{}
+	states   [numChecks]State
+}
+
+// runAfter runs check i with timeCheck once the checks it depends on have
+// finished, closing its channel in deps when it's done. If one of them
+// failed, the check isn't run and is UNKNOWN instead, so a total outage
+// shows up as the checks that failed rather than every check behind them.
+// A check missing a secret it needs is UNKNOWN without waiting.
+func (s *Server) runAfter(i int, check func(*Server, *sync.WaitGroup), on []int, deps *dependencies, done chan<- checkRun) {
+	defer func() {
+		deps.states[i] = *s.states()[i]
+		close(deps.finished[i])
+	}()
+
+	// Running without a secret would dial direct instead of through a proxy
+	// or fail to log in, neither of which says anything about the server
+	if s.unresolved[i] != nil && s.enabled()[i] {
+		*s.states()[i] = StateUnknown
+		s.classes[i] = ErrorConfig
+		*s.results()[i] = "Unable to resolve secret"
+		done <- checkRun{check: i, outcome: s}
+		return
+	}
+
+	for _, dep := range on {
+		select {
+		case <-deps.finished[dep]:
+		case <-s.runContext().Done():
+			done <- checkRun{check: i, outcome: s}
+			return
+		}
+	}
+
+	var failed []string
+	for _, dep := range on {
+		if st := deps.states[dep]; st != "" && st != StateOK && st != StateWarn {
+			failed = append(failed, fmt.Sprintf("%v %v", checkNames[dep], st))
+		}
+	}
+
+	if len(failed) > 0 && s.enabled()[i] {
+		*s.states()[i] = StateUnknown
+		s.classes[i] = ErrorDependency
+		*s.results()[i] = "Not checked, depends on " + strings.Join(failed, ", ")
+		done <- checkRun{check: i, outcome: s}
+		return
+	}
+
+	s.timeCheck(i, check, done)
+}
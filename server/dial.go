@@ -0,0 +1,168 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// jumpClients caches SSH connections to bastions, keyed by jump spec, so
+// every check through the same bastion shares one session
+var (
+	jumpMu      sync.Mutex
+	jumpClients = map[string]*ssh.Client{}
+)
+
+// sshJump returns the bastion checks are tunnelled through, as user@host[:port]
+func (s *Server) sshJump() (spec string, key string) {
+	if s.SSHJump != "" {
+		return s.SSHJump, s.SSHJumpKey
+	}
+
+	return Default.SSHJump, Default.SSHJumpKey
+}
+
+// dial opens a TCP connection to addr, through the SSH jump host if one is
+// configured for the server
+func (s *Server) dial(addr string) (net.Conn, error) {
+	return s.dialNetwork("tcp", addr)
+}
+
+// dialNetwork opens a TCP connection, or with "unix" one to a socket path,
+// through the SSH jump host if one is configured. A socket is then the one
+// on the jump host. Without a jump host TCP goes through the proxy, if set.
+func (s *Server) dialNetwork(network string, addr string) (net.Conn, error) {
+	spec, key := s.sshJump()
+	if spec == "" {
+		if proxy := s.proxyURL(); proxy != "" && network == "tcp" {
+			return s.dialProxy(proxy, addr)
+		}
+		dialer := net.Dialer{Timeout: s.timeout()}
+		return dialer.DialContext(s.runContext(), network, addr)
+	}
+
+	client, err := jumpClient(spec, key, s.timeout())
+	if err != nil {
+		return nil, fmt.Errorf("SSH jump host %v: %v", spec, err)
+	}
+
+	conn, err := client.Dial(network, addr)
+	if err != nil {
+		// The cached session may have gone away, so reconnect once
+		dropJumpClient(spec, client)
+
+		client, err = jumpClient(spec, key, s.timeout())
+		if err != nil {
+			return nil, fmt.Errorf("SSH jump host %v: %v", spec, err)
+		}
+
+		conn, err = client.Dial(network, addr)
+	}
+
+	return conn, err
+}
+
+// dialTLS opens a connection with dial and completes a TLS handshake on it
+func (s *Server) dialTLS(addr string, config *tls.Config) (*tls.Conn, error) {
+	conn, err := s.dial(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.handshakeTLS(conn, config)
+}
+
+// handshakeTLS completes a TLS handshake on conn, closing it on failure
+func (s *Server) handshakeTLS(conn net.Conn, config *tls.Config) (*tls.Conn, error) {
+	tlsConn := tls.Client(conn, config)
+	tlsConn.SetDeadline(time.Now().Add(s.timeout()))
+
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return tlsConn, nil
+}
+
+// jumpClient returns a connected SSH client for the jump spec
+func jumpClient(spec string, keyFile string, timeout time.Duration) (*ssh.Client, error) {
+	jumpMu.Lock()
+	defer jumpMu.Unlock()
+
+	if client, ok := jumpClients[spec]; ok {
+		return client, nil
+	}
+
+	at := strings.LastIndex(spec, "@")
+	if at < 0 {
+		return nil, fmt.Errorf("expected user@host[:port]")
+	}
+	user, addr := spec[:at], spec[at+1:]
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "22")
+	}
+
+	pem, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := ssh.ParsePrivateKey(pem)
+	if err != nil {
+		return nil, err
+	}
+
+	hostKeys, err := knownHosts()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeys,
+		Timeout:         timeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	jumpClients[spec] = client
+
+	return client, nil
+}
+
+// dropJumpClient forgets a cached client after it stops working
+func dropJumpClient(spec string, client *ssh.Client) {
+	jumpMu.Lock()
+	defer jumpMu.Unlock()
+
+	if jumpClients[spec] == client {
+		delete(jumpClients, spec)
+		client.Close()
+	}
+}
+
+// knownHosts verifies bastion host keys against Default.KnownHosts, or
+// ~/.ssh/known_hosts when that isn't set
+func knownHosts() (ssh.HostKeyCallback, error) {
+	file := Default.KnownHosts
+	if file == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		file = filepath.Join(home, ".ssh", "known_hosts")
+	}
+
+	return knownhosts.New(file)
+}
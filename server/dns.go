@@ -0,0 +1,263 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// recordTypes are the record types ExpectDNS can name
+var recordTypes = []string{"A", "AAAA", "CNAME", "MX", "TXT"}
+
+// CheckDNS looks up each record type named in ExpectDNS for the hostname,
+// through ResolverDNS if set, and fails when the answers differ from the
+// expected set in either direction. It catches hijacked or mistyped zones
+// that still resolve.
+func (s *Server) CheckDNS(wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	if !s.EnableDNS {
+		return
+	}
+
+	logger := s.GetLogger("DNS", 53)
+	s.setState(checkDNS, StateOK)
+
+	expected, err := parseRecords(s.ExpectDNS)
+	if err != nil {
+		s.ResultDNS = fmt.Sprintf("Invalid expected records: %v", err)
+		logger.Error(s.ResultDNS)
+		s.fail(checkDNS, StateCrit, ErrorConfig)
+		return
+	}
+
+	if len(expected) == 0 {
+		s.ResultDNS = "No expected records configured"
+		logger.Error(s.ResultDNS)
+		s.fail(checkDNS, StateCrit, ErrorConfig)
+		return
+	}
+
+	resolver := s.dnsResolver()
+
+	var drift []string
+	checked := 0
+
+	for _, kind := range recordTypes {
+		want, ok := expected[kind]
+		if !ok {
+			continue
+		}
+
+		got, err := lookupRecords(s.runContext(), resolver, kind, s.Hostname, s.timeout())
+		if err != nil {
+			drift = append(drift, fmt.Sprintf("%v lookup failed: %v", kind, err))
+			continue
+		}
+
+		missing, unexpected := recordDrift(want, got)
+		for _, value := range missing {
+			drift = append(drift, fmt.Sprintf("%v %v missing", kind, quoteRecord(kind, value)))
+		}
+		for _, value := range unexpected {
+			drift = append(drift, fmt.Sprintf("%v %v unexpected", kind, quoteRecord(kind, value)))
+		}
+
+		checked += len(want)
+	}
+
+	if len(drift) > 0 {
+		s.ResultDNS = strings.Join(drift, ", ")
+		logger.Error(s.ResultDNS)
+		s.fail(checkDNS, StateCrit, ErrorResponse)
+		return
+	}
+
+	s.ResultDNS = fmt.Sprintf("%d records as expected", checked)
+	if s.ResolverDNS != "" {
+		s.ResultDNS += " from " + s.ResolverDNS
+	}
+	logger.Infof("DNS Check OK. %v", s.ResultDNS)
+}
+
+// dnsResolver returns a resolver sending every query to ResolverDNS, port 53
+// unless given, or the system resolver when it is empty
+func (s *Server) dnsResolver() *net.Resolver {
+	if s.ResolverDNS == "" {
+		return net.DefaultResolver
+	}
+
+	addr := s.ResolverDNS
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "53")
+	}
+
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network string, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, addr)
+		},
+	}
+}
+
+// parseRecords reads "TYPE value" entries separated by semicolons or
+// newlines, e.g. `A 192.0.2.10; MX 10 mail.example.com; TXT "v=spf1 -all"`,
+// into the normalised values expected for each type
+func parseRecords(text string) (map[string][]string, error) {
+	records := make(map[string][]string)
+
+	for _, entry := range splitUnquoted(text) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		fields := strings.SplitN(entry, " ", 2)
+		kind := strings.ToUpper(fields[0])
+		if len(fields) < 2 || strings.TrimSpace(fields[1]) == "" {
+			return nil, fmt.Errorf("no value in '%v'", entry)
+		}
+
+		value, err := normaliseRecord(kind, strings.TrimSpace(fields[1]))
+		if err != nil {
+			return nil, err
+		}
+
+		records[kind] = append(records[kind], value)
+	}
+
+	return records, nil
+}
+
+// normaliseRecord puts an expected value in the form lookups return, so
+// case, trailing dots and IPv6 spelling don't count as drift
+func normaliseRecord(kind string, value string) (string, error) {
+	switch kind {
+	case "A", "AAAA":
+		ip := net.ParseIP(value)
+		if ip == nil || (kind == "A") != (ip.To4() != nil) {
+			return "", fmt.Errorf("invalid %v address '%v'", kind, value)
+		}
+		return ip.String(), nil
+	case "CNAME":
+		return canonicalName(value), nil
+	case "MX":
+		fields := strings.Fields(value)
+		if len(fields) != 2 {
+			return "", fmt.Errorf("MX '%v' needs a preference and host", value)
+		}
+		pref, err := strconv.ParseUint(fields[0], 10, 16)
+		if err != nil {
+			return "", fmt.Errorf("invalid MX preference '%v'", fields[0])
+		}
+		return fmt.Sprintf("%d %v", pref, canonicalName(fields[1])), nil
+	case "TXT":
+		if strings.HasPrefix(value, `"`) {
+			unquoted, err := strconv.Unquote(value)
+			if err != nil {
+				return "", fmt.Errorf("invalid TXT value %v", value)
+			}
+			return unquoted, nil
+		}
+		return value, nil
+	}
+
+	return "", fmt.Errorf("unsupported record type '%v'", kind)
+}
+
+// quoteRecord quotes TXT values, which may contain spaces and separators
+func quoteRecord(kind string, value string) string {
+	if kind == "TXT" {
+		return strconv.Quote(value)
+	}
+
+	return value
+}
+
+// canonicalName lower-cases a name and drops its trailing dot
+func canonicalName(name string) string {
+	return strings.TrimSuffix(strings.ToLower(name), ".")
+}
+
+// lookupRecords returns the normalised answers of a type for host. A name
+// without any is no answers rather than an error, so it shows as missing.
+func lookupRecords(ctx context.Context, resolver *net.Resolver, kind string, host string, timeout time.Duration) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var answers []string
+	var err error
+
+	switch kind {
+	case "A", "AAAA":
+		network := "ip4"
+		if kind == "AAAA" {
+			network = "ip6"
+		}
+		var ips []net.IP
+		ips, err = resolver.LookupIP(ctx, network, host)
+		for _, ip := range ips {
+			answers = append(answers, ip.String())
+		}
+	case "CNAME":
+		var target string
+		target, err = resolver.LookupCNAME(ctx, host)
+		// The name itself comes back when there is no CNAME
+		if err == nil && canonicalName(target) != canonicalName(host) {
+			answers = append(answers, canonicalName(target))
+		}
+	case "MX":
+		var mxs []*net.MX
+		mxs, err = resolver.LookupMX(ctx, host)
+		for _, mx := range mxs {
+			answers = append(answers, fmt.Sprintf("%d %v", mx.Pref, canonicalName(mx.Host)))
+		}
+	case "TXT":
+		answers, err = resolver.LookupTXT(ctx, host)
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return nil, nil
+	}
+
+	return answers, err
+}
+
+// recordDrift returns the expected values not answered and the answers not
+// expected
+func recordDrift(want []string, got []string) ([]string, []string) {
+	wanted := make(map[string]bool)
+	for _, value := range want {
+		wanted[value] = true
+	}
+
+	answered := make(map[string]bool)
+	var unexpected []string
+	for _, value := range got {
+		answered[value] = true
+		if !wanted[value] {
+			unexpected = append(unexpected, value)
+		}
+	}
+
+	var missing []string
+	for _, value := range want {
+		if !answered[value] {
+			missing = append(missing, value)
+		}
+	}
+
+	sort.Strings(missing)
+	sort.Strings(unexpected)
+
+	return missing, unexpected
+}
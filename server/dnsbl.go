@@ -0,0 +1,125 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// defaultDNSBL lists the blacklists used when DNSBL is empty
+const defaultDNSBL = "zen.spamhaus.org,bl.spamcop.net,b.barracudacentral.org"
+
+// CheckDNSBL looks the server's IP up in each DNS blacklist and fails if it
+// is listed in any, which stops its mail being delivered
+func (s *Server) CheckDNSBL(wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	if !s.EnableDNSBL {
+		return
+	}
+
+	logger := s.GetLogger("DNSBL", 53)
+	s.setState(checkDNSBL, StateOK)
+
+	ip, err := s.lookupIP("ip")
+	if err != nil {
+		s.ResultDNSBL = "Unable to resolve address"
+		logger.WithError(err).Error(s.ResultDNSBL)
+		s.fail(checkDNSBL, StateCrit, ErrorDNS)
+		return
+	}
+
+	lists := s.DNSBL
+	if lists == "" {
+		lists = defaultDNSBL
+	}
+
+	var listed, failed []string
+	checked := 0
+
+	for _, zone := range strings.Split(lists, ",") {
+		zone = strings.TrimSpace(zone)
+		if zone == "" {
+			continue
+		}
+		checked++
+
+		reason, err := s.dnsblLookup(ip, zone)
+		switch {
+		case err != nil:
+			failed = append(failed, zone)
+			logger.WithError(err).Warnf("Unable to query %v", zone)
+		case reason != "":
+			listed = append(listed, fmt.Sprintf("%v (%v)", zone, reason))
+		}
+	}
+
+	if len(listed) > 0 {
+		s.ResultDNSBL = "Listed in " + strings.Join(listed, ", ")
+		logger.Error(s.ResultDNSBL)
+		s.fail(checkDNSBL, StateCrit, ErrorResponse)
+		return
+	}
+
+	s.ResultDNSBL = fmt.Sprintf("Not listed in %d blacklists", checked-len(failed))
+
+	if len(failed) > 0 {
+		s.ResultDNSBL += fmt.Sprintf(", unable to query %v", strings.Join(failed, ", "))
+		s.fail(checkDNSBL, StateWarn, ErrorResponse)
+	}
+
+	logger.Infof("DNSBL Check OK. %v", s.ResultDNSBL)
+}
+
+// dnsblLookup queries zone for ip, e.g. 10.2.0.192.zen.spamhaus.org for
+// 192.0.2.10. A listing answers with a 127.0.0.x address and usually a TXT
+// record explaining it, which is returned. Not being listed returns "".
+func (s *Server) dnsblLookup(ip net.IP, zone string) (string, error) {
+	ctx, cancel := context.WithTimeout(s.runContext(), s.timeout())
+	defer cancel()
+
+	name := reverseName(ip) + "." + zone
+
+	addrs, err := net.DefaultResolver.LookupHost(ctx, name)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return "", nil
+		}
+		return "", err
+	}
+
+	// Spamhaus answers 127.255.255.x instead when it refuses the query,
+	// e.g. from a public resolver
+	for _, addr := range addrs {
+		if strings.HasPrefix(addr, "127.255.255.") {
+			return "", fmt.Errorf("%v refused the query with %v", zone, addr)
+		}
+	}
+
+	reason := addrs[0]
+	if txt, err := net.DefaultResolver.LookupTXT(ctx, name); err == nil && len(txt) > 0 {
+		reason = txt[0]
+	}
+
+	return reason, nil
+}
+
+// reverseName returns ip's octets, or an IPv6 address's nibbles, in reverse
+func reverseName(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d", v4[3], v4[2], v4[1], v4[0])
+	}
+
+	const hex = "0123456789abcdef"
+	nibbles := make([]string, 0, 32)
+	for i := len(ip) - 1; i >= 0; i-- {
+		nibbles = append(nibbles, string(hex[ip[i]&0x0f]), string(hex[ip[i]>>4]))
+	}
+
+	return strings.Join(nibbles, ".")
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// defaultDockerPort is the daemon's plain TCP port, used when dockerurl is
+// empty
+const defaultDockerPort = "2375"
+
+// dockerContainer is the part of the inspect response the check reads
+type dockerContainer struct {
+	State struct {
+		Status     string `json:"Status"`
+		Running    bool   `json:"Running"`
+		Restarting bool   `json:"Restarting"`
+		ExitCode   int    `json:"ExitCode"`
+		Health     *struct {
+			Status string `json:"Status"`
+		} `json:"Health"`
+	} `json:"State"`
+}
+
+// CheckDocker pings the Docker Engine API at URLDocker and inspects each
+// container named in ContainersDocker, which must be running and, if it has
+// a health check, healthy. unix:// URLs reach the daemon's socket, on the SSH
+// jump host when one is configured.
+func (s *Server) CheckDocker(wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	if !s.EnableDocker {
+		return
+	}
+
+	logger := s.GetLogger("DOCKER", 0)
+	s.setState(checkDocker, StateOK)
+
+	client, base, err := s.dockerClient()
+	if err != nil {
+		s.ResultDocker = fmt.Sprintf("Invalid Docker endpoint: %v", err)
+		logger.Error(s.ResultDocker)
+		s.fail(checkDocker, StateCrit, ErrorConfig)
+		return
+	}
+
+	var version struct {
+		Version string `json:"Version"`
+	}
+	status, err := s.dockerGet(client, base+"/version", &version)
+	if err == nil && status != http.StatusOK {
+		err = fmt.Errorf("daemon returned %v", http.StatusText(status))
+	}
+	if err != nil {
+		s.ResultDocker = fmt.Sprintf("Docker API not responding: %v", err)
+		logger.WithError(err).Error("Docker API not responding")
+		s.fail(checkDocker, StateCrit, classOf(err))
+		return
+	}
+
+	var problems []string
+	running := 0
+
+	for _, name := range strings.Split(s.ContainersDocker, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		var container dockerContainer
+		status, err := s.dockerGet(client, base+"/containers/"+url.PathEscape(name)+"/json", &container)
+		switch {
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("%v: %v", name, err))
+			s.fail(checkDocker, StateCrit, classOf(err))
+		case status == http.StatusNotFound:
+			problems = append(problems, name+" not found")
+			s.fail(checkDocker, StateCrit, ErrorResponse)
+		case status != http.StatusOK:
+			problems = append(problems, fmt.Sprintf("%v: daemon returned %v", name, http.StatusText(status)))
+			s.fail(checkDocker, StateCrit, ErrorResponse)
+		case !container.State.Running || container.State.Restarting:
+			problems = append(problems, fmt.Sprintf("%v %v (exit code %d)", name, container.State.Status, container.State.ExitCode))
+			s.fail(checkDocker, StateCrit, ErrorResponse)
+		case container.State.Health != nil && container.State.Health.Status == "starting":
+			problems = append(problems, name+" health check starting")
+			s.fail(checkDocker, StateWarn, ErrorResponse)
+		case container.State.Health != nil && container.State.Health.Status == "unhealthy":
+			problems = append(problems, name+" unhealthy")
+			s.fail(checkDocker, StateCrit, ErrorResponse)
+		default:
+			running++
+		}
+	}
+
+	s.ResultDocker = fmt.Sprintf("Docker %v, %d containers running", version.Version, running)
+
+	if len(problems) > 0 {
+		s.ResultDocker += ", " + strings.Join(problems, ", ")
+		logger.Error(s.ResultDocker)
+		return
+	}
+
+	logger.Infof("Docker Check OK. Response: %v", s.ResultDocker)
+}
+
+// dockerClient returns a client for URLDocker and the base URL requests are
+// made against. tcp:// is plain HTTP, as the Docker CLI treats it, and
+// https:// presents the client certificate from tlscert/tlskey.
+func (s *Server) dockerClient() (*http.Client, string, error) {
+	endpoint := s.URLDocker
+	if endpoint == "" {
+		endpoint = "http://" + net.JoinHostPort(s.IP, defaultDockerPort)
+	}
+
+	parsed, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, "", err
+	}
+
+	config, err := s.tlsConfig()
+	if err != nil {
+		return nil, "", err
+	}
+	client := s.httpClient(config)
+
+	switch parsed.Scheme {
+	case "http", "https":
+		return client, strings.TrimSuffix(endpoint, "/"), nil
+	case "tcp":
+		return client, "http://" + parsed.Host, nil
+	case "unix":
+		socket := parsed.Path
+		client.Transport.(*http.Transport).DialContext = func(ctx context.Context, network string, addr string) (net.Conn, error) {
+			conn, err := s.dialNetwork("unix", socket)
+			if err != nil {
+				return nil, &dialError{err}
+			}
+			return conn, nil
+		}
+		client.Transport.(*http.Transport).DialTLSContext = nil
+		return client, "http://docker", nil
+	}
+
+	return nil, "", fmt.Errorf("unsupported scheme '%v'", parsed.Scheme)
+}
+
+// dockerGet requests an API path and decodes a successful JSON response
+// into v, returning the status code
+func (s *Server) dockerGet(client *http.Client, url string, v interface{}) (int, error) {
+	ctx, cancel := context.WithTimeout(s.runContext(), s.timeout())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return 0, err
+	}
+	if agent := s.userAgent(); agent != "" {
+		req.Header.Set("User-Agent", agent)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, httpError(err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, nil
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return 0, fmt.Errorf("invalid response: %v", err)
+	}
+
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// domainLookupInterval is how long an RDAP answer is trusted. Expiry dates
+// rarely change and registries rate limit lookups.
+const domainLookupInterval = 24 * time.Hour
+
+// defaultDomainWarnDays is how close to expiry a registration warns
+const defaultDomainWarnDays = 30
+
+// domainCritDays is how close to expiry a registration is critical
+const domainCritDays = 7
+
+// errNotRegistered is returned for a domain the registry doesn't know
+var errNotRegistered = errors.New("domain not registered")
+
+// CheckDomain warns when the domain's registration is due to expire within
+// DomainWarnDays, and fails within a week of it or once it has lapsed
+func (s *Server) CheckDomain(wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	if !s.EnableDomain {
+		return
+	}
+
+	logger := s.GetLogger("DOMAIN", 0)
+	s.setState(checkDomain, StateOK)
+
+	domain := s.Domain
+	if domain == "" {
+		var err error
+		if domain, err = publicsuffix.EffectiveTLDPlusOne(s.Hostname); err != nil {
+			s.ResultDomain = "Unable to determine registered domain"
+			logger.WithError(err).Error(s.ResultDomain)
+			s.fail(checkDomain, StateCrit, ErrorConfig)
+			return
+		}
+	}
+
+	if time.Since(time.Unix(s.DomainAt, 0)) > domainLookupInterval {
+		expiry, err := s.rdapExpiry(domain)
+		if err == errNotRegistered {
+			s.ResultDomain = fmt.Sprintf("%v is not registered", domain)
+			logger.Error(s.ResultDomain)
+			s.fail(checkDomain, StateCrit, ErrorResponse)
+			return
+		}
+		if err != nil {
+			// Try again next run, the registration itself may be fine
+			s.ResultDomain = "RDAP lookup failed"
+			logger.WithError(err).Warn(s.ResultDomain)
+			s.fail(checkDomain, StateWarn, ErrorResponse)
+			return
+		}
+
+		s.DomainExpiry, s.DomainAt = expiry, time.Now().Unix()
+	}
+
+	if s.DomainExpiry == 0 {
+		s.ResultDomain = fmt.Sprintf("Registry publishes no expiry date for %v", domain)
+		logger.Warn(s.ResultDomain)
+		s.fail(checkDomain, StateWarn, ErrorResponse)
+		return
+	}
+
+	expiry := time.Unix(s.DomainExpiry, 0)
+	days := int(time.Until(expiry).Hours() / 24)
+	s.ResultDomain = fmt.Sprintf("%v registration expires %v (%d days)", domain, expiry.Format("2006-01-02"), days)
+
+	warn := s.DomainWarnDays
+	if warn <= 0 {
+		warn = defaultDomainWarnDays
+	}
+
+	switch {
+	case days < domainCritDays:
+		logger.Error(s.ResultDomain)
+		s.fail(checkDomain, StateCrit, ErrorResponse)
+	case days < warn:
+		logger.Warn(s.ResultDomain)
+		s.fail(checkDomain, StateWarn, ErrorResponse)
+	default:
+		logger.Infof("Domain Check OK. %v", s.ResultDomain)
+	}
+}
+
+// rdapExpiry looks domain up with the RDAP service at Default.RDAP and
+// returns its expiration event as a Unix time, or 0 when there is none
+func (s *Server) rdapExpiry(domain string) (int64, error) {
+	url := strings.TrimSuffix(Default.RDAP, "/") + "/domain/" + domain
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", "application/rdap+json")
+	if agent := s.userAgent(); agent != "" {
+		req.Header.Set("User-Agent", agent)
+	}
+
+	client := &http.Client{Timeout: s.timeout()}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return 0, errNotRegistered
+	case resp.StatusCode != http.StatusOK:
+		return 0, fmt.Errorf("RDAP returned %v", resp.Status)
+	}
+
+	var answer struct {
+		Events []struct {
+			Action string    `json:"eventAction"`
+			Date   time.Time `json:"eventDate"`
+		} `json:"events"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+		return 0, fmt.Errorf("Invalid RDAP response: %v", err)
+	}
+
+	for _, event := range answer.Events {
+		if event.Action == "expiration" {
+			return event.Date.Unix(), nil
+		}
+	}
+
+	return 0, nil
+}
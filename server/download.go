@@ -0,0 +1,87 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// countingBody counts and hashes the bytes read from a response body,
+// whoever reads them
+type countingBody struct {
+	io.ReadCloser
+	n    int64
+	hash hash.Hash
+}
+
+func (c *countingBody) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	c.hash.Write(p[:n])
+	return n, err
+}
+
+// sum returns the hex SHA-256 of what was read
+func (c *countingBody) sum() string {
+	return hex.EncodeToString(c.hash.Sum(nil))
+}
+
+// countBody starts counting the body of resp, so the size and checksum can
+// be known after the size limits, match and assertion have read parts of it
+func countBody(resp *http.Response) *countingBody {
+	counter := &countingBody{ReadCloser: resp.Body, hash: sha256.New()}
+	resp.Body = counter
+	return counter
+}
+
+// download reads the rest of the body, returning its total size and the
+// time since start, when the request was made
+func download(resp *http.Response, counter *countingBody, start time.Time) (int64, float64, error) {
+	_, err := io.Copy(io.Discard, resp.Body)
+	took := float64(time.Since(start).Microseconds()) / 1000
+
+	if err != nil {
+		return counter.n, took, fmt.Errorf("Unable to download response body after %d bytes: %v", counter.n, err)
+	}
+
+	return counter.n, took, nil
+}
+
+// sizeDrift compares a downloaded size with baseline, the last size
+// accepted, failing when it differs by more than SizeDriftHTTP percent. The
+// baseline only moves to sizes that are accepted, so an error page keeps
+// failing until the page is back or the baseline is reset to 0.
+func (s *Server) sizeDrift(size int64, baseline *int64) error {
+	if s.SizeDriftHTTP <= 0 || *baseline <= 0 {
+		*baseline = size
+		return nil
+	}
+
+	drift := float64(size-*baseline) / float64(*baseline) * 100
+	if drift > float64(s.SizeDriftHTTP) || drift < -float64(s.SizeDriftHTTP) {
+		return fmt.Errorf("Response body %d bytes, %+.0f%% from %d", size, drift, *baseline)
+	}
+
+	*baseline = size
+	return nil
+}
+
+// checksumDrift compares a body's SHA-256 with baseline, taking the first
+// one seen as the baseline. Clearing the baseline accepts the next page.
+func checksumDrift(sum string, baseline *string) error {
+	if *baseline == "" {
+		*baseline = sum
+		return nil
+	}
+
+	if !strings.EqualFold(sum, *baseline) {
+		return fmt.Errorf("Response body changed, SHA-256 %v", sum)
+	}
+
+	return nil
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// execStates maps Nagios plugin exit statuses to states. Any other status
+// is UNKNOWN, as Nagios treats it.
+var execStates = map[int]State{
+	0: StateOK,
+	1: StateWarn,
+	2: StateCrit,
+	3: StateUnknown,
+}
+
+// CheckExec runs CommandExec through the shell, e.g. a Nagios plugin such as
+// "/usr/lib/nagios/plugins/check_disk -H $VBMS_IP -w 20%". The exit status
+// sets the state and the first line of output, without performance data,
+// is the result. The target is passed in VBMS_HOSTNAME and VBMS_IP.
+func (s *Server) CheckExec(wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	if !s.EnableExec {
+		return
+	}
+
+	logger := s.GetLogger("EXEC", 0)
+	s.setState(checkExec, StateOK)
+
+	if strings.TrimSpace(s.CommandExec) == "" {
+		s.ResultExec = "No command configured"
+		logger.Error(s.ResultExec)
+		s.fail(checkExec, StateCrit, ErrorConfig)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(s.runContext(), s.timeout())
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", s.CommandExec)
+	cmd.Env = append(os.Environ(), "VBMS_HOSTNAME="+s.Hostname, "VBMS_IP="+s.IP)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	// Don't wait on children that outlive the shell and hold its output open
+	cmd.WaitDelay = time.Second
+
+	err := cmd.Run()
+
+	if ctx.Err() == context.DeadlineExceeded {
+		s.ResultExec = fmt.Sprintf("Command timed out after %v", s.timeout())
+		logger.Error(s.ResultExec)
+		s.fail(checkExec, StateCrit, ErrorTimeout)
+		return
+	}
+
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		s.ResultExec = "Unable to run command"
+		logger.WithError(err).Error(s.ResultExec)
+		s.fail(checkExec, StateCrit, ErrorConfig)
+		return
+	}
+
+	status := cmd.ProcessState.ExitCode()
+	state, ok := execStates[status]
+	if !ok {
+		state = StateUnknown
+	}
+
+	// Plugins print "DISK OK - free space: / 3326 MB | /=2643MB;..."
+	output := strings.TrimSpace(stdout.String())
+	if output == "" {
+		output = strings.TrimSpace(stderr.String())
+	}
+	line, _, _ := strings.Cut(output, "\n")
+	line, _, _ = strings.Cut(line, "|")
+	s.ResultExec = strings.TrimSpace(line)
+	if s.ResultExec == "" {
+		s.ResultExec = fmt.Sprintf("Exited with status %d", status)
+	}
+
+	s.fail(checkExec, state, ErrorResponse)
+
+	entry := logger.WithField("ExitStatus", status)
+	switch state {
+	case StateOK:
+		entry.Infof("Exec Check OK. Response: %v", s.ResultExec)
+	case StateWarn:
+		entry.Warn(s.ResultExec)
+	default:
+		entry.Error(s.ResultExec)
+	}
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CheckFTP expects a 220 greeting on port 21 and, when TLSFTP is set,
+// upgrades the control connection with AUTH TLS
+func (s *Server) CheckFTP(wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	if !s.EnableFTP {
+		return
+	}
+
+	logger := s.GetLogger("FTP", 21)
+	s.setState(checkFTP, StateOK)
+
+	conn, err := s.dial(net.JoinHostPort(s.IP, "21"))
+	if err != nil {
+		s.ResultFTP = "Unable to open FTP connection"
+		logger.WithError(err).Error(s.ResultFTP)
+		s.fail(checkFTP, StateCrit, ErrorConnect)
+		return
+	}
+
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(s.timeout()))
+
+	text := textproto.NewConn(conn)
+
+	code, msg, err := text.ReadResponse(220)
+	if code == 0 {
+		s.ResultFTP = "No response received from server"
+		logger.WithError(err).Error(s.ResultFTP)
+		s.fail(checkFTP, StateCrit, ErrorTimeout)
+		return
+	}
+
+	// Keep the first line of a multi-line greeting
+	result := fmt.Sprintf("%d %v", code, strings.SplitN(msg, "\n", 2)[0])
+	s.ResultFTP = result
+
+	if err != nil {
+		logger.Errorf("Returned invalid FTP greeting: '%v'", result)
+		s.fail(checkFTP, StateCrit, ErrorResponse)
+		return
+	}
+
+	if s.TLSFTP {
+		config, err := s.tlsConfig()
+		if err != nil {
+			s.ResultFTP = "Invalid TLS configuration"
+			logger.WithError(err).Error(s.ResultFTP)
+			s.fail(checkFTP, StateCrit, ErrorConfig)
+			return
+		}
+
+		if _, msg, err := command(text, 234, "AUTH TLS"); err != nil {
+			s.ResultFTP = fmt.Sprintf("AUTH TLS refused: %v", msg)
+			logger.WithError(err).Error(s.ResultFTP)
+			s.fail(checkFTP, StateCrit, ErrorTLS)
+			return
+		}
+
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.Handshake(); err != nil {
+			s.ResultFTP = "AUTH TLS handshake failed"
+			logger.WithError(err).Error(s.ResultFTP)
+			s.fail(checkFTP, StateCrit, ErrorTLS)
+			return
+		}
+
+		state := tlsConn.ConnectionState()
+		if len(state.PeerCertificates) > 0 {
+			s.expiries[checkFTP] = state.PeerCertificates[0].NotAfter.Unix()
+		}
+		if certExpiresSoon(state) {
+			s.fail(checkFTP, StateWarn, ErrorTLS)
+		}
+
+		s.ResultFTP = fmt.Sprintf("%v (AUTH TLS, certificate expires %v)", result, certExpiry(state))
+		text = textproto.NewConn(tlsConn)
+	}
+
+	text.Cmd("QUIT")
+
+	logger.Infof("FTP Check OK. Response: %v", s.ResultFTP)
+}
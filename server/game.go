@@ -0,0 +1,296 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// a2sInfo is the Source engine A2S_INFO query
+var a2sInfo = append([]byte{0xff, 0xff, 0xff, 0xff, 'T'}, "Source Engine Query\x00"...)
+
+// A2S reply headers
+const (
+	a2sChallenge = 'A'
+	a2sInfoReply = 'I'
+)
+
+// maxMinecraftStatus caps the status JSON, which can carry a server icon
+const maxMinecraftStatus = 1 << 20
+
+// minecraftFormatting matches the § colour and style codes in a MOTD
+var minecraftFormatting = regexp.MustCompile(`§.`)
+
+// CheckSource sends an A2S_INFO query to a Source engine server on UDP
+// 27015 and records its name, map and player counts
+func (s *Server) CheckSource(wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	if !s.EnableSource {
+		return
+	}
+
+	port := s.PortSource
+	if port == 0 {
+		port = 27015
+	}
+
+	logger := s.GetLogger("SOURCE", port)
+	s.setState(checkSource, StateOK)
+	s.PlayersSource, s.MaxPlayersSource, s.NameSource = 0, 0, ""
+
+	// UDP can't be carried over an SSH tunnel
+	if spec, _ := s.sshJump(); spec != "" {
+		s.ResultSource = "Source query not available through SSH jump host"
+		logger.Error(s.ResultSource)
+		s.fail(checkSource, StateCrit, ErrorConfig)
+		return
+	}
+
+	conn, err := net.DialTimeout("udp", net.JoinHostPort(s.IP, strconv.Itoa(port)), s.timeout())
+	if err != nil {
+		s.ResultSource = "Unable to open UDP socket"
+		logger.WithError(err).Error(s.ResultSource)
+		s.fail(checkSource, StateCrit, ErrorConnect)
+		return
+	}
+
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(s.timeout()))
+
+	reply, err := a2sQuery(conn)
+	if err != nil {
+		s.ResultSource = "No response received from server"
+		logger.WithError(err).Error(s.ResultSource)
+		s.fail(checkSource, StateCrit, ErrorTimeout)
+		return
+	}
+
+	var game string
+	var mapName string
+	s.NameSource, mapName, game, s.PlayersSource, s.MaxPlayersSource, err = parseA2SInfo(reply)
+	if err != nil {
+		s.ResultSource = fmt.Sprintf("Invalid A2S_INFO reply: %v", err)
+		logger.Error(s.ResultSource)
+		s.fail(checkSource, StateCrit, ErrorResponse)
+		return
+	}
+
+	s.ResultSource = fmt.Sprintf("%v (%v) on %v, %d/%d players", s.NameSource, game, mapName, s.PlayersSource, s.MaxPlayersSource)
+	logger.Infof("Source Check OK. Response: %v", s.ResultSource)
+}
+
+// a2sQuery sends A2S_INFO, answering a challenge if the server sends one as
+// servers have since 2020, and returns the info reply after its header
+func a2sQuery(conn net.Conn) ([]byte, error) {
+	query := a2sInfo
+	buf := make([]byte, 1400)
+
+	for attempt := 0; attempt < 2; attempt++ {
+		if _, err := conn.Write(query); err != nil {
+			return nil, err
+		}
+
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+
+		if n < 5 || !bytes.Equal(buf[:4], []byte{0xff, 0xff, 0xff, 0xff}) {
+			return nil, errors.New("not a single-packet A2S reply")
+		}
+
+		switch buf[4] {
+		case a2sInfoReply:
+			return append([]byte(nil), buf[5:n]...), nil
+		case a2sChallenge:
+			if n < 9 {
+				return nil, errors.New("short challenge")
+			}
+			query = append(append([]byte(nil), a2sInfo...), buf[5:9]...)
+		default:
+			return nil, fmt.Errorf("unexpected reply 0x%02x", buf[4])
+		}
+	}
+
+	return nil, errors.New("challenge not accepted")
+}
+
+// parseA2SInfo reads the name, map, game and player counts from an info
+// reply: protocol, name, map, folder, game, app ID, players, max players...
+func parseA2SInfo(reply []byte) (string, string, string, int, int, error) {
+	reader := bufio.NewReader(bytes.NewReader(reply))
+
+	if _, err := reader.ReadByte(); err != nil {
+		return "", "", "", 0, 0, err
+	}
+
+	var fields [4]string
+	for i := range fields {
+		field, err := reader.ReadString(0)
+		if err != nil {
+			return "", "", "", 0, 0, errors.New("truncated")
+		}
+		fields[i] = strings.TrimSuffix(field, "\x00")
+	}
+
+	counts := make([]byte, 4)
+	if _, err := io.ReadFull(reader, counts); err != nil {
+		return "", "", "", 0, 0, errors.New("truncated")
+	}
+
+	return fields[0], fields[1], fields[3], int(counts[2]), int(counts[3]), nil
+}
+
+// CheckMinecraft performs a Server List Ping to a Java edition server on
+// port 25565 and records its MOTD and player counts
+func (s *Server) CheckMinecraft(wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	if !s.EnableMinecraft {
+		return
+	}
+
+	port := s.PortMinecraft
+	if port == 0 {
+		port = 25565
+	}
+
+	logger := s.GetLogger("MINECRAFT", port)
+	s.setState(checkMinecraft, StateOK)
+	s.PlayersMinecraft, s.MaxPlayersMinecraft, s.MOTDMinecraft = 0, 0, ""
+
+	conn, err := s.dial(net.JoinHostPort(s.IP, strconv.Itoa(port)))
+	if err != nil {
+		s.ResultMinecraft = "Unable to open Minecraft connection"
+		logger.WithError(err).Error(s.ResultMinecraft)
+		s.fail(checkMinecraft, StateCrit, ErrorConnect)
+		return
+	}
+
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(s.timeout()))
+
+	host := s.Hostname
+	if host == "" {
+		host = s.IP
+	}
+
+	status, err := minecraftStatus(conn, host, port)
+	if err != nil {
+		s.ResultMinecraft = "No status received from server"
+		logger.WithError(err).Error(s.ResultMinecraft)
+		s.fail(checkMinecraft, StateCrit, ErrorTimeout)
+		return
+	}
+
+	var reply struct {
+		Version struct {
+			Name string `json:"name"`
+		} `json:"version"`
+		Players struct {
+			Max    int `json:"max"`
+			Online int `json:"online"`
+		} `json:"players"`
+		Description json.RawMessage `json:"description"`
+	}
+	if err := json.Unmarshal(status, &reply); err != nil {
+		s.ResultMinecraft = "Invalid status JSON"
+		logger.WithError(err).Error(s.ResultMinecraft)
+		s.fail(checkMinecraft, StateCrit, ErrorResponse)
+		return
+	}
+
+	s.PlayersMinecraft, s.MaxPlayersMinecraft = reply.Players.Online, reply.Players.Max
+	s.MOTDMinecraft = strings.Join(strings.Fields(minecraftFormatting.ReplaceAllString(chatText(reply.Description), "")), " ")
+
+	s.ResultMinecraft = fmt.Sprintf("%v, %d/%d players", reply.Version.Name, s.PlayersMinecraft, s.MaxPlayersMinecraft)
+	if s.MOTDMinecraft != "" {
+		s.ResultMinecraft = s.MOTDMinecraft + " (" + s.ResultMinecraft + ")"
+	}
+	logger.Infof("Minecraft Check OK. Response: %v", s.ResultMinecraft)
+}
+
+// minecraftStatus sends a handshake for the status state and a status
+// request, and returns the JSON the server answers with
+func minecraftStatus(conn net.Conn, host string, port int) ([]byte, error) {
+	var handshake []byte
+	handshake = append(handshake, 0x00)                     // Handshake packet
+	handshake = binary.AppendUvarint(handshake, 0xffffffff) // Protocol -1, any version
+	handshake = binary.AppendUvarint(handshake, uint64(len(host)))
+	handshake = append(handshake, host...)
+	handshake = binary.BigEndian.AppendUint16(handshake, uint16(port))
+	handshake = append(handshake, 0x01) // Next state: status
+
+	packet := binary.AppendUvarint(nil, uint64(len(handshake)))
+	packet = append(packet, handshake...)
+	packet = append(packet, 0x01, 0x00) // Status request
+
+	if _, err := conn.Write(packet); err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+
+	length, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, err
+	}
+	if length > maxMinecraftStatus {
+		return nil, fmt.Errorf("status of %d bytes too large", length)
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return nil, err
+	}
+
+	id, n := binary.Uvarint(body)
+	if n <= 0 || id != 0x00 {
+		return nil, errors.New("unexpected packet")
+	}
+	body = body[n:]
+
+	size, n := binary.Uvarint(body)
+	if n <= 0 || uint64(len(body)-n) < size {
+		return nil, errors.New("truncated status")
+	}
+
+	return body[n : n+int(size)], nil
+}
+
+// chatText flattens a chat component, either a plain string or an object
+// with text and extra components, to its text
+func chatText(raw json.RawMessage) string {
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text
+	}
+
+	var component struct {
+		Text  string            `json:"text"`
+		Extra []json.RawMessage `json:"extra"`
+	}
+	if err := json.Unmarshal(raw, &component); err != nil {
+		return ""
+	}
+
+	text = component.Text
+	for _, extra := range component.Extra {
+		text += chatText(extra)
+	}
+
+	return text
+}
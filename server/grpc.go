@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// CheckGRPC calls grpc.health.v1.Health/Check on port 50051 unless PortGRPC
+// is set. Anything but SERVING is a failure.
+func (s *Server) CheckGRPC(wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	if !s.EnableGRPC {
+		return
+	}
+
+	port := s.PortGRPC
+	if port == 0 {
+		port = 50051
+	}
+
+	logger := s.GetLogger("gRPC", port)
+	s.setState(checkGRPC, StateOK)
+
+	creds := insecure.NewCredentials()
+	if s.TLSGRPC {
+		config, err := s.tlsConfig()
+		if err != nil {
+			s.ResultGRPC = "Invalid TLS configuration"
+			logger.WithError(err).Error(s.ResultGRPC)
+			s.fail(checkGRPC, StateCrit, ErrorConfig)
+			return
+		}
+		creds = credentials.NewTLS(config)
+	}
+
+	addr := net.JoinHostPort(s.IP, strconv.Itoa(port))
+	conn, err := grpc.NewClient(addr,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return s.dial(addr)
+		}),
+		grpc.WithUserAgent(s.userAgent()),
+	)
+	if err != nil {
+		s.ResultGRPC = "Invalid gRPC configuration"
+		logger.WithError(err).Error(s.ResultGRPC)
+		s.fail(checkGRPC, StateCrit, ErrorConfig)
+		return
+	}
+
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(s.runContext(), s.timeout())
+	defer cancel()
+
+	var remote peer.Peer
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: s.ServiceGRPC}, grpc.Peer(&remote))
+	if err != nil {
+		switch status.Code(err) {
+		case codes.Unavailable, codes.DeadlineExceeded:
+			s.ResultGRPC = "Unable to open gRPC connection"
+		case codes.Unimplemented:
+			s.ResultGRPC = "Health service not implemented"
+		case codes.NotFound:
+			s.ResultGRPC = fmt.Sprintf("Unknown service '%v'", s.ServiceGRPC)
+		default:
+			s.ResultGRPC = fmt.Sprintf("Health check failed: %v", status.Convert(err).Message())
+		}
+		logger.WithError(err).Error(s.ResultGRPC)
+		s.fail(checkGRPC, StateCrit, ErrorResponse)
+		return
+	}
+
+	if info, ok := remote.AuthInfo.(credentials.TLSInfo); ok {
+		if len(info.State.PeerCertificates) > 0 {
+			s.expiries[checkGRPC] = info.State.PeerCertificates[0].NotAfter.Unix()
+		}
+		if certExpiresSoon(info.State) {
+			s.fail(checkGRPC, StateWarn, ErrorTLS)
+		}
+	}
+
+	s.ResultGRPC = resp.GetStatus().String()
+
+	if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		logger.Errorf("Returned health status %v", s.ResultGRPC)
+		s.fail(checkGRPC, StateCrit, ErrorResponse)
+		return
+	}
+
+	logger.Infof("gRPC Check OK. Response: %v", s.ResultGRPC)
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"strings"
+	"time"
+
+	"github.com/kisielk/sqlstruct"
+)
+
+// checkState is a check's row in checkstates. State is the soft state the
+// last run produced, HardState the state confirmed by Failures consecutive
+// non-OK runs, ChangedAt when HardState last changed and LastRun when the
+// check last ran. Flapping is set while it changes state too often to
+// notify each change, and FlapFrom is the hard state from before it started.
+type checkState struct {
+	ServerID  int    `sql:"serverid"`
+	Check     string `sql:"checkname"`
+	State     State  `sql:"state"`
+	HardState State  `sql:"hardstate"`
+	Failures  int    `sql:"failures"`
+	ChangedAt int64  `sql:"changedat"`
+	LastRun   int64  `sql:"lastrun"`
+	Flapping  bool   `sql:"flapping"`
+	FlapFrom  State  `sql:"flapfrom"`
+}
+
+// hardAfter returns how many consecutive non-OK runs confirm a state
+func (s *Server) hardAfter() int {
+	if s.HardAfter > 0 {
+		return s.HardAfter
+	}
+
+	return 1
+}
+
+// confirmStates counts consecutive non-OK runs of each check. The hard state
+// only follows a failure once HardAfter runs in a row have failed, while a
+// recovery is hard straight away.
+func (s *Server) confirmStates() {
+	logger := s.GetLogger("STATE", 0)
+
+	// Without the previous run to go on, take this run's states as they are
+	for i, st := range s.states() {
+		s.hardStates[i] = *st
+	}
+
+	// schedule loaded them before the checks ran
+	prev := s.previous
+	if prev == nil {
+		return
+	}
+
+	threshold := s.hardAfter()
+	now := time.Now().Unix()
+
+	for i, st := range s.states() {
+		if *st == "" {
+			continue
+		}
+
+		name := checkNames[i]
+		cs := prev[name]
+
+		// A check that didn't run this time stays as it was
+		if s.skipped[i] {
+			s.hardStates[i] = cs.HardState
+			continue
+		}
+		hard, failures := cs.HardState, 0
+		if *st != StateOK {
+			failures = cs.Failures + 1
+		}
+
+		switch {
+		case *st == StateOK || failures >= threshold:
+			hard = *st
+		case hard == "":
+			hard = StateOK
+		}
+
+		flapping := s.flapping(name, *st, cs.Flapping)
+		s.transition(i, cs, hard, flapping)
+
+		from := cs.FlapFrom
+		switch {
+		case flapping && !cs.Flapping:
+			from = cs.HardState
+		case !flapping:
+			from = ""
+		}
+
+		if hard != cs.HardState {
+			if cs.HardState != "" {
+				s.GetLogger(strings.ToUpper(name), 0).Warnf("Hard state %v after %d failed runs, was %v", hard, failures, cs.HardState)
+			}
+			cs.ChangedAt = now
+		}
+		s.hardStates[i] = hard
+
+		_, err := s.DB.Exec(`
+			INSERT OR REPLACE INTO checkstates (serverid, checkname, state, hardstate, failures, changedat, lastrun, flapping, flapfrom)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, s.ID, name, *st, hard, failures, cs.ChangedAt, now, flapping, from)
+		if err != nil {
+			logger.WithError(err).Error("Unable to record check state")
+			return
+		}
+	}
+}
+
+// loadCheckStates returns the server's check states from previous runs
+func (s *Server) loadCheckStates() (map[string]checkState, error) {
+	rows, err := s.DB.Query("SELECT * FROM checkstates WHERE serverid = ?", s.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	states := make(map[string]checkState)
+	for rows.Next() {
+		var cs checkState
+		if err := sqlstruct.Scan(&cs, rows); err != nil {
+			return nil, err
+		}
+		states[cs.Check] = cs
+	}
+
+	return states, rows.Err()
+}
+
+// hardState returns the confirmed state of a check by its database name
+func (s *Server) hardState(name string) State {
+	for i, check := range checkNames {
+		if check == name {
+			return s.hardStates[i]
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/blinktag/vbms/secrets"
+)
+
+// defaultHeartbeatGrace is how long a host may go without a heartbeat when
+// heartbeatgrace isn't set, enough for an hourly cron job
+const defaultHeartbeatGrace = time.Hour
+
+// heartbeatPath prefixes the token in heartbeat URLs
+const heartbeatPath = "/heartbeat/"
+
+// CheckHeartbeat fails when the host hasn't pushed a heartbeat within its grace
+// period. Nothing is probed, so it suits cron jobs and backups, which can't
+// be reached from outside.
+func (s *Server) CheckHeartbeat(wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	if !s.EnableHeartbeat {
+		return
+	}
+
+	logger := s.GetLogger("HEARTBEAT", 0)
+	s.setState(checkHeartbeat, StateOK)
+
+	if s.TokenHeartbeat == "" {
+		s.ResultHeartbeat = "No heartbeat token configured"
+		logger.Error(s.ResultHeartbeat)
+		s.fail(checkHeartbeat, StateCrit, ErrorConfig)
+		return
+	}
+
+	// Heartbeats arrive while checks run, so don't trust the loaded row
+	if err := s.DB.QueryRow("SELECT heartbeatat FROM servers WHERE id = ?", s.ID).Scan(&s.HeartbeatAt); err != nil {
+		s.ResultHeartbeat = "Unable to load last heartbeat"
+		logger.WithError(err).Error(s.ResultHeartbeat)
+		s.fail(checkHeartbeat, StateCrit, ErrorResponse)
+		return
+	}
+
+	if s.HeartbeatAt == 0 {
+		s.ResultHeartbeat = "No heartbeat received"
+		logger.Error(s.ResultHeartbeat)
+		s.fail(checkHeartbeat, StateCrit, ErrorResponse)
+		return
+	}
+
+	grace := defaultHeartbeatGrace
+	if s.GraceHeartbeat > 0 {
+		grace = time.Duration(s.GraceHeartbeat) * time.Second
+	}
+
+	age := time.Since(time.Unix(s.HeartbeatAt, 0)).Round(time.Second)
+	s.ResultHeartbeat = fmt.Sprintf("Last heartbeat %v ago", age)
+
+	if age > grace {
+		s.ResultHeartbeat += fmt.Sprintf(", expected within %v", grace)
+		logger.Error(s.ResultHeartbeat)
+		s.fail(checkHeartbeat, StateCrit, ErrorResponse)
+		return
+	}
+
+	logger.Infof("Heartbeat Check OK. %v", s.ResultHeartbeat)
+}
+
+// HeartbeatHandler records heartbeats sent to /heartbeat/<token> with POST,
+// or GET for clients that can't, e.g. from the end of a backup script:
+//
+//	curl -fsS -X POST https://vbms.example.com/heartbeat/3f9c2a...
+func HeartbeatHandler(db *sql.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodGet {
+			w.Header().Set("Allow", "POST, GET")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		token := strings.TrimPrefix(r.URL.Path, heartbeatPath)
+		if token == "" || token == r.URL.Path || strings.Contains(token, "/") {
+			http.NotFound(w, r)
+			return
+		}
+
+		id, err := heartbeatServer(db, token)
+		if err == sql.ErrNoRows {
+			http.NotFound(w, r)
+			return
+		}
+
+		if err == nil {
+			_, err = db.Exec("UPDATE servers SET heartbeatat = ? WHERE id = ?", time.Now().Unix(), id)
+		}
+		if err != nil {
+			logrus.WithError(err).Error("Unable to record heartbeat")
+			http.Error(w, "Unable to record heartbeat", http.StatusInternalServerError)
+			return
+		}
+
+		w.Write([]byte("OK\n"))
+	})
+}
+
+// heartbeatServer returns the ID of the server with heartbeats enabled whose
+// token is token. Tokens may be encrypted, so can't be matched in SQL.
+func heartbeatServer(db *sql.DB, token string) (int, error) {
+	rows, err := db.Query("SELECT id, heartbeattoken FROM servers WHERE enableheartbeat = 1 AND heartbeattoken != ''")
+	if err != nil {
+		return 0, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var stored string
+		if err := rows.Scan(&id, &stored); err != nil {
+			return 0, err
+		}
+
+		plain, err := secrets.Decrypt(stored)
+		if err != nil {
+			logrus.WithError(err).WithField("ServerID", id).Error("Unable to decrypt heartbeat token")
+			continue
+		}
+
+		if subtle.ConstantTimeCompare([]byte(plain), []byte(token)) == 1 {
+			return id, nil
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	return 0, sql.ErrNoRows
+}
@@ -0,0 +1,29 @@
+package server
+
+import (
+	"time"
+)
+
+// recordHistory appends the outcome of every check run this time to the
+// history table, flagged if they ran during maintenance. Disabled checks
+// have no state and, like those that weren't due, aren't recorded.
+func (s *Server) recordHistory() {
+	logger := s.GetLogger("HISTORY", 0)
+	now := time.Now()
+	maintenance := s.inMaintenance(now)
+
+	for i, st := range s.states() {
+		if *st == "" || s.skipped[i] {
+			continue
+		}
+
+		_, err := s.DB.Exec(`
+			INSERT INTO check_history (serverid, checkname, state, result, duration, checkedat, maintenance)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+		`, s.ID, checkNames[i], *st, *s.results()[i], s.durations[i].Milliseconds(), now.Unix(), maintenance)
+		if err != nil {
+			logger.WithError(err).Error("Unable to record check history")
+			return
+		}
+	}
+}
@@ -0,0 +1,330 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// dialError marks a failure to connect, as opposed to a failure to get a response
+type dialError struct {
+	err error
+}
+
+func (e *dialError) Error() string {
+	return e.err.Error()
+}
+
+func (e *dialError) Unwrap() error {
+	return e.err
+}
+
+// authError marks a failure to obtain credentials for a request
+type authError struct {
+	err error
+}
+
+func (e *authError) Error() string {
+	return e.err.Error()
+}
+
+// httpError turns a request error into a check result, classed by what went
+// wrong. A certificate that fails verification, or a handshake the server
+// rejects, e.g. for want of a client certificate, is reported apart from a
+// port that won't open.
+func httpError(err error) error {
+	var verify *tls.CertificateVerificationError
+	var op *net.OpError
+	var dial *dialError
+	var auth *authError
+
+	switch {
+	case errors.As(err, &verify):
+		return failure(ErrorTLS, "Certificate verification failed: %v", verify.Err)
+	case errors.As(err, &op) && op.Op == "remote error":
+		// crypto/tls reports alerts the server sent this way
+		return failure(ErrorTLS, "TLS handshake rejected by server: %v", op.Err)
+	case errors.As(err, &dial):
+		return failure(ErrorConnect, "Unable to open port")
+	case errors.As(err, &auth):
+		return failure(ErrorAuth, "Unable to authenticate")
+	default:
+		return failure(ErrorTimeout, "No response received from server")
+	}
+}
+
+// httpClient returns a client whose connections are opened with s.dial and
+// s.dialTLS, so HTTP checks honour jump hosts and TLS settings. Up to
+// Redirects redirects are followed, after which the redirect itself is
+// returned, and connections are not reused between checks.
+func (s *Server) httpClient(config *tls.Config) *http.Client {
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network string, addr string) (net.Conn, error) {
+			conn, err := s.dialTimed(ctx, addr)
+			if err != nil {
+				return nil, &dialError{err}
+			}
+			return conn, nil
+		},
+		DialTLSContext: func(ctx context.Context, network string, addr string) (net.Conn, error) {
+			conn, err := s.dialTimed(ctx, addr)
+			if err != nil {
+				return nil, &dialError{err}
+			}
+
+			start := time.Now()
+			tlsConn, err := s.handshakeTLS(conn, s.redirectTLS(config, addr))
+			if timing := requestTiming(ctx); timing != nil {
+				timing.TLS = time.Since(start)
+			}
+			if err != nil {
+				return nil, &dialError{err}
+			}
+			return tlsConn, nil
+		},
+		DisableKeepAlives: true,
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   s.timeout(),
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > s.Redirects {
+				return http.ErrUseLastResponse
+			}
+			return nil
+		},
+	}
+}
+
+// redirectTLS returns the TLS settings for a connection to addr. A plain HTTP
+// check redirected to HTTPS has none of its own, and a redirect to another
+// host is verified against that host unless tlsservername pins the name.
+func (s *Server) redirectTLS(config *tls.Config, addr string) *tls.Config {
+	if config == nil {
+		var err error
+		if config, err = s.tlsConfig(); err != nil {
+			config = &tls.Config{ServerName: s.Hostname}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil || s.TLSName != "" || host == s.Hostname || host == s.IP {
+		return config
+	}
+
+	config = config.Clone()
+	config.ServerName = host
+	return config
+}
+
+// redirectChain describes the redirects followed to reach resp, e.g.
+// "301 http://example.com/, 302 https://example.com/"
+func redirectChain(resp *http.Response) string {
+	var hops []string
+
+	for req := resp.Request; req != nil && req.Response != nil; req = req.Response.Request {
+		hop := fmt.Sprintf("%d %v", req.Response.StatusCode, req.Response.Request.URL)
+		hops = append([]string{hop}, hops...)
+	}
+
+	return strings.Join(hops, ", ")
+}
+
+// httpGet requests url, authenticating as configured for the server
+func (s *Server) httpGet(url string, config *tls.Config) (*http.Response, error) {
+	return s.httpGetTimed(url, config, nil)
+}
+
+// httpGetTimed is httpGet recording the request's phases in timing, if set
+func (s *Server) httpGetTimed(url string, config *tls.Config, timing *httpTiming) (*http.Response, error) {
+	client := s.httpClient(config)
+
+	ctx := s.runContext()
+	if timing != nil {
+		ctx = timing.trace(ctx)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	s.setHeaders(req)
+
+	if err := s.authorize(req); err != nil {
+		return nil, &authError{err}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Digest auth needs the server's challenge before it can answer
+	if s.AuthHTTP == AuthDigest && resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		if !strings.HasPrefix(strings.ToLower(challenge), "digest ") {
+			return resp, nil
+		}
+		resp.Body.Close()
+
+		retry, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return nil, err
+		}
+		s.setHeaders(retry)
+
+		authorization, err := digestAuthorization(challenge, retry.Method, retry.URL.RequestURI(), s.User, s.Password)
+		if err != nil {
+			return nil, &authError{err}
+		}
+		retry.Header.Set("Authorization", authorization)
+
+		return client.Do(retry)
+	}
+
+	return resp, nil
+}
+
+// setHeaders sets the Host and User-Agent headers. Requests made to the
+// server's IP are sent with its hostname as Host, so virtual hosts answer, and
+// HostHTTP overrides both.
+func (s *Server) setHeaders(req *http.Request) {
+	switch {
+	case s.HostHTTP != "":
+		req.Host = s.HostHTTP
+	case req.URL.Hostname() == s.IP && s.Hostname != "":
+		req.Host = s.Hostname
+	}
+
+	if agent := s.userAgent(); agent != "" {
+		req.Header.Set("User-Agent", agent)
+	}
+}
+
+// userAgent returns the User-Agent checks identify themselves with
+func (s *Server) userAgent() string {
+	if s.UserAgent == "" {
+		return Default.UserAgent
+	}
+
+	return s.UserAgent
+}
+
+// httpPath returns the path HTTP checks request, "/" unless configured
+func (s *Server) httpPath() string {
+	if s.PathHTTP == "" {
+		return "/"
+	}
+
+	if !strings.HasPrefix(s.PathHTTP, "/") {
+		return "/" + s.PathHTTP
+	}
+
+	return s.PathHTTP
+}
+
+// missingHeaders returns the required response headers that are absent or
+// don't have the expected value. HeadersHTTP is a comma separated list such
+// as "Strict-Transport-Security,X-Frame-Options=DENY,Content-Security-Policy".
+func (s *Server) missingHeaders(header http.Header) []string {
+	var missing []string
+
+	for _, required := range strings.Split(s.HeadersHTTP, ",") {
+		required = strings.TrimSpace(required)
+		if required == "" {
+			continue
+		}
+
+		name, want := required, ""
+		if i := strings.Index(required, "="); i >= 0 {
+			name, want = strings.TrimSpace(required[:i]), strings.TrimSpace(required[i+1:])
+		}
+
+		got := header.Get(name)
+		if got == "" || (want != "" && !strings.EqualFold(got, want)) {
+			missing = append(missing, name)
+		}
+	}
+
+	return missing
+}
+
+// checkSize enforces the configured Content-Length and body size limits. The
+// body is read only as far as needed, and what was read is put back so
+// assertions still see the whole response.
+func (s *Server) checkSize(resp *http.Response) error {
+	if s.LengthHTTP > 0 && resp.ContentLength != s.LengthHTTP {
+		if resp.ContentLength < 0 {
+			return fmt.Errorf("No Content-Length, expected %d", s.LengthHTTP)
+		}
+		return fmt.Errorf("Content-Length %d, expected %d", resp.ContentLength, s.LengthHTTP)
+	}
+
+	if s.MinBodyHTTP <= 0 && s.MaxBodyHTTP <= 0 {
+		return nil
+	}
+
+	// One byte past the maximum is enough to know it was exceeded
+	limit := s.MinBodyHTTP
+	if s.MaxBodyHTTP > 0 {
+		limit = s.MaxBodyHTTP + 1
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+	if err != nil {
+		return fmt.Errorf("Unable to read response body: %v", err)
+	}
+
+	size := int64(len(body))
+
+	if size < s.MinBodyHTTP {
+		return fmt.Errorf("Response body %d bytes, expected at least %d", size, s.MinBodyHTTP)
+	}
+
+	if s.MaxBodyHTTP > 0 && size > s.MaxBodyHTTP {
+		return fmt.Errorf("Response body larger than %d bytes", s.MaxBodyHTTP)
+	}
+
+	return nil
+}
+
+// checkMatch verifies the response body matches MatchHTTP, so an error page
+// served with a 200 doesn't pass. The body is put back for assertions.
+func (s *Server) checkMatch(resp *http.Response) error {
+	if s.MatchHTTP == "" {
+		return nil
+	}
+
+	re, err := regexp.Compile(s.MatchHTTP)
+	if err != nil {
+		return fmt.Errorf("Invalid body pattern '%v'", s.MatchHTTP)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxAssertBody))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+	if err != nil {
+		return fmt.Errorf("Unable to read response body: %v", err)
+	}
+
+	if !re.Match(body) {
+		return fmt.Errorf("Body does not match '%v'", s.MatchHTTP)
+	}
+
+	return nil
+}
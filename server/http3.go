@@ -0,0 +1,95 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// CheckHTTP3 requests the page over QUIC on UDP 443 and expects an HTTP/3
+// response, so a CDN advertising h3 is caught when only TCP still works
+func (s *Server) CheckHTTP3(wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	if !s.EnableHTTP3 {
+		return
+	}
+
+	logger := s.GetLogger("HTTP3", 443)
+	s.setState(checkHTTP3, StateOK)
+
+	// UDP can't be carried over an SSH tunnel
+	if spec, _ := s.sshJump(); spec != "" {
+		s.ResultHTTP3 = "HTTP/3 not available through SSH jump host"
+		logger.Error(s.ResultHTTP3)
+		s.fail(checkHTTP3, StateCrit, ErrorConfig)
+		return
+	}
+
+	config, err := s.tlsConfig()
+	if err != nil {
+		s.ResultHTTP3 = "Invalid TLS configuration"
+		logger.WithError(err).Error(s.ResultHTTP3)
+		s.fail(checkHTTP3, StateCrit, ErrorConfig)
+		return
+	}
+
+	host := s.Hostname
+	if s.pinned || host == "" {
+		host = s.IP
+	}
+
+	transport := &http3.Transport{
+		TLSClientConfig: config,
+		QUICConfig:      &quic.Config{HandshakeIdleTimeout: s.timeout()},
+	}
+	defer transport.Close()
+
+	client := &http.Client{Transport: transport, Timeout: s.timeout()}
+
+	req, err := http.NewRequest("GET", "https://"+net.JoinHostPort(host, "443")+s.httpPath(), nil)
+	if err != nil {
+		s.ResultHTTP3 = "Invalid request"
+		logger.WithError(err).Error(s.ResultHTTP3)
+		s.fail(checkHTTP3, StateCrit, ErrorConfig)
+		return
+	}
+	s.setHeaders(req)
+
+	if err := s.authorize(req); err != nil {
+		s.ResultHTTP3 = "Unable to authenticate"
+		logger.WithError(err).Error(s.ResultHTTP3)
+		s.fail(checkHTTP3, StateCrit, ErrorAuth)
+		return
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		s.ResultHTTP3 = "No HTTP/3 response received from server"
+		logger.WithError(err).Error(s.ResultHTTP3)
+		s.fail(checkHTTP3, StateCrit, ErrorTimeout)
+		return
+	}
+
+	defer resp.Body.Close()
+
+	if resp.TLS != nil && len(resp.TLS.PeerCertificates) > 0 {
+		s.expiries[checkHTTP3] = resp.TLS.PeerCertificates[0].NotAfter.Unix()
+	}
+
+	// Expect response of "HTTP/3.0 200 OK"
+	s.ResultHTTP3 = fmt.Sprintf("%v %v", resp.Proto, resp.Status)
+
+	if resp.ProtoMajor != 3 || !isValidHTTPResponse(s.ResultHTTP3, s.expectHTTP()) {
+		logger.Errorf("Returned invalid HTTP/3 response: '%v'", s.ResultHTTP3)
+		s.fail(checkHTTP3, StateCrit, ErrorResponse)
+		return
+	}
+
+	logger.Infof("HTTP/3 Check OK. Response: %v", s.ResultHTTP3)
+}
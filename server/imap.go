@@ -0,0 +1,140 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CheckIMAP reads the IMAP greeting on 143, or 993 with implicit TLS, and
+// logs in when credentials are configured
+func (s *Server) CheckIMAP(wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	if !s.EnableIMAP {
+		return
+	}
+
+	port := s.PortIMAP
+	if port == 0 {
+		port = 143
+		if s.TLSIMAP {
+			port = 993
+		}
+	}
+
+	logger := s.GetLogger("IMAP", port)
+	s.setState(checkIMAP, StateOK)
+
+	addr := net.JoinHostPort(s.IP, strconv.Itoa(port))
+
+	var conn net.Conn
+	if s.TLSIMAP {
+		config, err := s.tlsConfig()
+		if err != nil {
+			s.ResultIMAP = "Invalid TLS configuration"
+			logger.WithError(err).Error(s.ResultIMAP)
+			s.fail(checkIMAP, StateCrit, ErrorConfig)
+			return
+		}
+
+		tlsConn, err := s.dialTLS(addr, config)
+		if err != nil {
+			s.ResultIMAP = "Unable to open IMAPS connection"
+			logger.WithError(err).Error(s.ResultIMAP)
+			s.fail(checkIMAP, StateCrit, ErrorConnect)
+			return
+		}
+
+		if len(tlsConn.ConnectionState().PeerCertificates) > 0 {
+			s.expiries[checkIMAP] = tlsConn.ConnectionState().PeerCertificates[0].NotAfter.Unix()
+		}
+		conn = tlsConn
+	} else {
+		plain, err := s.dial(addr)
+		if err != nil {
+			s.ResultIMAP = "Unable to open IMAP connection"
+			logger.WithError(err).Error(s.ResultIMAP)
+			s.fail(checkIMAP, StateCrit, ErrorConnect)
+			return
+		}
+
+		conn = plain
+	}
+
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(s.timeout()))
+
+	reader := bufio.NewReader(conn)
+
+	// Expect "* OK [CAPABILITY ...] ready", or PREAUTH
+	result, err := reader.ReadString('\n')
+	if err != nil {
+		s.ResultIMAP = "No response received from server"
+		logger.Error(s.ResultIMAP)
+		s.fail(checkIMAP, StateCrit, ErrorTimeout)
+		return
+	}
+	result = strings.TrimSpace(result)
+	s.ResultIMAP = result
+
+	if !strings.HasPrefix(result, "* OK") && !strings.HasPrefix(result, "* PREAUTH") {
+		logger.Errorf("Returned invalid IMAP greeting: '%v'", result)
+		s.fail(checkIMAP, StateCrit, ErrorResponse)
+		return
+	}
+
+	if s.UserIMAP != "" {
+		login := fmt.Sprintf("LOGIN %v %v", imapQuote(s.UserIMAP), imapQuote(s.PasswordIMAP))
+		if reply, err := imapCommand(conn, reader, "a1", login); err != nil {
+			s.ResultIMAP = fmt.Sprintf("Login failed: %v", reply)
+			logger.WithError(err).Error(s.ResultIMAP)
+			s.fail(checkIMAP, StateCrit, ErrorAuth)
+			return
+		}
+
+		s.ResultIMAP = fmt.Sprintf("%v (logged in as %v)", result, s.UserIMAP)
+	}
+
+	imapCommand(conn, reader, "a2", "LOGOUT")
+
+	logger.Infof("IMAP Check OK. Response: %v", s.ResultIMAP)
+}
+
+// imapCommand sends a tagged command and returns the tagged reply, failing
+// unless it is OK. Untagged responses in between are skipped.
+func imapCommand(conn net.Conn, reader *bufio.Reader, tag string, command string) (string, error) {
+	if _, err := fmt.Fprintf(conn, "%v %v\r\n", tag, command); err != nil {
+		return "", err
+	}
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, tag+" ") {
+			continue
+		}
+
+		reply := strings.TrimPrefix(line, tag+" ")
+		if !strings.HasPrefix(reply, "OK") {
+			return reply, fmt.Errorf("%v", reply)
+		}
+
+		return reply, nil
+	}
+}
+
+// imapQuote returns value as an IMAP quoted string
+func imapQuote(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+}
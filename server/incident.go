@@ -0,0 +1,114 @@
+package server
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/kisielk/sqlstruct"
+)
+
+// Incident is a period during which at least one of a server's checks was
+// hard CRIT. Checks lists every check that failed during it. An open
+// incident has no ResolvedAt.
+type Incident struct {
+	ID          int    `sql:"id" json:"id"`
+	ServerID    int    `sql:"serverid" json:"-"`
+	Hostname    string `sql:"hostname" json:"hostname"`
+	Checks      string `sql:"checks" json:"checks"`
+	Result      string `sql:"result" json:"result"`
+	StartedAt   int64  `sql:"startedat" json:"startedat"`
+	ResolvedAt  int64  `sql:"resolvedat" json:"resolvedat,omitempty"`
+	Maintenance bool   `sql:"maintenance" json:"maintenance"`
+}
+
+// Duration is how long the incident lasted, or has lasted so far
+func (i Incident) Duration(now time.Time) time.Duration {
+	end := now.Unix()
+	if i.ResolvedAt > 0 {
+		end = i.ResolvedAt
+	}
+
+	return time.Duration(end-i.StartedAt) * time.Second
+}
+
+// trackIncident opens an incident when a check goes hard CRIT, adds checks
+// failing while it is open and resolves it once none is CRIT
+func (s *Server) trackIncident() {
+	logger := s.GetLogger("INCIDENT", 0)
+	now := time.Now()
+
+	var failing []string
+	var result string
+	for i, st := range s.hardStates {
+		if st == StateCrit {
+			failing = append(failing, checkNames[i])
+			if result == "" {
+				result = *s.results()[i]
+			}
+		}
+	}
+
+	var open Incident
+	err := s.DB.QueryRow("SELECT id, checks, startedat FROM incidents WHERE serverid = ? AND resolvedat = 0", s.ID).Scan(&open.ID, &open.Checks, &open.StartedAt)
+	if err != nil && err != sql.ErrNoRows {
+		logger.WithError(err).Error("Unable to load open incident")
+		return
+	}
+
+	switch {
+	case open.ID == 0 && len(failing) > 0:
+		_, err = s.DB.Exec(`
+			INSERT INTO incidents (serverid, hostname, checks, result, startedat, maintenance)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`, s.ID, s.Hostname, strings.Join(failing, ","), result, now.Unix(), s.inMaintenance(now))
+		logger.Warnf("Incident opened: %v", strings.Join(failing, ", "))
+	case open.ID > 0 && len(failing) == 0:
+		_, err = s.DB.Exec("UPDATE incidents SET resolvedat = ? WHERE id = ?", now.Unix(), open.ID)
+		logger.Infof("Incident resolved after %v", open.Duration(now).Round(time.Second))
+	case open.ID > 0:
+		checks := strings.Split(open.Checks, ",")
+		seen := map[string]bool{}
+		for _, name := range checks {
+			seen[name] = true
+		}
+		for _, name := range failing {
+			if !seen[name] {
+				checks = append(checks, name)
+			}
+		}
+		if len(checks) > len(seen) {
+			_, err = s.DB.Exec("UPDATE incidents SET checks = ? WHERE id = ?", strings.Join(checks, ","), open.ID)
+		}
+	}
+
+	if err != nil {
+		logger.WithError(err).Error("Unable to record incident")
+	}
+}
+
+// LoadIncidents returns the incidents overlapping from to to, for one server
+// when hostname is set, oldest first
+func LoadIncidents(db *sql.DB, from time.Time, to time.Time, hostname string) ([]Incident, error) {
+	rows, err := db.Query(`
+		SELECT * FROM incidents
+		WHERE startedat < ? AND (resolvedat = 0 OR resolvedat >= ?) AND (? = '' OR hostname = ?)
+		ORDER BY startedat
+	`, to.Unix(), from.Unix(), hostname, hostname)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	incidents := []Incident{}
+	for rows.Next() {
+		var i Incident
+		if err := sqlstruct.Scan(&i, rows); err != nil {
+			return nil, err
+		}
+		incidents = append(incidents, i)
+	}
+
+	return incidents, rows.Err()
+}
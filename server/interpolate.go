@@ -0,0 +1,132 @@
+package server
+
+import (
+	"os"
+	"regexp"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/blinktag/vbms/secrets"
+)
+
+// envReference matches ${VAR} style references. Bare $ signs are left alone
+// so regular expressions stored alongside settings keep their anchors.
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Interpolate replaces ${VAR} references in value with environment variables,
+// allowing secrets to be injected at runtime instead of stored in plaintext
+func Interpolate(value string) string {
+	return envReference.ReplaceAllStringFunc(value, func(ref string) string {
+		name := envReference.FindStringSubmatch(ref)[1]
+
+		val, ok := os.LookupEnv(name)
+		if !ok {
+			logrus.Warnf("Environment variable %v referenced in configuration is not set", name)
+		}
+
+		return val
+	})
+}
+
+// SensitiveColumns hold credentials, which are encrypted at rest when a
+// database key is configured
+var SensitiveColumns = []string{
+	"proxy",
+	"httppassword",
+	"httptoken",
+	"oauthclientsecret",
+	"imappassword",
+	"pop3password",
+	"mysqlpassword",
+	"pgpassword",
+	"ldappassword",
+	"snmpcommunity",
+	"amqppassword",
+	"radiussecret",
+	"radiuspassword",
+	"k8stoken",
+	"httpheaders",
+	"pluginconfig",
+	"heartbeattoken",
+}
+
+// setting is a connection setting and the checks that need it, every check
+// when none are listed
+type setting struct {
+	value  *string
+	checks []int
+}
+
+// settings returns the connection settings which may be encrypted or contain
+// environment or secret store references
+func (s *Server) settings() []setting {
+	return []setting{
+		{value: &s.Hostname},
+		{value: &s.IP},
+		{value: &s.TLSCAFile},
+		{value: &s.TLSName},
+		{value: &s.TLSCert},
+		{value: &s.TLSKey},
+		{value: &s.SSHJump},
+		{value: &s.SSHJumpKey},
+		{value: &s.Proxy},
+		{value: &s.User},
+		{value: &s.Password},
+		{value: &s.Token},
+		{value: &s.OAuthURL},
+		{value: &s.OAuthID},
+		{value: &s.OAuthSecret},
+		{value: &s.HeadersHTTP, checks: []int{checkHTTP, checkHTTPS}},
+		{value: &s.PluginConfig, checks: []int{checkPlugins}},
+		{value: &s.UserIMAP, checks: []int{checkIMAP}},
+		{value: &s.PasswordIMAP, checks: []int{checkIMAP}},
+		{value: &s.UserPOP3, checks: []int{checkPOP3, checkPOP3S}},
+		{value: &s.PassPOP3, checks: []int{checkPOP3, checkPOP3S}},
+		{value: &s.UserMySQL, checks: []int{checkMySQL}},
+		{value: &s.PasswordMySQL, checks: []int{checkMySQL}},
+		{value: &s.UserPostgres, checks: []int{checkPostgres}},
+		{value: &s.PasswordPostgres, checks: []int{checkPostgres}},
+		{value: &s.BindLDAP, checks: []int{checkLDAP}},
+		{value: &s.PasswordLDAP, checks: []int{checkLDAP}},
+		{value: &s.CommunitySNMP, checks: []int{checkSNMP}},
+		{value: &s.CommandExec, checks: []int{checkExec}},
+		{value: &s.UserAMQP, checks: []int{checkAMQP}},
+		{value: &s.PasswordAMQP, checks: []int{checkAMQP}},
+		{value: &s.SecretRADIUS, checks: []int{checkRADIUS}},
+		{value: &s.UserRADIUS, checks: []int{checkRADIUS}},
+		{value: &s.PasswordRADIUS, checks: []int{checkRADIUS}},
+		{value: &s.URLDocker, checks: []int{checkDocker}},
+		{value: &s.TokenKubernetes, checks: []int{checkKubernetes}},
+	}
+}
+
+// resolveSettings decrypts the connection settings, expands environment
+// references and then fetches any secret store references. A setting that
+// can't be resolved is left blank and the checks needing it are noted in
+// unresolved, so they aren't run without it.
+func (s *Server) resolveSettings() {
+	for _, setting := range s.settings() {
+		value, err := secrets.Decrypt(*setting.value)
+		if err == nil {
+			value, err = secrets.Resolve(Interpolate(value))
+		}
+		if err != nil {
+			logrus.WithError(err).WithField("Server", s.Hostname).Errorf("Unable to resolve secret %v", *setting.value)
+			s.unresolve(err, setting.checks...)
+		}
+		*setting.value = value
+	}
+}
+
+// unresolve notes err against checks, or every check when none are given
+func (s *Server) unresolve(err error, checks ...int) {
+	if len(checks) == 0 {
+		for i := range s.unresolved {
+			s.unresolved[i] = err
+		}
+		return
+	}
+
+	for _, i := range checks {
+		s.unresolved[i] = err
+	}
+}
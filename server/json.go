@@ -0,0 +1,311 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// jsonAssertion compares the value at a JSONPath with a JSON literal, as in
+// `$.checks[0].status == "ok"`. Without an operator the path only has to exist.
+type jsonAssertion struct {
+	path string
+	op   string
+	want interface{}
+}
+
+// jsonOperators are tried longest first, so "<=" isn't read as "<"
+var jsonOperators = []string{"==", "!=", "<=", ">=", "<", ">"}
+
+// CheckJSON fetches URLJSON, by default the server's HTTPS path, and
+// evaluates AssertJSON against the JSON body. The values each path selected
+// are kept in ValuesJSON as a JSON object keyed by path.
+func (s *Server) CheckJSON(wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	if !s.EnableJSON {
+		return
+	}
+
+	logger := s.GetLogger("JSON", 0)
+	s.setState(checkJSON, StateOK)
+	s.ValuesJSON = ""
+
+	assertions, err := parseJSONAssertions(s.AssertJSON)
+	if err != nil {
+		s.ResultJSON = err.Error()
+		logger.Error(s.ResultJSON)
+		s.fail(checkJSON, StateCrit, ErrorConfig)
+		return
+	}
+
+	config, err := s.tlsConfig()
+	if err != nil {
+		s.ResultJSON = "Invalid TLS configuration"
+		logger.WithError(err).Error(s.ResultJSON)
+		s.fail(checkJSON, StateCrit, ErrorConfig)
+		return
+	}
+
+	url := s.URLJSON
+	if url == "" {
+		host := s.Hostname
+		if s.pinned || host == "" {
+			host = s.IP
+		}
+		url = "https://" + net.JoinHostPort(host, "443") + s.httpPath()
+	}
+
+	resp, err := s.httpGet(url, config)
+	if err != nil {
+		failed := httpError(err)
+		s.ResultJSON = failed.Error()
+		logger.WithError(err).Error(s.ResultJSON)
+		s.fail(checkJSON, StateCrit, classOf(failed))
+		return
+	}
+
+	defer resp.Body.Close()
+
+	status := resp.Proto + " " + resp.Status
+	if !isValidHTTPResponse(status, s.expectHTTP()) {
+		s.ResultJSON = status
+		logger.Errorf("Returned invalid JSON API response: '%v'", status)
+		s.fail(checkJSON, StateCrit, ErrorResponse)
+		return
+	}
+
+	var doc interface{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxAssertBody)).Decode(&doc); err != nil {
+		s.ResultJSON = "Response body is not JSON"
+		logger.WithError(err).Error(s.ResultJSON)
+		s.fail(checkJSON, StateCrit, ErrorResponse)
+		return
+	}
+
+	values := map[string]interface{}{}
+	var failures []string
+
+	for _, a := range assertions {
+		got, found, err := jsonLookup(doc, a.path)
+		if err != nil {
+			failures = append(failures, err.Error())
+			continue
+		}
+		if !found {
+			failures = append(failures, fmt.Sprintf("%v not found", a.path))
+			continue
+		}
+		values[a.path] = got
+
+		ok, err := a.holds(got)
+		if err != nil {
+			failures = append(failures, err.Error())
+		} else if !ok {
+			want, _ := json.Marshal(a.want)
+			have, _ := json.Marshal(got)
+			failures = append(failures, fmt.Sprintf("%v is %s, expected %v %s", a.path, have, a.op, want))
+		}
+	}
+
+	if out, err := json.Marshal(values); err == nil {
+		s.ValuesJSON = string(out)
+	}
+
+	if len(failures) > 0 {
+		s.ResultJSON = strings.Join(failures, "; ")
+		logger.Error(s.ResultJSON)
+		s.fail(checkJSON, StateCrit, ErrorResponse)
+		return
+	}
+
+	s.ResultJSON = fmt.Sprintf("%v, %d assertions passed", status, len(assertions))
+	logger.Infof("JSON Check OK. Response: %v", s.ResultJSON)
+}
+
+// parseJSONAssertions reads assertions separated by semicolons or newlines.
+// Separators inside quoted strings are kept.
+func parseJSONAssertions(text string) ([]jsonAssertion, error) {
+	var assertions []jsonAssertion
+
+	for _, part := range splitUnquoted(text) {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		a := jsonAssertion{path: part}
+
+		for i := 0; i < len(part) && a.op == ""; i++ {
+			if part[i] == '\'' || part[i] == '"' {
+				// Skip bracketed names such as $['a<b']
+				if end := strings.IndexByte(part[i+1:], part[i]); end >= 0 {
+					i += end + 1
+				}
+				continue
+			}
+
+			for _, op := range jsonOperators {
+				if strings.HasPrefix(part[i:], op) {
+					a.path, a.op = strings.TrimSpace(part[:i]), op
+					literal := strings.TrimSpace(part[i+len(op):])
+					if err := json.Unmarshal([]byte(literal), &a.want); err != nil {
+						return nil, fmt.Errorf("Invalid value in assertion '%v'", part)
+					}
+					break
+				}
+			}
+		}
+
+		if !strings.HasPrefix(a.path, "$") {
+			return nil, fmt.Errorf("Invalid JSONPath in assertion '%v'", part)
+		}
+
+		assertions = append(assertions, a)
+	}
+
+	if len(assertions) == 0 {
+		return nil, fmt.Errorf("No JSON assertions configured")
+	}
+
+	return assertions, nil
+}
+
+// splitUnquoted splits text at semicolons and newlines outside double quotes
+func splitUnquoted(text string) []string {
+	var parts []string
+	quoted, escaped, start := false, false, 0
+
+	for i, c := range text {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\' && quoted:
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case (c == ';' || c == '\n') && !quoted:
+			parts = append(parts, text[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(parts, text[start:])
+}
+
+// jsonLookup follows a JSONPath made of $, .name, ['name'] and [index]
+// steps, reporting whether anything was found there
+func jsonLookup(doc interface{}, path string) (interface{}, bool, error) {
+	rest := strings.TrimPrefix(path, "$")
+	cur := doc
+
+	for rest != "" {
+		var key string
+		index := -1
+
+		switch {
+		case rest[0] == '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			key, rest = rest[1:end+1], rest[end+1:]
+		case strings.HasPrefix(rest, "['") || strings.HasPrefix(rest, `["`):
+			end := strings.Index(rest[2:], string(rest[1])+"]")
+			if end < 0 {
+				return nil, false, fmt.Errorf("Invalid JSONPath '%v'", path)
+			}
+			key, rest = rest[2:end+2], rest[end+4:]
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, false, fmt.Errorf("Invalid JSONPath '%v'", path)
+			}
+			n, err := strconv.Atoi(rest[1:end])
+			if err != nil || n < 0 {
+				return nil, false, fmt.Errorf("Invalid JSONPath '%v'", path)
+			}
+			index, rest = n, rest[end+1:]
+		default:
+			return nil, false, fmt.Errorf("Invalid JSONPath '%v'", path)
+		}
+
+		if index >= 0 {
+			list, ok := cur.([]interface{})
+			if !ok || index >= len(list) {
+				return nil, false, nil
+			}
+			cur = list[index]
+			continue
+		}
+
+		object, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, false, nil
+		}
+		if cur, ok = object[key]; !ok {
+			return nil, false, nil
+		}
+	}
+
+	return cur, true, nil
+}
+
+// holds compares got with the expected value. Numbers and strings can be
+// ordered, anything else only tested for equality.
+func (a jsonAssertion) holds(got interface{}) (bool, error) {
+	if a.op == "" {
+		return true, nil
+	}
+
+	if a.op == "==" || a.op == "!=" {
+		have, _ := json.Marshal(got)
+		want, _ := json.Marshal(a.want)
+		return (string(have) == string(want)) == (a.op == "=="), nil
+	}
+
+	var cmp int
+	switch want := a.want.(type) {
+	case float64:
+		have, ok := got.(float64)
+		if !ok {
+			return false, fmt.Errorf("%v is not a number", a.path)
+		}
+		cmp = compareNumbers(have, want)
+	case string:
+		have, ok := got.(string)
+		if !ok {
+			return false, fmt.Errorf("%v is not a string", a.path)
+		}
+		cmp = strings.Compare(have, want)
+	default:
+		return false, fmt.Errorf("Can't order %v with %v", a.path, a.op)
+	}
+
+	switch a.op {
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	default:
+		return cmp >= 0, nil
+	}
+}
+
+// compareNumbers returns -1, 0 or 1 as a is less than, equal to or greater than b
+func compareNumbers(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
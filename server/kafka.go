@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// CheckKafka sends a metadata request to the broker on port 9092 and records
+// how many brokers the cluster has. A cluster without a controller can't
+// elect partition leaders, so that fails too.
+func (s *Server) CheckKafka(wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	if !s.EnableKafka {
+		return
+	}
+
+	port := s.PortKafka
+	if port == 0 {
+		port = 9092
+	}
+
+	logger := s.GetLogger("KAFKA", port)
+	s.setState(checkKafka, StateOK)
+	s.BrokersKafka = 0
+
+	transport := &kafka.Transport{
+		Dial: func(ctx context.Context, network string, addr string) (net.Conn, error) {
+			return s.dial(addr)
+		},
+		DialTimeout: s.timeout(),
+		ClientID:    s.userAgent(),
+	}
+	defer transport.CloseIdleConnections()
+
+	if s.TLSKafka {
+		var err error
+		if transport.TLS, err = s.tlsConfig(); err != nil {
+			s.ResultKafka = "Invalid TLS configuration"
+			logger.WithError(err).Error(s.ResultKafka)
+			s.fail(checkKafka, StateCrit, ErrorConfig)
+			return
+		}
+	}
+
+	addr := net.JoinHostPort(s.IP, strconv.Itoa(port))
+	client := &kafka.Client{Addr: kafka.TCP(addr), Timeout: s.timeout(), Transport: transport}
+
+	ctx, cancel := context.WithTimeout(s.runContext(), s.timeout())
+	defer cancel()
+
+	start := time.Now()
+	meta, err := client.Metadata(ctx, &kafka.MetadataRequest{})
+	if err != nil {
+		s.ResultKafka = fmt.Sprintf("Metadata request failed: %v", err)
+		logger.WithError(err).Error("Metadata request failed")
+		s.fail(checkKafka, StateCrit, ErrorResponse)
+		return
+	}
+	took := time.Since(start).Round(time.Millisecond)
+
+	s.BrokersKafka = len(meta.Brokers)
+
+	controller := false
+	for _, broker := range meta.Brokers {
+		if broker.ID == meta.Controller.ID {
+			controller = true
+		}
+	}
+
+	if !controller {
+		s.ResultKafka = fmt.Sprintf("%d brokers, no controller", s.BrokersKafka)
+		logger.Error(s.ResultKafka)
+		s.fail(checkKafka, StateCrit, ErrorResponse)
+		return
+	}
+
+	s.ResultKafka = fmt.Sprintf("%d brokers, controller %d at %v (metadata %v)", s.BrokersKafka, meta.Controller.ID,
+		net.JoinHostPort(meta.Controller.Host, strconv.Itoa(meta.Controller.Port)), took)
+
+	if s.MinBrokersKafka > 0 && s.BrokersKafka < s.MinBrokersKafka {
+		s.ResultKafka += fmt.Sprintf(", expected at least %d brokers", s.MinBrokersKafka)
+		logger.Error(s.ResultKafka)
+		s.fail(checkKafka, StateCrit, ErrorResponse)
+		return
+	}
+
+	logger.Infof("Kafka Check OK. Response: %v", s.ResultKafka)
+}
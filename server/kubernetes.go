@@ -0,0 +1,368 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultKubernetesPort is the API server's, used when there is no
+// kubeconfig or k8surl
+const defaultKubernetesPort = "6443"
+
+// maxKubernetesBody caps API responses, allowing for the node list of a
+// large cluster
+const maxKubernetesBody = 32 << 20
+
+// kubeconfig is the part of a kubeconfig file needed to reach the current
+// context's cluster with a token or client certificate
+type kubeconfig struct {
+	CurrentContext string `yaml:"current-context"`
+	Clusters       []struct {
+		Name    string `yaml:"name"`
+		Cluster struct {
+			Server                   string `yaml:"server"`
+			CertificateAuthority     string `yaml:"certificate-authority"`
+			CertificateAuthorityData string `yaml:"certificate-authority-data"`
+			InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify"`
+			TLSServerName            string `yaml:"tls-server-name"`
+		} `yaml:"cluster"`
+	} `yaml:"clusters"`
+	Contexts []struct {
+		Name    string `yaml:"name"`
+		Context struct {
+			Cluster string `yaml:"cluster"`
+			User    string `yaml:"user"`
+		} `yaml:"context"`
+	} `yaml:"contexts"`
+	Users []struct {
+		Name string `yaml:"name"`
+		User struct {
+			Token                 string `yaml:"token"`
+			TokenFile             string `yaml:"tokenFile"`
+			ClientCertificate     string `yaml:"client-certificate"`
+			ClientCertificateData string `yaml:"client-certificate-data"`
+			ClientKey             string `yaml:"client-key"`
+			ClientKeyData         string `yaml:"client-key-data"`
+		} `yaml:"user"`
+	} `yaml:"users"`
+}
+
+// kubeEndpoint is where and how the API server is reached
+type kubeEndpoint struct {
+	url    string
+	token  string
+	config *tls.Config
+}
+
+// CheckKubernetes asks the API server's /readyz whether it is ready to serve,
+// reporting the failing checks when it isn't, and with NodesKubernetes also
+// requires every node to be Ready. The cluster and credentials come from the
+// kubeconfig in ConfigKubernetes, or URLKubernetes and TokenKubernetes.
+func (s *Server) CheckKubernetes(wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	if !s.EnableKubernetes {
+		return
+	}
+
+	logger := s.GetLogger("KUBERNETES", 0)
+	s.setState(checkKubernetes, StateOK)
+
+	endpoint, err := s.kubeEndpoint()
+	if err != nil {
+		s.ResultKubernetes = fmt.Sprintf("Invalid Kubernetes configuration: %v", err)
+		logger.Error(s.ResultKubernetes)
+		s.fail(checkKubernetes, StateCrit, ErrorConfig)
+		return
+	}
+
+	client := s.httpClient(endpoint.config)
+
+	status, body, err := s.kubeGet(client, endpoint, "/readyz?verbose")
+	if err != nil {
+		failed := httpError(err)
+		s.ResultKubernetes = failed.Error()
+		logger.WithError(err).Error(s.ResultKubernetes)
+		s.fail(checkKubernetes, StateCrit, classOf(failed))
+		return
+	}
+
+	switch status {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		s.ResultKubernetes = fmt.Sprintf("Not authorised to read /readyz (%v)", http.StatusText(status))
+		logger.Error(s.ResultKubernetes)
+		s.fail(checkKubernetes, StateCrit, ErrorAuth)
+		return
+	default:
+		s.ResultKubernetes = "API server not ready"
+		if failed := failedReadyz(body); len(failed) > 0 {
+			s.ResultKubernetes += ": " + strings.Join(failed, ", ")
+		}
+		logger.Error(s.ResultKubernetes)
+		s.fail(checkKubernetes, StateCrit, ErrorResponse)
+		return
+	}
+
+	s.ResultKubernetes = "API server ready"
+
+	if s.NodesKubernetes {
+		nodes, notReady, err := s.kubeNodes(client, endpoint)
+		if err != nil {
+			s.ResultKubernetes += fmt.Sprintf(", unable to list nodes: %v", err)
+			logger.WithError(err).Error("Unable to list nodes")
+			s.fail(checkKubernetes, StateCrit, classOf(err))
+			return
+		}
+
+		s.ResultKubernetes += fmt.Sprintf(", %d/%d nodes Ready", nodes-len(notReady), nodes)
+
+		if len(notReady) > 0 {
+			s.ResultKubernetes += ", not ready: " + strings.Join(notReady, ", ")
+			logger.Error(s.ResultKubernetes)
+			s.fail(checkKubernetes, StateCrit, ErrorResponse)
+			return
+		}
+	}
+
+	logger.Infof("Kubernetes Check OK. Response: %v", s.ResultKubernetes)
+}
+
+// kubeEndpoint reads the current context from ConfigKubernetes if set.
+// URLKubernetes and TokenKubernetes override it, and without a kubeconfig
+// the server's own TLS settings are used.
+func (s *Server) kubeEndpoint() (*kubeEndpoint, error) {
+	config, err := s.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := &kubeEndpoint{
+		url:    "https://" + net.JoinHostPort(s.IP, defaultKubernetesPort),
+		config: config,
+	}
+
+	if s.ConfigKubernetes != "" {
+		if err := endpoint.load(s.ConfigKubernetes); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.URLKubernetes != "" {
+		endpoint.url = s.URLKubernetes
+	}
+	if s.TokenKubernetes != "" {
+		endpoint.token = s.TokenKubernetes
+	}
+	endpoint.url = strings.TrimSuffix(endpoint.url, "/")
+
+	return endpoint, nil
+}
+
+// load applies the current context of a kubeconfig file. Credentials from
+// exec or auth provider plugins aren't supported, a token has to be given.
+func (e *kubeEndpoint) load(file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	var kc kubeconfig
+	if err := yaml.Unmarshal(data, &kc); err != nil {
+		return err
+	}
+
+	var clusterName, userName string
+	for _, c := range kc.Contexts {
+		if c.Name == kc.CurrentContext {
+			clusterName, userName = c.Context.Cluster, c.Context.User
+		}
+	}
+	if clusterName == "" {
+		return fmt.Errorf("context '%v' not found in %v", kc.CurrentContext, file)
+	}
+
+	found := false
+	for _, c := range kc.Clusters {
+		if c.Name != clusterName {
+			continue
+		}
+		found = true
+
+		e.url = c.Cluster.Server
+		e.config.InsecureSkipVerify = c.Cluster.InsecureSkipTLSVerify
+		if c.Cluster.TLSServerName != "" {
+			e.config.ServerName = c.Cluster.TLSServerName
+		}
+
+		ca, err := base64.StdEncoding.DecodeString(c.Cluster.CertificateAuthorityData)
+		if err != nil {
+			return fmt.Errorf("invalid certificate-authority-data: %v", err)
+		}
+		if c.Cluster.CertificateAuthority != "" {
+			if ca, err = os.ReadFile(c.Cluster.CertificateAuthority); err != nil {
+				return err
+			}
+		}
+		if len(ca) > 0 {
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(ca) {
+				return fmt.Errorf("no certificates in the authority of cluster %v", clusterName)
+			}
+			e.config.RootCAs = pool
+		}
+	}
+	if !found {
+		return fmt.Errorf("cluster '%v' not found in %v", clusterName, file)
+	}
+
+	for _, u := range kc.Users {
+		if u.Name != userName {
+			continue
+		}
+
+		e.token = u.User.Token
+		if u.User.TokenFile != "" {
+			token, err := os.ReadFile(u.User.TokenFile)
+			if err != nil {
+				return err
+			}
+			e.token = strings.TrimSpace(string(token))
+		}
+
+		cert, err := base64.StdEncoding.DecodeString(u.User.ClientCertificateData)
+		if err != nil {
+			return fmt.Errorf("invalid client-certificate-data: %v", err)
+		}
+		key, err := base64.StdEncoding.DecodeString(u.User.ClientKeyData)
+		if err != nil {
+			return fmt.Errorf("invalid client-key-data: %v", err)
+		}
+		if u.User.ClientCertificate != "" {
+			if cert, err = os.ReadFile(u.User.ClientCertificate); err != nil {
+				return err
+			}
+		}
+		if u.User.ClientKey != "" {
+			if key, err = os.ReadFile(u.User.ClientKey); err != nil {
+				return err
+			}
+		}
+		if len(cert) > 0 {
+			pair, err := tls.X509KeyPair(cert, key)
+			if err != nil {
+				return err
+			}
+			e.config.Certificates = []tls.Certificate{pair}
+		}
+	}
+
+	return nil
+}
+
+// kubeGet requests an API path with the endpoint's token, returning the
+// status and body
+func (s *Server) kubeGet(client *http.Client, endpoint *kubeEndpoint, path string) (int, []byte, error) {
+	ctx, cancel := context.WithTimeout(s.runContext(), s.timeout())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint.url+path, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	s.setHeaders(req)
+	req.Header.Set("Accept", "application/json")
+	if endpoint.token != "" {
+		req.Header.Set("Authorization", "Bearer "+endpoint.token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxKubernetesBody))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return resp.StatusCode, body, nil
+}
+
+// failedReadyz returns the checks a verbose /readyz marks with [-], e.g.
+// "etcd" from "[-]etcd failed: reason withheld"
+func failedReadyz(body []byte) []string {
+	var failed []string
+
+	scanner := bufio.NewScanner(strings.NewReader(string(body)))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "[-]") {
+			continue
+		}
+		name, _, _ := strings.Cut(strings.TrimPrefix(line, "[-]"), " ")
+		failed = append(failed, name)
+	}
+
+	return failed
+}
+
+// kubeNodes lists the cluster's nodes, returning how many there are and the
+// names of those whose Ready condition isn't True
+func (s *Server) kubeNodes(client *http.Client, endpoint *kubeEndpoint) (int, []string, error) {
+	status, body, err := s.kubeGet(client, endpoint, "/api/v1/nodes")
+	if err != nil {
+		return 0, nil, httpError(err)
+	}
+	if status != http.StatusOK {
+		return 0, nil, fmt.Errorf("API server returned %v", http.StatusText(status))
+	}
+
+	var list struct {
+		Items []struct {
+			Metadata struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+			Status struct {
+				Conditions []struct {
+					Type   string `json:"type"`
+					Status string `json:"status"`
+				} `json:"conditions"`
+			} `json:"status"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(body, &list); err != nil {
+		return 0, nil, fmt.Errorf("invalid node list: %v", err)
+	}
+
+	var notReady []string
+	for _, node := range list.Items {
+		ready := false
+		for _, condition := range node.Status.Conditions {
+			if condition.Type == "Ready" && condition.Status == "True" {
+				ready = true
+			}
+		}
+		if !ready {
+			notReady = append(notReady, node.Metadata.Name)
+		}
+	}
+
+	return len(list.Items), notReady, nil
+}
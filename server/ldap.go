@@ -0,0 +1,104 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// CheckLDAP binds to the directory on 389, or 636 with implicit TLS. The bind
+// is anonymous unless BindLDAP names a DN to bind as with PasswordLDAP.
+func (s *Server) CheckLDAP(wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	if !s.EnableLDAP {
+		return
+	}
+
+	port := s.PortLDAP
+	if port == 0 {
+		port = 389
+		if s.TLSLDAP {
+			port = 636
+		}
+	}
+
+	logger := s.GetLogger("LDAP", port)
+	s.setState(checkLDAP, StateOK)
+
+	addr := net.JoinHostPort(s.IP, strconv.Itoa(port))
+	start := time.Now()
+
+	var conn net.Conn
+	if s.TLSLDAP {
+		config, err := s.tlsConfig()
+		if err != nil {
+			s.ResultLDAP = "Invalid TLS configuration"
+			logger.WithError(err).Error(s.ResultLDAP)
+			s.fail(checkLDAP, StateCrit, ErrorConfig)
+			return
+		}
+
+		tlsConn, err := s.dialTLS(addr, config)
+		if err != nil {
+			s.ResultLDAP = "Unable to open LDAPS connection"
+			logger.WithError(err).Error(s.ResultLDAP)
+			s.fail(checkLDAP, StateCrit, ErrorConnect)
+			return
+		}
+
+		state := tlsConn.ConnectionState()
+		if len(state.PeerCertificates) > 0 {
+			s.expiries[checkLDAP] = state.PeerCertificates[0].NotAfter.Unix()
+		}
+		if certExpiresSoon(state) {
+			s.fail(checkLDAP, StateWarn, ErrorTLS)
+		}
+		conn = tlsConn
+	} else {
+		plain, err := s.dial(addr)
+		if err != nil {
+			s.ResultLDAP = "Unable to open LDAP connection"
+			logger.WithError(err).Error(s.ResultLDAP)
+			s.fail(checkLDAP, StateCrit, ErrorConnect)
+			return
+		}
+		conn = plain
+	}
+
+	client := ldap.NewConn(conn, s.TLSLDAP)
+	client.SetTimeout(s.timeout())
+	client.Start()
+	defer client.Close()
+
+	bindAs := "anonymous"
+	var err error
+	if s.BindLDAP == "" {
+		err = client.UnauthenticatedBind("")
+	} else {
+		bindAs = s.BindLDAP
+		err = client.Bind(s.BindLDAP, s.PasswordLDAP)
+	}
+
+	if err != nil {
+		reason := err.Error()
+		var result *ldap.Error
+		if errors.As(err, &result) {
+			reason = ldap.LDAPResultCodeMap[result.ResultCode]
+		}
+
+		s.ResultLDAP = fmt.Sprintf("Bind as %v failed: %v", bindAs, reason)
+		logger.WithError(err).Errorf("Bind as %v failed", bindAs)
+		s.fail(checkLDAP, StateCrit, ErrorAuth)
+		return
+	}
+
+	s.ResultLDAP = fmt.Sprintf("Bound as %v in %v", bindAs, time.Since(start).Round(time.Millisecond))
+	logger.Infof("LDAP Check OK. Response: %v", s.ResultLDAP)
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"sync"
+)
+
+// slots bound how many checks run at once across the fleet and against each
+// target, so a large batch doesn't run out of file descriptors or hit one
+// firewall with a burst of connections
+var slots = struct {
+	sync.Mutex
+	once  sync.Once
+	all   chan struct{}
+	hosts map[string]chan struct{}
+}{hosts: map[string]chan struct{}{}}
+
+// acquire waits for a free slot for one of the server's checks, within
+// Default.MaxChecks and Default.MaxChecksPerHost, and returns the function
+// that frees it. A limit of 0 is no limit.
+func (s *Server) acquire() func() {
+	slots.once.Do(func() {
+		if Default.MaxChecks > 0 {
+			slots.all = make(chan struct{}, Default.MaxChecks)
+		}
+	})
+
+	var host chan struct{}
+	if Default.MaxChecksPerHost > 0 {
+		target := s.IP
+		if target == "" {
+			target = s.Hostname
+		}
+
+		slots.Lock()
+		host = slots.hosts[target]
+		if host == nil {
+			host = make(chan struct{}, Default.MaxChecksPerHost)
+			slots.hosts[target] = host
+		}
+		slots.Unlock()
+	}
+
+	// Take the host's slot first, so checks queued behind a busy host don't
+	// hold fleet slots other hosts could use
+	if host != nil {
+		host <- struct{}{}
+	}
+	if slots.all != nil {
+		slots.all <- struct{}{}
+	}
+
+	return func() {
+		if slots.all != nil {
+			<-slots.all
+		}
+		if host != nil {
+			<-host
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kisielk/sqlstruct"
+)
+
+// Recurrences a scheduled maintenance window can repeat with
+const (
+	RepeatNone    = ""
+	RepeatDaily   = "daily"
+	RepeatWeekly  = "weekly"
+	RepeatMonthly = "monthly"
+)
+
+// MaintenanceWindow is a row in maintenancewindows: planned work on one
+// server, the servers with a tag, or every server when neither is set. It
+// runs from StartsAt to EndsAt and, with Repeat, again each day, week or
+// month after until Until.
+type MaintenanceWindow struct {
+	ID       int    `sql:"id"`
+	ServerID int    `sql:"serverid"`
+	Tag      string `sql:"tag"`
+	StartsAt int64  `sql:"startsat"`
+	EndsAt   int64  `sql:"endsat"`
+	Repeat   string `sql:"repeat"`
+	Until    int64  `sql:"until"`
+	Reason   string `sql:"reason"`
+}
+
+// Validate checks the window is one that can be scheduled
+func (m MaintenanceWindow) Validate() error {
+	if m.EndsAt <= m.StartsAt {
+		return fmt.Errorf("maintenance window must end after it starts")
+	}
+
+	switch m.Repeat {
+	case RepeatNone, RepeatDaily, RepeatWeekly, RepeatMonthly:
+	default:
+		return fmt.Errorf("Unknown recurrence '%v'", m.Repeat)
+	}
+
+	return nil
+}
+
+// occurrence returns the start of the nth repeat of the window, counting
+// calendar days and months in loc so it keeps its local time across DST
+func (m MaintenanceWindow) occurrence(n int, loc *time.Location) time.Time {
+	start := time.Unix(m.StartsAt, 0).In(loc)
+
+	switch m.Repeat {
+	case RepeatDaily:
+		return start.AddDate(0, 0, n)
+	case RepeatWeekly:
+		return start.AddDate(0, 0, 7*n)
+	case RepeatMonthly:
+		return start.AddDate(0, n, 0)
+	}
+
+	return start
+}
+
+// contains reports whether t falls in the window or one of its repeats
+func (m MaintenanceWindow) contains(t time.Time, loc *time.Location) bool {
+	if t.Unix() < m.StartsAt || (m.Until > 0 && t.Unix() >= m.Until) {
+		return false
+	}
+
+	length := time.Duration(m.EndsAt-m.StartsAt) * time.Second
+	elapsed := t.Sub(time.Unix(m.StartsAt, 0))
+
+	var n int
+	switch m.Repeat {
+	case RepeatDaily:
+		n = int(elapsed / (24 * time.Hour))
+	case RepeatWeekly:
+		n = int(elapsed / (7 * 24 * time.Hour))
+	case RepeatMonthly:
+		start := time.Unix(m.StartsAt, 0).In(loc)
+		local := t.In(loc)
+		n = (local.Year()-start.Year())*12 + int(local.Month()-start.Month())
+	}
+
+	// DST and month lengths can put t in the repeat either side of n
+	for _, i := range []int{n - 1, n, n + 1} {
+		if i < 0 || (m.Repeat == RepeatNone && i != 0) {
+			continue
+		}
+		start := m.occurrence(i, loc)
+		if !t.Before(start) && t.Before(start.Add(length)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// tags returns the server's comma separated tags
+func (s *Server) tags() []string {
+	var tags []string
+	for _, tag := range strings.Split(s.Tags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+
+	return tags
+}
+
+// HasTag reports whether the server is tagged with tag
+func (s *Server) HasTag(tag string) bool {
+	for _, t := range s.tags() {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// inScheduledMaintenance reports whether t falls in a maintenance window
+// scheduled for the server, one of its tags or the whole fleet
+func (s *Server) inScheduledMaintenance(t time.Time) bool {
+	rows, err := s.DB.Query(`
+		SELECT * FROM maintenancewindows
+		WHERE (serverid = ? OR serverid = 0) AND startsat <= ? AND (until = 0 OR until > ?)
+	`, s.ID, t.Unix(), t.Unix())
+	if err != nil {
+		s.GetLogger("SCHEDULE", 0).WithError(err).Error("Unable to load maintenance windows")
+		return false
+	}
+
+	defer rows.Close()
+
+	loc := s.location()
+	for rows.Next() {
+		var m MaintenanceWindow
+		if err := sqlstruct.Scan(&m, rows); err != nil {
+			s.GetLogger("SCHEDULE", 0).WithError(err).Error("Unable to load maintenance window")
+			continue
+		}
+
+		if m.ServerID == 0 && m.Tag != "" && !s.HasTag(m.Tag) {
+			continue
+		}
+
+		if m.contains(t, loc) {
+			return true
+		}
+	}
+
+	return false
+}
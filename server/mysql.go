@@ -0,0 +1,146 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// mysqlServer carries the server being checked to the MySQL driver's dialer
+type mysqlServer struct{}
+
+func init() {
+	// Connections opened by the driver go through dial like every other check
+	mysql.RegisterDialContext("vbms", func(ctx context.Context, addr string) (net.Conn, error) {
+		s, ok := ctx.Value(mysqlServer{}).(*Server)
+		if !ok {
+			return nil, errors.New("no server to dial for")
+		}
+		return s.dial(addr)
+	})
+}
+
+// CheckMySQL reads the MySQL handshake on port 3306 for the server version
+// and, when UserMySQL is set, logs in and runs SELECT 1
+func (s *Server) CheckMySQL(wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	if !s.EnableMySQL {
+		return
+	}
+
+	port := s.PortMySQL
+	if port == 0 {
+		port = 3306
+	}
+
+	logger := s.GetLogger("MySQL", port)
+	s.setState(checkMySQL, StateOK)
+
+	addr := net.JoinHostPort(s.IP, strconv.Itoa(port))
+
+	start := time.Now()
+	conn, err := s.dial(addr)
+	if err != nil {
+		s.ResultMySQL = "Unable to open MySQL connection"
+		logger.WithError(err).Error(s.ResultMySQL)
+		s.fail(checkMySQL, StateCrit, ErrorConnect)
+		return
+	}
+
+	conn.SetDeadline(time.Now().Add(s.timeout()))
+	version, err := mysqlVersion(conn)
+	conn.Close()
+	if err != nil {
+		s.ResultMySQL = err.Error()
+		logger.Error(s.ResultMySQL)
+		s.fail(checkMySQL, StateCrit, classOf(err))
+		return
+	}
+
+	s.ResultMySQL = fmt.Sprintf("MySQL %v (connect %v)", version, time.Since(start).Round(time.Millisecond))
+
+	if s.UserMySQL == "" {
+		logger.Infof("MySQL Check OK. Response: %v", s.ResultMySQL)
+		return
+	}
+
+	cfg := mysql.NewConfig()
+	cfg.User = s.UserMySQL
+	cfg.Passwd = s.PasswordMySQL
+	cfg.Net = "vbms"
+	cfg.Addr = addr
+	cfg.Timeout = s.timeout()
+	cfg.ReadTimeout = s.timeout()
+
+	connector, err := mysql.NewConnector(cfg)
+	if err != nil {
+		s.ResultMySQL = "Invalid MySQL configuration"
+		logger.WithError(err).Error(s.ResultMySQL)
+		s.fail(checkMySQL, StateCrit, ErrorConfig)
+		return
+	}
+
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(s.runContext(), s.timeout())
+	defer cancel()
+
+	var one int
+	if err := db.QueryRowContext(context.WithValue(ctx, mysqlServer{}, s), "SELECT 1").Scan(&one); err != nil {
+		s.ResultMySQL = fmt.Sprintf("SELECT 1 failed: %v", err)
+		logger.WithError(err).Error("SELECT 1 failed")
+		s.fail(checkMySQL, StateCrit, ErrorResponse)
+		return
+	}
+
+	logger.Infof("MySQL Check OK. Response: %v", s.ResultMySQL)
+}
+
+// mysqlVersion returns the version from the server's initial handshake
+// packet, or the error the server greets us with
+func mysqlVersion(conn net.Conn) (string, error) {
+	// Packets are a 3 byte little-endian length and a sequence number
+	reader := bufio.NewReader(conn)
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return "", failure(ErrorTimeout, "No response received from server")
+	}
+
+	length := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil || length < 2 {
+		return "", errors.New("Returned invalid MySQL handshake")
+	}
+
+	switch payload[0] {
+	case 0x0a:
+		// Protocol 10, followed by the NUL terminated version
+		for i, b := range payload[1:] {
+			if b == 0 {
+				return string(payload[1 : i+1]), nil
+			}
+		}
+		return "", errors.New("Returned invalid MySQL handshake")
+	case 0xff:
+		// Refused before the handshake, e.g. "Host is not allowed to connect"
+		if length < 3 {
+			return "", errors.New("MySQL refused connection")
+		}
+		return "", fmt.Errorf("MySQL error %d: %s", binary.LittleEndian.Uint16(payload[1:3]), payload[3:])
+	default:
+		return "", fmt.Errorf("Unsupported MySQL protocol version %d", payload[0])
+	}
+}
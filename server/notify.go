@@ -0,0 +1,188 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/blinktag/vbms/notify"
+)
+
+// flapWindow is how many of a check's recent runs flap detection looks at
+const flapWindow = 20
+
+// notifyTimeout bounds sending a run's notifications
+const notifyTimeout = time.Minute
+
+// errNotDown is returned when acknowledging a check that isn't hard CRIT
+var errNotDown = errors.New("Check isn't down")
+
+// event is a transition confirmStates found, sent once the run is recorded
+type event struct {
+	kind     notify.Kind
+	check    int
+	previous State
+	since    int64
+}
+
+// flapping reports whether the check changed state in at least
+// Default.FlapThreshold percent of its last flapWindow runs, this one
+// included. A flapping check only settles below half the threshold, so one
+// on the edge doesn't keep starting and stopping. Without enough history it
+// stays as it was.
+func (s *Server) flapping(name string, current State, was bool) bool {
+	threshold := Default.FlapThreshold
+	if threshold <= 0 {
+		return false
+	}
+
+	rows, err := s.DB.Query("SELECT state FROM check_history WHERE serverid = ? AND checkname = ? ORDER BY checkedat DESC LIMIT ?", s.ID, name, flapWindow-1)
+	if err != nil {
+		s.GetLogger("NOTIFY", 0).WithError(err).Error("Unable to load history for flap detection")
+		return was
+	}
+	defer rows.Close()
+
+	states := []State{current}
+	for rows.Next() {
+		var st State
+		if err := rows.Scan(&st); err != nil {
+			return was
+		}
+		states = append(states, st)
+	}
+
+	if len(states) < flapWindow {
+		return was
+	}
+
+	changes := 0
+	for i := 1; i < len(states); i++ {
+		if states[i] != states[i-1] {
+			changes++
+		}
+	}
+	percent := changes * 100 / (len(states) - 1)
+
+	if was {
+		return percent*2 >= threshold
+	}
+
+	return percent >= threshold
+}
+
+// transition records the event, if any, a check's new hard state makes:
+// down when it goes CRIT, recovered when it's back to OK from CRIT, and
+// flapping, instead of either, when it starts to flap. Once it stops
+// flapping, where it settled is compared with the hard state from before it
+// started, and recovered is sent for OK so the flapping alert is resolved.
+// A check that stays CRIT is firing, for channels whose alerts expire.
+func (s *Server) transition(i int, cs checkState, hard State, flapping bool) {
+	ev := event{check: i, previous: cs.HardState, since: cs.ChangedAt}
+
+	switch {
+	case flapping && !cs.Flapping:
+		ev.kind = notify.Flapping
+	case flapping:
+		return
+	case cs.Flapping:
+		ev.previous = cs.FlapFrom
+		switch {
+		case hard == StateCrit && cs.FlapFrom != StateCrit:
+			ev.kind = notify.Down
+		case hard == StateCrit:
+			ev.kind = notify.Firing
+		case hard == StateOK:
+			ev.kind = notify.Recovered
+		default:
+			return
+		}
+	case hard == StateCrit && cs.HardState == StateCrit:
+		ev.kind = notify.Firing
+	case cs.HardState == "" || hard == cs.HardState:
+		return
+	case hard == StateCrit:
+		ev.kind = notify.Down
+	case hard == StateOK && cs.HardState == StateCrit:
+		ev.kind = notify.Recovered
+	default:
+		return
+	}
+
+	s.events = append(s.events, ev)
+}
+
+// notify sends the run's events to the channels routed to take them. Planned
+// work is expected to break things, so nothing is sent during maintenance.
+func (s *Server) notify() {
+	if len(s.events) == 0 {
+		return
+	}
+
+	now := time.Now()
+	if s.inMaintenance(now) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+
+	for _, ev := range s.events {
+		e := notify.Event{
+			Kind:     ev.kind,
+			ServerID: s.ID,
+			Hostname: s.Hostname,
+			Tags:     s.tags(),
+			Check:    checkNames[ev.check],
+			State:    string(*s.states()[ev.check]),
+			Previous: string(ev.previous),
+			Result:   *s.results()[ev.check],
+			At:       now,
+		}
+		if ev.since > 0 {
+			e.Since = time.Unix(ev.since, 0)
+		}
+
+		notify.Dispatch(ctx, s.DB, e)
+	}
+}
+
+// Acknowledge tells the channels routed to take it that someone is working
+// on a down check of the server called hostname, e.g. so PagerDuty stops
+// escalating the page
+func Acknowledge(db *sql.DB, hostname string, check string) error {
+	rows, err := db.Query("SELECT * FROM servers WHERE hostname = ?", hostname)
+	if err != nil {
+		return err
+	}
+	if !rows.Next() {
+		rows.Close()
+		return fmt.Errorf("%w %v", errUnknownServer, hostname)
+	}
+	s := NewServer(db, rows)
+	rows.Close()
+
+	states, err := s.loadCheckStates()
+	if err != nil {
+		return err
+	}
+
+	for i, name := range checkNames {
+		if name != check {
+			continue
+		}
+
+		cs := states[name]
+		if cs.HardState != StateCrit {
+			return fmt.Errorf("%w: %v %v", errNotDown, hostname, check)
+		}
+
+		s.events = []event{{kind: notify.Acknowledged, check: i, previous: cs.HardState, since: cs.ChangedAt}}
+		s.notify()
+		return nil
+	}
+
+	return fmt.Errorf("Unknown check '%v'", check)
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// checkOCSP verifies the OCSP response stapled to a handshake is current,
+// signed for the server's certificate and doesn't revoke it, and describes
+// its age, e.g. "OCSP good, stapled 3h0m0s ago"
+func checkOCSP(state *tls.ConnectionState) (string, error) {
+	if len(state.OCSPResponse) == 0 {
+		return "", errors.New("No OCSP response stapled")
+	}
+
+	if len(state.PeerCertificates) == 0 {
+		return "", errors.New("No certificate presented")
+	}
+	leaf := state.PeerCertificates[0]
+
+	// Prefer the verified chain's issuer, it may not have been sent
+	var issuer *x509.Certificate
+	switch {
+	case len(state.VerifiedChains) > 0 && len(state.VerifiedChains[0]) > 1:
+		issuer = state.VerifiedChains[0][1]
+	case len(state.PeerCertificates) > 1:
+		issuer = state.PeerCertificates[1]
+	default:
+		return "", errors.New("No issuer to check the OCSP response against")
+	}
+
+	resp, err := ocsp.ParseResponseForCert(state.OCSPResponse, leaf, issuer)
+	if err != nil {
+		return "", fmt.Errorf("Invalid OCSP response: %v", err)
+	}
+
+	if !resp.NextUpdate.IsZero() && time.Now().After(resp.NextUpdate) {
+		return "", fmt.Errorf("Stapled OCSP response expired %v", resp.NextUpdate.Format("2006-01-02 15:04"))
+	}
+
+	age := time.Since(resp.ThisUpdate).Round(time.Minute)
+
+	switch resp.Status {
+	case ocsp.Good:
+		return fmt.Sprintf("OCSP good, stapled %v ago", age), nil
+	case ocsp.Revoked:
+		return "", fmt.Errorf("Certificate revoked %v", resp.RevokedAt.Format("2006-01-02"))
+	default:
+		return "", errors.New("OCSP status unknown")
+	}
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// maxParentDepth bounds the walk up a chain of parents, in case the chain
+// loops back on itself
+const maxParentDepth = 16
+
+// parents returns the hostnames of the servers this one is reached through,
+// e.g. the router it sits behind
+func (s *Server) parents() []string {
+	var parents []string
+	for _, parent := range strings.Split(s.Parents, ",") {
+		if parent = strings.TrimSpace(parent); parent != "" {
+			parents = append(parents, parent)
+		}
+	}
+
+	return parents
+}
+
+// parentsDown returns the parent the server is cut off by when every one of
+// its parents' last pings failed, or was itself unreachable, or "" when any
+// parent is up. A parent that doesn't ping, or isn't known, counts as up. A
+// loop back to the server is an error, as it would keep a whole chain
+// unreachable.
+func (s *Server) parentsDown() (string, error) {
+	parents := s.parents()
+	if len(parents) == 0 {
+		return "", nil
+	}
+
+	if err := s.checkParentLoop(); err != nil {
+		return "", err
+	}
+
+	for _, parent := range parents {
+		var state State
+		err := s.DB.QueryRow(`
+			SELECT cs.state FROM servers p
+			JOIN checkstates cs ON cs.serverid = p.id AND cs.checkname = 'ping'
+			WHERE p.hostname = ? AND p.enableping = 1
+		`, parent).Scan(&state)
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+
+		if state != StateCrit && state != StateUnreachable {
+			return "", nil
+		}
+	}
+
+	return parents[0], nil
+}
+
+// checkParentLoop walks up the server's parents, failing if they lead back
+// to it
+func (s *Server) checkParentLoop() error {
+	seen := map[string]bool{}
+	next := s.parents()
+
+	for depth := 0; len(next) > 0; depth++ {
+		if depth == maxParentDepth {
+			return fmt.Errorf("parents nested more than %d deep", maxParentDepth)
+		}
+
+		var above []string
+		for _, hostname := range next {
+			if strings.EqualFold(hostname, s.Hostname) {
+				return fmt.Errorf("parents loop back to %v", s.Hostname)
+			}
+			if seen[hostname] {
+				continue
+			}
+			seen[hostname] = true
+
+			var parents string
+			err := s.DB.QueryRow("SELECT parents FROM servers WHERE hostname = ?", hostname).Scan(&parents)
+			if err != nil && err != sql.ErrNoRows {
+				return err
+			}
+
+			parent := Server{Parents: parents}
+			above = append(above, parent.parents()...)
+		}
+		next = above
+	}
+
+	return nil
+}
+
+// markUnreachable turns the server's failures into UNREACHABLE when its
+// parents are down, so a dead router shows as one outage rather than one
+// for everything behind it. Unreachable checks don't count against uptime
+// or open incidents. Warnings still stand, as the server answered.
+func (s *Server) markUnreachable() {
+	logger := s.GetLogger("PARENTS", 0)
+
+	parent, err := s.parentsDown()
+	if err != nil {
+		logger.WithError(err).Error("Unable to check parents, treating them as up")
+		return
+	}
+	if parent == "" {
+		return
+	}
+
+	marked := 0
+	for i, st := range s.states() {
+		if s.skipped[i] || *st != StateCrit {
+			continue
+		}
+
+		*st = StateUnreachable
+		s.classes[i] = ErrorUnreachable
+		*s.results()[i] = fmt.Sprintf("Unreachable via %v: %v", parent, *s.results()[i])
+		marked++
+	}
+
+	if marked > 0 {
+		logger.Warnf("%d checks unreachable, parent %v is down", marked, parent)
+	}
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// errUnknownServer is returned for a hostname that isn't in servers
+var errUnknownServer = errors.New("Unknown server")
+
+// SetPaused pauses or resumes checks of the server called hostname. A paused
+// server keeps its history but isn't queued in any batch until resumed.
+func SetPaused(db *sql.DB, hostname string, paused bool, reason string) error {
+	var pausedAt int64
+	if paused {
+		pausedAt = time.Now().Unix()
+	} else {
+		reason = ""
+	}
+
+	res, err := db.Exec("UPDATE servers SET paused = ?, pausedat = ?, pausedreason = ? WHERE hostname = ?", paused, pausedAt, reason, hostname)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w %v", errUnknownServer, hostname)
+	}
+
+	return nil
+}
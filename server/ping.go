@@ -0,0 +1,158 @@
+package server
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// defaultPingCount is how many echo requests a ping check sends
+const defaultPingCount = 3
+
+// pingInterval is the least time between echo requests, as ping(8) waits
+const pingInterval = time.Second
+
+// pingSamples sends count echo requests to ip one after another and returns
+// the round trip of each one answered. Each waits up to its share of the
+// timeout. Raw ICMP sockets need root or CAP_NET_RAW, which UnprivilegedPing
+// avoids by using datagram sockets.
+func (s *Server) pingSamples(ip net.IP, count int) ([]time.Duration, error) {
+	network, listen, proto := "ip4:icmp", "0.0.0.0", 1
+	var request icmp.Type = ipv4.ICMPTypeEcho
+	var reply icmp.Type = ipv4.ICMPTypeEchoReply
+
+	if ip.To4() == nil {
+		network, listen, proto = "ip6:ipv6-icmp", "::", 58
+		request, reply = ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply
+	}
+
+	if Default.UnprivilegedPing {
+		network = "udp4"
+		if proto == 58 {
+			network = "udp6"
+		}
+	}
+
+	conn, err := icmp.ListenPacket(network, listen)
+	if err != nil {
+		return nil, err
+	}
+
+	defer conn.Close()
+
+	var dst net.Addr = &net.IPAddr{IP: ip}
+	if Default.UnprivilegedPing {
+		dst = &net.UDPAddr{IP: ip}
+	}
+
+	// Datagram sockets have their ID rewritten by the kernel, so only
+	// the sequence number and source identify our replies there
+	id := rand.Intn(0xffff)
+	wait := s.timeout() / time.Duration(count)
+
+	var rtts []time.Duration
+	buf := make([]byte, 1500)
+
+	for seq := 1; seq <= count; seq++ {
+		msg := icmp.Message{
+			Type: request,
+			Body: &icmp.Echo{ID: id, Seq: seq, Data: []byte("vbms")},
+		}
+
+		packet, err := msg.Marshal(nil)
+		if err != nil {
+			return nil, err
+		}
+
+		sent := time.Now()
+		if _, err := conn.WriteTo(packet, dst); err != nil {
+			return nil, err
+		}
+
+		conn.SetReadDeadline(sent.Add(wait))
+
+		for {
+			n, peer, err := conn.ReadFrom(buf)
+			if err != nil {
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					break
+				}
+				return nil, err
+			}
+
+			if !samePeer(peer, ip) {
+				continue
+			}
+
+			resp, err := icmp.ParseMessage(proto, buf[:n])
+			if err != nil || resp.Type != reply {
+				continue
+			}
+
+			echo, ok := resp.Body.(*icmp.Echo)
+			if !ok || echo.Seq != seq || (!Default.UnprivilegedPing && echo.ID != id) {
+				continue
+			}
+
+			rtts = append(rtts, time.Since(sent))
+			break
+		}
+
+		if seq < count {
+			time.Sleep(time.Until(sent.Add(pingInterval)))
+		}
+	}
+
+	return rtts, nil
+}
+
+// samePeer reports whether a reply came from ip
+func samePeer(peer net.Addr, ip net.IP) bool {
+	switch addr := peer.(type) {
+	case *net.IPAddr:
+		return addr.IP.Equal(ip)
+	case *net.UDPAddr:
+		return addr.IP.Equal(ip)
+	}
+	return false
+}
+
+// setPingStats records loss as a percentage of count, and the minimum,
+// average and maximum round trips in milliseconds. Jitter is the mean
+// difference between consecutive round trips.
+func (s *Server) setPingStats(rtts []time.Duration, count int) {
+	s.PingLoss = float64(count-len(rtts)) * 100 / float64(count)
+	s.PingMin, s.PingAvg, s.PingMax, s.PingJitter = 0, 0, 0, 0
+
+	if len(rtts) == 0 {
+		return
+	}
+
+	lo, hi, sum, jitter := rtts[0], rtts[0], time.Duration(0), time.Duration(0)
+	for i, rtt := range rtts {
+		lo = min(lo, rtt)
+		hi = max(hi, rtt)
+		sum += rtt
+		if i > 0 {
+			jitter += (rtt - rtts[i-1]).Abs()
+		}
+	}
+
+	s.PingMin = milliseconds(lo)
+	s.PingMax = milliseconds(hi)
+	s.PingAvg = milliseconds(sum / time.Duration(len(rtts)))
+	if len(rtts) > 1 {
+		s.PingJitter = milliseconds(jitter / time.Duration(len(rtts)-1))
+	}
+}
+
+// milliseconds converts d to fractional milliseconds, to the microsecond
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// plugin runs a check given the JSON request on stdin and returns its stdout
+type plugin interface {
+	run(ctx context.Context, input []byte) ([]byte, error)
+}
+
+// plugins maps plugin names to their implementation
+var plugins = map[string]plugin{}
+
+// execPlugin is an executable run once per check
+type execPlugin struct {
+	path string
+}
+
+// LoadPlugins registers every executable and WASM module in dir as a check
+// plugin named after its file, e.g. plugins/radius or plugins/radius.wasm is
+// enabled on a server with plugins = "radius"
+func LoadPlugins(dir string) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || info.IsDir() {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		name := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+
+		if filepath.Ext(path) == ".wasm" {
+			p, err := loadWASM(path)
+			if err != nil {
+				return len(plugins), fmt.Errorf("%v: %v", path, err)
+			}
+			plugins[name] = p
+			continue
+		}
+
+		if info.Mode()&0111 != 0 {
+			plugins[name] = &execPlugin{path: path}
+		}
+	}
+
+	return len(plugins), nil
+}
+
+// pluginRequest is written to a plugin's stdin as JSON
+type pluginRequest struct {
+	ID       int             `json:"id"`
+	Hostname string          `json:"hostname"`
+	IP       string          `json:"ip"`
+	Timeout  float64         `json:"timeout"`
+	Config   json.RawMessage `json:"config,omitempty"`
+}
+
+// pluginResponse is read from a plugin's stdout as JSON. Status is "ok",
+// "warn" or "fail".
+type pluginResponse struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// CheckPlugins runs each plugin enabled for the server
+func (s *Server) CheckPlugins(wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	if strings.TrimSpace(s.Plugins) == "" {
+		return
+	}
+
+	logger := s.GetLogger("PLUGIN", 0)
+	s.setState(checkPlugins, StateOK)
+
+	// Per-plugin settings, keyed by plugin name
+	config := map[string]json.RawMessage{}
+	if s.PluginConfig != "" {
+		if err := json.Unmarshal([]byte(s.PluginConfig), &config); err != nil {
+			s.ResultPlugins = "Invalid plugin configuration"
+			logger.WithError(err).Error(s.ResultPlugins)
+			s.fail(checkPlugins, StateCrit, ErrorConfig)
+			return
+		}
+	}
+
+	var results []string
+
+	for _, name := range strings.Split(s.Plugins, ",") {
+		name = strings.TrimSpace(name)
+
+		resp, err := s.runPlugin(name, config[name])
+		if err != nil {
+			results = append(results, fmt.Sprintf("%v: %v", name, err))
+			logger.WithError(err).Errorf("Plugin %v failed to run", name)
+			s.fail(checkPlugins, StateCrit, ErrorResponse)
+			continue
+		}
+
+		results = append(results, fmt.Sprintf("%v: %v", name, resp.Message))
+
+		switch resp.Status {
+		case "ok":
+			logger.Infof("Plugin %v OK. Response: %v", name, resp.Message)
+		case "warn":
+			logger.Warnf("Plugin %v warning: %v", name, resp.Message)
+			s.fail(checkPlugins, StateWarn, ErrorResponse)
+		default:
+			logger.Errorf("Plugin %v failed: %v", name, resp.Message)
+			s.fail(checkPlugins, StateCrit, ErrorResponse)
+		}
+	}
+
+	s.ResultPlugins = strings.Join(results, "; ")
+}
+
+// runPlugin executes a plugin with the server on stdin and decodes its result
+func (s *Server) runPlugin(name string, config json.RawMessage) (*pluginResponse, error) {
+	p, ok := plugins[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown plugin")
+	}
+
+	input, err := json.Marshal(pluginRequest{
+		ID:       s.ID,
+		Hostname: s.Hostname,
+		IP:       s.IP,
+		Timeout:  s.timeout().Seconds(),
+		Config:   config,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(s.runContext(), s.timeout())
+	defer cancel()
+
+	output, err := p.run(context.WithValue(ctx, pluginServer{}, s), input)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp pluginResponse
+	if err := json.Unmarshal(output, &resp); err != nil {
+		return nil, fmt.Errorf("Invalid plugin output: %v", err)
+	}
+
+	return &resp, nil
+}
+
+// run executes the plugin binary, reporting stderr if it exits non-zero
+func (p *execPlugin) run(ctx context.Context, input []byte) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.path)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%v: %v", err, msg)
+		}
+		return nil, err
+	}
+
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CheckPOP3S opens a TLS connection on port 995 and checks for a POP3 greeting
+func (s *Server) CheckPOP3S(wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	if !s.EnablePOP3S {
+		return
+	}
+
+	logger := s.GetLogger("POP3S", 995)
+	s.setState(checkPOP3S, StateOK)
+
+	config, err := s.tlsConfig()
+	if err != nil {
+		s.ResultPOP3S = "Invalid TLS configuration"
+		logger.WithError(err).Error(s.ResultPOP3S)
+		s.fail(checkPOP3S, StateCrit, ErrorConfig)
+		return
+	}
+
+	conn, err := s.dialTLS(net.JoinHostPort(s.IP, "995"), config)
+	if err != nil {
+		s.ResultPOP3S = "Unable to open POP3S connection"
+		logger.WithError(err).Error(s.ResultPOP3S)
+		s.fail(checkPOP3S, StateCrit, ErrorConnect)
+		return
+	}
+
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(s.timeout()))
+
+	state := conn.ConnectionState()
+	if len(state.PeerCertificates) > 0 {
+		s.expiries[checkPOP3S] = state.PeerCertificates[0].NotAfter.Unix()
+	}
+
+	result, err := s.pop3Session(conn)
+	s.ResultPOP3S = result
+	if err != nil {
+		logger.Error(s.ResultPOP3S)
+		s.fail(checkPOP3S, StateCrit, classOf(err))
+		return
+	}
+
+	if expiry := certExpiry(state); expiry != "" {
+		s.ResultPOP3S = fmt.Sprintf("%v (certificate expires %v)", result, expiry)
+	}
+	if certExpiresSoon(state) {
+		s.fail(checkPOP3S, StateWarn, ErrorTLS)
+	}
+
+	logger.Infof("Returned on port 995: %v", s.ResultPOP3S)
+}
+
+// pop3Session reads the greeting, which must be +OK and match BannerPOP3,
+// logs in with USER and PASS when a user is configured, and ends with QUIT.
+// It returns the result to record, which describes the failure on error.
+func (s *Server) pop3Session(conn net.Conn) (string, error) {
+	reader := bufio.NewReader(conn)
+
+	result, err := reader.ReadString('\n')
+	if err != nil {
+		return "No response received from server", failure(ErrorTimeout, "%v", err)
+	}
+	result = strings.TrimSpace(result)
+
+	if !strings.HasPrefix(result, "+OK") {
+		return fmt.Sprintf("Returned invalid POP3 greeting: '%v'", result), fmt.Errorf("invalid greeting")
+	}
+
+	// Make sure the expected daemon answered
+	if err := matchBanner(s.BannerPOP3, result); err != nil {
+		return err.Error(), err
+	}
+
+	if s.UserPOP3 != "" {
+		for _, cmd := range []string{"USER " + s.UserPOP3, "PASS " + s.PassPOP3} {
+			reply, err := pop3Command(conn, reader, cmd)
+			if err != nil {
+				return fmt.Sprintf("Login failed: %v", reply), failure(ErrorAuth, "%v", err)
+			}
+		}
+		result = fmt.Sprintf("%v (logged in as %v)", result, s.UserPOP3)
+	}
+
+	pop3Command(conn, reader, "QUIT")
+
+	return result, nil
+}
+
+// pop3Command sends a command and reads its single line reply, failing
+// unless it is +OK
+func pop3Command(conn net.Conn, reader *bufio.Reader, cmd string) (string, error) {
+	if _, err := fmt.Fprintf(conn, "%v\r\n", cmd); err != nil {
+		return "", err
+	}
+
+	reply, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	reply = strings.TrimSpace(reply)
+	if !strings.HasPrefix(reply, "+OK") {
+		return reply, fmt.Errorf("%v", reply)
+	}
+
+	return reply, nil
+}
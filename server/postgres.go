@@ -0,0 +1,153 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// pgSSLRequest asks a PostgreSQL server whether it accepts TLS. Every server
+// answers it with a single byte before any authentication.
+var pgSSLRequest = binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, 8), 80877103)
+
+// pgDialer opens the driver's connections with dial, so jump hosts apply
+type pgDialer struct {
+	s *Server
+}
+
+func (d pgDialer) Dial(network, address string) (net.Conn, error) {
+	return d.s.dial(address)
+}
+
+func (d pgDialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	return d.s.dial(address)
+}
+
+// CheckPostgres probes PostgreSQL on port 5432 with an SSLRequest and, when
+// UserPostgres is set, logs in and runs SELECT 1
+func (s *Server) CheckPostgres(wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	if !s.EnablePostgres {
+		return
+	}
+
+	port := s.PortPostgres
+	if port == 0 {
+		port = 5432
+	}
+
+	logger := s.GetLogger("PostgreSQL", port)
+	s.setState(checkPostgres, StateOK)
+
+	addr := net.JoinHostPort(s.IP, strconv.Itoa(port))
+
+	start := time.Now()
+	conn, err := s.dial(addr)
+	if err != nil {
+		s.ResultPostgres = "Unable to open PostgreSQL connection"
+		logger.WithError(err).Error(s.ResultPostgres)
+		s.fail(checkPostgres, StateCrit, ErrorConnect)
+		return
+	}
+
+	conn.SetDeadline(time.Now().Add(s.timeout()))
+	conn.Write(pgSSLRequest)
+
+	reply := make([]byte, 1)
+	_, err = io.ReadFull(conn, reply)
+	conn.Close()
+	if err != nil {
+		s.ResultPostgres = "No response received from server"
+		logger.WithError(err).Error(s.ResultPostgres)
+		s.fail(checkPostgres, StateCrit, ErrorTimeout)
+		return
+	}
+
+	var tls string
+	switch reply[0] {
+	case 'S':
+		tls = "TLS available"
+	case 'N':
+		tls = "no TLS"
+	default:
+		s.ResultPostgres = fmt.Sprintf("Returned invalid SSLRequest reply 0x%02x", reply[0])
+		logger.Error(s.ResultPostgres)
+		s.fail(checkPostgres, StateCrit, ErrorResponse)
+		return
+	}
+
+	s.ResultPostgres = fmt.Sprintf("PostgreSQL, %v (connect %v)", tls, time.Since(start).Round(time.Millisecond))
+
+	if s.UserPostgres == "" {
+		logger.Infof("PostgreSQL Check OK. Response: %v", s.ResultPostgres)
+		return
+	}
+
+	if s.TLSPostgres && reply[0] != 'S' {
+		s.ResultPostgres = "PostgreSQL does not offer TLS"
+		logger.Error(s.ResultPostgres)
+		s.fail(checkPostgres, StateCrit, ErrorTLS)
+		return
+	}
+
+	connector, err := pq.NewConnector(s.postgresDSN(addr))
+	if err != nil {
+		s.ResultPostgres = "Invalid PostgreSQL configuration"
+		logger.WithError(err).Error(s.ResultPostgres)
+		s.fail(checkPostgres, StateCrit, ErrorConfig)
+		return
+	}
+	connector.Dialer(pgDialer{s})
+
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(s.runContext(), s.timeout())
+	defer cancel()
+
+	var one int
+	if err := db.QueryRowContext(ctx, "SELECT 1").Scan(&one); err != nil {
+		s.ResultPostgres = fmt.Sprintf("SELECT 1 failed: %v", err)
+		logger.WithError(err).Error("SELECT 1 failed")
+		s.fail(checkPostgres, StateCrit, ErrorResponse)
+		return
+	}
+
+	logger.Infof("PostgreSQL Check OK. Response: %v", s.ResultPostgres)
+}
+
+// postgresDSN returns the connection URL for logging in to addr. TLS is only
+// required when TLSPostgres is set, and the database defaults to the user's.
+func (s *Server) postgresDSN(addr string) string {
+	mode := "disable"
+	if s.TLSPostgres {
+		mode = "require"
+	}
+
+	query := url.Values{}
+	query.Set("sslmode", mode)
+	query.Set("connect_timeout", strconv.Itoa(int(s.timeout().Seconds())))
+
+	dsn := url.URL{
+		Scheme:   "postgres",
+		User:     url.UserPassword(s.UserPostgres, s.PasswordPostgres),
+		Host:     addr,
+		RawQuery: query.Encode(),
+	}
+	if s.DBPostgres != "" {
+		dsn.Path = "/" + s.DBPostgres
+	}
+
+	return dsn.String()
+}
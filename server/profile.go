@@ -0,0 +1,121 @@
+package server
+
+import (
+	"github.com/kisielk/sqlstruct"
+)
+
+// Profile is a reusable set of checks and thresholds servers can reference
+type Profile struct {
+	ID          int    `sql:"id"`
+	Name        string `sql:"name"`
+	EnableHTTP  bool   `sql:"enablehttp"`
+	EnableSMTP  bool   `sql:"enablesmtp"`
+	EnablePOP3  bool   `sql:"enablepop3"`
+	EnableHTTPS bool   `sql:"enablehttps"`
+	EnablePing  bool   `sql:"enableping"`
+	Timeout     int    `sql:"timeout"`
+	Interval    int    `sql:"interval"`
+	Retries     int    `sql:"retries"`
+	Backoff     int    `sql:"retrybackoff"`
+	HardAfter   int    `sql:"hardafter"`
+	ExpectHTTP  string `sql:"httpexpect"`
+	LatencyHTTP int    `sql:"httplatency"`
+
+	// Interval for member servers while they're failing
+	FailingInterval int `sql:"failinginterval"`
+
+	// Intervals of particular checks, see Server.CheckIntervals
+	CheckIntervals string `sql:"checkintervals"`
+
+	// Dependencies between checks, see Server.CheckDepends
+	CheckDepends string `sql:"checkdepends"`
+
+	// Availability objective for member servers, as a percentage
+	SLO float64 `sql:"slo"`
+	SLA float64 `sql:"sla"`
+
+	// Timezone and maintenance windows for member servers
+	Timezone    string `sql:"timezone"`
+	Maintenance string `sql:"maintenance"`
+}
+
+// applyProfile fills in anything the server doesn't set itself from its
+// profile. Checks enabled by either are run, and the server's own thresholds
+// win over the profile's, which win over the fleet defaults.
+func (s *Server) applyProfile() error {
+	if s.Profile == "" {
+		return nil
+	}
+
+	rows, err := s.DB.Query("SELECT * FROM profiles WHERE name = ?", s.Profile)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	if !rows.Next() {
+		return rows.Err()
+	}
+
+	var p Profile
+	if err := sqlstruct.Scan(&p, rows); err != nil {
+		return err
+	}
+
+	s.EnableHTTP = s.EnableHTTP || p.EnableHTTP
+	s.EnableSMTP = s.EnableSMTP || p.EnableSMTP
+	s.EnablePOP3 = s.EnablePOP3 || p.EnablePOP3
+	s.EnableHTTPS = s.EnableHTTPS || p.EnableHTTPS
+	s.EnablePing = s.EnablePing || p.EnablePing
+
+	if s.Timeout == 0 {
+		s.Timeout = p.Timeout
+	}
+
+	if s.Retries == 0 {
+		s.Retries = p.Retries
+	}
+
+	if s.RetryBackoff == 0 {
+		s.RetryBackoff = p.Backoff
+	}
+
+	if s.HardAfter == 0 {
+		s.HardAfter = p.HardAfter
+	}
+
+	if s.CheckIntervals == "" {
+		s.CheckIntervals = p.CheckIntervals
+	}
+
+	if s.CheckDepends == "" {
+		s.CheckDepends = p.CheckDepends
+	}
+
+	if s.ExpectHTTP == "" {
+		s.ExpectHTTP = p.ExpectHTTP
+	}
+
+	if s.LatencyHTTP == 0 {
+		s.LatencyHTTP = p.LatencyHTTP
+	}
+
+	if s.SLO == 0 {
+		s.SLO = p.SLO
+	}
+
+	if s.SLA == 0 {
+		s.SLA = p.SLA
+	}
+
+	if s.Timezone == "" {
+		s.Timezone = p.Timezone
+	}
+
+	if s.Maintenance == "" {
+		s.Maintenance = p.Maintenance
+	}
+
+	return nil
+}
@@ -0,0 +1,276 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+)
+
+// defaultPrometheusPort is the node exporter's, scraped when promurl is empty
+const defaultPrometheusPort = "9100"
+
+// metricSelector matches a metric name and optional labels, as in
+// `queue_size{queue="mail"}`
+var metricSelector = regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)\s*(?:\{(.*)\})?$`)
+
+// metricLabel matches one name="value" pair of a selector
+var metricLabel = regexp.MustCompile(`^\s*([a-zA-Z_][a-zA-Z0-9_]*)\s*=\s*"((?:[^"\\]|\\.)*)"\s*$`)
+
+// metricAssertion compares every series matching a selector with a number.
+// Without an operator some series only has to exist.
+type metricAssertion struct {
+	text   string
+	name   string
+	labels map[string]string
+	op     string
+	want   float64
+}
+
+// CheckPrometheus scrapes a Prometheus text format endpoint, failing if it
+// doesn't parse, and evaluates AssertPrometheus against the samples, e.g.
+// `up == 1; queue_size{queue="mail"} < 100`
+func (s *Server) CheckPrometheus(wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	if !s.EnablePrometheus {
+		return
+	}
+
+	logger := s.GetLogger("PROMETHEUS", 0)
+	s.setState(checkPrometheus, StateOK)
+
+	assertions, err := parseMetricAssertions(s.AssertPrometheus)
+	if err != nil {
+		s.ResultPrometheus = err.Error()
+		logger.Error(s.ResultPrometheus)
+		s.fail(checkPrometheus, StateCrit, ErrorConfig)
+		return
+	}
+
+	url := s.URLPrometheus
+	if url == "" {
+		url = "http://" + net.JoinHostPort(s.IP, defaultPrometheusPort) + "/metrics"
+	}
+
+	config, err := s.tlsConfig()
+	if err != nil {
+		s.ResultPrometheus = "Invalid TLS configuration"
+		logger.WithError(err).Error(s.ResultPrometheus)
+		s.fail(checkPrometheus, StateCrit, ErrorConfig)
+		return
+	}
+
+	resp, err := s.httpGet(url, config)
+	if err != nil {
+		failed := httpError(err)
+		s.ResultPrometheus = failed.Error()
+		logger.WithError(err).Error(s.ResultPrometheus)
+		s.fail(checkPrometheus, StateCrit, classOf(failed))
+		return
+	}
+
+	defer resp.Body.Close()
+
+	status := resp.Proto + " " + resp.Status
+	if !isValidHTTPResponse(status, s.expectHTTP()) {
+		s.ResultPrometheus = status
+		logger.Errorf("Returned invalid metrics response: '%v'", status)
+		s.fail(checkPrometheus, StateCrit, ErrorResponse)
+		return
+	}
+
+	parser := expfmt.NewTextParser(model.UTF8Validation)
+	families, err := parser.TextToMetricFamilies(io.LimitReader(resp.Body, maxAssertBody))
+	if err != nil {
+		s.ResultPrometheus = fmt.Sprintf("Invalid metrics: %v", err)
+		logger.WithError(err).Error("Unable to parse metrics")
+		s.fail(checkPrometheus, StateCrit, ErrorResponse)
+		return
+	}
+
+	var results, failures []string
+
+	for _, a := range assertions {
+		values := matchingSamples(families, a)
+		if len(values) == 0 {
+			failures = append(failures, fmt.Sprintf("%v not found", a.text))
+			continue
+		}
+
+		for _, value := range values {
+			if !a.holds(value) {
+				failures = append(failures, fmt.Sprintf("%v is %v, expected %v %v", a.text, formatSample(value), a.op, formatSample(a.want)))
+				break
+			}
+		}
+
+		results = append(results, fmt.Sprintf("%v %v", a.text, formatSample(values[0])))
+	}
+
+	if len(failures) > 0 {
+		s.ResultPrometheus = strings.Join(failures, "; ")
+		logger.Error(s.ResultPrometheus)
+		s.fail(checkPrometheus, StateCrit, ErrorResponse)
+		return
+	}
+
+	s.ResultPrometheus = fmt.Sprintf("%d metric families", len(families))
+	if len(results) > 0 {
+		s.ResultPrometheus += ", " + strings.Join(results, ", ")
+	}
+	logger.Infof("Prometheus Check OK. %v", s.ResultPrometheus)
+}
+
+// parseMetricAssertions reads assertions separated by semicolons or
+// newlines. None just checks that the endpoint parses.
+func parseMetricAssertions(text string) ([]metricAssertion, error) {
+	var assertions []metricAssertion
+
+	for _, part := range splitUnquoted(text) {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		a := metricAssertion{text: part}
+		selector := part
+
+		// Operators can't appear in a selector outside its quoted label values
+		if end := strings.LastIndex(part, "}"); end >= 0 || !strings.Contains(part, "{") {
+			rest := part[end+1:]
+			for _, op := range jsonOperators {
+				if i := strings.Index(rest, op); i >= 0 {
+					want, err := strconv.ParseFloat(strings.TrimSpace(rest[i+len(op):]), 64)
+					if err != nil {
+						return nil, fmt.Errorf("Invalid value in assertion '%v'", part)
+					}
+					selector, a.op, a.want = strings.TrimSpace(part[:end+1+i]), op, want
+					a.text = selector
+					break
+				}
+			}
+		}
+
+		match := metricSelector.FindStringSubmatch(selector)
+		if match == nil {
+			return nil, fmt.Errorf("Invalid metric in assertion '%v'", part)
+		}
+		a.name = match[1]
+
+		if match[2] != "" {
+			a.labels = map[string]string{}
+			for _, pair := range strings.Split(match[2], ",") {
+				if strings.TrimSpace(pair) == "" {
+					continue
+				}
+				label := metricLabel.FindStringSubmatch(pair)
+				if label == nil {
+					return nil, fmt.Errorf("Invalid label in assertion '%v'", part)
+				}
+				value, err := strconv.Unquote(`"` + label[2] + `"`)
+				if err != nil {
+					return nil, fmt.Errorf("Invalid label in assertion '%v'", part)
+				}
+				a.labels[label[1]] = value
+			}
+		}
+
+		assertions = append(assertions, a)
+	}
+
+	return assertions, nil
+}
+
+// matchingSamples returns the values of the series with the assertion's name
+// and labels. Summaries and histograms are matched by their _sum and _count.
+func matchingSamples(families map[string]*dto.MetricFamily, a metricAssertion) []float64 {
+	name, part := a.name, ""
+	family, ok := families[name]
+	if !ok {
+		for _, suffix := range []string{"_sum", "_count"} {
+			if base := strings.TrimSuffix(name, suffix); base != name {
+				family, part = families[base], suffix
+			}
+		}
+	}
+	if family == nil {
+		return nil
+	}
+
+	var values []float64
+
+	for _, metric := range family.GetMetric() {
+		if !labelsMatch(metric.GetLabel(), a.labels) {
+			continue
+		}
+
+		switch {
+		case metric.Gauge != nil && part == "":
+			values = append(values, metric.GetGauge().GetValue())
+		case metric.Counter != nil && part == "":
+			values = append(values, metric.GetCounter().GetValue())
+		case metric.Untyped != nil && part == "":
+			values = append(values, metric.GetUntyped().GetValue())
+		case metric.Summary != nil && part == "_sum":
+			values = append(values, metric.GetSummary().GetSampleSum())
+		case metric.Summary != nil && part == "_count":
+			values = append(values, float64(metric.GetSummary().GetSampleCount()))
+		case metric.Histogram != nil && part == "_sum":
+			values = append(values, metric.GetHistogram().GetSampleSum())
+		case metric.Histogram != nil && part == "_count":
+			values = append(values, float64(metric.GetHistogram().GetSampleCount()))
+		}
+	}
+
+	return values
+}
+
+// labelsMatch reports whether a series has every wanted label value
+func labelsMatch(pairs []*dto.LabelPair, want map[string]string) bool {
+	have := map[string]string{}
+	for _, pair := range pairs {
+		have[pair.GetName()] = pair.GetValue()
+	}
+
+	for name, value := range want {
+		if have[name] != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// holds compares a sample with the expected value
+func (a metricAssertion) holds(value float64) bool {
+	switch a.op {
+	case "==":
+		return value == a.want
+	case "!=":
+		return value != a.want
+	case "<":
+		return value < a.want
+	case "<=":
+		return value <= a.want
+	case ">":
+		return value > a.want
+	case ">=":
+		return value >= a.want
+	}
+
+	return true
+}
+
+// formatSample prints a sample value without needless decimals
+func formatSample(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,265 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RADIUS packet codes, RFC 2865
+const (
+	radiusAccessRequest   = 1
+	radiusAccessAccept    = 2
+	radiusAccessReject    = 3
+	radiusAccessChallenge = 11
+)
+
+// RADIUS attribute types
+const (
+	radiusUserName      = 1
+	radiusUserPassword  = 2
+	radiusReplyMessage  = 18
+	radiusNASIdentifier = 32
+	radiusMessageAuth   = 80
+)
+
+// radiusAttempts is how many times a request is sent before giving up,
+// since it travels over UDP
+const radiusAttempts = 3
+
+// CheckRADIUS sends a PAP Access-Request for UserRADIUS to the server on UDP
+// 1812, signed with the shared SecretRADIUS, and expects an Access-Accept.
+// An Access-Challenge, e.g. for a second factor, only warns.
+func (s *Server) CheckRADIUS(wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	if !s.EnableRADIUS {
+		return
+	}
+
+	port := s.PortRADIUS
+	if port == 0 {
+		port = 1812
+	}
+
+	logger := s.GetLogger("RADIUS", port)
+	s.setState(checkRADIUS, StateOK)
+
+	// UDP can't be carried over an SSH tunnel
+	if spec, _ := s.sshJump(); spec != "" {
+		s.ResultRADIUS = "RADIUS not available through SSH jump host"
+		logger.Error(s.ResultRADIUS)
+		s.fail(checkRADIUS, StateCrit, ErrorConfig)
+		return
+	}
+
+	if s.SecretRADIUS == "" || s.UserRADIUS == "" {
+		s.ResultRADIUS = "RADIUS needs a shared secret and test user"
+		logger.Error(s.ResultRADIUS)
+		s.fail(checkRADIUS, StateCrit, ErrorConfig)
+		return
+	}
+
+	request, authenticator, err := radiusRequest(s.UserRADIUS, s.PasswordRADIUS, s.SecretRADIUS)
+	if err != nil {
+		s.ResultRADIUS = "Unable to build Access-Request"
+		logger.WithError(err).Error(s.ResultRADIUS)
+		s.fail(checkRADIUS, StateCrit, ErrorConfig)
+		return
+	}
+
+	conn, err := net.DialTimeout("udp", net.JoinHostPort(s.IP, strconv.Itoa(port)), s.timeout())
+	if err != nil {
+		s.ResultRADIUS = "Unable to open UDP socket"
+		logger.WithError(err).Error(s.ResultRADIUS)
+		s.fail(checkRADIUS, StateCrit, ErrorConnect)
+		return
+	}
+
+	defer conn.Close()
+
+	start := time.Now()
+	reply, err := radiusExchange(conn, request, s.timeout())
+	if err != nil {
+		s.ResultRADIUS = "No response received from server"
+		logger.WithError(err).Error(s.ResultRADIUS)
+		s.fail(checkRADIUS, StateCrit, ErrorTimeout)
+		return
+	}
+	took := time.Since(start).Round(time.Millisecond)
+
+	if err := radiusVerify(reply, request[1], authenticator, s.SecretRADIUS); err != nil {
+		s.ResultRADIUS = fmt.Sprintf("Invalid reply: %v", err)
+		logger.Error(s.ResultRADIUS)
+		s.fail(checkRADIUS, StateCrit, ErrorResponse)
+		return
+	}
+
+	message := ""
+	if text := radiusAttribute(reply, radiusReplyMessage); text != nil {
+		message = fmt.Sprintf(": %s", text)
+	}
+
+	switch reply[0] {
+	case radiusAccessAccept:
+		s.ResultRADIUS = fmt.Sprintf("Access-Accept for %v (%v)%v", s.UserRADIUS, took, message)
+		logger.Infof("RADIUS Check OK. Response: %v", s.ResultRADIUS)
+	case radiusAccessChallenge:
+		s.ResultRADIUS = fmt.Sprintf("Access-Challenge for %v (%v)%v", s.UserRADIUS, took, message)
+		logger.Warn(s.ResultRADIUS)
+		s.fail(checkRADIUS, StateWarn, ErrorResponse)
+	case radiusAccessReject:
+		s.ResultRADIUS = fmt.Sprintf("Access-Reject for %v (%v)%v", s.UserRADIUS, took, message)
+		logger.Error(s.ResultRADIUS)
+		s.fail(checkRADIUS, StateCrit, ErrorAuth)
+	default:
+		s.ResultRADIUS = fmt.Sprintf("Unexpected reply code %d", reply[0])
+		logger.Error(s.ResultRADIUS)
+		s.fail(checkRADIUS, StateCrit, ErrorResponse)
+	}
+}
+
+// radiusRequest builds an Access-Request with a PAP password and a
+// Message-Authenticator, which servers patched for BlastRADIUS require. It
+// returns the packet and its Request Authenticator.
+func radiusRequest(user string, password string, secret string) ([]byte, []byte, error) {
+	header := make([]byte, 20)
+	if _, err := rand.Read(header[1:20]); err != nil {
+		return nil, nil, err
+	}
+	header[0] = radiusAccessRequest
+	authenticator := header[4:20]
+
+	// Message-Authenticator goes first and is signed while still zero
+	attrs := radiusAppend(nil, radiusMessageAuth, make([]byte, md5.Size))
+	attrs = radiusAppend(attrs, radiusUserName, []byte(user))
+	attrs = radiusAppend(attrs, radiusUserPassword, radiusHidePassword(password, secret, authenticator))
+	attrs = radiusAppend(attrs, radiusNASIdentifier, []byte("vbms"))
+
+	packet := append(header, attrs...)
+	if len(packet) > 4096 {
+		return nil, nil, errors.New("request too large")
+	}
+	binary.BigEndian.PutUint16(packet[2:4], uint16(len(packet)))
+
+	mac := hmac.New(md5.New, []byte(secret))
+	mac.Write(packet)
+	copy(packet[22:38], mac.Sum(nil))
+
+	return packet, append([]byte(nil), authenticator...), nil
+}
+
+// radiusAppend adds an attribute, keeping within the 253 byte value limit
+func radiusAppend(attrs []byte, kind byte, value []byte) []byte {
+	if len(value) > 253 {
+		value = value[:253]
+	}
+
+	return append(append(attrs, kind, byte(len(value)+2)), value...)
+}
+
+// radiusHidePassword obscures a PAP password as RFC 2865 section 5.2 does,
+// chaining MD5 of the secret over each 16 byte block
+func radiusHidePassword(password string, secret string, authenticator []byte) []byte {
+	padded := []byte(password)
+	if len(padded) == 0 || len(padded)%16 != 0 {
+		padded = append(padded, make([]byte, 16-len(padded)%16)...)
+	}
+	if len(padded) > 128 {
+		padded = padded[:128]
+	}
+
+	hidden := make([]byte, len(padded))
+	previous := authenticator
+
+	for i := 0; i < len(padded); i += 16 {
+		sum := md5.Sum(append([]byte(secret), previous...))
+		for j := 0; j < 16; j++ {
+			hidden[i+j] = padded[i+j] ^ sum[j]
+		}
+		previous = hidden[i : i+16]
+	}
+
+	return hidden
+}
+
+// radiusExchange sends the request until a reply with its identifier arrives,
+// spreading the attempts over the timeout
+func radiusExchange(conn net.Conn, request []byte, timeout time.Duration) ([]byte, error) {
+	buf := make([]byte, 4096)
+	var err error
+
+	for attempt := 0; attempt < radiusAttempts; attempt++ {
+		if _, err = conn.Write(request); err != nil {
+			return nil, err
+		}
+
+		deadline := time.Now().Add(timeout / radiusAttempts)
+		conn.SetReadDeadline(deadline)
+
+		for time.Now().Before(deadline) {
+			var n int
+			if n, err = conn.Read(buf); err != nil {
+				break
+			}
+			if n >= 20 && buf[1] == request[1] {
+				return append([]byte(nil), buf[:n]...), nil
+			}
+		}
+	}
+
+	return nil, err
+}
+
+// radiusVerify checks a reply's length and Response Authenticator, which
+// only matches when both sides share the secret
+func radiusVerify(reply []byte, id byte, authenticator []byte, secret string) error {
+	length := int(binary.BigEndian.Uint16(reply[2:4]))
+	if length < 20 || length > len(reply) {
+		return errors.New("bad length")
+	}
+	reply = reply[:length]
+
+	if reply[1] != id {
+		return errors.New("identifier mismatch")
+	}
+
+	sum := md5.New()
+	sum.Write(reply[:4])
+	sum.Write(authenticator)
+	sum.Write(reply[20:])
+	sum.Write([]byte(secret))
+
+	if !bytes.Equal(sum.Sum(nil), reply[4:20]) {
+		return errors.New("response authenticator mismatch, check the shared secret")
+	}
+
+	return nil
+}
+
+// radiusAttribute returns the value of the first attribute of a kind
+func radiusAttribute(packet []byte, kind byte) []byte {
+	attrs := packet[20:]
+
+	for len(attrs) >= 2 {
+		size := int(attrs[1])
+		if size < 2 || size > len(attrs) {
+			return nil
+		}
+		if attrs[0] == kind {
+			return attrs[2:size]
+		}
+		attrs = attrs[size:]
+	}
+
+	return nil
+}
@@ -0,0 +1,24 @@
+package server
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// RequestRecheck queues the server called hostname to be checked in the
+// next batch, whether it's due or not, with every check run whatever its
+// interval. The request holds until an instance has claimed the server
+// after it was made, so it isn't lost to a run already underway.
+func RequestRecheck(db *sql.DB, hostname string) error {
+	res, err := db.Exec("UPDATE servers SET recheckat = ? WHERE hostname = ?", time.Now().Unix(), hostname)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("%w %v", errUnknownServer, hostname)
+	}
+
+	return nil
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/kisielk/sqlstruct"
+	"golang.org/x/crypto/ssh"
+)
+
+// maxRemediationOutput caps how much command output is kept in the audit log
+const maxRemediationOutput = 64 << 10
+
+// Remediation is a command run over SSH when a check on a server fails
+type Remediation struct {
+	ID       int    `sql:"id"`
+	ServerID int    `sql:"serverid"`
+	Check    string `sql:"checkname"`
+	Command  string `sql:"command"`
+	User     string `sql:"sshuser"`
+	Key      string `sql:"sshkey"`
+	Port     int    `sql:"sshport"`
+	FiredAt  int64  `sql:"firedat"`
+}
+
+// remediate runs the remediation for each failed check at most once per
+// incident, re-arming it once the check passes again
+func (s *Server) remediate() {
+	logger := s.GetLogger("REMEDIATE", 0)
+
+	// Planned work is expected to break things
+	if s.inMaintenance(time.Now()) {
+		return
+	}
+
+	rows, err := s.DB.Query("SELECT * FROM remediations WHERE serverid = ?", s.ID)
+	if err != nil {
+		logger.WithError(err).Error("Unable to load remediations")
+		return
+	}
+
+	var actions []Remediation
+	for rows.Next() {
+		var r Remediation
+		if err := sqlstruct.Scan(&r, rows); err != nil {
+			logger.WithError(err).Error("Unable to load remediation")
+			continue
+		}
+		actions = append(actions, r)
+	}
+	rows.Close()
+
+	for _, r := range actions {
+		// Warnings aren't outages, so only a confirmed CRIT triggers remediation
+		failed := s.hardState(r.Check) == StateCrit
+
+		switch {
+		case !failed && r.FiredAt > 0:
+			// Recovered, so the next failure is a new incident
+			s.DB.Exec("UPDATE remediations SET firedat = 0 WHERE id = ?", r.ID)
+		case failed && r.FiredAt == 0:
+			s.runRemediation(r)
+		}
+	}
+}
+
+// runRemediation executes a remediation and writes the audit log
+func (s *Server) runRemediation(r Remediation) {
+	logger := s.GetLogger("REMEDIATE", r.Port).WithField("Check", r.Check)
+	now := time.Now().Unix()
+
+	// Mark as fired first, so a crash can't make it run twice
+	if _, err := s.DB.Exec("UPDATE remediations SET firedat = ? WHERE id = ?", now, r.ID); err != nil {
+		logger.WithError(err).Error("Unable to record remediation")
+		return
+	}
+
+	logger.Warnf("Running remediation: %v", r.Command)
+
+	output, status, err := s.runSSH(r)
+	if err != nil {
+		output = fmt.Sprintf("%v\n%v", output, err)
+	}
+	if len(output) > maxRemediationOutput {
+		output = output[:maxRemediationOutput]
+	}
+
+	_, dbErr := s.DB.Exec(`
+		INSERT INTO remediationlog (remediationid, serverid, checkname, command, ranat, exitstatus, output)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, r.ID, s.ID, r.Check, r.Command, now, status, output)
+	if dbErr != nil {
+		logger.WithError(dbErr).Error("Unable to write remediation audit log")
+	}
+
+	entry := logger.WithField("ExitStatus", status).WithField("Output", output)
+	if status == 0 {
+		entry.Info("Remediation completed")
+	} else {
+		entry.Error("Remediation failed")
+	}
+}
+
+// runSSH runs the remediation command on the server, returning its combined
+// output and exit status. Status is -1 when the command couldn't be run or
+// was cut off after RUN_TIMEOUT, so a hung command can't hold the server's
+// claim.
+func (s *Server) runSSH(r Remediation) (string, int, error) {
+	pem, err := os.ReadFile(Interpolate(r.Key))
+	if err != nil {
+		return "", -1, err
+	}
+
+	signer, err := ssh.ParsePrivateKey(pem)
+	if err != nil {
+		return "", -1, err
+	}
+
+	hostKeys, err := knownHosts()
+	if err != nil {
+		return "", -1, err
+	}
+
+	port := r.Port
+	if port == 0 {
+		port = 22
+	}
+	addr := net.JoinHostPort(s.IP, strconv.Itoa(port))
+
+	// Connect the same way checks do, so bastions are honoured
+	conn, err := s.dial(addr)
+	if err != nil {
+		return "", -1, err
+	}
+
+	// ClientConfig.Timeout only covers ssh.Dial, so bound the handshake here
+	conn.SetDeadline(time.Now().Add(s.timeout()))
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, &ssh.ClientConfig{
+		User:            r.User,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeys,
+		Timeout:         s.timeout(),
+	})
+	if err != nil {
+		conn.Close()
+		return "", -1, err
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	client := ssh.NewClient(sshConn, chans, reqs)
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return "", -1, err
+	}
+
+	defer session.Close()
+
+	// Closing the connection ends a command that runs too long
+	timeout := runTimeout()
+	timer := time.AfterFunc(timeout, func() { client.Close() })
+
+	output, err := session.CombinedOutput(r.Command)
+	if !timer.Stop() {
+		return string(output), -1, fmt.Errorf("Timed out after %v", timeout)
+	}
+	if exit, ok := err.(*ssh.ExitError); ok {
+		return string(output), exit.ExitStatus(), nil
+	}
+	if err != nil {
+		return string(output), -1, err
+	}
+
+	return string(output), 0, nil
+}
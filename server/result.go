@@ -0,0 +1,136 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrorClass groups why a check failed, so failures can be counted or routed
+// without reading the result text
+type ErrorClass string
+
+// Error classes. A check that passed has none.
+const (
+	ErrorConfig   ErrorClass = "config"
+	ErrorDNS      ErrorClass = "dns"
+	ErrorConnect  ErrorClass = "connect"
+	ErrorTimeout  ErrorClass = "timeout"
+	ErrorTLS      ErrorClass = "tls"
+	ErrorAuth     ErrorClass = "auth"
+	ErrorResponse ErrorClass = "response"
+
+	// ErrorUnreachable is a failure behind a parent that is down, and
+	// ErrorDependency a check not run as one it depends on failed
+	ErrorUnreachable ErrorClass = "unreachable"
+	ErrorDependency  ErrorClass = "dependency"
+)
+
+// checkError is a failure that knows its class, for helpers that can fail
+// in more than one way and leave their callers to record the result
+type checkError struct {
+	class ErrorClass
+	msg   string
+}
+
+func (e *checkError) Error() string {
+	return e.msg
+}
+
+// failure returns an error of the given class, formatted as fmt.Errorf does
+func failure(class ErrorClass, format string, a ...interface{}) error {
+	return &checkError{class, fmt.Sprintf(format, a...)}
+}
+
+// classOf returns the class err was given where it occurred. Errors without
+// one are response errors, as the server answered but not as hoped.
+func classOf(err error) ErrorClass {
+	var failed *checkError
+	if errors.As(err, &failed) {
+		return failed.class
+	}
+
+	return ErrorResponse
+}
+
+// CheckResult is the outcome of one check on a run, as stored in
+// check_results
+type CheckResult struct {
+	ServerID   int        `sql:"serverid"`
+	Check      string     `sql:"checkname"`
+	Status     State      `sql:"status"`
+	Message    string     `sql:"message"`
+	Latency    float64    `sql:"latency"`
+	CheckedAt  int64      `sql:"checkedat"`
+	ErrorClass ErrorClass `sql:"errorclass"`
+}
+
+// Results returns the outcome of each check run on the last run, with the
+// latency in milliseconds
+func (s *Server) Results() []CheckResult {
+	var results []CheckResult
+	now := time.Now()
+
+	for i, st := range s.states() {
+		if *st == "" || s.skipped[i] {
+			continue
+		}
+
+		result := CheckResult{
+			ServerID:  s.ID,
+			Check:     checkNames[i],
+			Status:    *st,
+			Message:   *s.results()[i],
+			Latency:   float64(s.durations[i].Microseconds()) / 1000,
+			CheckedAt: now.Unix(),
+		}
+		if *st != StateOK {
+			result.ErrorClass = s.classes[i]
+		}
+
+		results = append(results, result)
+	}
+
+	return results
+}
+
+// recordResults updates the server's rows in check_results with this run's
+func (s *Server) recordResults() {
+	logger := s.GetLogger("RESULTS", 0)
+	results := s.Results()
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		logger.WithError(err).Error("Unable to record check results")
+		return
+	}
+
+	// Checks that have been disabled no longer have a result, while those
+	// that weren't due keep theirs
+	for i, st := range s.states() {
+		if *st != "" {
+			continue
+		}
+		if _, err := tx.Exec("DELETE FROM check_results WHERE serverid = ? AND checkname = ?", s.ID, checkNames[i]); err != nil {
+			tx.Rollback()
+			logger.WithError(err).Error("Unable to record check results")
+			return
+		}
+	}
+
+	for _, r := range results {
+		_, err := tx.Exec(`
+			INSERT OR REPLACE INTO check_results (serverid, checkname, status, message, latency, checkedat, errorclass)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+		`, r.ServerID, r.Check, r.Status, r.Message, r.Latency, r.CheckedAt, r.ErrorClass)
+		if err != nil {
+			tx.Rollback()
+			logger.WithError(err).Error("Unable to record check results")
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		logger.WithError(err).Error("Unable to record check results")
+	}
+}
@@ -0,0 +1,31 @@
+package server
+
+import "time"
+
+// attempts returns how many times check i may run in a run. Checks computed
+// from what vbms has already recorded, rather than by asking the server,
+// would only fail the same way again and get one attempt.
+func (s *Server) attempts(i int) int {
+	if i == checkSLO || i == checkHeartbeat {
+		return 1
+	}
+
+	retries := s.Retries
+	if retries == 0 {
+		retries = Default.Retries
+	}
+	if retries < 0 {
+		retries = 0
+	}
+
+	return retries + 1
+}
+
+// retryBackoff returns how long to wait before the first retry of a check
+func (s *Server) retryBackoff() time.Duration {
+	if s.RetryBackoff > 0 {
+		return time.Millisecond * time.Duration(s.RetryBackoff)
+	}
+
+	return Default.RetryBackoff
+}
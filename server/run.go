@@ -0,0 +1,200 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// checkRun is a check launched on a run against outcome, its own copy of
+// server. timeCheck sends it on the run's channel once the check has
+// finished, and only the collector copies the outcome into server, so
+// nothing is persisted while a check is still writing it.
+type checkRun struct {
+	server   *Server
+	check    int
+	attempts int
+	outcome  *Server
+}
+
+// runInfo describes a run, recorded in the runs table once it's complete
+type runInfo struct {
+	started  time.Time
+	finished time.Time
+	checks   int
+	retried  int
+	timedOut int
+
+	// interrupted is set when the run was cancelled before its checks
+	// reported, so its results are discarded
+	interrupted bool
+}
+
+// runContext returns the context checks make their requests in, cancelled
+// when vbms shuts down
+func (s *Server) runContext() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+
+	return s.ctx
+}
+
+// runTimeout returns how long a run waits for its checks to report
+func runTimeout() time.Duration {
+	if Default.RunTimeout > 0 {
+		return Default.RunTimeout
+	}
+
+	return 5 * time.Minute
+}
+
+// collect waits for each launched check to report on done, up to
+// runTimeout after the run started, adopting each outcome as it arrives. A
+// check still running then is marked CRIT as timed out and abandoned, its
+// late report dropped, so one hung check can't hold back the rest of the
+// server's results.
+func (s *Server) collect(done <-chan checkRun, launched []checkRun) {
+	timeout := runTimeout()
+	timer := time.NewTimer(time.Until(s.run.started.Add(timeout)))
+	defer timer.Stop()
+
+	pending := map[*Server]checkRun{}
+	for _, run := range launched {
+		pending[run.outcome] = run
+	}
+
+	for len(pending) > 0 {
+		select {
+		case <-s.runContext().Done():
+			s.run.interrupted = true
+			return
+		case reported := <-done:
+			run, ok := pending[reported.outcome]
+			if !ok {
+				continue
+			}
+			delete(pending, reported.outcome)
+			run.server.adopt(run.check, reported.outcome)
+
+			// Disabled checks report too, without a state
+			if *run.server.states()[run.check] == "" {
+				continue
+			}
+			s.run.checks++
+			if reported.attempts > 1 {
+				s.run.retried++
+			}
+		case <-timer.C:
+			for _, run := range pending {
+				*run.server.results()[run.check] = fmt.Sprintf("Check timed out after %v", timeout)
+				*run.server.states()[run.check] = StateCrit
+				run.server.classes[run.check] = ErrorTimeout
+				run.server.GetLogger("RUN", 0).Errorf("%v check still running after %v, giving up on it", checkNames[run.check], timeout)
+				s.run.timedOut++
+			}
+			return
+		}
+	}
+}
+
+// adopt copies what check i found, and the bookkeeping it keeps, from out,
+// the copy of the server it ran against
+func (s *Server) adopt(i int, out *Server) {
+	*s.results()[i] = *out.results()[i]
+	*s.states()[i] = *out.states()[i]
+	s.durations[i] = out.durations[i]
+	s.expiries[i] = out.expiries[i]
+	s.classes[i] = out.classes[i]
+
+	switch i {
+	case checkHTTP:
+		s.DNSTimeHTTP, s.ConnectTimeHTTP, s.TLSTimeHTTP, s.TTFBHTTP, s.TotalTimeHTTP = out.DNSTimeHTTP, out.ConnectTimeHTTP, out.TLSTimeHTTP, out.TTFBHTTP, out.TotalTimeHTTP
+		s.BytesHTTP, s.BaselineHTTP, s.DownloadTimeHTTP = out.BytesHTTP, out.BaselineHTTP, out.DownloadTimeHTTP
+		s.SHA256HTTP = out.SHA256HTTP
+	case checkHTTPS:
+		s.DNSTimeHTTPS, s.ConnectTimeHTTPS, s.TLSTimeHTTPS, s.TTFBHTTPS, s.TotalTimeHTTPS = out.DNSTimeHTTPS, out.ConnectTimeHTTPS, out.TLSTimeHTTPS, out.TTFBHTTPS, out.TotalTimeHTTPS
+		s.BytesHTTPS, s.BaselineHTTPS, s.DownloadTimeHTTPS = out.BytesHTTPS, out.BaselineHTTPS, out.DownloadTimeHTTPS
+		s.SHA256HTTPS = out.SHA256HTTPS
+		s.TLSVersion, s.TLSCipher = out.TLSVersion, out.TLSCipher
+	case checkPing:
+		s.PingLoss, s.PingMin, s.PingAvg, s.PingMax, s.PingJitter = out.PingLoss, out.PingMin, out.PingAvg, out.PingMax, out.PingJitter
+	case checkDomain:
+		s.DomainExpiry, s.DomainAt = out.DomainExpiry, out.DomainAt
+	case checkJSON:
+		s.ValuesJSON = out.ValuesJSON
+	case checkHeartbeat:
+		s.HeartbeatAt = out.HeartbeatAt
+	case checkKafka:
+		s.BrokersKafka = out.BrokersKafka
+	case checkSource:
+		s.NameSource, s.PlayersSource, s.MaxPlayersSource = out.NameSource, out.PlayersSource, out.MaxPlayersSource
+	case checkMinecraft:
+		s.MOTDMinecraft, s.PlayersMinecraft, s.MaxPlayersMinecraft = out.MOTDMinecraft, out.PlayersMinecraft, out.MaxPlayersMinecraft
+	}
+}
+
+// persist stores the results once the run is complete, then acts on them.
+// An interrupted run's results are incomplete, and cancelled checks would
+// read as failures, so the server is released to be checked again instead.
+func (s *Server) persist() {
+	s.run.finished = time.Now()
+
+	if s.run.interrupted {
+		s.GetLogger("RUN", 0).Warn("Run interrupted, discarding its results")
+		s.Release()
+		return
+	}
+
+	s.markUnreachable()
+	s.recordPing()
+	s.Failing = s.failing()
+	s.UpdateDatabase()
+	s.confirmStates()
+	s.trackIncident()
+	s.recordResults()
+	s.recordHistory()
+	s.recordRun()
+	s.notify()
+	s.traceOnFailure()
+	s.remediate()
+	s.releaseClaim()
+}
+
+// failing reports whether any check is CRIT, so the server is checked every
+// FailingInterval until it recovers. Unreachable servers wait for their
+// parent instead.
+func (s *Server) failing() bool {
+	for _, st := range s.states() {
+		if *st == StateCrit {
+			return true
+		}
+	}
+
+	return false
+}
+
+// recordRun appends when the run started and finished, how many checks ran,
+// were retried, weren't due or timed out, and the worst state it found
+func (s *Server) recordRun() {
+	var worst State
+	skipped := 0
+	for i, st := range s.states() {
+		if s.skipped[i] {
+			skipped++
+			continue
+		}
+		if severity[*st] > severity[worst] {
+			worst = *st
+		}
+	}
+
+	_, err := s.DB.Exec(`
+		INSERT INTO runs (serverid, instance, startedat, finishedat, duration, checks, retried, skipped, timedout, state)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, s.ID, s.ClaimedBy, s.run.started.Unix(), s.run.finished.Unix(), s.run.finished.Sub(s.run.started).Milliseconds(),
+		s.run.checks, s.run.retried, skipped, s.run.timedOut, worst)
+	if err != nil {
+		s.GetLogger("RUN", 0).WithError(err).Error("Unable to record run")
+	}
+}
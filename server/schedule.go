@@ -0,0 +1,100 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// checkIntervals parses CheckIntervals, e.g. "ping=60, domain=24h,
+// dnsbl=168h", a comma separated list of check names and how often they run
+// as seconds or a Go duration
+func (s *Server) checkIntervals() (map[string]time.Duration, error) {
+	intervals := map[string]time.Duration{}
+
+	for _, part := range strings.Split(s.CheckIntervals, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		name, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("Invalid check interval '%v'", part)
+		}
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+
+		every, err := time.ParseDuration(value)
+		if seconds, serr := strconv.Atoi(value); serr == nil {
+			every, err = time.Duration(seconds)*time.Second, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Invalid interval for %v: %v", name, err)
+		}
+
+		intervals[name] = every
+	}
+
+	for name := range intervals {
+		if !isCheckName(name) {
+			return nil, fmt.Errorf("Unknown check '%v'", name)
+		}
+	}
+
+	return intervals, nil
+}
+
+// isCheckName reports whether name is one of checkNames
+func isCheckName(name string) bool {
+	for _, check := range checkNames {
+		if check == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// schedule loads the state of each check from the last run and marks the
+// checks whose own interval hasn't elapsed since they last ran as skipped,
+// keeping their last result. The server's interval still decides when it is
+// checked at all, so a check runs on the first check of the server after it
+// is due.
+func (s *Server) schedule() {
+	logger := s.GetLogger("SCHEDULE", 0)
+
+	prev, err := s.loadCheckStates()
+	if err != nil {
+		logger.WithError(err).Error("Unable to load check states")
+		return
+	}
+	s.previous = prev
+
+	if s.Force || s.RecheckAt > 0 {
+		return
+	}
+
+	intervals, err := s.checkIntervals()
+	if err != nil {
+		logger.WithError(err).Error("Invalid check intervals, running every check")
+		return
+	}
+
+	now := time.Now()
+	enabled := s.enabled()
+	for i, name := range checkNames {
+		every, ok := intervals[name]
+		cs, ran := prev[name]
+		if !ok || !ran || cs.LastRun == 0 || !enabled[i] {
+			continue
+		}
+
+		// Without a result to keep, e.g. after being enabled again, run it
+		if *s.states()[i] == "" {
+			continue
+		}
+
+		s.skipped[i] = now.Before(time.Unix(cs.LastRun, 0).Add(every))
+	}
+}
@@ -2,7 +2,9 @@ package server
 
 import (
 	"bufio"
+	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"log"
 	"net"
@@ -17,7 +19,6 @@ import (
 
 	"github.com/Sirupsen/logrus"
 	"github.com/kisielk/sqlstruct"
-	fastping "github.com/tatsushid/go-fastping"
 )
 
 // Server holds details for current server
@@ -30,13 +31,522 @@ type Server struct {
 	EnableSMTP  bool   `sql:"enablestmp"`
 	ResultSMTP  string `sql:"smtpresult"`
 	PortSMTP    int    `sql:"smtpport"`
+	BannerSMTP  string `sql:"smtpbanner"`
+	TLSSMTP     bool   `sql:"smtptls"`
+	StartTLS    bool   `sql:"smtpstarttls"`
+	FromSMTP    string `sql:"smtpfrom"`
+	RcptSMTP    string `sql:"smtprcpt"`
 	EnablePOP3  bool   `sql:"enablepop3"`
 	ResultPOP3  string `sql:"pop3result"`
+	BannerPOP3  string `sql:"pop3banner"`
+	UserPOP3    string `sql:"pop3user"`
+	PassPOP3    string `sql:"pop3password"`
+	EnablePOP3S bool   `sql:"enablepop3s"`
+	ResultPOP3S string `sql:"pop3sresult"`
 	EnableHTTPS bool   `sql:"enablehttps"`
 	ResultHTTPS string `sql:"httpsresult"`
+	TLSCAFile   string `sql:"tlscafile"`
+	TLSInsecure bool   `sql:"tlsinsecure"`
+	TLSNoHost   bool   `sql:"tlsskiphostname"`
+	TLSName     string `sql:"tlsservername"`
+	TLSCert     string `sql:"tlscert"`
+	TLSKey      string `sql:"tlskey"`
+	TLSPolicy   string `sql:"tlspolicy"`
+	OCSPHTTPS   bool   `sql:"httpsocsp"`
 	EnablePing  bool   `sql:"enableping"`
 	ResultPing  string `sql:"pingresult"`
+	Timeout     int    `sql:"timeout"`
+	Interval    int    `sql:"interval"`
+
+	// While Failing, the server is checked every FailingInterval seconds
+	// instead, until it recovers
+	FailingInterval int  `sql:"failinginterval"`
+	Failing         bool `sql:"failing"`
+
+	// Extra attempts a failing check gets, waiting RetryBackoff milliseconds
+	// before the first and twice as long before each one after
+	Retries      int `sql:"retries"`
+	RetryBackoff int `sql:"retrybackoff"`
+
+	// Consecutive non-OK runs before a check's hard state follows it
+	HardAfter int `sql:"hardafter"`
+
+	// How often particular checks run, when less often than the server is
+	// checked, e.g. "domain=24h, dnsbl=168h"
+	CheckIntervals string `sql:"checkintervals"`
+
+	// Checks that only run once others have passed, e.g. "https=ping"
+	CheckDepends string `sql:"checkdepends"`
+
+	ExpectHTTP  string `sql:"httpexpect"`
+	PathHTTP    string `sql:"httppath"`
+	HostHTTP    string `sql:"httphost"`
+	Redirects   int    `sql:"httpredirects"`
+	UserAgent   string `sql:"httpuseragent"`
+	HeadersHTTP string `sql:"httpheaders"`
+	AssertHTTP  string `sql:"httpassert"`
+	MatchHTTP   string `sql:"httpmatch"`
+	MinBodyHTTP int64  `sql:"httpminbody"`
+	MaxBodyHTTP int64  `sql:"httpmaxbody"`
+	LengthHTTP  int64  `sql:"httplength"`
+	AuthHTTP    string `sql:"httpauth"`
+	User        string `sql:"httpuser"`
+	Password    string `sql:"httppassword"`
+	Token       string `sql:"httptoken"`
+	OAuthURL    string `sql:"oauthtokenurl"`
+	OAuthID     string `sql:"oauthclientid"`
+	OAuthSecret string `sql:"oauthclientsecret"`
+	OAuthScopes string `sql:"oauthscopes"`
+	SSHJump     string `sql:"sshjump"`
+	SSHJumpKey  string `sql:"sshjumpkey"`
+	Proxy       string `sql:"proxy"`
+	AllAddrs    bool   `sql:"checkalladdrs"`
+	Profile     string `sql:"profile"`
+	Tags        string `sql:"tags"`
+	Parents     string `sql:"parents"`
 	DB          *sql.DB
+
+	// Force runs every enabled check on the next run, whether it's due or
+	// not, for one-off runs from the command line
+	Force bool
+
+	// Paused servers aren't checked, see SetPaused
+	Paused       bool   `sql:"paused"`
+	PausedAt     int64  `sql:"pausedat"`
+	PausedReason string `sql:"pausedreason"`
+
+	// The instance checking the server, and since when, released once the
+	// checks are done
+	ClaimedBy string `sql:"claimedby"`
+	ClaimedAt int64  `sql:"claimedat"`
+
+	// When a recheck was requested, see RequestRecheck
+	RecheckAt int64 `sql:"recheckat"`
+
+	// Check plugins to run, their settings as a JSON object keyed by plugin
+	// name, and their combined results
+	Plugins       string `sql:"plugins"`
+	PluginConfig  string `sql:"pluginconfig"`
+	ResultPlugins string `sql:"pluginresult"`
+
+	// Severity of each check's last result, OK, WARN or CRIT
+	StateHTTP    State `sql:"httpstate"`
+	StateSMTP    State `sql:"smtpstate"`
+	StatePOP3    State `sql:"pop3state"`
+	StatePOP3S   State `sql:"pop3sstate"`
+	StateHTTPS   State `sql:"httpsstate"`
+	StatePing    State `sql:"pingstate"`
+	StatePlugins State `sql:"pluginstate"`
+
+	// IMAP, with implicit TLS when TLSIMAP is set and an optional LOGIN
+	EnableIMAP   bool   `sql:"enableimap"`
+	TLSIMAP      bool   `sql:"imaptls"`
+	PortIMAP     int    `sql:"imapport"`
+	UserIMAP     string `sql:"imapuser"`
+	PasswordIMAP string `sql:"imappassword"`
+	ResultIMAP   string `sql:"imapresult"`
+	StateIMAP    State  `sql:"imapstate"`
+
+	// SSH banner, and the expected host key as "SHA256:..." from ssh-keygen -l
+	EnableSSH      bool   `sql:"enablessh"`
+	PortSSH        int    `sql:"sshport"`
+	SSHFingerprint string `sql:"sshfingerprint"`
+	ResultSSH      string `sql:"sshresult"`
+	StateSSH       State  `sql:"sshstate"`
+
+	// FTP greeting, upgraded with AUTH TLS when TLSFTP is set
+	EnableFTP bool   `sql:"enableftp"`
+	TLSFTP    bool   `sql:"ftptls"`
+	ResultFTP string `sql:"ftpresult"`
+	StateFTP  State  `sql:"ftpstate"`
+
+	// MySQL handshake, and a SELECT 1 when UserMySQL is set
+	EnableMySQL   bool   `sql:"enablemysql"`
+	PortMySQL     int    `sql:"mysqlport"`
+	UserMySQL     string `sql:"mysqluser"`
+	PasswordMySQL string `sql:"mysqlpassword"`
+	ResultMySQL   string `sql:"mysqlresult"`
+	StateMySQL    State  `sql:"mysqlstate"`
+
+	// PostgreSQL SSLRequest probe, and a SELECT 1 when UserPostgres is set
+	EnablePostgres   bool   `sql:"enablepg"`
+	PortPostgres     int    `sql:"pgport"`
+	TLSPostgres      bool   `sql:"pgtls"`
+	UserPostgres     string `sql:"pguser"`
+	PasswordPostgres string `sql:"pgpassword"`
+	DBPostgres       string `sql:"pgdatabase"`
+	ResultPostgres   string `sql:"pgresult"`
+	StatePostgres    State  `sql:"pgstate"`
+
+	// LDAP bind on 389, or 636 with implicit TLS, anonymous unless BindLDAP is set
+	EnableLDAP   bool   `sql:"enableldap"`
+	TLSLDAP      bool   `sql:"ldaptls"`
+	PortLDAP     int    `sql:"ldapport"`
+	BindLDAP     string `sql:"ldapbinddn"`
+	PasswordLDAP string `sql:"ldappassword"`
+	ResultLDAP   string `sql:"ldapresult"`
+	StateLDAP    State  `sql:"ldapstate"`
+
+	// SNMPv2c GET of OIDSNMP, sysUpTime unless set
+	EnableSNMP    bool   `sql:"enablesnmp"`
+	CommunitySNMP string `sql:"snmpcommunity"`
+	OIDSNMP       string `sql:"snmpoid"`
+	ResultSNMP    string `sql:"snmpresult"`
+	StateSNMP     State  `sql:"snmpstate"`
+
+	// grpc.health.v1 Check of ServiceGRPC, or the whole server when empty
+	EnableGRPC  bool   `sql:"enablegrpc"`
+	PortGRPC    int    `sql:"grpcport"`
+	TLSGRPC     bool   `sql:"grpctls"`
+	ServiceGRPC string `sql:"grpcservice"`
+	ResultGRPC  string `sql:"grpcresult"`
+	StateGRPC   State  `sql:"grpcstate"`
+
+	// Registration expiry of Domain, or the hostname's registered domain,
+	// looked up over RDAP at most once a day
+	EnableDomain   bool   `sql:"enabledomain"`
+	Domain         string `sql:"domain"`
+	DomainWarnDays int    `sql:"domainwarndays"`
+	DomainExpiry   int64  `sql:"domainexpiry"`
+	DomainAt       int64  `sql:"domaincheckedat"`
+	ResultDomain   string `sql:"domainresult"`
+	StateDomain    State  `sql:"domainstate"`
+
+	// DNS blacklists the IP is looked up in, comma separated
+	EnableDNSBL bool   `sql:"enablednsbl"`
+	DNSBL       string `sql:"dnsbl"`
+	ResultDNSBL string `sql:"dnsblresult"`
+	StateDNSBL  State  `sql:"dnsblstate"`
+
+	// HTTPS over QUIC on UDP 443
+	EnableHTTP3 bool   `sql:"enablehttp3"`
+	ResultHTTP3 string `sql:"http3result"`
+	StateHTTP3  State  `sql:"http3state"`
+
+	// JSON health endpoint, with JSONPath assertions separated by semicolons
+	// such as `$.status == "ok"; $.queue_depth < 100`, and the values found
+	EnableJSON bool   `sql:"enablejson"`
+	URLJSON    string `sql:"jsonurl"`
+	AssertJSON string `sql:"jsonassert"`
+	ValuesJSON string `sql:"jsonvalues"`
+	ResultJSON string `sql:"jsonresult"`
+	StateJSON  State  `sql:"jsonstate"`
+
+	// Scripted HTTP transaction, whose steps are in transactionsteps
+	EnableTransaction bool   `sql:"enabletransaction"`
+	ResultTransaction string `sql:"transactionresult"`
+	StateTransaction  State  `sql:"transactionstate"`
+
+	// Local Nagios-style command, whose exit status is the state
+	EnableExec  bool   `sql:"enableexec"`
+	CommandExec string `sql:"execcommand"`
+	ResultExec  string `sql:"execresult"`
+	StateExec   State  `sql:"execstate"`
+
+	// Passive heartbeat, pushed by the host to /heartbeat/<token>
+	EnableHeartbeat bool   `sql:"enableheartbeat"`
+	TokenHeartbeat  string `sql:"heartbeattoken"`
+	GraceHeartbeat  int    `sql:"heartbeatgrace"`
+	HeartbeatAt     int64  `sql:"heartbeatat"`
+	ResultHeartbeat string `sql:"heartbeatresult"`
+	StateHeartbeat  State  `sql:"heartbeatstate"`
+
+	// Prometheus metrics endpoint, with assertions on its samples
+	EnablePrometheus bool   `sql:"enableprom"`
+	URLPrometheus    string `sql:"promurl"`
+	AssertPrometheus string `sql:"promassert"`
+	ResultPrometheus string `sql:"promresult"`
+	StatePrometheus  State  `sql:"promstate"`
+
+	// AMQP broker, with an optional queue depth limit read from the
+	// RabbitMQ management API
+	EnableAMQP   bool   `sql:"enableamqp"`
+	PortAMQP     int    `sql:"amqpport"`
+	TLSAMQP      bool   `sql:"amqptls"`
+	UserAMQP     string `sql:"amqpuser"`
+	PasswordAMQP string `sql:"amqppassword"`
+	VhostAMQP    string `sql:"amqpvhost"`
+	APIAMQP      string `sql:"amqpapi"`
+	QueueAMQP    string `sql:"amqpqueue"`
+	MaxQueueAMQP int    `sql:"amqpmaxqueue"`
+	ResultAMQP   string `sql:"amqpresult"`
+	StateAMQP    State  `sql:"amqpstate"`
+
+	// Kafka broker metadata, with how many brokers the cluster reports
+	EnableKafka     bool   `sql:"enablekafka"`
+	PortKafka       int    `sql:"kafkaport"`
+	TLSKafka        bool   `sql:"kafkatls"`
+	MinBrokersKafka int    `sql:"kafkaminbrokers"`
+	BrokersKafka    int    `sql:"kafkabrokers"`
+	ResultKafka     string `sql:"kafkaresult"`
+	StateKafka      State  `sql:"kafkastate"`
+
+	// Any TCP service, matched on the first line it sends after the
+	// optional probe, which may contain escapes such as \r\n
+	EnableBanner bool   `sql:"enablebanner"`
+	PortBanner   int    `sql:"bannerport"`
+	TLSBanner    bool   `sql:"bannertls"`
+	SendBanner   string `sql:"bannersend"`
+	ExpectBanner string `sql:"bannerexpect"`
+	ResultBanner string `sql:"bannerresult"`
+	StateBanner  State  `sql:"bannerstate"`
+
+	// Source engine game server, queried with A2S_INFO
+	EnableSource     bool   `sql:"enablesource"`
+	PortSource       int    `sql:"sourceport"`
+	NameSource       string `sql:"sourcename"`
+	PlayersSource    int    `sql:"sourceplayers"`
+	MaxPlayersSource int    `sql:"sourcemaxplayers"`
+	ResultSource     string `sql:"sourceresult"`
+	StateSource      State  `sql:"sourcestate"`
+
+	// Minecraft Java edition server, queried with a Server List Ping
+	EnableMinecraft     bool   `sql:"enableminecraft"`
+	PortMinecraft       int    `sql:"mcport"`
+	MOTDMinecraft       string `sql:"mcmotd"`
+	PlayersMinecraft    int    `sql:"mcplayers"`
+	MaxPlayersMinecraft int    `sql:"mcmaxplayers"`
+	ResultMinecraft     string `sql:"mcresult"`
+	StateMinecraft      State  `sql:"mcstate"`
+
+	// RADIUS Access-Request for a test user, signed with the shared secret
+	EnableRADIUS   bool   `sql:"enableradius"`
+	PortRADIUS     int    `sql:"radiusport"`
+	SecretRADIUS   string `sql:"radiussecret"`
+	UserRADIUS     string `sql:"radiususer"`
+	PasswordRADIUS string `sql:"radiuspassword"`
+	ResultRADIUS   string `sql:"radiusresult"`
+	StateRADIUS    State  `sql:"radiusstate"`
+
+	// Expected DNS records for the hostname, e.g. "A 192.0.2.10; MX 10 mx.example.com"
+	EnableDNS   bool   `sql:"enabledns"`
+	ResolverDNS string `sql:"dnsresolver"`
+	ExpectDNS   string `sql:"dnsexpect"`
+	ResultDNS   string `sql:"dnsresult"`
+	StateDNS    State  `sql:"dnsstate"`
+
+	// Docker Engine API, with containers expected to be running and healthy
+	EnableDocker     bool   `sql:"enabledocker"`
+	URLDocker        string `sql:"dockerurl"`
+	ContainersDocker string `sql:"dockercontainers"`
+	ResultDocker     string `sql:"dockerresult"`
+	StateDocker      State  `sql:"dockerstate"`
+
+	// Kubernetes API server readiness, and optionally every node's
+	EnableKubernetes bool   `sql:"enablek8s"`
+	URLKubernetes    string `sql:"k8surl"`
+	TokenKubernetes  string `sql:"k8stoken"`
+	ConfigKubernetes string `sql:"k8sconfig"`
+	NodesKubernetes  bool   `sql:"k8snodes"`
+	ResultKubernetes string `sql:"k8sresult"`
+	StateKubernetes  State  `sql:"k8sstate"`
+
+	// Clock drift against ours, read over NTP or from the HTTP Date header
+	EnableClock bool   `sql:"enableclock"`
+	ClockSource string `sql:"clocksource"`
+	ClockDrift  int    `sql:"clockdrift"`
+	ResultClock string `sql:"clockresult"`
+	StateClock  State  `sql:"clockstate"`
+
+	// Comparison of the same path against a peer, e.g. canary against prod
+	CompareWith    string `sql:"comparewith"`
+	ComparePath    string `sql:"comparepath"`
+	CompareTLS     bool   `sql:"comparetls"`
+	CompareLatency int    `sql:"comparelatency"`
+	ResultCompare  string `sql:"compareresult"`
+	StateCompare   State  `sql:"comparestate"`
+
+	// Availability objective as a percentage, alerted on by burn rate
+	SLO       float64 `sql:"slo"`
+	ResultSLO string  `sql:"sloresult"`
+	StateSLO  State   `sql:"slostate"`
+
+	// Uptime promised over 30 days, as a percentage, flagged in uptime when
+	// it is breached
+	SLA float64 `sql:"sla"`
+
+	// Timezone schedules are read in, and maintenance windows in it during
+	// which failures don't trigger remediation or count against the SLO,
+	// e.g. "Mon-Fri 02:00-03:00; Sun 00:00-06:00"
+	Timezone    string `sql:"timezone"`
+	Maintenance string `sql:"maintenance"`
+
+	// Echo requests sent per ping check, and the last run's loss percentage
+	// and round trips in milliseconds
+	PingCount  int     `sql:"pingcount"`
+	PingLoss   float64 `sql:"pingloss"`
+	PingMin    float64 `sql:"pingmin"`
+	PingAvg    float64 `sql:"pingavg"`
+	PingMax    float64 `sql:"pingmax"`
+	PingJitter float64 `sql:"pingjitter"`
+
+	// Phases of the last HTTP and HTTPS requests in milliseconds, and the
+	// total time over which either is degraded to WARN
+	LatencyHTTP      int     `sql:"httplatency"`
+	DNSTimeHTTP      float64 `sql:"httpdns"`
+	ConnectTimeHTTP  float64 `sql:"httpconnect"`
+	TLSTimeHTTP      float64 `sql:"httptls"`
+	TTFBHTTP         float64 `sql:"httpttfb"`
+	TotalTimeHTTP    float64 `sql:"httptotal"`
+	DNSTimeHTTPS     float64 `sql:"httpsdns"`
+	ConnectTimeHTTPS float64 `sql:"httpsconnect"`
+	TLSTimeHTTPS     float64 `sql:"httpstls"`
+	TTFBHTTPS        float64 `sql:"httpsttfb"`
+	TotalTimeHTTPS   float64 `sql:"httpstotal"`
+
+	// Whole body downloads, the size and time of the last and the size the
+	// next is compared with, failing beyond SizeDriftHTTP percent
+	DownloadHTTP      bool    `sql:"httpdownload"`
+	SizeDriftHTTP     int     `sql:"httpsizedrift"`
+	BytesHTTP         int64   `sql:"httpbytes"`
+	BaselineHTTP      int64   `sql:"httpbaseline"`
+	DownloadTimeHTTP  float64 `sql:"httpdownloadtime"`
+	BytesHTTPS        int64   `sql:"httpsbytes"`
+	BaselineHTTPS     int64   `sql:"httpsbaseline"`
+	DownloadTimeHTTPS float64 `sql:"httpsdownloadtime"`
+
+	// SHA-256 of the body the first time it was fetched, which it must
+	// still match when ChecksumHTTP is set
+	ChecksumHTTP bool   `sql:"httpchecksum"`
+	SHA256HTTP   string `sql:"httpsha256"`
+	SHA256HTTPS  string `sql:"httpssha256"`
+
+	// Path to the server traced when a network check fails, as "icmp", "tcp"
+	// or "tcp:PORT", and when it was taken
+	Traceroute  string `sql:"traceroute"`
+	ResultTrace string `sql:"traceresult"`
+	TraceAt     int64  `sql:"traceat"`
+
+	// Wake-on-LAN recovery for hosts failing their ping check
+	WoLMAC       string `sql:"wolmac"`
+	WoLAddr      string `sql:"wolbroadcast"`
+	WoLThreshold int    `sql:"wolthreshold"`
+	WoLSent      int64  `sql:"wolsent"`
+	ResultWoL    string `sql:"wolresult"`
+	PingFailures int    `sql:"pingfailures"`
+
+	// TLS version and cipher suite the last HTTPS check negotiated
+	TLSVersion string `sql:"tlsversion"`
+	TLSCipher  string `sql:"tlscipher"`
+
+	// When the certificate last presented by HTTPS or SMTPS expires
+	CertExpiry int64 `sql:"certexpiry"`
+
+	// durations records how long each check took on this run, and expiries
+	// when the certificate each check was presented expires
+	durations [numChecks]time.Duration
+	expiries  [numChecks]int64
+
+	// classes records why each check that failed on this run did, see fail
+	classes [numChecks]ErrorClass
+
+	// hardStates are the states confirmed over consecutive runs, and events
+	// the transitions between them to notify
+	hardStates [numChecks]State
+	events     []event
+
+	// previous holds each check's row in checkstates before this run, and
+	// skipped the checks not due to run this time, see schedule
+	previous map[string]checkState
+	skipped  [numChecks]bool
+
+	// run is this run's completion metadata, see recordRun, and ctx cancels
+	// its checks when vbms shuts down
+	run runInfo
+	ctx context.Context
+
+	// unresolved holds, for each check, why a setting it needs couldn't be
+	// decrypted or fetched, see resolveSettings
+	unresolved [numChecks]error
+
+	// pinned is set when checking one of several resolved addresses, so
+	// checks which normally dial the hostname dial IP instead
+	pinned bool
+}
+
+// Checks, in the order they are run
+const (
+	checkHTTP = iota
+	checkSMTP
+	checkPOP3
+	checkHTTPS
+	checkPing
+	checkPlugins
+	checkClock
+	checkCompare
+	checkSLO
+	checkIMAP
+	checkPOP3S
+	checkSSH
+	checkFTP
+	checkMySQL
+	checkPostgres
+	checkLDAP
+	checkSNMP
+	checkGRPC
+	checkDomain
+	checkDNSBL
+	checkHTTP3
+	checkJSON
+	checkTransaction
+	checkExec
+	checkHeartbeat
+	checkPrometheus
+	checkAMQP
+	checkKafka
+	checkBanner
+	checkSource
+	checkMinecraft
+	checkRADIUS
+	checkDNS
+	checkDocker
+	checkKubernetes
+	numChecks
+)
+
+// checkNames are how checks are referred to in the database
+var checkNames = [numChecks]string{"http", "smtp", "pop3", "https", "ping", "plugins", "clock", "compare", "slo", "imap", "pop3s", "ssh", "ftp", "mysql", "postgres", "ldap", "snmp", "grpc", "domain", "dnsbl", "http3", "json", "transaction", "exec", "heartbeat", "prometheus", "amqp", "kafka", "banner", "source", "minecraft", "radius", "dns", "docker", "kubernetes"}
+
+// Defaults holds fleet-wide settings inherited by servers that don't override them
+type Defaults struct {
+	Timeout      time.Duration
+	Retries      int
+	RetryBackoff time.Duration
+	ExpectHTTP   string
+	SSHJump      string
+	SSHJumpKey   string
+	KnownHosts   string
+	Proxy        string
+	TLSCert      string
+	TLSKey       string
+	UserAgent    string
+	RDAP         string
+
+	// UnprivilegedPing sends pings from UDP datagram sockets, which don't
+	// need root or CAP_NET_RAW where net.ipv4.ping_group_range allows it
+	UnprivilegedPing bool
+
+	// MaxChecks and MaxChecksPerHost limit how many checks run at once, in
+	// total and against one address. 0 is unlimited.
+	MaxChecks        int
+	MaxChecksPerHost int
+
+	// FlapThreshold is the percentage of recent runs a check has to change
+	// state in to be flapping. 0 turns flap detection off.
+	FlapThreshold int
+
+	// RunTimeout is how long a server's run waits for its checks before
+	// recording the stragglers as timed out
+	RunTimeout time.Duration
+}
+
+// Default is applied to every server unless its own row says otherwise
+var Default = Defaults{
+	Timeout:      10 * time.Second,
+	RetryBackoff: time.Second,
+	ExpectHTTP:   "200",
+	UserAgent:    "vbms",
+	RDAP:         "https://rdap.org/",
 }
 
 // NewServer returns a populated Server struct
@@ -45,10 +555,33 @@ func NewServer(db *sql.DB, rows *sql.Rows) Server {
 
 	sqlstruct.Scan(&srv, rows)
 	srv.DB = db
+	srv.resolveSettings()
+
+	if err := srv.applyProfile(); err != nil {
+		logrus.WithError(err).WithField("Server", srv.Hostname).Errorf("Unable to load profile %v", srv.Profile)
+	}
 
 	return srv
 }
 
+// timeout returns how long a check may wait on the server
+func (s *Server) timeout() time.Duration {
+	if s.Timeout > 0 {
+		return time.Second * time.Duration(s.Timeout)
+	}
+
+	return Default.Timeout
+}
+
+// expectHTTP returns the comma separated list of acceptable HTTP status codes
+func (s *Server) expectHTTP() string {
+	if s.ExpectHTTP != "" {
+		return s.ExpectHTTP
+	}
+
+	return Default.ExpectHTTP
+}
+
 // GetLogger returns instance of logrus prepopulated with server fields
 func (s *Server) GetLogger(service string, port int) *logrus.Entry {
 	contextLogger := logrus.WithFields(logrus.Fields{
@@ -70,38 +603,104 @@ func (s *Server) CheckHTTP(wg *sync.WaitGroup) {
 	}
 
 	logger := s.GetLogger("HTTP", 80)
-
-	// Open connection on port 80
-	conn, err := net.Dial("tcp", s.IP+":80")
+	s.setState(checkHTTP, StateOK)
+	s.BytesHTTP, s.DownloadTimeHTTP = 0, 0
+
+	// Request the page on port 80
+	timing := new(httpTiming)
+	resp, err := s.httpGetTimed("http://"+net.JoinHostPort(s.IP, "80")+s.httpPath(), nil, timing)
+	timing.done()
+	latency := timing.Total
+	s.DNSTimeHTTP, s.ConnectTimeHTTP, s.TLSTimeHTTP, s.TTFBHTTP, s.TotalTimeHTTP = timing.millis()
 	if err != nil {
-		s.ResultHTTP = "Unable to open port"
+		failed := httpError(err)
+		s.ResultHTTP = failed.Error()
 		logger.WithError(err).Error(s.ResultHTTP)
+		s.fail(checkHTTP, StateCrit, classOf(failed))
 		return
 	}
 
-	// Ensure we close after returning
-	defer conn.Close()
+	defer resp.Body.Close()
 
-	// Send basic GET request
-	fmt.Fprintf(conn, "GET / HTTP/1.0\r\n\r\n")
+	var counter *countingBody
+	if s.DownloadHTTP || s.ChecksumHTTP {
+		counter = countBody(resp)
+	}
 
-	// Read first line response
-	result, err := bufio.NewReader(conn).ReadString('\n')
-	if err != nil {
-		s.ResultHTTP = "No response received from server"
+	// Expect response of "HTTP/1.1 200 OK", noting any redirects followed
+	result := resp.Proto + " " + resp.Status
+	if chain := redirectChain(resp); chain != "" {
+		result = fmt.Sprintf("%v (redirected from %v)", result, chain)
+	}
+	s.ResultHTTP = result
+
+	if !isValidHTTPResponse(result, s.expectHTTP()) {
+		logger.Errorf("Returned invalid HTTPS response: '%v'", result)
+		s.fail(checkHTTP, StateCrit, ErrorResponse)
+		return
+	}
+
+	// Catch truncated deploys and empty pages served with a 200
+	if err := s.checkSize(resp); err != nil {
+		s.ResultHTTP = err.Error()
 		logger.Error(s.ResultHTTP)
+		s.fail(checkHTTP, StateCrit, ErrorResponse)
 		return
 	}
 
-	// Expect response of "HTTP/1.1 200 OK"
-	result = strings.TrimSpace(result)
+	if err := s.checkMatch(resp); err != nil {
+		s.ResultHTTP = err.Error()
+		logger.Error(s.ResultHTTP)
+		s.fail(checkHTTP, StateCrit, ErrorResponse)
+		return
+	}
+
+	// Missing security headers are a warning, not a failure
+	if missing := s.missingHeaders(resp.Header); len(missing) > 0 {
+		result = fmt.Sprintf("%v (missing security headers: %v)", result, strings.Join(missing, ", "))
+		logger.Warn(result)
+		s.fail(checkHTTP, StateWarn, ErrorResponse)
+	}
+
+	// A slow response is degraded rather than down
+	if slow := s.slowResponse(latency); slow != "" {
+		result = fmt.Sprintf("%v (%v)", result, slow)
+		logger.Warn(result)
+		s.fail(checkHTTP, StateWarn, ErrorResponse)
+	}
+
+	result, st, class := s.applyAssertion(resp, latency, result)
 	s.ResultHTTP = result
+	s.fail(checkHTTP, st, class)
+	if st == StateCrit {
+		logger.Error(result)
+		return
+	}
 
-	if isValidHTTPResponse(result) {
-		logger.Infof("HTTP Check Ok. Response: %v", result)
-	} else {
-		logger.Errorf("Returned invalid HTTPS response: '%v'", result)
+	// The whole page, as an error page served with a 200 is much smaller and
+	// a defaced one hashes differently
+	if counter != nil {
+		size, took, err := download(resp, counter, timing.start)
+		s.BytesHTTP, s.DownloadTimeHTTP = size, took
+		if err == nil && s.DownloadHTTP {
+			err = s.sizeDrift(size, &s.BaselineHTTP)
+		}
+		if err == nil && s.ChecksumHTTP {
+			err = checksumDrift(counter.sum(), &s.SHA256HTTP)
+		}
+		if err != nil {
+			s.ResultHTTP = err.Error()
+			logger.Error(s.ResultHTTP)
+			s.fail(checkHTTP, StateCrit, ErrorResponse)
+			return
+		}
+		if s.DownloadHTTP {
+			result = fmt.Sprintf("%v (%d bytes in %.0fms)", result, size, took)
+			s.ResultHTTP = result
+		}
 	}
+
+	logger.Infof("HTTP Check Ok. Response: %v", result)
 }
 
 // CheckHTTPS opens connection on port 80 and checks for HTTP response
@@ -114,46 +713,275 @@ func (s *Server) CheckHTTPS(wg *sync.WaitGroup) {
 	}
 
 	logger := s.GetLogger("HTTPS", 443)
+	s.setState(checkHTTPS, StateOK)
+	s.TLSVersion, s.TLSCipher = "", ""
+	s.BytesHTTPS, s.DownloadTimeHTTPS = 0, 0
 
-	dialer := &net.Dialer{Timeout: time.Second * 3}
+	config, err := s.tlsConfig()
+	if err != nil {
+		s.ResultHTTPS = "Invalid TLS configuration"
+		logger.WithError(err).Error(s.ResultHTTPS)
+		s.fail(checkHTTPS, StateCrit, ErrorConfig)
+		return
+	}
 
-	// Open connection on port 443
-	conn, err := tls.DialWithDialer(dialer, "tcp", s.Hostname+":443", &tls.Config{})
+	host := s.Hostname
+	if s.pinned {
+		host = s.IP
+	}
+
+	// Request the page on port 443
+	addr := net.JoinHostPort(host, "443")
+	timing := new(httpTiming)
+	resp, err := s.httpGetTimed("https://"+addr+s.httpPath(), config, timing)
+	timing.done()
+	latency := timing.Total
+	s.DNSTimeHTTPS, s.ConnectTimeHTTPS, s.TLSTimeHTTPS, s.TTFBHTTPS, s.TotalTimeHTTPS = timing.millis()
 	if err != nil {
-		s.ResultHTTPS = "Unable to open port"
+		failed := httpError(err)
+		s.ResultHTTPS = failed.Error()
 		logger.WithError(err).Error(s.ResultHTTPS)
+		s.fail(checkHTTPS, StateCrit, classOf(failed))
 		return
 	}
 
-	// Ensure we close after returning
-	defer conn.Close()
+	defer resp.Body.Close()
 
-	// Send basic GET request
-	fmt.Fprintf(conn, "GET / HTTP/1.0\r\n\r\n")
+	var counter *countingBody
+	if s.DownloadHTTP || s.ChecksumHTTP {
+		counter = countBody(resp)
+	}
 
-	// Read first line response
-	result, err := bufio.NewReader(conn).ReadString('\n')
-	if err != nil {
-		s.ResultHTTPS = "No response received from server"
+	if resp.TLS != nil {
+		if len(resp.TLS.PeerCertificates) > 0 {
+			s.expiries[checkHTTPS] = resp.TLS.PeerCertificates[0].NotAfter.Unix()
+		}
+		s.TLSVersion = tls.VersionName(resp.TLS.Version)
+		s.TLSCipher = tls.CipherSuiteName(resp.TLS.CipherSuite)
+	}
+
+	// Expect response of "HTTP/1.1 200 OK", noting any redirects followed
+	result := resp.Proto + " " + resp.Status
+	if chain := redirectChain(resp); chain != "" {
+		result = fmt.Sprintf("%v (redirected from %v)", result, chain)
+	}
+	s.ResultHTTPS = result
+
+	if !isValidHTTPResponse(result, s.expectHTTP()) {
+		logger.Errorf("Returned invalid HTTPS response: '%v'", result)
+		s.fail(checkHTTPS, StateCrit, ErrorResponse)
+		return
+	}
+
+	// Up is not enough if the server still accepts what the policy forbids
+	if err := s.checkTLSPolicy(addr, config); err != nil {
+		s.ResultHTTPS = err.Error()
 		logger.Error(s.ResultHTTPS)
+		s.fail(checkHTTPS, StateCrit, ErrorTLS)
 		return
 	}
 
-	// Expect response of "HTTP/1.1 200 OK"
-	result = strings.TrimSpace(result)
+	// A revoked certificate only shows in its OCSP response
+	if s.OCSPHTTPS && resp.TLS != nil {
+		status, err := checkOCSP(resp.TLS)
+		if err != nil {
+			s.ResultHTTPS = err.Error()
+			logger.Error(s.ResultHTTPS)
+			s.fail(checkHTTPS, StateCrit, ErrorTLS)
+			return
+		}
+		result = fmt.Sprintf("%v (%v)", result, status)
+	}
+
+	// Catch truncated deploys and empty pages served with a 200
+	if err := s.checkSize(resp); err != nil {
+		s.ResultHTTPS = err.Error()
+		logger.Error(s.ResultHTTPS)
+		s.fail(checkHTTPS, StateCrit, ErrorResponse)
+		return
+	}
+
+	if err := s.checkMatch(resp); err != nil {
+		s.ResultHTTPS = err.Error()
+		logger.Error(s.ResultHTTPS)
+		s.fail(checkHTTPS, StateCrit, ErrorResponse)
+		return
+	}
+
+	// Missing security headers are a warning, not a failure
+	if missing := s.missingHeaders(resp.Header); len(missing) > 0 {
+		result = fmt.Sprintf("%v (missing security headers: %v)", result, strings.Join(missing, ", "))
+		logger.Warn(result)
+		s.fail(checkHTTPS, StateWarn, ErrorResponse)
+	}
+
+	// A slow response is degraded rather than down
+	if slow := s.slowResponse(latency); slow != "" {
+		result = fmt.Sprintf("%v (%v)", result, slow)
+		logger.Warn(result)
+		s.fail(checkHTTPS, StateWarn, ErrorResponse)
+	}
+
+	result, st, class := s.applyAssertion(resp, latency, result)
 	s.ResultHTTPS = result
+	s.fail(checkHTTPS, st, class)
+	if st == StateCrit {
+		logger.Error(result)
+		return
+	}
 
-	if isValidHTTPResponse(result) {
-		logger.Infof("HTTP Check Ok. Response: %v", result)
-	} else {
-		logger.Errorf("Returned invalid HTTPS response: '%v'", result)
+	// The whole page, as an error page served with a 200 is much smaller and
+	// a defaced one hashes differently
+	if counter != nil {
+		size, took, err := download(resp, counter, timing.start)
+		s.BytesHTTPS, s.DownloadTimeHTTPS = size, took
+		if err == nil && s.DownloadHTTP {
+			err = s.sizeDrift(size, &s.BaselineHTTPS)
+		}
+		if err == nil && s.ChecksumHTTP {
+			err = checksumDrift(counter.sum(), &s.SHA256HTTPS)
+		}
+		if err != nil {
+			s.ResultHTTPS = err.Error()
+			logger.Error(s.ResultHTTPS)
+			s.fail(checkHTTPS, StateCrit, ErrorResponse)
+			return
+		}
+		if s.DownloadHTTP {
+			result = fmt.Sprintf("%v (%d bytes in %.0fms)", result, size, took)
+			s.ResultHTTPS = result
+		}
+	}
+
+	logger.Infof("HTTP Check Ok. Response: %v", result)
+}
+
+// tlsConfig builds the TLS client settings for this server. The verification
+// name defaults to the hostname, a custom CA bundle replaces the system pool
+// and a client certificate is presented to servers requiring mutual TLS.
+// TLSInsecure skips verification entirely, while TLSNoHost still verifies
+// the chain but accepts a certificate for any name.
+func (s *Server) tlsConfig() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         s.Hostname,
+		InsecureSkipVerify: s.TLSInsecure,
+	}
+
+	if s.TLSName != "" {
+		config.ServerName = s.TLSName
+	}
+
+	if s.TLSCAFile != "" {
+		bundle, err := os.ReadFile(s.TLSCAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("No certificates found in %v", s.TLSCAFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if s.TLSNoHost && !s.TLSInsecure {
+		config.InsecureSkipVerify = true
+		config.VerifyConnection = verifyChain(config.RootCAs)
+	}
+
+	// Go only presents the certificate to servers that ask for one
+	if certFile, keyFile := s.clientCertificate(); certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// clientCertificate returns the certificate and key files for mutual TLS,
+// the server's own or else the fleet-wide pair
+func (s *Server) clientCertificate() (string, string) {
+	if s.TLSCert != "" || s.TLSKey != "" {
+		return s.TLSCert, s.TLSKey
+	}
+
+	return Default.TLSCert, Default.TLSKey
+}
+
+// certExpiry returns the date the certificate presented on a TLS connection expires
+func certExpiry(state tls.ConnectionState) string {
+	if len(state.PeerCertificates) == 0 {
+		return ""
+	}
+
+	return state.PeerCertificates[0].NotAfter.Format("2006-01-02")
+}
+
+// earliestExpiry returns the earliest expiry of the certificates presented on this
+// run, or the last one known if no check saw a certificate
+func (s *Server) earliestExpiry() int64 {
+	earliest := int64(0)
+
+	for _, expiry := range s.expiries {
+		if expiry > 0 && (earliest == 0 || expiry < earliest) {
+			earliest = expiry
+		}
+	}
+
+	if earliest == 0 {
+		return s.CertExpiry
+	}
+
+	return earliest
+}
+
+// certExpiresSoon reports whether the presented certificate expires within
+// certWarnDays
+func certExpiresSoon(state tls.ConnectionState) bool {
+	if len(state.PeerCertificates) == 0 {
+		return false
+	}
+
+	return time.Until(state.PeerCertificates[0].NotAfter) < certWarnDays*24*time.Hour
+}
+
+// isValidHTTPResponse checks if the status code in the HTTP response line is
+// one of the expected codes, e.g. "200" or "200,301"
+func isValidHTTPResponse(response string, expect string) bool {
+	fields := strings.Fields(response)
+	if len(fields) < 2 {
+		return false
 	}
+
+	for _, code := range strings.Split(expect, ",") {
+		if strings.TrimSpace(code) == fields[1] {
+			return true
+		}
+	}
+
+	return false
 }
 
-// isValidHTTPResponse checks if HTTP resonse from server is HTTP code 200
-func isValidHTTPResponse(response string) bool {
-	re := regexp.MustCompile("200 OK")
-	return re.FindString(response) != ""
+// matchBanner checks a service greeting against an optional expected pattern.
+// An empty pattern accepts any banner.
+func matchBanner(pattern string, banner string) error {
+	if pattern == "" {
+		return nil
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("Invalid banner pattern '%v'", pattern)
+	}
+
+	if !re.MatchString(banner) {
+		return fmt.Errorf("Unexpected banner: '%v'", banner)
+	}
+
+	return nil
 }
 
 // CheckSMTP sends HELO to STMP server and expects a response
@@ -166,38 +994,117 @@ func (s *Server) CheckSMTP(wg *sync.WaitGroup) {
 	}
 
 	logger := s.GetLogger("SMTP", s.PortSMTP)
+	s.setState(checkSMTP, StateOK)
 
 	// Convert port to string for concatenation
 	port := strconv.Itoa(s.PortSMTP)
 
-	// Open connection
-	conn, err := net.DialTimeout("tcp", s.IP+port, 10*time.Second)
+	// Open connection, negotiating TLS straight away for SMTPS
+	var conn net.Conn
+	var expiry string
+	addr := net.JoinHostPort(s.IP, port)
+
+	if s.TLSSMTP {
+		config, err := s.tlsConfig()
+		if err != nil {
+			s.ResultSMTP = "Invalid TLS configuration"
+			logger.WithError(err).Error(s.ResultSMTP)
+			s.fail(checkSMTP, StateCrit, ErrorConfig)
+			return
+		}
+
+		tlsConn, err := s.dialTLS(addr, config)
+		if err != nil {
+			s.ResultSMTP = "Unable to open SMTPS connection"
+			logger.WithError(err).Error(s.ResultSMTP)
+			s.fail(checkSMTP, StateCrit, ErrorConnect)
+			return
+		}
+
+		expiry = certExpiry(tlsConn.ConnectionState())
+		if certs := tlsConn.ConnectionState().PeerCertificates; len(certs) > 0 {
+			s.expiries[checkSMTP] = certs[0].NotAfter.Unix()
+		}
+		if certExpiresSoon(tlsConn.ConnectionState()) {
+			s.fail(checkSMTP, StateWarn, ErrorTLS)
+		}
+		conn = tlsConn
+	} else {
+		plain, err := s.dial(addr)
 
-	// Log failure
-	if err != nil {
-		s.ResultSMTP = "Unable to open SMTP connection"
-		logger.Error(s.ResultSMTP)
-		return
+		// Log failure
+		if err != nil {
+			s.ResultSMTP = "Unable to open SMTP connection"
+			logger.Error(s.ResultSMTP)
+			s.fail(checkSMTP, StateCrit, ErrorConnect)
+			return
+		}
+
+		conn = plain
 	}
 
 	// Make sure we close connection after function returns
 	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(s.timeout()))
 
 	// Read first line
-	result, err := bufio.NewReader(conn).ReadString('\n')
+	reader := bufio.NewReader(conn)
+	result, err := reader.ReadString('\n')
 	if err != nil {
 		s.ResultSMTP = "No response received from server"
 		logger.Error(s.ResultSMTP)
+		s.fail(checkSMTP, StateCrit, ErrorTimeout)
 		return
 	}
 	result = strings.TrimSpace(result)
 
 	s.ResultSMTP = result
 
-	logger.Infof("SMTP Check OK. Response: %v", result)
+	// Make sure the expected daemon answered
+	if err := matchBanner(s.BannerSMTP, result); err != nil {
+		s.ResultSMTP = err.Error()
+		logger.Error(s.ResultSMTP)
+		s.fail(checkSMTP, StateCrit, ErrorResponse)
+		return
+	}
+
+	// Prove encrypted submission works and mail is accepted, not just that
+	// the port answers
+	var notes []string
+	if (s.StartTLS && !s.TLSSMTP) || s.RcptSMTP != "" {
+		session, err := s.smtpSession(conn, reader, result)
+		if err != nil {
+			s.ResultSMTP = err.Error()
+			logger.Error(s.ResultSMTP)
+			s.fail(checkSMTP, StateCrit, classOf(err))
+			return
+		}
+
+		if session.tls != nil {
+			expiry = certExpiry(*session.tls)
+			if len(session.tls.PeerCertificates) > 0 {
+				s.expiries[checkSMTP] = session.tls.PeerCertificates[0].NotAfter.Unix()
+			}
+			if certExpiresSoon(*session.tls) {
+				s.fail(checkSMTP, StateWarn, ErrorTLS)
+			}
+			notes = append(notes, "STARTTLS")
+		}
+		notes = append(notes, session.replies...)
+	}
+
+	if expiry != "" {
+		notes = append(notes, "certificate expires "+expiry)
+	}
+
+	if len(notes) > 0 {
+		s.ResultSMTP = fmt.Sprintf("%v (%v)", result, strings.Join(notes, ", "))
+	}
+
+	logger.Infof("SMTP Check OK. Response: %v", s.ResultSMTP)
 }
 
-// CheckPOP3 opens connection on port 80 and checks for HTTP response
+// CheckPOP3 opens connection on port 110 and checks for a POP3 greeting
 func (s *Server) CheckPOP3(wg *sync.WaitGroup) {
 
 	defer wg.Done()
@@ -207,34 +1114,51 @@ func (s *Server) CheckPOP3(wg *sync.WaitGroup) {
 	}
 
 	logger := s.GetLogger("POP3", 110)
+	s.setState(checkPOP3, StateOK)
 
-	// Open connection on port 80
-	conn, err := net.Dial("tcp", s.IP+":110")
+	// Open connection on port 110
+	conn, err := s.dial(net.JoinHostPort(s.IP, "110"))
 	if err != nil {
 		s.ResultPOP3 = "Unable to open POP3 Connection"
 		logger.Error(s.ResultPOP3)
+		s.fail(checkPOP3, StateCrit, ErrorConnect)
 		return
 	}
 
 	// Ensure we close after returning
 	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(s.timeout()))
 
-	// Send basic GET request
-	fmt.Fprintf(conn, "GET / HTTP/1.0\r\n\r\n")
-
-	// Read first line of response
-	result, err := bufio.NewReader(conn).ReadString('\n')
+	result, err := s.pop3Session(conn)
+	s.ResultPOP3 = result
 	if err != nil {
-		s.ResultPOP3 = "No response received from server"
 		logger.Error(s.ResultPOP3)
+		s.fail(checkPOP3, StateCrit, classOf(err))
 		return
 	}
 
-	result = strings.TrimSpace(result)
+	logger.Infof("Returned on port 110: %v", result)
+}
 
-	s.ResultPOP3 = result
+// lookupIP returns the server's address on network, "ip" or "ip4", looking
+// it up when the ip column holds a hostname, as import-ansible can write
+func (s *Server) lookupIP(network string) (net.IP, error) {
+	if ip := net.ParseIP(s.IP); ip != nil {
+		if network == "ip4" && ip.To4() == nil {
+			return nil, fmt.Errorf("%v is not an IPv4 address", s.IP)
+		}
+		return ip, nil
+	}
 
-	logger.Infof("Returned on port 110: %v", result)
+	ctx, cancel := context.WithTimeout(s.runContext(), s.timeout())
+	defer cancel()
+
+	ips, err := net.DefaultResolver.LookupIP(ctx, network, s.IP)
+	if err != nil {
+		return nil, err
+	}
+
+	return ips[0], nil
 }
 
 // CheckPing pings the server and expects a response.
@@ -247,34 +1171,59 @@ func (s *Server) CheckPing(wg *sync.WaitGroup) {
 	}
 
 	logger := s.GetLogger("PING", 0)
+	s.setState(checkPing, StateOK)
 
-	// Check if we're UID of 0
-	if os.Getuid() != 0 {
-		s.ResultPing = "Ping requires root"
+	// ICMP can't be carried over an SSH tunnel
+	if spec, _ := s.sshJump(); spec != "" {
+		s.ResultPing = "Ping not available through SSH jump host"
 		logger.Error(s.ResultPing)
+		s.fail(checkPing, StateCrit, ErrorConfig)
 		return
 	}
 
-	// We haven't received ping yet
-	received := false
+	ip, err := s.lookupIP("ip")
+	if err != nil {
+		s.ResultPing = "Unable to resolve address"
+		logger.WithError(err).Error(s.ResultPing)
+		s.fail(checkPing, StateCrit, ErrorDNS)
+		return
+	}
 
-	p := fastping.NewPinger()
-	ra, _ := net.ResolveIPAddr("ip4:icmp", s.IP)
-	p.AddIPAddr(ra)
-	p.OnRecv = func(addr *net.IPAddr, rtt time.Duration) {
-		received = true
-		s.ResultPing = fmt.Sprintf("IP Addr: %s receive, RTT: %v\n", addr.String(), rtt)
+	count := s.PingCount
+	if count <= 0 {
+		count = defaultPingCount
 	}
 
-	err := p.Run()
+	// Raw sockets fail without root or CAP_NET_RAW, and datagram sockets
+	// outside ping_group_range
+	rtts, err := s.pingSamples(ip, count)
 	if err != nil {
-		fmt.Println(err)
+		s.ResultPing = "Ping requires root, CAP_NET_RAW or PING_UNPRIVILEGED"
+		if Default.UnprivilegedPing {
+			s.ResultPing = "Ping not permitted by net.ipv4.ping_group_range"
+		}
+		logger.WithError(err).Error(s.ResultPing)
+		s.fail(checkPing, StateCrit, ErrorConfig)
+		return
 	}
 
+	s.setPingStats(rtts, count)
+	received := len(rtts) > 0
+
+	s.ResultPing = fmt.Sprintf("%d/%d received, %.0f%% loss", len(rtts), count, s.PingLoss)
 	if received {
-		logger.Info("Ping successful")
-	} else {
+		s.ResultPing += fmt.Sprintf(", rtt min/avg/max/jitter %.3f/%.3f/%.3f/%.3f ms", s.PingMin, s.PingAvg, s.PingMax, s.PingJitter)
+	}
+
+	switch {
+	case !received:
 		logger.Error("Ping failed")
+		s.fail(checkPing, StateCrit, ErrorTimeout)
+	case len(rtts) < count:
+		logger.Warnf("Ping lost packets: %v", s.ResultPing)
+		s.fail(checkPing, StateWarn, ErrorTimeout)
+	default:
+		logger.Infof("Ping successful: %v", s.ResultPing)
 	}
 }
 
@@ -289,7 +1238,112 @@ func (s *Server) UpdateDatabase() {
 					smtpresult = ?,
 					pop3result = ?,
 					httpsresult = ?,
-					pingresult = ?
+					pingresult = ?,
+					pluginresult = ?,
+					httpstate = ?,
+					smtpstate = ?,
+					pop3state = ?,
+					httpsstate = ?,
+					pingstate = ?,
+					pluginstate = ?,
+					clockresult = ?,
+					clockstate = ?,
+					compareresult = ?,
+					comparestate = ?,
+					sloresult = ?,
+					slostate = ?,
+					imapresult = ?,
+					imapstate = ?,
+					pop3sresult = ?,
+					pop3sstate = ?,
+					sshresult = ?,
+					sshstate = ?,
+					ftpresult = ?,
+					ftpstate = ?,
+					mysqlresult = ?,
+					mysqlstate = ?,
+					pgresult = ?,
+					pgstate = ?,
+					ldapresult = ?,
+					ldapstate = ?,
+					snmpresult = ?,
+					snmpstate = ?,
+					grpcresult = ?,
+					grpcstate = ?,
+					domainresult = ?,
+					domainstate = ?,
+					dnsblresult = ?,
+					dnsblstate = ?,
+					http3result = ?,
+					http3state = ?,
+					jsonresult = ?,
+					jsonstate = ?,
+					transactionresult = ?,
+					transactionstate = ?,
+					execresult = ?,
+					execstate = ?,
+					heartbeatresult = ?,
+					heartbeatstate = ?,
+					promresult = ?,
+					promstate = ?,
+					amqpresult = ?,
+					amqpstate = ?,
+					kafkabrokers = ?,
+					kafkaresult = ?,
+					kafkastate = ?,
+					bannerresult = ?,
+					bannerstate = ?,
+					sourcename = ?,
+					sourceplayers = ?,
+					sourcemaxplayers = ?,
+					sourceresult = ?,
+					sourcestate = ?,
+					mcmotd = ?,
+					mcplayers = ?,
+					mcmaxplayers = ?,
+					mcresult = ?,
+					mcstate = ?,
+					radiusresult = ?,
+					radiusstate = ?,
+					dnsresult = ?,
+					dnsstate = ?,
+					dockerresult = ?,
+					dockerstate = ?,
+					k8sresult = ?,
+					k8sstate = ?,
+					jsonvalues = ?,
+					domainexpiry = ?,
+					domaincheckedat = ?,
+					certexpiry = ?,
+					tlsversion = ?,
+					tlscipher = ?,
+					pingloss = ?,
+					pingmin = ?,
+					pingavg = ?,
+					pingmax = ?,
+					pingjitter = ?,
+					httpdns = ?,
+					httpconnect = ?,
+					httptls = ?,
+					httpttfb = ?,
+					httptotal = ?,
+					httpsdns = ?,
+					httpsconnect = ?,
+					httpstls = ?,
+					httpsttfb = ?,
+					httpstotal = ?,
+					httpbytes = ?,
+					httpbaseline = ?,
+					httpdownloadtime = ?,
+					httpsbytes = ?,
+					httpsbaseline = ?,
+					httpsdownloadtime = ?,
+					httpsha256 = ?,
+					httpssha256 = ?,
+					pingfailures = ?,
+					failing = ?,
+					wolsent = ?,
+					wolresult = ?
 				WHERE id = ?
 			`)
 
@@ -297,24 +1351,212 @@ func (s *Server) UpdateDatabase() {
 		log.Panic(err)
 	}
 
-	_, err = stmt.Exec(s.ResultHTTP, s.ResultSMTP, s.ResultPOP3, s.ResultHTTPS, s.ResultPing, s.ID)
+	_, err = stmt.Exec(s.ResultHTTP, s.ResultSMTP, s.ResultPOP3, s.ResultHTTPS, s.ResultPing, s.ResultPlugins,
+		s.StateHTTP, s.StateSMTP, s.StatePOP3, s.StateHTTPS, s.StatePing, s.StatePlugins,
+		s.ResultClock, s.StateClock, s.ResultCompare, s.StateCompare,
+		s.ResultSLO, s.StateSLO, s.ResultIMAP, s.StateIMAP,
+		s.ResultPOP3S, s.StatePOP3S, s.ResultSSH, s.StateSSH,
+		s.ResultFTP, s.StateFTP, s.ResultMySQL, s.StateMySQL,
+		s.ResultPostgres, s.StatePostgres,
+		s.ResultLDAP, s.StateLDAP,
+		s.ResultSNMP, s.StateSNMP,
+		s.ResultGRPC, s.StateGRPC,
+		s.ResultDomain, s.StateDomain, s.DomainExpiry, s.DomainAt,
+		s.ResultDNSBL, s.StateDNSBL,
+		s.ResultHTTP3, s.StateHTTP3,
+		s.ResultJSON, s.StateJSON, s.ValuesJSON,
+		s.ResultTransaction, s.StateTransaction,
+		s.ResultExec, s.StateExec,
+		s.ResultHeartbeat, s.StateHeartbeat,
+		s.ResultPrometheus, s.StatePrometheus,
+		s.ResultAMQP, s.StateAMQP,
+		s.BrokersKafka, s.ResultKafka, s.StateKafka,
+		s.ResultBanner, s.StateBanner,
+		s.NameSource, s.PlayersSource, s.MaxPlayersSource, s.ResultSource, s.StateSource,
+		s.MOTDMinecraft, s.PlayersMinecraft, s.MaxPlayersMinecraft, s.ResultMinecraft, s.StateMinecraft,
+		s.ResultRADIUS, s.StateRADIUS,
+		s.ResultDNS, s.StateDNS,
+		s.ResultDocker, s.StateDocker,
+		s.ResultKubernetes, s.StateKubernetes,
+		s.earliestExpiry(), s.TLSVersion, s.TLSCipher,
+		s.PingLoss, s.PingMin, s.PingAvg, s.PingMax, s.PingJitter,
+		s.DNSTimeHTTP, s.ConnectTimeHTTP, s.TLSTimeHTTP, s.TTFBHTTP, s.TotalTimeHTTP,
+		s.DNSTimeHTTPS, s.ConnectTimeHTTPS, s.TLSTimeHTTPS, s.TTFBHTTPS, s.TotalTimeHTTPS,
+		s.BytesHTTP, s.BaselineHTTP, s.DownloadTimeHTTP, s.BytesHTTPS, s.BaselineHTTPS, s.DownloadTimeHTTPS,
+		s.SHA256HTTP, s.SHA256HTTPS,
+		s.PingFailures, s.Failing, s.WoLSent, s.ResultWoL, s.ID)
 
 	if err != nil {
 		log.Panic(err)
 	}
 }
 
-// RunChecks initiates all service checks for a server in goroutines
-func (s *Server) RunChecks() {
+// startChecks launches every service check due, each reporting on done as
+// it finishes, and returns the checks launched
+func (s *Server) startChecks(done chan<- checkRun) []checkRun {
+	// States only get worse during a run, so start from none, leaving the
+	// checks that aren't due as they were
+	for i, st := range s.states() {
+		if !s.skipped[i] {
+			*st = ""
+			s.classes[i] = ""
+		}
+	}
+
+	checks := [numChecks]func(*Server, *sync.WaitGroup){
+		checkHTTP:        (*Server).CheckHTTP,
+		checkSMTP:        (*Server).CheckSMTP,
+		checkPOP3:        (*Server).CheckPOP3,
+		checkHTTPS:       (*Server).CheckHTTPS,
+		checkPing:        (*Server).CheckPing,
+		checkPlugins:     (*Server).CheckPlugins,
+		checkClock:       (*Server).CheckClock,
+		checkCompare:     (*Server).CheckCompare,
+		checkSLO:         (*Server).CheckSLO,
+		checkIMAP:        (*Server).CheckIMAP,
+		checkPOP3S:       (*Server).CheckPOP3S,
+		checkSSH:         (*Server).CheckSSH,
+		checkFTP:         (*Server).CheckFTP,
+		checkMySQL:       (*Server).CheckMySQL,
+		checkPostgres:    (*Server).CheckPostgres,
+		checkLDAP:        (*Server).CheckLDAP,
+		checkSNMP:        (*Server).CheckSNMP,
+		checkGRPC:        (*Server).CheckGRPC,
+		checkDomain:      (*Server).CheckDomain,
+		checkDNSBL:       (*Server).CheckDNSBL,
+		checkHTTP3:       (*Server).CheckHTTP3,
+		checkJSON:        (*Server).CheckJSON,
+		checkTransaction: (*Server).CheckTransaction,
+		checkExec:        (*Server).CheckExec,
+		checkHeartbeat:   (*Server).CheckHeartbeat,
+		checkPrometheus:  (*Server).CheckPrometheus,
+		checkAMQP:        (*Server).CheckAMQP,
+		checkKafka:       (*Server).CheckKafka,
+		checkBanner:      (*Server).CheckBanner,
+		checkSource:      (*Server).CheckSource,
+		checkMinecraft:   (*Server).CheckMinecraft,
+		checkRADIUS:      (*Server).CheckRADIUS,
+		checkDNS:         (*Server).CheckDNS,
+		checkDocker:      (*Server).CheckDocker,
+		checkKubernetes:  (*Server).CheckKubernetes,
+	}
 
-	wg := new(sync.WaitGroup)
+	depends, err := s.checkDepends()
+	if err != nil {
+		s.GetLogger("SCHEDULE", 0).WithError(err).Error("Invalid check dependencies, ignoring them")
+		depends = nil
+	}
 
-	wg.Add(5)
-	go s.CheckHTTP(wg)
-	go s.CheckSMTP(wg)
-	go s.CheckPOP3(wg)
-	go s.CheckHTTPS(wg)
-	go s.CheckPing(wg)
-	s.UpdateDatabase()
-	wg.Wait()
+	// Each check's channel closes once its final state is set, for the
+	// checks depending on it. Those that aren't due keep their state, so
+	// are done already.
+	deps := new(dependencies)
+	for i := range deps.finished {
+		deps.finished[i] = make(chan struct{})
+		if s.skipped[i] {
+			deps.states[i] = *s.states()[i]
+			close(deps.finished[i])
+		}
+	}
+
+	// Each check runs against its own copy of the server, so nothing it
+	// writes is shared with the collector or the other checks
+	var launched []checkRun
+	for i, check := range checks {
+		if s.skipped[i] {
+			continue
+		}
+		work := *s
+		launched = append(launched, checkRun{server: s, check: i, outcome: &work})
+		go work.runAfter(i, check, depends[i], deps, done)
+	}
+
+	return launched
+}
+
+// timeCheck runs a check, retrying it while it fails, and records how long
+// the last attempt took before reporting on done. s is the check's own copy
+// of the server, sent as its outcome.
+func (s *Server) timeCheck(i int, check func(*Server, *sync.WaitGroup), done chan<- checkRun) {
+	attempts := s.attempts(i)
+	attempt := 1
+
+	defer func() {
+		done <- checkRun{check: i, attempts: attempt, outcome: s}
+	}()
+
+	for ; ; attempt++ {
+		release := s.acquire()
+		start := time.Now()
+		wg := new(sync.WaitGroup)
+		wg.Add(1)
+		check(s, wg)
+		s.durations[i] = time.Since(start)
+		release()
+
+		if *s.states()[i] != StateCrit || attempt == attempts {
+			if attempt > 1 {
+				*s.results()[i] += fmt.Sprintf(" (attempt %d of %d)", attempt, attempts)
+			}
+			return
+		}
+
+		backoff := s.retryBackoff() << (attempt - 1)
+		s.GetLogger(strings.ToUpper(checkNames[i]), 0).Warnf("Check failed, retrying in %v: %v", backoff, *s.results()[i])
+		select {
+		case <-time.After(backoff):
+		case <-s.runContext().Done():
+			return
+		}
+		*s.states()[i] = ""
+		s.classes[i] = ""
+	}
+}
+
+// RunChecks runs all service checks for a server in goroutines, persisting
+// the results once every check has reported or the run has timed out. When
+// ctx is cancelled, the checks are abandoned and the server released.
+func (s *Server) RunChecks(ctx context.Context) {
+
+	s.ctx = ctx
+	s.run = runInfo{started: time.Now()}
+	s.schedule()
+
+	if s.AllAddrs {
+		s.checkAllAddrs()
+	} else {
+		done := make(chan checkRun, numChecks)
+		s.collect(done, s.startChecks(done))
+	}
+
+	s.persist()
+}
+
+// releaseClaim lets the server be claimed again once it's due, unless
+// another instance took over an expired claim meanwhile. A recheck
+// requested before the claim has been done, one requested since still
+// stands.
+func (s *Server) releaseClaim() {
+	s.unclaim(`
+		UPDATE servers SET claimedby = '', recheckat = CASE WHEN recheckat <= claimedat THEN 0 ELSE recheckat END
+		WHERE id = ? AND claimedby = ? AND claimedat = ?
+	`)
+}
+
+// Release gives up the claim on a server without checking it, e.g. on
+// shutdown, leaving it due so the next instance checks it straight away
+func (s *Server) Release() {
+	s.unclaim("UPDATE servers SET claimedby = '', lastupdate = 0 WHERE id = ? AND claimedby = ? AND claimedat = ?")
+}
+
+// unclaim runs a query clearing the server's claim, if this instance's
+// claim is still the one recorded
+func (s *Server) unclaim(query string) {
+	if s.ClaimedBy == "" {
+		return
+	}
+
+	if _, err := s.DB.Exec(query, s.ID, s.ClaimedBy, s.ClaimedAt); err != nil {
+		s.GetLogger("BATCH", 0).WithError(err).Error("Unable to release claim")
+	}
 }
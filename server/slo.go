@@ -0,0 +1,88 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// burnAlert fires when the error budget is being spent at least Rate times
+// faster than the SLO allows over both a long and short window. The short
+// window stops the alert firing long after the problem has gone away.
+type burnAlert struct {
+	Name  string
+	Long  time.Duration
+	Short time.Duration
+	Rate  float64
+	State State
+}
+
+// burnAlerts follow the multi-window, multi-burn-rate alerts in the Google
+// SRE workbook: a fast burn spends 2% of a 30 day budget in an hour, a slow
+// burn 5% in six hours
+var burnAlerts = []burnAlert{
+	{"Fast burn", time.Hour, 5 * time.Minute, 14.4, StateCrit},
+	{"Slow burn", 6 * time.Hour, 30 * time.Minute, 6, StateWarn},
+}
+
+// CheckSLO compares the share of failed checks in the history against the
+// server's availability objective
+func (s *Server) CheckSLO(wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	if s.SLO <= 0 || s.SLO >= 100 {
+		return
+	}
+
+	logger := s.GetLogger("SLO", 0)
+	s.setState(checkSLO, StateOK)
+
+	budget := 1 - s.SLO/100
+
+	for _, alert := range burnAlerts {
+		long, err := s.errorRatio(alert.Long)
+		if err != nil {
+			s.ResultSLO = "Unable to read check history"
+			logger.WithError(err).Error(s.ResultSLO)
+			s.fail(checkSLO, StateCrit, ErrorResponse)
+			return
+		}
+
+		short, err := s.errorRatio(alert.Short)
+		if err != nil {
+			s.ResultSLO = "Unable to read check history"
+			logger.WithError(err).Error(s.ResultSLO)
+			s.fail(checkSLO, StateCrit, ErrorResponse)
+			return
+		}
+
+		if long/budget >= alert.Rate && short/budget >= alert.Rate {
+			s.ResultSLO = fmt.Sprintf("%v: %.1fx over %v, %.1fx over %v against %v%%",
+				alert.Name, long/budget, alert.Long, short/budget, alert.Short, s.SLO)
+			logger.Warn(s.ResultSLO)
+			s.fail(checkSLO, alert.State, ErrorResponse)
+			return
+		}
+	}
+
+	hour, _ := s.errorRatio(time.Hour)
+	s.ResultSLO = fmt.Sprintf("Burn rate %.1fx over 1h against %v%%", hour/budget, s.SLO)
+	logger.Infof("SLO Check OK. %v", s.ResultSLO)
+}
+
+// errorRatio is the share of check runs in the window which were CRIT,
+// leaving out the SLO check itself and runs during maintenance
+func (s *Server) errorRatio(window time.Duration) (float64, error) {
+	var runs, failed int
+
+	err := s.DB.QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM(state = 'CRIT'), 0) FROM check_history
+		WHERE serverid = ? AND checkname != 'slo' AND maintenance = 0 AND checkedat >= ?
+	`, s.ID, time.Now().Add(-window).Unix()).Scan(&runs, &failed)
+	if err != nil || runs == 0 {
+		return 0, err
+	}
+
+	return float64(failed) / float64(runs), nil
+}
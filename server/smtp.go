@@ -0,0 +1,145 @@
+package server
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"strings"
+)
+
+// smtpSession is what was learned talking to a mail server past its greeting
+type smtpSession struct {
+	// tls is set once the session was upgraded with STARTTLS
+	tls *tls.ConnectionState
+	// replies are the response codes to the probe transaction, e.g. "RCPT 250"
+	replies []string
+}
+
+// ehloName is the name we introduce ourselves to mail servers with
+func ehloName() string {
+	if name, err := os.Hostname(); err == nil && name != "" {
+		return name
+	}
+
+	return "localhost"
+}
+
+// smtpSession continues an SMTP conversation after the greeting, whose first
+// line has already been read. With StartTLS set the server must advertise
+// STARTTLS and negotiate TLS. With RcptSMTP set a transaction is started
+// with MAIL FROM and RCPT TO and abandoned with RSET, so nothing is sent.
+func (s *Server) smtpSession(conn net.Conn, reader *bufio.Reader, greeting string) (*smtpSession, error) {
+	session := &smtpSession{}
+	text := textproto.NewConn(struct {
+		io.Reader
+		io.Writer
+		io.Closer
+	}{reader, conn, conn})
+
+	// Skip the rest of a multi-line greeting
+	for line := greeting; len(line) > 3 && line[3] == '-'; {
+		var err error
+		if line, err = text.ReadLine(); err != nil {
+			return nil, failure(ErrorTimeout, "No response received from server")
+		}
+	}
+
+	ext, err := ehlo(text)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.StartTLS && !s.TLSSMTP {
+		config, err := s.tlsConfig()
+		if err != nil {
+			return nil, failure(ErrorConfig, "Invalid TLS configuration")
+		}
+
+		if !ext["STARTTLS"] {
+			return nil, failure(ErrorTLS, "STARTTLS not offered")
+		}
+
+		if _, msg, err := command(text, 220, "STARTTLS"); err != nil {
+			return nil, failure(ErrorTLS, "STARTTLS refused: %v", msg)
+		}
+
+		tlsConn := tls.Client(conn, config)
+		if err := tlsConn.Handshake(); err != nil {
+			return nil, failure(ErrorTLS, "STARTTLS handshake failed: %v", err)
+		}
+
+		state := tlsConn.ConnectionState()
+		session.tls = &state
+
+		text = textproto.NewConn(tlsConn)
+		if _, err := ehlo(text); err != nil {
+			return nil, err
+		}
+	}
+
+	defer text.Cmd("QUIT")
+
+	if s.RcptSMTP == "" {
+		return session, nil
+	}
+
+	from := s.FromSMTP
+	if from == "" {
+		from = "vbms@" + ehloName()
+	}
+
+	steps := []struct {
+		verb string
+		line string
+	}{
+		{"MAIL", fmt.Sprintf("MAIL FROM:<%v>", from)},
+		{"RCPT", fmt.Sprintf("RCPT TO:<%v>", s.RcptSMTP)},
+		{"RSET", "RSET"},
+	}
+
+	for _, step := range steps {
+		code, msg, err := command(text, 25, step.line)
+		if code != 0 {
+			session.replies = append(session.replies, fmt.Sprintf("%v %d", step.verb, code))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%v rejected: %d %v", step.verb, code, msg)
+		}
+	}
+
+	return session, nil
+}
+
+// command sends a line and reads the reply, expecting the given code
+func command(text *textproto.Conn, expect int, line string) (int, string, error) {
+	id, err := text.Cmd("%s", line)
+	if err != nil {
+		return 0, "", err
+	}
+
+	text.StartResponse(id)
+	defer text.EndResponse(id)
+
+	return text.ReadResponse(expect)
+}
+
+// ehlo greets the server and returns the extensions it advertises
+func ehlo(text *textproto.Conn) (map[string]bool, error) {
+	_, msg, err := command(text, 250, "EHLO "+ehloName())
+	if err != nil {
+		return nil, fmt.Errorf("EHLO rejected: %v", msg)
+	}
+
+	ext := map[string]bool{}
+	for _, line := range strings.Split(msg, "\n")[1:] {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			ext[strings.ToUpper(fields[0])] = true
+		}
+	}
+
+	return ext, nil
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+// defaultOID is sysUpTime.0, which every SNMP agent serves
+const defaultOID = ".1.3.6.1.2.1.1.3.0"
+
+// CheckSNMP performs an SNMPv2c GET of OIDSNMP on port 161 and records the
+// value, so switches, printers and UPSes can be covered too
+func (s *Server) CheckSNMP(wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	if !s.EnableSNMP {
+		return
+	}
+
+	logger := s.GetLogger("SNMP", 161)
+	s.setState(checkSNMP, StateOK)
+
+	// UDP can't be carried over an SSH tunnel
+	if spec, _ := s.sshJump(); spec != "" {
+		s.ResultSNMP = "SNMP not available through SSH jump host"
+		logger.Error(s.ResultSNMP)
+		s.fail(checkSNMP, StateCrit, ErrorConfig)
+		return
+	}
+
+	community := s.CommunitySNMP
+	if community == "" {
+		community = "public"
+	}
+
+	oid := s.OIDSNMP
+	if oid == "" {
+		oid = defaultOID
+	}
+
+	client := &gosnmp.GoSNMP{
+		Target:    s.IP,
+		Port:      161,
+		Community: community,
+		Version:   gosnmp.Version2c,
+		Timeout:   s.timeout(),
+		Retries:   1,
+	}
+
+	if err := client.Connect(); err != nil {
+		s.ResultSNMP = "Unable to open SNMP connection"
+		logger.WithError(err).Error(s.ResultSNMP)
+		s.fail(checkSNMP, StateCrit, ErrorConnect)
+		return
+	}
+
+	defer client.Conn.Close()
+
+	packet, err := client.Get([]string{oid})
+	if err != nil {
+		s.ResultSNMP = "No response received from agent"
+		logger.WithError(err).Error(s.ResultSNMP)
+		s.fail(checkSNMP, StateCrit, ErrorTimeout)
+		return
+	}
+
+	if packet.Error != gosnmp.NoError || len(packet.Variables) == 0 {
+		s.ResultSNMP = fmt.Sprintf("GET %v failed: %v", oid, packet.Error)
+		logger.Error(s.ResultSNMP)
+		s.fail(checkSNMP, StateCrit, ErrorResponse)
+		return
+	}
+
+	variable := packet.Variables[0]
+
+	switch variable.Type {
+	case gosnmp.NoSuchObject, gosnmp.NoSuchInstance, gosnmp.EndOfMibView:
+		s.ResultSNMP = fmt.Sprintf("%v not found", oid)
+		logger.Error(s.ResultSNMP)
+		s.fail(checkSNMP, StateCrit, ErrorResponse)
+		return
+	}
+
+	s.ResultSNMP = fmt.Sprintf("%v = %v", oid, snmpValue(variable))
+	logger.Infof("SNMP Check OK. Response: %v", s.ResultSNMP)
+}
+
+// snmpValue formats a variable for display. TimeTicks are hundredths of a
+// second, shown as a duration.
+func snmpValue(variable gosnmp.SnmpPDU) string {
+	switch variable.Type {
+	case gosnmp.OctetString:
+		return string(variable.Value.([]byte))
+	case gosnmp.TimeTicks:
+		ticks := gosnmp.ToBigInt(variable.Value).Int64()
+		return (time.Duration(ticks) * 10 * time.Millisecond).String()
+	case gosnmp.Integer, gosnmp.Counter32, gosnmp.Gauge32, gosnmp.Counter64, gosnmp.Uinteger32:
+		return gosnmp.ToBigInt(variable.Value).String()
+	default:
+		return fmt.Sprint(variable.Value)
+	}
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// errHostKeySeen stops the SSH handshake once the host key has been read,
+// since the check never logs in
+var errHostKeySeen = errors.New("host key seen")
+
+// CheckSSH reads the SSH version banner and, when SSHFingerprint is set,
+// checks the host key against it to catch re-imaged hosts or a MITM
+func (s *Server) CheckSSH(wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	if !s.EnableSSH {
+		return
+	}
+
+	port := s.PortSSH
+	if port == 0 {
+		port = 22
+	}
+
+	logger := s.GetLogger("SSH", port)
+	s.setState(checkSSH, StateOK)
+
+	addr := net.JoinHostPort(s.IP, strconv.Itoa(port))
+	conn, err := s.dial(addr)
+	if err != nil {
+		s.ResultSSH = "Unable to open SSH connection"
+		logger.WithError(err).Error(s.ResultSSH)
+		s.fail(checkSSH, StateCrit, ErrorConnect)
+		return
+	}
+
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(s.timeout()))
+
+	// Expect "SSH-2.0-OpenSSH_9.6"
+	reader := bufio.NewReader(conn)
+	banner, err := reader.ReadString('\n')
+	if err != nil {
+		s.ResultSSH = "No response received from server"
+		logger.Error(s.ResultSSH)
+		s.fail(checkSSH, StateCrit, ErrorTimeout)
+		return
+	}
+	banner = strings.TrimSpace(banner)
+	s.ResultSSH = banner
+
+	if !strings.HasPrefix(banner, "SSH-") {
+		logger.Errorf("Returned invalid SSH banner: '%v'", banner)
+		s.fail(checkSSH, StateCrit, ErrorResponse)
+		return
+	}
+
+	if s.SSHFingerprint == "" {
+		logger.Infof("SSH Check OK. Banner: %v", banner)
+		return
+	}
+
+	// Replay the banner so the handshake sees the whole stream
+	replay := &replayConn{io.MultiReader(strings.NewReader(banner+"\r\n"), reader), conn}
+
+	var fingerprint string
+	_, _, _, err = ssh.NewClientConn(replay, addr, &ssh.ClientConfig{
+		User: "vbms",
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			fingerprint = ssh.FingerprintSHA256(key)
+			return errHostKeySeen
+		},
+		Timeout: s.timeout(),
+	})
+	if fingerprint == "" {
+		s.ResultSSH = "SSH handshake failed"
+		logger.WithError(err).Error(s.ResultSSH)
+		s.fail(checkSSH, StateCrit, ErrorResponse)
+		return
+	}
+
+	if fingerprint != strings.TrimSpace(s.SSHFingerprint) {
+		s.ResultSSH = fmt.Sprintf("Host key changed: %v", fingerprint)
+		logger.Error(s.ResultSSH)
+		s.fail(checkSSH, StateCrit, ErrorResponse)
+		return
+	}
+
+	s.ResultSSH = fmt.Sprintf("%v (host key %v)", banner, fingerprint)
+	logger.Infof("SSH Check OK. %v", s.ResultSSH)
+}
+
+// replayConn reads from Reader instead of the connection it wraps
+type replayConn struct {
+	io.Reader
+	net.Conn
+}
+
+func (c *replayConn) Read(b []byte) (int, error) {
+	return c.Reader.Read(b)
+}
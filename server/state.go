@@ -0,0 +1,247 @@
+package server
+
+import "strings"
+
+// State is the severity of a check's last result
+type State string
+
+// States, in increasing order of severity. A disabled check has no state.
+// UNREACHABLE is a failure behind a parent that is down, see markUnreachable.
+const (
+	StateOK          State = "OK"
+	StateWarn        State = "WARN"
+	StateUnknown     State = "UNKNOWN"
+	StateUnreachable State = "UNREACHABLE"
+	StateCrit        State = "CRIT"
+)
+
+// severity ranks states so a check can only be made worse on a run
+var severity = map[State]int{
+	"":               0,
+	StateOK:          1,
+	StateWarn:        2,
+	StateUnknown:     3,
+	StateUnreachable: 4,
+	StateCrit:        5,
+}
+
+// Worse reports whether st is more severe than other
+func (st State) Worse(other State) bool {
+	return severity[st] > severity[other]
+}
+
+// ExitCode returns the plugin exit code for a state, as Nagios reads them,
+// with no state counting as OK
+func (st State) ExitCode() int {
+	switch st {
+	case StateWarn:
+		return 1
+	case StateCrit:
+		return 2
+	case StateUnknown, StateUnreachable:
+		return 3
+	}
+
+	return 0
+}
+
+// StateColumns are bookkeeping written by checks, like results, rather than
+// configured. Applying a config leaves them alone.
+var StateColumns = []string{
+	"pingfailures",
+	"wolsent",
+	"httpstate",
+	"smtpstate",
+	"pop3state",
+	"httpsstate",
+	"pingstate",
+	"pluginstate",
+	"clockstate",
+	"comparestate",
+	"slostate",
+	"imapstate",
+	"pop3sstate",
+	"sshstate",
+	"ftpstate",
+	"mysqlstate",
+	"pgstate",
+	"ldapstate",
+	"snmpstate",
+	"grpcstate",
+	"domainstate",
+	"dnsblstate",
+	"http3state",
+	"jsonstate",
+	"transactionstate",
+	"execstate",
+	"heartbeatstate",
+	"promstate",
+	"amqpstate",
+	"kafkastate",
+	"bannerstate",
+	"sourcestate",
+	"mcstate",
+	"radiusstate",
+	"dnsstate",
+	"dockerstate",
+	"k8sstate",
+	"certexpiry",
+	"httpsha256",
+	"httpssha256",
+	"httpbytes",
+	"httpbaseline",
+	"httpdownloadtime",
+	"httpsbytes",
+	"httpsbaseline",
+	"httpsdownloadtime",
+	"claimedby",
+	"claimedat",
+	"recheckat",
+	"paused",
+	"pausedat",
+	"pausedreason",
+	"heartbeatat",
+	"failing",
+	"domainexpiry",
+	"domaincheckedat",
+	"jsonvalues",
+	"pingloss",
+	"pingmin",
+	"pingavg",
+	"pingmax",
+	"pingjitter",
+	"httpdns",
+	"httpconnect",
+	"httptls",
+	"httpttfb",
+	"httptotal",
+	"httpsdns",
+	"httpsconnect",
+	"httpstls",
+	"httpsttfb",
+	"httpstotal",
+	"sourcename",
+	"sourceplayers",
+	"sourcemaxplayers",
+	"mcmotd",
+	"mcplayers",
+	"mcmaxplayers",
+	"traceat",
+	"tlsversion",
+	"tlscipher",
+	"kafkabrokers",
+}
+
+// certWarnDays is how close to expiry a certificate makes a check WARN
+const certWarnDays = 14
+
+// states returns the state fields in the same order as results
+func (s *Server) states() []*State {
+	return []*State{
+		&s.StateHTTP,
+		&s.StateSMTP,
+		&s.StatePOP3,
+		&s.StateHTTPS,
+		&s.StatePing,
+		&s.StatePlugins,
+		&s.StateClock,
+		&s.StateCompare,
+		&s.StateSLO,
+		&s.StateIMAP,
+		&s.StatePOP3S,
+		&s.StateSSH,
+		&s.StateFTP,
+		&s.StateMySQL,
+		&s.StatePostgres,
+		&s.StateLDAP,
+		&s.StateSNMP,
+		&s.StateGRPC,
+		&s.StateDomain,
+		&s.StateDNSBL,
+		&s.StateHTTP3,
+		&s.StateJSON,
+		&s.StateTransaction,
+		&s.StateExec,
+		&s.StateHeartbeat,
+		&s.StatePrometheus,
+		&s.StateAMQP,
+		&s.StateKafka,
+		&s.StateBanner,
+		&s.StateSource,
+		&s.StateMinecraft,
+		&s.StateRADIUS,
+		&s.StateDNS,
+		&s.StateDocker,
+		&s.StateKubernetes,
+	}
+}
+
+// enabled reports which checks will run, matching the conditions each
+// check returns early on
+func (s *Server) enabled() [numChecks]bool {
+	return [numChecks]bool{
+		checkHTTP:        s.EnableHTTP,
+		checkSMTP:        s.EnableSMTP,
+		checkPOP3:        s.EnablePOP3,
+		checkHTTPS:       s.EnableHTTPS,
+		checkPing:        s.EnablePing,
+		checkPlugins:     strings.TrimSpace(s.Plugins) != "",
+		checkClock:       s.EnableClock,
+		checkCompare:     s.CompareWith != "",
+		checkSLO:         s.SLO > 0 && s.SLO < 100,
+		checkIMAP:        s.EnableIMAP,
+		checkPOP3S:       s.EnablePOP3S,
+		checkSSH:         s.EnableSSH,
+		checkFTP:         s.EnableFTP,
+		checkMySQL:       s.EnableMySQL,
+		checkPostgres:    s.EnablePostgres,
+		checkLDAP:        s.EnableLDAP,
+		checkSNMP:        s.EnableSNMP,
+		checkGRPC:        s.EnableGRPC,
+		checkDomain:      s.EnableDomain,
+		checkDNSBL:       s.EnableDNSBL,
+		checkHTTP3:       s.EnableHTTP3,
+		checkJSON:        s.EnableJSON,
+		checkTransaction: s.EnableTransaction,
+		checkExec:        s.EnableExec,
+		checkHeartbeat:   s.EnableHeartbeat,
+		checkPrometheus:  s.EnablePrometheus,
+		checkAMQP:        s.EnableAMQP,
+		checkKafka:       s.EnableKafka,
+		checkBanner:      s.EnableBanner,
+		checkSource:      s.EnableSource,
+		checkMinecraft:   s.EnableMinecraft,
+		checkRADIUS:      s.EnableRADIUS,
+		checkDNS:         s.EnableDNS,
+		checkDocker:      s.EnableDocker,
+		checkKubernetes:  s.EnableKubernetes,
+	}
+}
+
+// setState raises a check's state to st, leaving a worse state in place
+func (s *Server) setState(check int, st State) {
+	cur := s.states()[check]
+	if severity[st] > severity[*cur] {
+		*cur = st
+	}
+}
+
+// fail raises a check's state to st as setState does, recording class as
+// why it failed when that makes it worse
+func (s *Server) fail(check int, st State, class ErrorClass) {
+	if severity[st] > severity[*s.states()[check]] {
+		s.classes[check] = class
+	}
+	s.setState(check, st)
+}
+
+// state returns the state of a check by its database name
+func (s *Server) state(name string) State {
+	for i, check := range checkNames {
+		if check == name {
+			return *s.states()[i]
+		}
+	}
+
+	return ""
+}
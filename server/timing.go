@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http/httptrace"
+	"time"
+)
+
+// httpTiming records how long the phases of an HTTP request took, for the
+// last connection made when redirects are followed. Phases a request didn't
+// need, such as the DNS lookup for an IP address, stay zero.
+type httpTiming struct {
+	start   time.Time
+	DNS     time.Duration
+	Connect time.Duration
+	TLS     time.Duration
+	TTFB    time.Duration
+	Total   time.Duration
+}
+
+// timingKey carries the request's httpTiming to the transport's dialers
+type timingKey struct{}
+
+// trace returns ctx set up to record into t, starting the clock
+func (t *httpTiming) trace(ctx context.Context) context.Context {
+	t.start = time.Now()
+
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotFirstResponseByte: func() {
+			t.TTFB = time.Since(t.start)
+		},
+	})
+
+	return context.WithValue(ctx, timingKey{}, t)
+}
+
+// done records the total time once the response has been received
+func (t *httpTiming) done() {
+	t.Total = time.Since(t.start)
+}
+
+// millis returns the phases in milliseconds, as they are stored
+func (t *httpTiming) millis() (float64, float64, float64, float64, float64) {
+	ms := func(d time.Duration) float64 {
+		return float64(d.Microseconds()) / 1000
+	}
+
+	return ms(t.DNS), ms(t.Connect), ms(t.TLS), ms(t.TTFB), ms(t.Total)
+}
+
+// requestTiming returns the httpTiming a request's context carries, if any
+func requestTiming(ctx context.Context) *httpTiming {
+	timing, _ := ctx.Value(timingKey{}).(*httpTiming)
+	return timing
+}
+
+// dialTimed dials addr like dial, recording the DNS lookup and connect times
+// when the request is timed. A jump host or proxy resolves names itself, so
+// the lookup can only be split out without one.
+func (s *Server) dialTimed(ctx context.Context, addr string) (net.Conn, error) {
+	timing := requestTiming(ctx)
+	if timing == nil {
+		return s.dial(addr)
+	}
+
+	addrs := []string{addr}
+
+	host, port, err := net.SplitHostPort(addr)
+	if spec, _ := s.sshJump(); spec == "" && s.proxyURL() == "" && err == nil && net.ParseIP(host) == nil {
+		start := time.Now()
+		ips, err := net.DefaultResolver.LookupHost(ctx, host)
+		timing.DNS = time.Since(start)
+		if err != nil {
+			return nil, err
+		}
+
+		addrs = addrs[:0]
+		for _, ip := range ips {
+			addrs = append(addrs, net.JoinHostPort(ip, port))
+		}
+	}
+
+	// Try each address in turn, as dialing the name would
+	start := time.Now()
+	var conn net.Conn
+	for _, addr := range addrs {
+		if conn, err = s.dial(addr); err == nil {
+			break
+		}
+	}
+	timing.Connect = time.Since(start)
+
+	return conn, err
+}
+
+// slowResponse describes a response slower than LatencyHTTP milliseconds,
+// or returns "" when it's quick enough or no threshold is set
+func (s *Server) slowResponse(latency time.Duration) string {
+	if s.LatencyHTTP <= 0 || latency <= time.Duration(s.LatencyHTTP)*time.Millisecond {
+		return ""
+	}
+
+	return fmt.Sprintf("slow, %v over %dms", latency.Round(time.Millisecond), s.LatencyHTTP)
+}
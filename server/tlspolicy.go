@@ -0,0 +1,97 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+)
+
+// TLS policy levels a server can be held to
+const (
+	// PolicyIntermediate rejects TLS 1.0/1.1 and weak cipher suites
+	PolicyIntermediate = "intermediate"
+	// PolicyModern additionally rejects anything below TLS 1.3
+	PolicyModern = "modern"
+)
+
+// weakCiphers are the suites Go itself considers insecure, e.g. RC4 and 3DES
+func weakCiphers() []uint16 {
+	var ids []uint16
+	for _, suite := range tls.InsecureCipherSuites() {
+		ids = append(ids, suite.ID)
+	}
+	return ids
+}
+
+// accepts reports whether the server completes a handshake restricted to the
+// given versions and, when set, cipher suites
+func (s *Server) accepts(addr string, base *tls.Config, maxVersion uint16, ciphers []uint16) bool {
+	config := base.Clone()
+	config.InsecureSkipVerify = true
+	config.VerifyConnection = nil
+	config.MinVersion = tls.VersionTLS10
+	config.MaxVersion = maxVersion
+	config.CipherSuites = ciphers
+
+	conn, err := s.dialTLS(addr, config)
+	if err != nil {
+		return false
+	}
+
+	conn.Close()
+	return true
+}
+
+// checkTLSPolicy probes addr with deliberately weak handshakes and returns
+// an error describing the first thing the server should have refused
+func (s *Server) checkTLSPolicy(addr string, config *tls.Config) error {
+	var maxAllowed uint16
+
+	switch s.TLSPolicy {
+	case "":
+		return nil
+	case PolicyIntermediate:
+		maxAllowed = tls.VersionTLS12
+	case PolicyModern:
+		maxAllowed = tls.VersionTLS13
+	default:
+		return fmt.Errorf("Unknown TLS policy '%v'", s.TLSPolicy)
+	}
+
+	for _, version := range []uint16{tls.VersionTLS10, tls.VersionTLS11, tls.VersionTLS12} {
+		if version >= maxAllowed {
+			break
+		}
+		if s.accepts(addr, config, version, nil) {
+			return fmt.Errorf("TLS policy violation: accepts %v", tls.VersionName(version))
+		}
+	}
+
+	if maxAllowed == tls.VersionTLS12 && s.accepts(addr, config, tls.VersionTLS12, weakCiphers()) {
+		return fmt.Errorf("TLS policy violation: accepts weak cipher suites")
+	}
+
+	return nil
+}
+
+// verifyChain returns a VerifyConnection callback checking the presented
+// chain against roots, or the system pool when nil, but not the name
+func verifyChain(roots *x509.CertPool) func(tls.ConnectionState) error {
+	return func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) == 0 {
+			return errors.New("no certificate presented")
+		}
+
+		opts := x509.VerifyOptions{Roots: roots, Intermediates: x509.NewCertPool()}
+		for _, cert := range state.PeerCertificates[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+
+		if _, err := state.PeerCertificates[0].Verify(opts); err != nil {
+			return &tls.CertificateVerificationError{UnverifiedCertificates: state.PeerCertificates, Err: err}
+		}
+
+		return nil
+	}
+}
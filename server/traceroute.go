@@ -0,0 +1,255 @@
+package server
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// Traceroute probe types
+const (
+	TraceICMP = "icmp"
+	TraceTCP  = "tcp"
+)
+
+// maxHops is how far a traceroute goes before giving up, as traceroute(8)
+const maxHops = 30
+
+// hopTimeout is how long each hop has to answer
+const hopTimeout = time.Second
+
+// traceChecks are the checks whose failure is worth a traceroute. The SLO,
+// comparison and plugin checks don't fail because of the path.
+var traceChecks = []int{checkHTTP, checkSMTP, checkPOP3, checkHTTPS, checkHTTP3, checkJSON, checkTransaction, checkPrometheus, checkAMQP, checkKafka, checkBanner, checkSource, checkMinecraft, checkRADIUS, checkPing, checkClock,
+	checkIMAP, checkPOP3S, checkSSH, checkFTP, checkMySQL, checkPostgres, checkLDAP, checkSNMP, checkGRPC}
+
+// traceOnFailure runs a traceroute when a network check has just gone CRIT,
+// so the path at the start of the outage is kept with the server. It runs
+// once per incident and is cleared when every check recovers.
+func (s *Server) traceOnFailure() {
+	if s.Traceroute == "" {
+		return
+	}
+
+	failing := false
+	for _, check := range traceChecks {
+		if *s.states()[check] == StateCrit {
+			failing = true
+		}
+	}
+
+	switch {
+	case !failing && s.ResultTrace == "":
+		return
+	case !failing:
+		s.ResultTrace, s.TraceAt = "", 0
+	case s.ResultTrace != "":
+		return
+	default:
+		logger := s.GetLogger("TRACE", 0)
+		s.TraceAt = time.Now().Unix()
+
+		hops, err := s.traceroute()
+		if err != nil {
+			s.ResultTrace = fmt.Sprintf("Traceroute failed: %v", err)
+			logger.WithError(err).Error("Traceroute failed")
+		} else {
+			s.ResultTrace = hops
+			logger.Infof("Traceroute: %v", hops)
+		}
+	}
+
+	if _, err := s.DB.Exec("UPDATE servers SET traceresult = ?, traceat = ? WHERE id = ?", s.ResultTrace, s.TraceAt, s.ID); err != nil {
+		s.GetLogger("TRACE", 0).WithError(err).Error("Unable to record traceroute")
+	}
+}
+
+// traceroute probes the path to the server one TTL at a time and lists the
+// hops, e.g. "1 10.0.0.1 0.4ms; 2 *; 3 203.0.113.10 12.1ms". Traceroute is
+// "icmp" for echo requests, or "tcp" or "tcp:443" for SYNs to a port, 80
+// unless given. Either way ICMP replies are read from a raw socket, which
+// needs root or CAP_NET_RAW.
+func (s *Server) traceroute() (string, error) {
+	// Probes can't be carried over an SSH tunnel
+	if spec, _ := s.sshJump(); spec != "" {
+		return "", errors.New("not available through SSH jump host")
+	}
+
+	ip, err := s.lookupIP("ip4")
+	if err != nil {
+		return "", fmt.Errorf("only IPv4 targets can be traced: %v", err)
+	}
+	ip = ip.To4()
+
+	mode, port := s.Traceroute, 80
+	if i := strings.Index(mode, ":"); i >= 0 {
+		p, err := strconv.Atoi(mode[i+1:])
+		if err != nil {
+			return "", fmt.Errorf("invalid port in '%v'", s.Traceroute)
+		}
+		mode, port = mode[:i], p
+	}
+
+	if mode != TraceICMP && mode != TraceTCP {
+		return "", fmt.Errorf("unknown traceroute type '%v'", mode)
+	}
+
+	conn, err := icmp.ListenPacket("ip4:icmp", "0.0.0.0")
+	if err != nil {
+		return "", err
+	}
+
+	defer conn.Close()
+
+	id := rand.Intn(0xffff)
+	buf := make([]byte, 1500)
+	var hops []string
+
+	for ttl := 1; ttl <= maxHops; ttl++ {
+		sent := time.Now()
+
+		// A TCP probe answered by the target ends the trace
+		reached := make(chan time.Duration, 1)
+		if mode == TraceTCP {
+			go func(ttl int) {
+				reached <- tcpProbe(ip, port, ttl)
+			}(ttl)
+		} else if err := echoProbe(conn, ip, id, ttl); err != nil {
+			return "", err
+		}
+
+		conn.SetReadDeadline(sent.Add(hopTimeout))
+
+		var hop net.IP
+		var rtt time.Duration
+		final := false
+
+		for hop == nil {
+			n, peer, err := conn.ReadFrom(buf)
+			if err != nil {
+				break
+			}
+
+			from := peer.(*net.IPAddr).IP
+			msg, err := icmp.ParseMessage(1, buf[:n])
+			if err != nil {
+				continue
+			}
+
+			switch body := msg.Body.(type) {
+			case *icmp.Echo:
+				if mode == TraceICMP && msg.Type == ipv4.ICMPTypeEchoReply && body.ID == id && body.Seq == ttl && from.Equal(ip) {
+					hop, final = from, true
+				}
+			case *icmp.TimeExceeded:
+				if probeMatches(body.Data, mode, ip, id, ttl, port) {
+					hop = from
+				}
+			case *icmp.DstUnreach:
+				if probeMatches(body.Data, mode, ip, id, ttl, port) {
+					hop, final = from, true
+				}
+			}
+			rtt = time.Since(sent)
+		}
+
+		if hop == nil && mode == TraceTCP {
+			if took := <-reached; took > 0 {
+				hop, rtt, final = ip, took, true
+			}
+		}
+
+		if hop == nil {
+			hops = append(hops, fmt.Sprintf("%d *", ttl))
+			continue
+		}
+
+		hops = append(hops, fmt.Sprintf("%d %v %vms", ttl, hop, milliseconds(rtt.Round(100*time.Microsecond))))
+		if final {
+			break
+		}
+	}
+
+	return strings.Join(hops, "; "), nil
+}
+
+// echoProbe sends an echo request to ip that expires after ttl hops
+func echoProbe(conn *icmp.PacketConn, ip net.IP, id int, ttl int) error {
+	if err := conn.IPv4PacketConn().SetTTL(ttl); err != nil {
+		return err
+	}
+
+	msg := icmp.Message{
+		Type: ipv4.ICMPTypeEcho,
+		Body: &icmp.Echo{ID: id, Seq: ttl, Data: []byte("vbms")},
+	}
+
+	packet, err := msg.Marshal(nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.WriteTo(packet, &net.IPAddr{IP: ip})
+	return err
+}
+
+// tcpProbe opens a connection to ip:port that expires after ttl hops. It
+// returns how long the target took to answer, with a SYN-ACK or a reset, or
+// 0 if it didn't.
+func tcpProbe(ip net.IP, port int, ttl int) time.Duration {
+	dialer := net.Dialer{
+		Timeout: hopTimeout,
+		Control: func(network, address string, c syscall.RawConn) error {
+			var err error
+			c.Control(func(fd uintptr) {
+				err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TTL, ttl)
+			})
+			return err
+		},
+	}
+
+	start := time.Now()
+	conn, err := dialer.Dial("tcp4", net.JoinHostPort(ip.String(), strconv.Itoa(port)))
+	if err == nil {
+		conn.Close()
+		return time.Since(start)
+	}
+
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return time.Since(start)
+	}
+
+	return 0
+}
+
+// probeMatches reports whether the original datagram quoted in an ICMP error
+// is our probe for ttl: an echo request with our ID and sequence, or a TCP
+// segment to the traced port
+func probeMatches(data []byte, mode string, ip net.IP, id int, ttl int, port int) bool {
+	if len(data) < 20 {
+		return false
+	}
+
+	header := int(data[0]&0x0f) * 4
+	if len(data) < header+8 || !net.IP(data[16:20]).Equal(ip) {
+		return false
+	}
+	quoted := data[header:]
+
+	switch mode {
+	case TraceICMP:
+		return data[9] == 1 && quoted[0] == byte(ipv4.ICMPTypeEcho) &&
+			int(binary.BigEndian.Uint16(quoted[4:6])) == id && int(binary.BigEndian.Uint16(quoted[6:8])) == ttl
+	default:
+		return data[9] == 6 && int(binary.BigEndian.Uint16(quoted[2:4])) == port
+	}
+}
@@ -0,0 +1,358 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blinktag/vbms/secrets"
+	"github.com/kisielk/sqlstruct"
+)
+
+// maxStepRedirects is how many redirects a step with follow set goes through
+const maxStepRedirects = 10
+
+// stepVariable matches {{name}} references to values captured by earlier
+// steps, which are distinct from ${VAR} environment references
+var stepVariable = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// TransactionStep is one request of a scripted transaction. Steps run in
+// position order sharing a cookie jar, and values captured from a response
+// can be used in the URL, headers and body of the steps after it.
+type TransactionStep struct {
+	ID        int    `sql:"id"`
+	ServerID  int    `sql:"serverid"`
+	Position  int    `sql:"position"`
+	Method    string `sql:"method"`
+	URL       string `sql:"url"`
+	Headers   string `sql:"headers"`
+	Body      string `sql:"body"`
+	Follow    bool   `sql:"follow"`
+	Expect    string `sql:"expect"`
+	Match     string `sql:"match"`
+	Capture   string `sql:"capture"`
+	Result    string `sql:"result"`
+	State     State  `sql:"state"`
+	Duration  int64  `sql:"duration"`
+	CheckedAt int64  `sql:"checkedat"`
+}
+
+// CheckTransaction runs the server's transaction steps in order, e.g. a login
+// POST, the redirect it returns and the dashboard behind it. The first step
+// to fail fails the check and the rest are skipped. Each step's outcome is
+// saved with it.
+func (s *Server) CheckTransaction(wg *sync.WaitGroup) {
+
+	defer wg.Done()
+
+	if !s.EnableTransaction {
+		return
+	}
+
+	logger := s.GetLogger("TRANSACTION", 0)
+	s.setState(checkTransaction, StateOK)
+
+	steps, err := s.transactionSteps()
+	if err != nil {
+		s.ResultTransaction = "Unable to load transaction steps"
+		logger.WithError(err).Error(s.ResultTransaction)
+		s.fail(checkTransaction, StateCrit, ErrorResponse)
+		return
+	}
+
+	if len(steps) == 0 {
+		s.ResultTransaction = "No transaction steps configured"
+		logger.Error(s.ResultTransaction)
+		s.fail(checkTransaction, StateCrit, ErrorConfig)
+		return
+	}
+
+	config, err := s.tlsConfig()
+	if err != nil {
+		s.ResultTransaction = "Invalid TLS configuration"
+		logger.WithError(err).Error(s.ResultTransaction)
+		s.fail(checkTransaction, StateCrit, ErrorConfig)
+		return
+	}
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		s.ResultTransaction = "Unable to create cookie jar"
+		logger.WithError(err).Error(s.ResultTransaction)
+		s.fail(checkTransaction, StateCrit, ErrorResponse)
+		return
+	}
+
+	client := s.httpClient(config)
+	client.Jar = jar
+
+	// Relative step URLs are resolved against the page before them
+	host := s.Hostname
+	if s.pinned || host == "" {
+		host = s.IP
+	}
+	page := &url.URL{Scheme: "https", Host: host, Path: "/"}
+
+	vars := map[string]string{}
+	var total time.Duration
+	now := time.Now().Unix()
+
+	for i := range steps {
+		step := &steps[i]
+		step.CheckedAt = now
+
+		if *s.states()[checkTransaction] == StateCrit {
+			step.Result, step.State, step.Duration = "Skipped", "", 0
+			continue
+		}
+
+		start := time.Now()
+		next, err := s.runStep(client, step, page, vars)
+		took := time.Since(start)
+		total += took
+		step.Duration = took.Milliseconds()
+
+		if err != nil {
+			step.Result, step.State = err.Error(), StateCrit
+			s.ResultTransaction = fmt.Sprintf("Step %d: %v", i+1, err)
+			logger.WithError(err).Errorf("Transaction step %d failed", i+1)
+			s.fail(checkTransaction, StateCrit, classOf(err))
+			continue
+		}
+
+		step.State = StateOK
+		page = next
+	}
+
+	for _, step := range steps {
+		_, err := s.DB.Exec("UPDATE transactionsteps SET result = ?, state = ?, duration = ?, checkedat = ? WHERE id = ?",
+			step.Result, step.State, step.Duration, step.CheckedAt, step.ID)
+		if err != nil {
+			logger.WithError(err).Error("Unable to record transaction step")
+			break
+		}
+	}
+
+	if *s.states()[checkTransaction] == StateCrit {
+		return
+	}
+
+	s.ResultTransaction = fmt.Sprintf("%d steps OK in %vms", len(steps), total.Milliseconds())
+	logger.Infof("Transaction Check OK. %v", s.ResultTransaction)
+}
+
+// TransactionSecrets are the transactionsteps columns which may hold
+// credentials, encrypted at rest like SensitiveColumns
+var TransactionSecrets = []string{"headers", "body"}
+
+// transactionSteps loads the server's steps in the order they run, with their
+// headers and body decrypted and environment and secret store references in
+// them resolved
+func (s *Server) transactionSteps() ([]TransactionStep, error) {
+	rows, err := s.DB.Query("SELECT * FROM transactionsteps WHERE serverid = ? ORDER BY position, id", s.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var steps []TransactionStep
+	for rows.Next() {
+		var step TransactionStep
+		if err := sqlstruct.Scan(&step, rows); err != nil {
+			return nil, err
+		}
+
+		for _, setting := range []*string{&step.Headers, &step.Body} {
+			value, err := secrets.Decrypt(*setting)
+			if err == nil {
+				value, err = secrets.Resolve(Interpolate(value))
+			}
+			if err != nil {
+				return nil, fmt.Errorf("step %d: %v", step.Position, err)
+			}
+			*setting = value
+		}
+
+		steps = append(steps, step)
+	}
+
+	return steps, rows.Err()
+}
+
+// runStep makes a step's request relative to page and checks the response,
+// setting the step's result. It returns the URL of the page it ended on,
+// which later relative URLs are resolved against.
+func (s *Server) runStep(client *http.Client, step *TransactionStep, page *url.URL, vars map[string]string) (*url.URL, error) {
+	method := strings.ToUpper(step.Method)
+	if method == "" {
+		method = "GET"
+	}
+
+	target, err := page.Parse(expandStep(step.URL, vars))
+	if err != nil {
+		return nil, failure(ErrorConfig, "Invalid URL '%v'", step.URL)
+	}
+
+	step.Result = method + " " + target.String()
+
+	body := expandStep(step.Body, vars)
+	req, err := http.NewRequest(method, target.String(), strings.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", step.Result, err)
+	}
+	s.setHeaders(req)
+
+	for _, line := range strings.Split(expandStep(step.Headers, vars), "\n") {
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		req.Header.Set(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	if body != "" && req.Header.Get("Content-Type") == "" {
+		if json.Valid([]byte(body)) {
+			req.Header.Set("Content-Type", "application/json")
+		} else {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+	}
+
+	// Without follow a redirect is the step's response, so the next step
+	// can check where it went
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if !step.Follow || len(via) >= maxStepRedirects {
+			return http.ErrUseLastResponse
+		}
+		return nil
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		failed := httpError(err)
+		return nil, failure(classOf(failed), "%v: %v", step.Result, failed)
+	}
+
+	defer resp.Body.Close()
+
+	content, err := io.ReadAll(io.LimitReader(resp.Body, maxAssertBody))
+	if err != nil {
+		return nil, fmt.Errorf("%v: Unable to read response body: %v", step.Result, err)
+	}
+
+	status := resp.Proto + " " + resp.Status
+	step.Result += " " + resp.Status
+
+	expect := step.Expect
+	if expect == "" {
+		expect = s.expectHTTP()
+	}
+
+	if !isValidHTTPResponse(status, expect) {
+		return nil, fmt.Errorf("%v, expected %v", step.Result, expect)
+	}
+
+	if step.Match != "" {
+		re, err := regexp.Compile(step.Match)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid body pattern '%v'", step.Match)
+		}
+		if !re.Match(content) {
+			return nil, fmt.Errorf("%v, body does not match '%v'", step.Result, step.Match)
+		}
+	}
+
+	if location, err := resp.Location(); err == nil {
+		vars["location"] = location.String()
+	} else {
+		delete(vars, "location")
+	}
+
+	for _, line := range strings.Split(step.Capture, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if err := captureStep(line, resp, content, vars); err != nil {
+			return nil, fmt.Errorf("%v, %v", step.Result, err)
+		}
+	}
+
+	return resp.Request.URL, nil
+}
+
+// captureStep saves a value from a response for later steps. Each capture is
+// "name = header:Name", "name = json:$.path" or "name = body:pattern", where
+// a pattern's first group, or else its whole match, is taken.
+func captureStep(line string, resp *http.Response, content []byte, vars map[string]string) error {
+	name, source, ok := strings.Cut(line, "=")
+	name, source = strings.TrimSpace(name), strings.TrimSpace(source)
+	kind, arg, _ := strings.Cut(source, ":")
+
+	if !ok || !stepVariable.MatchString("{{"+name+"}}") {
+		return fmt.Errorf("invalid capture '%v'", line)
+	}
+
+	switch kind {
+	case "header":
+		value := resp.Header.Get(arg)
+		if value == "" {
+			return fmt.Errorf("no %v header to capture", arg)
+		}
+		vars[name] = value
+	case "json":
+		var doc interface{}
+		if err := json.Unmarshal(content, &doc); err != nil {
+			return fmt.Errorf("response body is not JSON")
+		}
+		value, found, err := jsonLookup(doc, arg)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("%v not found", arg)
+		}
+		if str, ok := value.(string); ok {
+			vars[name] = str
+		} else {
+			out, _ := json.Marshal(value)
+			vars[name] = string(out)
+		}
+	case "body":
+		re, err := regexp.Compile(arg)
+		if err != nil {
+			return fmt.Errorf("invalid capture pattern '%v'", arg)
+		}
+		match := re.FindSubmatch(content)
+		switch {
+		case match == nil:
+			return fmt.Errorf("body does not match '%v'", arg)
+		case len(match) > 1:
+			vars[name] = string(match[1])
+		default:
+			vars[name] = string(match[0])
+		}
+	default:
+		return fmt.Errorf("invalid capture '%v'", line)
+	}
+
+	return nil
+}
+
+// expandStep replaces {{name}} references with captured values. Unknown
+// names are left as they are, so the failure shows what was missing.
+func expandStep(value string, vars map[string]string) string {
+	return stepVariable.ReplaceAllStringFunc(value, func(ref string) string {
+		if val, ok := vars[stepVariable.FindStringSubmatch(ref)[1]]; ok {
+			return val
+		}
+		return ref
+	})
+}
@@ -0,0 +1,208 @@
+package server
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/kisielk/sqlstruct"
+)
+
+// uptimeWindows are the rolling windows uptime is reported over. The SLA is
+// judged on the last.
+var uptimeWindows = [3]time.Duration{24 * time.Hour, 7 * 24 * time.Hour, 30 * 24 * time.Hour}
+
+// Uptime is the share of runs that weren't CRIT over each of uptimeWindows,
+// for one check or, when Check is "", all of a server's checks. Runs during
+// maintenance and the SLO check aren't counted, and a window without runs
+// is 100%.
+type Uptime struct {
+	ServerID  int     `sql:"serverid" json:"-"`
+	Hostname  string  `sql:"hostname" json:"hostname"`
+	Check     string  `sql:"checkname" json:"check,omitempty"`
+	Day       float64 `sql:"day" json:"day"`
+	Week      float64 `sql:"week" json:"week"`
+	Month     float64 `sql:"month" json:"month"`
+	SLA       float64 `sql:"sla" json:"sla,omitempty"`
+	Breached  bool    `sql:"breached" json:"breached"`
+	UpdatedAt int64   `sql:"updatedat" json:"updatedat"`
+}
+
+// uptimeCount tallies runs and passes in each window
+type uptimeCount struct {
+	runs   [len(uptimeWindows)]int
+	passed [len(uptimeWindows)]int
+}
+
+func (c *uptimeCount) add(o uptimeCount) {
+	for i := range c.runs {
+		c.runs[i] += o.runs[i]
+		c.passed[i] += o.passed[i]
+	}
+}
+
+func (c *uptimeCount) percent(i int) float64 {
+	if c.runs[i] == 0 {
+		return 100
+	}
+
+	return 100 * float64(c.passed[i]) / float64(c.runs[i])
+}
+
+// UpdateUptime recomputes every server's rows in the uptime table from the
+// history. A server's SLA comes from its sla column, or its profile's, and
+// it is breached when the 30 day uptime falls below it.
+func UpdateUptime(db *sql.DB, now time.Time) error {
+	servers, err := loadSLAs(db)
+	if err != nil {
+		return err
+	}
+
+	day, week, month := now.Add(-uptimeWindows[0]).Unix(), now.Add(-uptimeWindows[1]).Unix(), now.Add(-uptimeWindows[2]).Unix()
+
+	rows, err := db.Query(`
+		SELECT serverid, checkname,
+			SUM(checkedat >= ?), SUM(checkedat >= ? AND state != 'CRIT'),
+			SUM(checkedat >= ?), SUM(checkedat >= ? AND state != 'CRIT'),
+			COUNT(*), SUM(state != 'CRIT')
+		FROM check_history
+		WHERE checkedat >= ? AND maintenance = 0 AND checkname != 'slo'
+		GROUP BY serverid, checkname
+		ORDER BY serverid, checkname
+	`, day, day, week, week, month)
+	if err != nil {
+		return err
+	}
+
+	var uptimes []Uptime
+	totals := map[int]*uptimeCount{}
+
+	for rows.Next() {
+		var id int
+		var check string
+		var c uptimeCount
+		if err := rows.Scan(&id, &check, &c.runs[0], &c.passed[0], &c.runs[1], &c.passed[1], &c.runs[2], &c.passed[2]); err != nil {
+			rows.Close()
+			return err
+		}
+
+		// History of deleted servers has nobody to report to
+		srv, ok := servers[id]
+		if !ok {
+			continue
+		}
+
+		if totals[id] == nil {
+			totals[id] = &uptimeCount{}
+		}
+		totals[id].add(c)
+
+		uptimes = append(uptimes, srv.uptime(check, c, now))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, srv := range servers {
+		var c uptimeCount
+		if totals[id] != nil {
+			c = *totals[id]
+		}
+		uptimes = append(uptimes, srv.uptime("", c, now))
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM uptime"); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, u := range uptimes {
+		_, err := tx.Exec(`
+			INSERT INTO uptime (serverid, hostname, checkname, day, week, month, sla, breached, updatedat)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, u.ServerID, u.Hostname, u.Check, u.Day, u.Week, u.Month, u.SLA, u.Breached, u.UpdatedAt)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// slaTarget is the part of a server uptime is reported with
+type slaTarget struct {
+	id       int
+	hostname string
+	sla      float64
+}
+
+func (t slaTarget) uptime(check string, c uptimeCount, now time.Time) Uptime {
+	u := Uptime{
+		ServerID:  t.id,
+		Hostname:  t.hostname,
+		Check:     check,
+		Day:       c.percent(0),
+		Week:      c.percent(1),
+		Month:     c.percent(2),
+		SLA:       t.sla,
+		UpdatedAt: now.Unix(),
+	}
+	u.Breached = u.SLA > 0 && u.Month < u.SLA
+
+	return u
+}
+
+// loadSLAs returns every server with its SLA target, by ID
+func loadSLAs(db *sql.DB) (map[int]slaTarget, error) {
+	rows, err := db.Query(`
+		SELECT s.id, s.hostname, COALESCE(NULLIF(s.sla, 0), p.sla, 0)
+		FROM servers s LEFT JOIN profiles p ON p.name = s.profile
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	servers := map[int]slaTarget{}
+	for rows.Next() {
+		var t slaTarget
+		if err := rows.Scan(&t.id, &t.hostname, &t.sla); err != nil {
+			return nil, err
+		}
+		servers[t.id] = t
+	}
+
+	return servers, rows.Err()
+}
+
+// LoadUptime returns the uptime rows for hostname, or every server when it
+// is "", server totals first
+func LoadUptime(db *sql.DB, hostname string) ([]Uptime, error) {
+	rows, err := db.Query(`
+		SELECT * FROM uptime WHERE ? = '' OR hostname = ?
+		ORDER BY hostname, checkname
+	`, hostname, hostname)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	uptimes := []Uptime{}
+	for rows.Next() {
+		var u Uptime
+		if err := sqlstruct.Scan(&u, rows); err != nil {
+			return nil, err
+		}
+		uptimes = append(uptimes, u)
+	}
+
+	return uptimes, rows.Err()
+}
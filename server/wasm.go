@@ -0,0 +1,150 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/tetratelabs/wazero/sys"
+)
+
+// wasmRuntime is shared by all WASM plugins. Modules get no filesystem or
+// socket access, only the JSON request on stdin and the vbms.exchange host
+// function for talking to the server being checked.
+var (
+	wasmOnce    sync.Once
+	wasmRuntime wazero.Runtime
+	hostErr     error
+)
+
+// pluginServer is the context key for the server a plugin is checking
+type pluginServer struct{}
+
+// wasmPlugin is a WASI module compiled once at startup and instantiated for
+// each check
+type wasmPlugin struct {
+	module wazero.CompiledModule
+}
+
+// loadWASM compiles a WASI command module
+func loadWASM(path string) (*wasmPlugin, error) {
+	binary, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+
+	wasmOnce.Do(func() {
+		// Close modules when a check times out rather than letting them spin
+		config := wazero.NewRuntimeConfig().WithCloseOnContextDone(true)
+		wasmRuntime = wazero.NewRuntimeWithConfig(ctx, config)
+		wasi_snapshot_preview1.MustInstantiate(ctx, wasmRuntime)
+
+		_, hostErr = wasmRuntime.NewHostModuleBuilder("vbms").
+			NewFunctionBuilder().WithFunc(exchange).Export("exchange").
+			Instantiate(ctx)
+	})
+
+	if hostErr != nil {
+		return nil, hostErr
+	}
+
+	module, err := wasmRuntime.CompileModule(ctx, binary)
+	if err != nil {
+		return nil, err
+	}
+
+	return &wasmPlugin{module: module}, nil
+}
+
+// run instantiates the module, which runs its _start function to completion
+func (p *wasmPlugin) run(ctx context.Context, input []byte) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+
+	// An empty name lets several checks run the same plugin at once
+	config := wazero.NewModuleConfig().
+		WithName("").
+		WithStdin(bytes.NewReader(input)).
+		WithStdout(&stdout).
+		WithStderr(&stderr)
+
+	mod, err := wasmRuntime.InstantiateModule(ctx, p.module, config)
+	if mod != nil {
+		defer mod.Close(ctx)
+	}
+
+	var exit *sys.ExitError
+	if errors.As(err, &exit) && exit.ExitCode() == 0 {
+		err = nil
+	}
+
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%v: %v", err, msg)
+		}
+		return nil, err
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// exchange is imported by WASM plugins as vbms.exchange. It connects to the
+// address at addrPtr (host:port), through any SSH jump host configured for
+// the server, sends the request bytes and copies up to respCap bytes of the
+// reply into module memory. It returns the reply length, or -1 on failure.
+func exchange(ctx context.Context, m api.Module, addrPtr, addrLen, reqPtr, reqLen, respPtr, respCap uint32) int32 {
+	s, ok := ctx.Value(pluginServer{}).(*Server)
+	if !ok {
+		return -1
+	}
+
+	addr, ok := m.Memory().Read(addrPtr, addrLen)
+	if !ok {
+		return -1
+	}
+
+	req, ok := m.Memory().Read(reqPtr, reqLen)
+	if !ok {
+		return -1
+	}
+
+	conn, err := s.dial(string(addr))
+	if err != nil {
+		return -1
+	}
+
+	defer conn.Close()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(s.timeout())
+	}
+	conn.SetDeadline(deadline)
+
+	if _, err := conn.Write(req); err != nil {
+		return -1
+	}
+
+	// Read until the server closes, the buffer fills or time runs out
+	resp := make([]byte, respCap)
+	n, err := io.ReadFull(conn, resp)
+	if n == 0 && err != nil && err != io.EOF {
+		return -1
+	}
+
+	if !m.Memory().Write(respPtr, resp[:n]) {
+		return -1
+	}
+
+	return int32(n)
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// window is a recurring period of the day, on some days of the week
+type window struct {
+	days  [7]bool
+	start int
+	end   int
+}
+
+// weekdays are how days are written in window definitions
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// parseWindows reads windows separated by semicolons. Each is a time range,
+// optionally preceded by the days it applies to, e.g. "02:00-03:00",
+// "Mon-Fri 09:00-17:00" or "Sat,Sun 22:00-02:00". A range ending before it
+// starts runs past midnight into the next day.
+func parseWindows(spec string) ([]window, error) {
+	var windows []window
+
+	for _, part := range strings.Split(spec, ";") {
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			continue
+		}
+
+		var w window
+		span := fields[len(fields)-1]
+
+		switch len(fields) {
+		case 1:
+			for i := range w.days {
+				w.days[i] = true
+			}
+		case 2:
+			if err := w.parseDays(fields[0]); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("Invalid window '%v'", part)
+		}
+
+		times := strings.Split(span, "-")
+		if len(times) != 2 {
+			return nil, fmt.Errorf("Invalid time range '%v'", span)
+		}
+
+		var err error
+		if w.start, err = parseClock(times[0]); err != nil {
+			return nil, err
+		}
+		if w.end, err = parseClock(times[1]); err != nil {
+			return nil, err
+		}
+
+		windows = append(windows, w)
+	}
+
+	return windows, nil
+}
+
+// parseDays reads a comma separated list of days or day ranges
+func (w *window) parseDays(spec string) error {
+	for _, part := range strings.Split(strings.ToLower(spec), ",") {
+		bounds := strings.SplitN(part, "-", 2)
+
+		first, err := parseWeekday(bounds[0])
+		if err != nil {
+			return err
+		}
+
+		last := first
+		if len(bounds) == 2 {
+			if last, err = parseWeekday(bounds[1]); err != nil {
+				return err
+			}
+		}
+
+		for d := first; ; d = (d + 1) % 7 {
+			w.days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// parseWeekday returns the index of an abbreviated day name
+func parseWeekday(name string) (int, error) {
+	for i, day := range weekdays {
+		if strings.HasPrefix(name, day) {
+			return i, nil
+		}
+	}
+
+	return 0, fmt.Errorf("Unknown day '%v'", name)
+}
+
+// parseClock returns minutes past midnight for HH:MM, allowing 24:00
+func parseClock(value string) (int, error) {
+	var h, m int
+	if _, err := fmt.Sscanf(value, "%d:%d", &h, &m); err != nil || h < 0 || h > 24 || m < 0 || m > 59 || (h == 24 && m > 0) {
+		return 0, fmt.Errorf("Invalid time '%v'", value)
+	}
+
+	return h*60 + m, nil
+}
+
+// contains reports whether t, already in the window's timezone, falls inside it
+func (w window) contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	today := int(t.Weekday())
+	yesterday := (today + 6) % 7
+
+	if w.start <= w.end {
+		return w.days[today] && minute >= w.start && minute < w.end
+	}
+
+	// Past midnight, the window belongs to the day it started on
+	return (w.days[today] && minute >= w.start) || (w.days[yesterday] && minute < w.end)
+}
+
+// location returns the server's timezone, falling back to the probe's own
+func (s *Server) location() *time.Location {
+	if s.Timezone == "" {
+		return time.Local
+	}
+
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		s.GetLogger("SCHEDULE", 0).WithError(err).Errorf("Unknown timezone %v", s.Timezone)
+		return time.Local
+	}
+
+	return loc
+}
+
+// inMaintenance reports whether t falls in one of the server's maintenance
+// windows, read in the server's timezone, or in scheduled maintenance
+func (s *Server) inMaintenance(t time.Time) bool {
+	if s.DB != nil && s.inScheduledMaintenance(t) {
+		return true
+	}
+
+	if s.Maintenance == "" {
+		return false
+	}
+
+	windows, err := parseWindows(s.Maintenance)
+	if err != nil {
+		s.GetLogger("SCHEDULE", 0).WithError(err).Error("Invalid maintenance windows")
+		return false
+	}
+
+	local := t.In(s.location())
+	for _, w := range windows {
+		if w.contains(local) {
+			return true
+		}
+	}
+
+	return false
+}
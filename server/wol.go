@@ -0,0 +1,85 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"time"
+)
+
+// defaultWoLThreshold is how many consecutive failed pings trigger a wake-up
+const defaultWoLThreshold = 3
+
+// sendMagicPacket broadcasts a Wake-on-LAN magic packet for mac to addr,
+// which defaults to the limited broadcast address on the discard port
+func sendMagicPacket(mac string, addr string) error {
+	hw, err := net.ParseMAC(mac)
+	if err != nil {
+		return err
+	}
+
+	if addr == "" {
+		addr = "255.255.255.255:9"
+	}
+
+	// Six 0xFF bytes followed by the MAC address sixteen times
+	packet := append(bytes.Repeat([]byte{0xff}, 6), bytes.Repeat(hw, 16)...)
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+
+	_, err = conn.Write(packet)
+	return err
+}
+
+// recordPing tracks consecutive ping failures, sends a magic packet once a
+// LAN host has failed WoLThreshold times in a row, and notes whether it came
+// back. It goes by the run's final ping state, so a retried ping counts
+// once, and only a ping that went unanswered is a failure.
+func (s *Server) recordPing() {
+	if !s.EnablePing || s.skipped[checkPing] {
+		return
+	}
+
+	logger := s.GetLogger("WOL", 9)
+
+	switch {
+	case s.StatePing == StateOK || s.StatePing == StateWarn:
+		if s.WoLSent > 0 {
+			took := time.Since(time.Unix(s.WoLSent, 0)).Round(time.Second)
+			s.ResultWoL = fmt.Sprintf("Host came back %v after wake-on-LAN", took)
+			s.WoLSent = 0
+			logger.Info(s.ResultWoL)
+		}
+		s.PingFailures = 0
+		return
+	case s.StatePing != StateCrit || s.classes[checkPing] != ErrorTimeout:
+		return
+	}
+
+	s.PingFailures++
+
+	threshold := s.WoLThreshold
+	if threshold <= 0 {
+		threshold = defaultWoLThreshold
+	}
+
+	// One wake-up per outage
+	if s.WoLMAC == "" || s.WoLSent > 0 || s.PingFailures < threshold {
+		return
+	}
+
+	if err := sendMagicPacket(s.WoLMAC, s.WoLAddr); err != nil {
+		s.ResultWoL = "Unable to send wake-on-LAN packet"
+		logger.WithError(err).Error(s.ResultWoL)
+		return
+	}
+
+	s.WoLSent = time.Now().Unix()
+	s.ResultWoL = fmt.Sprintf("Wake-on-LAN sent after %d failed pings, waiting for host", s.PingFailures)
+	logger.Warn(s.ResultWoL)
+}